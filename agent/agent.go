@@ -1,11 +1,24 @@
 package agent
 
 import (
-	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rcrowley/go-metrics"
 	"github.com/wavefronthq/go-proxy/api"
+	"github.com/wavefronthq/go-proxy/config"
+	"github.com/wavefronthq/go-proxy/logger"
+)
+
+const (
+	DefaultCheckinInterval = time.Minute
+
+	// upper bound of the delay between failed check-ins, unless the interval is longer
+	maxCheckinBackoff = 10 * time.Minute
+
+	// first delay between failed check-ins until the agent is registered
+	registrationRetryDelay = time.Second
 )
 
 // Agent interface.
@@ -20,46 +33,141 @@ type DefaultAgent struct {
 	PushAgent  bool
 	Ephemeral  bool
 	ServerURL  string
+	// time between check-ins, defaults to DefaultCheckinInterval
+	CheckinInterval time.Duration
+	// called with the configuration returned by each successful check-in
+	OnConfig func(agentConfig *config.AgentConfig)
+	// service the agent metrics are checked in with if set, e.g. a separate tenant for the
+	// proxy's own telemetry, the ApiService then checks in without them
+	MetricsService api.WavefrontAPI
+	// prepended to the names of the agent metrics if set
+	MetricsPrefix string
+	// time between captures of the runtime statistics, captured at each check-in only if 0
+	RuntimeStatsInterval time.Duration
+	registered           int32
+	registeredCh         chan struct{} // closed once registered
+	initOnce             sync.Once
 }
 
 func (a *DefaultAgent) InitAgent() {
-	// register agent GC and memory usage statistics
-	// buildAgentMetrics() updates these stats every minute
+	// register agent GC and memory usage statistics, updated by buildAgentMetrics() at each
+	// check-in and every RuntimeStatsInterval if set
 	metrics.RegisterRuntimeMemStats(metrics.DefaultRegistry)
+	if a.RuntimeStatsInterval > 0 {
+		go captureRuntimeStatsEvery(a.RuntimeStatsInterval)
+	}
 
-	// fetch configuration once per minute
-	checkinTicker := time.NewTicker(time.Minute * time.Duration(1))
-	go a.checkin(checkinTicker)
+	if a.CheckinInterval <= 0 {
+		a.CheckinInterval = DefaultCheckinInterval
+	}
+	go a.checkin()
 }
 
-func (a *DefaultAgent) checkin(ticker *time.Ticker) {
-	for range ticker.C {
-		a.doCheckin()
+// Returns true once the agent has successfully checked in with the Wavefront server.
+func (a *DefaultAgent) Registered() bool {
+	return atomic.LoadInt32(&a.registered) == 1
+}
+
+// Waits until the agent has checked in with the Wavefront server, or for the timeout if it is
+// positive. Returns whether the agent is registered.
+func (a *DefaultAgent) WaitRegistered(timeout time.Duration) bool {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-a.registeredChan():
+		return true
+	case <-expired:
+		return a.Registered()
 	}
 }
 
-func (a *DefaultAgent) doCheckin() {
-	log.Println("Fetching configuration from", a.ServerURL)
+func (a *DefaultAgent) registeredChan() chan struct{} {
+	a.initOnce.Do(func() { a.registeredCh = make(chan struct{}) })
+	return a.registeredCh
+}
+
+func (a *DefaultAgent) checkin() {
+	// check in immediately rather than waiting for the first interval
+	failures := 0
+	for {
+		if a.doCheckin() {
+			failures = 0
+		} else {
+			failures++
+		}
+		if a.Registered() {
+			time.Sleep(checkinDelay(a.CheckinInterval, failures))
+		} else {
+			time.Sleep(registrationDelay(a.CheckinInterval, failures))
+		}
+	}
+}
 
-	agentMetrics, err := buildAgentMetrics()
+// Doubles the delay after each consecutive failure up to maxCheckinBackoff.
+func checkinDelay(interval time.Duration, failures int) time.Duration {
+	delay := interval
+	for i := 0; i < failures && delay < maxCheckinBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxCheckinBackoff && interval < maxCheckinBackoff {
+		delay = maxCheckinBackoff
+	}
+	return delay
+}
+
+// Retries the first check-in sooner, doubling the delay from registrationRetryDelay after each
+// failure up to the check-in interval.
+func registrationDelay(interval time.Duration, failures int) time.Duration {
+	delay := registrationRetryDelay
+	for i := 1; i < failures && delay < interval; i++ {
+		delay *= 2
+	}
+	if delay > interval {
+		delay = interval
+	}
+	return delay
+}
+
+// Returns false if the check-in failed.
+func (a *DefaultAgent) doCheckin() bool {
+	logger.Debug("Fetching configuration from", a.ServerURL)
+
+	agentMetrics, err := buildAgentMetrics(a.MetricsPrefix)
 	if err != nil {
-		log.Println("buildAgentMetrics error", err)
-		return
+		logger.Error("buildAgentMetrics error", err)
+		return false
 	}
 
 	currentTime := getCurrentTime()
+	if a.MetricsService != nil {
+		// a failure to report the metrics does not fail the check-in fetching the configuration
+		_, err := a.MetricsService.Checkin(currentTime, a.LocalAgent, a.PushAgent, a.Ephemeral, agentMetrics)
+		if err != nil {
+			logger.Warn("Internal metrics checkin error", err)
+		}
+		agentMetrics = noAgentMetrics
+	}
 	agentConfig, err := a.ApiService.Checkin(currentTime, a.LocalAgent, a.PushAgent, a.Ephemeral, agentMetrics)
 	if err != nil {
-		log.Println("Checkin error", err)
-		return
+		logger.Warn("Checkin error", err)
+		return false
+	}
+	if atomic.CompareAndSwapInt32(&a.registered, 0, 1) {
+		close(a.registeredChan())
 	}
 
-	//TODO: update forwarder based on fetched configuration
-	log.Println("AgentConfig", *agentConfig)
+	if a.OnConfig != nil {
+		a.OnConfig(agentConfig)
+	}
 	err = a.ApiService.AgentConfigProcessed()
 	if err != nil {
-		log.Println("AgentConfigProcessed error", err)
+		logger.Warn("AgentConfigProcessed error", err)
 	}
+	return true
 }
 
 func getCurrentTime() int64 {
@@ -0,0 +1,152 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/config"
+)
+
+type testAPI struct {
+	agentConfig *config.AgentConfig
+	err         error
+	processed   int
+	metrics     []byte // of the last check-in
+}
+
+func (api *testAPI) GetConfig(currentMillis, bytesLeft, bytesPerMinute, currentQueueSize int64) (*config.AgentConfig, error) {
+	return api.agentConfig, api.err
+}
+
+func (api *testAPI) Checkin(currentMillis int64, localAgent, pushAgent, ephemeral bool, agentMetrics []byte) (*config.AgentConfig, error) {
+	api.metrics = agentMetrics
+	return api.agentConfig, api.err
+}
+
+func (api *testAPI) PostData(workUnitId, format, pointLines string) (*http.Response, error) {
+	return &http.Response{StatusCode: http.StatusAccepted}, nil
+}
+
+func (api *testAPI) AgentError(details string) {}
+
+func (api *testAPI) AgentConfigProcessed() error {
+	api.processed++
+	return nil
+}
+
+func TestCheckinAppliesConfig(t *testing.T) {
+	// normally registered by InitAgent
+	metrics.RegisterRuntimeMemStats(metrics.DefaultRegistry)
+
+	interval := 5000
+	api := &testAPI{agentConfig: &config.AgentConfig{PushFlushInterval: &interval}}
+	var applied *config.AgentConfig
+	agent := &DefaultAgent{ApiService: api, OnConfig: func(agentConfig *config.AgentConfig) {
+		applied = agentConfig
+	}}
+
+	if !agent.doCheckin() || !agent.Registered() {
+		t.Fatal("Expected successful check-in")
+	}
+	if applied == nil || *applied.PushFlushInterval != 5000 || api.processed != 1 {
+		t.Errorf("Expected the config to be applied and processed, found %v %d", applied, api.processed)
+	}
+
+	applied = nil
+	api.err = errors.New("unavailable")
+	if agent.doCheckin() || applied != nil {
+		t.Error("Expected failed check-in without applying config")
+	}
+}
+
+func TestCheckinMetricsService(t *testing.T) {
+	metrics.RegisterRuntimeMemStats(metrics.DefaultRegistry)
+	metrics.GetOrRegisterCounter("checkin.test.count", nil).Inc(1)
+
+	main := &testAPI{agentConfig: &config.AgentConfig{}}
+	ops := &testAPI{err: errors.New("unavailable")}
+	agent := &DefaultAgent{ApiService: main, MetricsService: ops, MetricsPrefix: "proxy"}
+
+	// the configuration is still fetched when the metrics are not accepted
+	if !agent.doCheckin() || main.processed != 1 {
+		t.Fatal("Expected successful check-in with the main server")
+	}
+	if string(main.metrics) != "{}" {
+		t.Errorf("Expected no metrics sent to the main server, found %s", main.metrics)
+	}
+	var stats map[string]interface{}
+	if err := json.Unmarshal(ops.metrics, &stats); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stats["proxy.checkin.test.count"]; !ok {
+		t.Errorf("Expected prefixed metrics sent to the metrics service, found %v", stats)
+	}
+	if _, ok := stats["checkin.test.count"]; ok {
+		t.Error("Expected no metrics without the prefix")
+	}
+}
+
+func TestCheckinDelay(t *testing.T) {
+	cases := []struct {
+		interval time.Duration
+		failures int
+		expected time.Duration
+	}{
+		{time.Minute, 0, time.Minute},
+		{time.Minute, 1, 2 * time.Minute},
+		{time.Minute, 3, 8 * time.Minute},
+		{time.Minute, 10, maxCheckinBackoff},
+		{time.Hour, 2, time.Hour},
+	}
+	for _, c := range cases {
+		if delay := checkinDelay(c.interval, c.failures); delay != c.expected {
+			t.Errorf("Expected delay %v for %v after %d failures, found %v", c.expected, c.interval, c.failures, delay)
+		}
+	}
+}
+
+func TestWaitRegistered(t *testing.T) {
+	metrics.RegisterRuntimeMemStats(metrics.DefaultRegistry)
+
+	api := &testAPI{agentConfig: &config.AgentConfig{}, err: errors.New("unavailable")}
+	agent := &DefaultAgent{ApiService: api}
+	agent.doCheckin()
+	if agent.WaitRegistered(10 * time.Millisecond) {
+		t.Fatal("Expected the wait to time out before registration")
+	}
+
+	api.err = nil
+	done := make(chan bool)
+	go func() { done <- agent.doCheckin() }()
+	if !agent.WaitRegistered(0) {
+		t.Error("Expected the wait to end once registered")
+	}
+	<-done
+	// check-ins after registration do not close the channel again
+	if !agent.doCheckin() || !agent.WaitRegistered(time.Millisecond) {
+		t.Error("Expected the agent to stay registered")
+	}
+}
+
+func TestRegistrationDelay(t *testing.T) {
+	cases := []struct {
+		interval time.Duration
+		failures int
+		expected time.Duration
+	}{
+		{time.Minute, 1, time.Second},
+		{time.Minute, 2, 2 * time.Second},
+		{time.Minute, 4, 8 * time.Second},
+		{time.Minute, 10, time.Minute},
+		{500 * time.Millisecond, 1, 500 * time.Millisecond},
+	}
+	for _, c := range cases {
+		if delay := registrationDelay(c.interval, c.failures); delay != c.expected {
+			t.Errorf("Expected delay %v for %v after %d failures, found %v", c.expected, c.interval, c.failures, delay)
+		}
+	}
+}
@@ -2,51 +2,97 @@ package agent
 
 import (
 	"bufio"
-	"log"
+	"fmt"
 	"os"
+	"strings"
 
 	satori "github.com/satori/go.uuid"
+	"github.com/wavefronthq/go-proxy/logger"
 )
 
-func CreateOrGetAgentId(idFile string) string {
+// Settings for resolving the agent ID. An explicit AgentId is used as is, otherwise
+// the ID is derived from the hostname when a Salt is set, or read from the IdFile.
+type AgentIdConfig struct {
+	AgentId  string
+	Hostname string
+	Salt     string
+	IdFile   string
+}
+
+// Resolves the agent ID. Derived IDs stay the same across restarts of ephemeral containers
+// without persistent storage, so no orphaned agents are left behind.
+func ResolveAgentId(cfg AgentIdConfig) (string, error) {
+	switch {
+	case cfg.AgentId != "":
+		if _, err := satori.FromString(cfg.AgentId); err != nil {
+			return "", fmt.Errorf("invalid agentId %q: %v", cfg.AgentId, err)
+		}
+		logger.Info("Using agentId", cfg.AgentId)
+		return cfg.AgentId, nil
+	case cfg.Salt != "":
+		agentId := deriveAgentId(cfg.Hostname, cfg.Salt)
+		logger.Info("Using agentId", agentId, "derived from hostname", cfg.Hostname)
+		return agentId, nil
+	default:
+		return CreateOrGetAgentId(cfg.IdFile)
+	}
+}
+
+func deriveAgentId(hostname, salt string) string {
+	return satori.NewV5(satori.NamespaceDNS, salt+"."+hostname).String()
+}
+
+// Reads the agent ID from the file, creating a new ID if the file does not exist
+// or does not contain a valid ID.
+func CreateOrGetAgentId(idFile string) (string, error) {
 	if _, err := os.Stat(idFile); os.IsNotExist(err) {
-		agentId := getUUID()
-		log.Println("Created agentId", agentId)
-		writeAgentId(agentId, idFile)
-		return agentId
+		return createAgentId(idFile)
 	}
-	return readAgentId(idFile)
+	agentId, err := readAgentId(idFile)
+	if err != nil {
+		return "", err
+	}
+	if _, err := satori.FromString(agentId); err != nil {
+		logger.Warnf("Replacing invalid agentId in %s", idFile)
+		return createAgentId(idFile)
+	}
+	logger.Info("Using agentId", agentId)
+	return agentId, nil
+}
+
+func createAgentId(idFile string) (string, error) {
+	agentId := getUUID()
+	logger.Info("Created agentId", agentId)
+	return agentId, writeAgentId(agentId, idFile)
 }
 
 func getUUID() string {
 	return satori.NewV4().String()
 }
 
-func writeAgentId(agentId, idFile string) {
-	file, err := os.OpenFile(idFile, os.O_RDWR|os.O_CREATE, 0644)
+func writeAgentId(agentId, idFile string) error {
+	file, err := os.OpenFile(idFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer file.Close()
 	_, err = file.WriteString(agentId + "\n")
+	return err
 }
 
-func readAgentId(idFile string) string {
+func readAgentId(idFile string) (string, error) {
 	file, err := os.Open(idFile)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
 	defer file.Close()
 
 	agentId := ""
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
-		agentId = scanner.Text()
-	}
-
-	if err := scanner.Err(); err != nil {
-		panic(err)
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			agentId = line
+		}
 	}
-	log.Println("Using agentId", agentId)
-	return agentId
+	return agentId, scanner.Err()
 }
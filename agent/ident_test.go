@@ -0,0 +1,68 @@
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveAgentId(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wavefront-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	idFile := filepath.Join(dir, ".wavefront_id")
+
+	// explicit IDs skip the file
+	explicit := "2cd0ff46-3bd9-4a6c-9c2d-8b0f8a3b8a5e"
+	id, err := ResolveAgentId(AgentIdConfig{AgentId: explicit, IdFile: idFile})
+	if err != nil || id != explicit {
+		t.Errorf("Expected explicit agentId, found %s %v", id, err)
+	}
+	if _, err = ResolveAgentId(AgentIdConfig{AgentId: "not-a-uuid", IdFile: idFile}); err == nil {
+		t.Error("Error expected for invalid explicit agentId")
+	}
+
+	// derived IDs are stable for a hostname and salt
+	derived, _ := ResolveAgentId(AgentIdConfig{Hostname: "web1", Salt: "prod", IdFile: idFile})
+	again, _ := ResolveAgentId(AgentIdConfig{Hostname: "web1", Salt: "prod", IdFile: idFile})
+	other, _ := ResolveAgentId(AgentIdConfig{Hostname: "web2", Salt: "prod", IdFile: idFile})
+	if derived != again || derived == other {
+		t.Errorf("Expected stable agentIds per hostname, found %s %s %s", derived, again, other)
+	}
+	if _, err = os.Stat(idFile); !os.IsNotExist(err) {
+		t.Error("Expected no agentId file for explicit and derived agentIds")
+	}
+
+	// file IDs are created once and reused
+	created, err := ResolveAgentId(AgentIdConfig{IdFile: idFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ = ResolveAgentId(AgentIdConfig{IdFile: idFile}); id != created {
+		t.Errorf("Expected agentId %s from file, found %s", created, id)
+	}
+}
+
+func TestCorruptAgentIdFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "wavefront-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("garbage\x00\n")
+	file.Close()
+
+	id, err := CreateOrGetAgentId(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id == "" || id == "garbage\x00" {
+		t.Errorf("Expected a new agentId, found %q", id)
+	}
+	if again, _ := CreateOrGetAgentId(file.Name()); again != id {
+		t.Errorf("Expected the replaced agentId %s, found %s", id, again)
+	}
+}
@@ -7,12 +7,19 @@ import (
 	"github.com/rcrowley/go-metrics"
 )
 
-func buildAgentMetrics() ([]byte, error) {
+// checked in with the main server when the metrics are reported to a separate one
+var noAgentMetrics = []byte("{}")
+
+// Returns the registered metrics as JSON, with their names prefixed by the prefix if not empty.
+func buildAgentMetrics(prefix string) ([]byte, error) {
 	// update GC and memory stats before populating the map
-	metrics.CaptureRuntimeMemStatsOnce(metrics.DefaultRegistry)
+	captureRuntimeStats()
 
 	var stats map[string]interface{} = make(map[string]interface{})
 	metrics.DefaultRegistry.Each(func(name string, i interface{}) {
+		if prefix != "" {
+			name = combine(prefix, name)
+		}
 		switch metric := i.(type) {
 		case metrics.Counter:
 			stats[name] = metric.Count()
@@ -24,14 +31,25 @@ func buildAgentMetrics() ([]byte, error) {
 			timer := metric.Snapshot()
 			addHisto(stats, name, timer.Min(), timer.Max(), timer.Mean(),
 				timer.Percentiles([]float64{0.5, 0.75, 0.95, 0.99, 0.999}))
-			addRate(stats, name, timer.Count(), timer.Rate1(), timer.RateMean())
+			addRate(stats, name, timer)
 		case metrics.Histogram:
+			// histograms are not necessarily durations, the raw values are reported with the
+			// suffixes of the timer durations
 			histo := metric.Snapshot()
-			addHisto(stats, name, histo.Min(), histo.Max(), histo.Mean(),
-				histo.Percentiles([]float64{0.5, 0.75, 0.95, 0.99, 0.999}))
+			percentiles := histo.Percentiles([]float64{0.5, 0.75, 0.95, 0.99, 0.999})
+			stats[combine(name, "count")] = histo.Count()
+			stats[combine(name, "min")] = histo.Min()
+			stats[combine(name, "max")] = histo.Max()
+			stats[combine(name, "mean")] = histo.Mean()
+			stats[combine(name, "median")] = percentiles[0]
+			stats[combine(name, "p75")] = percentiles[1]
+			stats[combine(name, "p95")] = percentiles[2]
+			stats[combine(name, "p99")] = percentiles[3]
+			stats[combine(name, "p999")] = percentiles[4]
+			// deprecated, the duration keys reported before, to be removed in the next release
+			addHisto(stats, name, histo.Min(), histo.Max(), histo.Mean(), percentiles)
 		case metrics.Meter:
-			meter := metric.Snapshot()
-			addRate(stats, name, meter.Count(), meter.Rate1(), meter.RateMean())
+			addRate(stats, name, metric.Snapshot())
 		}
 	})
 	return json.Marshal(stats)
@@ -49,10 +67,21 @@ func addHisto(stats map[string]interface{}, name string, min, max int64, mean fl
 	stats[combine(name, "duration.p999")] = percentiles[4] / 1e6
 }
 
-func addRate(stats map[string]interface{}, name string, count int64, m1, mean float64) {
-	stats[combine(name, "rate.count")] = count
-	stats[combine(name, "rate.m1")] = m1
-	stats[combine(name, "rate.mean")] = mean
+// rates per second of meters and timers
+type rates interface {
+	Count() int64
+	Rate1() float64
+	Rate5() float64
+	Rate15() float64
+	RateMean() float64
+}
+
+func addRate(stats map[string]interface{}, name string, r rates) {
+	stats[combine(name, "rate.count")] = r.Count()
+	stats[combine(name, "rate.m1")] = r.Rate1()
+	stats[combine(name, "rate.m5")] = r.Rate5()
+	stats[combine(name, "rate.m15")] = r.Rate15()
+	stats[combine(name, "rate.mean")] = r.RateMean()
 }
 
 func combine(prefix, name string) string {
@@ -0,0 +1,56 @@
+package agent
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestBuildAgentMetricsRates(t *testing.T) {
+	metrics.GetOrRegisterMeter("flush.2878.points", nil).Mark(10)
+
+	b, err := buildAgentMetrics("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stats map[string]interface{}
+	if err := json.Unmarshal(b, &stats); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"rate.count", "rate.m1", "rate.m5", "rate.m15", "rate.mean"} {
+		if _, ok := stats["flush.2878.points."+key]; !ok {
+			t.Errorf("Expected flush.2878.points.%s in agent metrics, found %v", key, stats)
+		}
+	}
+	if count := stats["flush.2878.points.rate.count"]; count != float64(10) {
+		t.Errorf("Expected a count of 10, found %v", count)
+	}
+}
+
+func TestBuildAgentMetricsHistograms(t *testing.T) {
+	metrics.RegisterRuntimeMemStats(metrics.DefaultRegistry)
+	histogram := metrics.GetOrRegisterHistogram("connections.2878.points", nil, metrics.NewUniformSample(100))
+	histogram.Update(2000000)
+
+	b, err := buildAgentMetrics("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stats map[string]interface{}
+	if err := json.Unmarshal(b, &stats); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"count", "min", "max", "mean", "median", "p75", "p95", "p99", "p999"} {
+		if _, ok := stats["connections.2878.points."+key]; !ok {
+			t.Errorf("Expected connections.2878.points.%s in agent metrics, found %v", key, stats)
+		}
+	}
+	if median := stats["connections.2878.points.median"]; median != float64(2000000) {
+		t.Errorf("Expected the raw median of 2000000, found %v", median)
+	}
+	// the keys reported before, scaled as durations in milliseconds
+	if median := stats["connections.2878.points.duration.median"]; median != float64(2) {
+		t.Errorf("Expected the deprecated median of 2, found %v", median)
+	}
+}
@@ -0,0 +1,202 @@
+package agent
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const prometheusNamespace = "wavefront_proxy"
+
+var (
+	prometheusQuantiles = []float64{0.5, 0.75, 0.95, 0.99, 0.999}
+
+	// metric families keyed by listener, where the second name segment is the listener port
+	prometheusPortFamilies = map[string]bool{
+		"backpressure": true,
+		"buffer":       true,
+		"connections":  true,
+		"flush":        true,
+		"ingest":       true,
+		"points":       true,
+		"push":         true,
+	}
+
+	// help text for well known metrics, keyed by metric name with the port removed
+	prometheusHelp = map[string]string{
+		"points.received":   "Points received by the listener.",
+		"points.sent":       "Points flushed to the Wavefront server.",
+		"points.blocked":    "Points dropped because they could not be decoded.",
+		"points.queued":     "Points spooled because the memory buffer was full.",
+		"points.replayed":   "Spooled points flushed to the Wavefront server.",
+		"push.duration":     "Time taken to flush a batch of points in seconds.",
+		"buffer.disk.bytes": "Bytes of points spooled to disk.",
+
+		"buffer.memory.points":     "Points buffered in memory by the listener.",
+		"buffer.memory.points.max": "Most points buffered in memory by the listener, reset every minute.",
+
+		"connections.points":     "Points read per connection by the listener.",
+		"connections.per.source": "Connections per source IP open during each minute.",
+		"connections.sources":    "Source IPs with connections tracked by the listener.",
+
+		"ingest.points.rate.m1":  "Points received per second, averaged over 1 minute.",
+		"ingest.points.rate.m5":  "Points received per second, averaged over 5 minutes.",
+		"ingest.points.rate.m15": "Points received per second, averaged over 15 minutes.",
+		"flush.points.rate.m1":   "Points flushed to the Wavefront server per second, averaged over 1 minute.",
+		"flush.points.rate.m5":   "Points flushed to the Wavefront server per second, averaged over 5 minutes.",
+		"flush.points.rate.m15":  "Points flushed to the Wavefront server per second, averaged over 15 minutes.",
+	}
+)
+
+type prometheusFamily struct {
+	name    string
+	help    string
+	kind    string
+	samples []string
+}
+
+// Serves the metrics registry in the Prometheus text exposition format.
+func PrometheusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	WritePrometheusMetrics(w)
+}
+
+// Writes the metrics registry in the Prometheus text exposition format.
+// The listener port of per-listener metrics is exported as a port label.
+func WritePrometheusMetrics(out io.Writer) error {
+	families := make(map[string]*prometheusFamily)
+	family := func(name, kind string) *prometheusFamily {
+		key, _ := prometheusName(name)
+		f, ok := families[key]
+		if !ok {
+			f = &prometheusFamily{name: key, kind: kind, help: prometheusHelpText(name)}
+			families[key] = f
+		}
+		return f
+	}
+
+	metrics.DefaultRegistry.Each(func(name string, i interface{}) {
+		_, labels := prometheusName(name)
+		switch metric := i.(type) {
+		case metrics.Counter:
+			f := family(name, "counter")
+			f.addSample("", labels, float64(metric.Count()))
+		case metrics.Gauge:
+			f := family(name, "gauge")
+			f.addSample("", labels, float64(metric.Value()))
+		case metrics.GaugeFloat64:
+			f := family(name, "gauge")
+			f.addSample("", labels, metric.Value())
+		case metrics.Meter:
+			// the moving average rates are exported as gauges alongside the count
+			meter := metric.Snapshot()
+			family(name, "counter").addSample("", labels, float64(meter.Count()))
+			family(name+".rate.m1", "gauge").addSample("", labels, meter.Rate1())
+			family(name+".rate.m5", "gauge").addSample("", labels, meter.Rate5())
+			family(name+".rate.m15", "gauge").addSample("", labels, meter.Rate15())
+		case metrics.Timer:
+			// durations are exported in seconds
+			timer := metric.Snapshot()
+			f := family(name, "summary")
+			f.addSummary(labels, timer.Percentiles(prometheusQuantiles), float64(timer.Sum()), timer.Count(), 1e-9)
+		case metrics.Histogram:
+			histo := metric.Snapshot()
+			f := family(name, "summary")
+			f.addSummary(labels, histo.Percentiles(prometheusQuantiles), float64(histo.Sum()), histo.Count(), 1)
+		}
+	})
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := bufio.NewWriter(out)
+	for _, name := range names {
+		f := families[name]
+		sort.Strings(f.samples)
+		fmt.Fprintf(w, "# HELP %s %s\n", f.name, f.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+		for _, sample := range f.samples {
+			w.WriteString(sample)
+		}
+	}
+	return w.Flush()
+}
+
+func (f *prometheusFamily) addSample(suffix, labels string, value float64) {
+	f.samples = append(f.samples, fmt.Sprintf("%s%s%s %s\n", f.name, suffix, labels, formatPrometheusValue(value)))
+}
+
+func (f *prometheusFamily) addSummary(labels string, percentiles []float64, sum float64, count int64, scale float64) {
+	for i, q := range prometheusQuantiles {
+		quantileLabels := addPrometheusLabel(labels, "quantile", strconv.FormatFloat(q, 'f', -1, 64))
+		f.addSample("", quantileLabels, percentiles[i]*scale)
+	}
+	f.addSample("_sum", labels, sum*scale)
+	f.addSample("_count", labels, float64(count))
+}
+
+// Returns the Prometheus metric name and labels for a dotted metric name.
+func prometheusName(name string) (string, string) {
+	parts, port := splitPrometheusPort(name)
+	labels := ""
+	if port != "" {
+		labels = addPrometheusLabel(labels, "port", port)
+	}
+
+	var buf strings.Builder
+	buf.WriteString(prometheusNamespace)
+	for _, part := range parts {
+		buf.WriteByte('_')
+		for _, r := range part {
+			if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+				buf.WriteRune(r)
+			} else {
+				buf.WriteByte('_')
+			}
+		}
+	}
+	return buf.String(), labels
+}
+
+// Splits a dotted metric name into its segments, removing the listener port of
+// per-listener metrics. Numeric segments elsewhere, such as the octets of an IP
+// address, are kept in the name.
+func splitPrometheusPort(name string) ([]string, string) {
+	parts := strings.Split(name, ".")
+	if len(parts) > 2 && prometheusPortFamilies[parts[0]] {
+		if _, err := strconv.Atoi(parts[1]); err == nil {
+			port := parts[1]
+			return append(parts[:1], parts[2:]...), port
+		}
+	}
+	return parts, ""
+}
+
+func prometheusHelpText(name string) string {
+	parts, _ := splitPrometheusPort(name)
+	if help, ok := prometheusHelp[strings.Join(parts, ".")]; ok {
+		return help
+	}
+	return "Proxy metric " + name + "."
+}
+
+func addPrometheusLabel(labels, key, value string) string {
+	label := key + "=" + strconv.Quote(value)
+	if labels == "" {
+		return "{" + label + "}"
+	}
+	return labels[:len(labels)-1] + "," + label + "}"
+}
+
+func formatPrometheusValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
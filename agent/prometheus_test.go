@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestWritePrometheusMetrics(t *testing.T) {
+	metrics.GetOrRegisterCounter("points.2878.received", nil).Inc(5)
+	metrics.GetOrRegisterCounter("points.4242.received", nil).Inc(2)
+	metrics.GetOrRegisterGauge("buffer.disk.bytes", nil).Update(100)
+	metrics.GetOrRegisterTimer("push.2878.duration", nil).Update(2 * time.Second)
+	metrics.GetOrRegisterMeter("ingest.2878.points", nil).Mark(3)
+
+	var buf bytes.Buffer
+	if err := WritePrometheusMetrics(&buf); err != nil {
+		t.Fatal(err)
+	}
+	output := buf.String()
+
+	expected := []string{
+		"# HELP wavefront_proxy_points_received Points received by the listener.\n" +
+			"# TYPE wavefront_proxy_points_received counter\n" +
+			"wavefront_proxy_points_received{port=\"2878\"} 5\n" +
+			"wavefront_proxy_points_received{port=\"4242\"} 2\n",
+		"# TYPE wavefront_proxy_buffer_disk_bytes gauge\nwavefront_proxy_buffer_disk_bytes 100\n",
+		"# TYPE wavefront_proxy_push_duration summary\n",
+		"wavefront_proxy_push_duration{port=\"2878\",quantile=\"0.99\"} 2\n",
+		"wavefront_proxy_push_duration_sum{port=\"2878\"} 2\n",
+		"wavefront_proxy_push_duration_count{port=\"2878\"} 1\n",
+		"# TYPE wavefront_proxy_ingest_points counter\nwavefront_proxy_ingest_points{port=\"2878\"} 3\n",
+		"# HELP wavefront_proxy_ingest_points_rate_m5 Points received per second, averaged over 5 minutes.\n" +
+			"# TYPE wavefront_proxy_ingest_points_rate_m5 gauge\n",
+	}
+	for _, s := range expected {
+		if !strings.Contains(output, s) {
+			t.Errorf("Expected output to contain %q, found:\n%s", s, output)
+		}
+	}
+}
+
+func TestPrometheusNumericSegments(t *testing.T) {
+	metrics.GetOrRegisterCounter("ratelimit.10.0.0.1.dropped", nil).Inc(4)
+	metrics.GetOrRegisterCounter("push.shard.10.0.0.2.points", nil).Inc(1)
+
+	var buf bytes.Buffer
+	if err := WritePrometheusMetrics(&buf); err != nil {
+		t.Fatal(err)
+	}
+	output := buf.String()
+
+	for _, s := range []string{
+		"wavefront_proxy_ratelimit_10_0_0_1_dropped 4\n",
+		"wavefront_proxy_push_shard_10_0_0_2_points 1\n",
+	} {
+		if !strings.Contains(output, s) {
+			t.Errorf("Expected output to contain %q, found:\n%s", s, output)
+		}
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		start := strings.IndexByte(line, '{')
+		if strings.HasPrefix(line, "#") || start < 0 {
+			continue
+		}
+		seen := make(map[string]bool)
+		for _, label := range strings.Split(line[start+1:strings.IndexByte(line, '}')], ",") {
+			key := label[:strings.IndexByte(label, '=')]
+			if seen[key] {
+				t.Errorf("Duplicate label %q in %q", key, line)
+			}
+			seen[key] = true
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package agent
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// serializes the captures, which report the mallocs, frees and GC pauses since the previous one
+var runtimeStatsMtx sync.Mutex
+
+// Updates the Go runtime statistics registered by InitAgent, such as runtime.NumGoroutine,
+// runtime.MemStats.HeapAlloc and the runtime.MemStats.PauseNs histogram of GC pauses.
+func captureRuntimeStats() {
+	runtimeStatsMtx.Lock()
+	defer runtimeStatsMtx.Unlock()
+	metrics.CaptureRuntimeMemStatsOnce(metrics.DefaultRegistry)
+}
+
+// Captures the runtime statistics every interval, so they are current for the Prometheus
+// endpoint and leaks show between check-ins.
+func captureRuntimeStatsEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		captureRuntimeStats()
+	}
+}
@@ -0,0 +1,34 @@
+package agent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestCaptureRuntimeStatsEvery(t *testing.T) {
+	metrics.RegisterRuntimeMemStats(metrics.DefaultRegistry)
+	goroutines := metrics.DefaultRegistry.Get("runtime.NumGoroutine").(metrics.Gauge)
+	heapAlloc := metrics.DefaultRegistry.Get("runtime.MemStats.HeapAlloc").(metrics.Gauge)
+	captureRuntimeStats()
+	before := goroutines.Value()
+
+	// leaked goroutines show without a check-in
+	done := make(chan struct{})
+	defer close(done)
+	for i := 0; i < 10; i++ {
+		go func() { <-done }()
+	}
+	go captureRuntimeStatsEvery(10 * time.Millisecond)
+	deadline := time.Now().Add(time.Second)
+	for goroutines.Value() < before+10 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if goroutines.Value() < before+10 {
+		t.Errorf("Expected at least %d goroutines, found %d", before+10, goroutines.Value())
+	}
+	if heapAlloc.Value() <= 0 {
+		t.Errorf("Expected the heap allocation captured, found %d", heapAlloc.Value())
+	}
+}
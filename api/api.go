@@ -2,20 +2,51 @@ package api
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/rcrowley/go-metrics"
 	"github.com/wavefronthq/go-proxy/config"
+	"github.com/wavefronthq/go-proxy/logger"
+)
+
+const (
+	// bytes of a response body kept for its error details
+	maxResponseBody = 4096
+	// bytes of a response body read past the kept bytes so the connection can be reused,
+	// longer responses close the connection
+	maxDrainBody = 1 << 20
+
+	// time allowed for each post of points, including reading the response
+	DefaultFlushTimeout = 30 * time.Second
 )
 
 var (
-	client     = &http.Client{Timeout: time.Second * 30}
+	retryBaseDelay = time.Millisecond * 500
+	retryMaxDelay  = time.Second * 30
+
 	pointError = errors.New("Invalid points")
+
+	retriedBatches  = metrics.GetOrRegisterCounter("push.retries", nil)
+	backoffDelay    = metrics.GetOrRegisterGauge("push.backoff.delay", nil)
+	timedOutFlushes = metrics.GetOrRegisterCounter("push.timeouts", nil)
+
+	uncompressedBytes = metrics.GetOrRegisterMeter("push.bytes.uncompressed", nil)
+	compressedBytes   = metrics.GetOrRegisterMeter("push.bytes.compressed", nil)
+
+	// time of each post to the server, per server once the service writes to additional servers
+	flushLatency = metrics.GetOrRegisterTimer("flush.latency", nil)
 )
 
 // API interface for the agent.
@@ -28,14 +59,48 @@ type WavefrontAPI interface {
 }
 
 type WavefrontAPIService struct {
-	ServerURL string
-	AgentID   string
-	Hostname  string
-	Token     string
-	Version   string
+	ServerURL    string
+	AgentID      string
+	Hostname     string
+	Token        string
+	Version      string
+	FlushRetries int
+	// time allowed for each post of points, DefaultFlushTimeout if 0
+	FlushTimeout time.Duration
+	GzipUpload   bool
+	// if set the service is in dry run mode, points are recorded instead of sent and the server is not contacted
+	DryRun *DryRunWriter
+	// if set points are posted to the direct ingestion API with the token as a bearer token, and
+	// the agent endpoints are not called as the proxy does not register
+	DirectIngestion bool
+	// if set posts are not attempted while the circuit is open
+	Breaker  *CircuitBreaker
+	tokenMtx sync.RWMutex
+	latency  metrics.Timer // flush.latency.<server host> if the points are also sent to other servers
+}
+
+// Replaces the token used for subsequent requests.
+func (service *WavefrontAPIService) SetToken(token string) {
+	service.tokenMtx.Lock()
+	defer service.tokenMtx.Unlock()
+	service.Token = token
+}
+
+// Returns the token used for requests.
+func (service *WavefrontAPIService) CurrentToken() string {
+	return service.token()
+}
+
+func (service *WavefrontAPIService) token() string {
+	service.tokenMtx.RLock()
+	defer service.tokenMtx.RUnlock()
+	return service.Token
 }
 
 func (service *WavefrontAPIService) GetConfig(currentMillis, bytesLeft, bytesPerMinute, currentQueueSize int64) (*config.AgentConfig, error) {
+	if service.DryRun != nil || service.DirectIngestion {
+		return &config.AgentConfig{}, nil
+	}
 	apiURL := service.ServerURL + getConfigSuffix
 	apiURL = fmt.Sprintf(apiURL, service.AgentID)
 
@@ -46,7 +111,7 @@ func (service *WavefrontAPIService) GetConfig(currentMillis, bytesLeft, bytesPer
 
 	q := req.URL.Query()
 	q.Add(hostnameParam, service.Hostname)
-	q.Add(tokenParam, service.Token)
+	q.Add(tokenParam, service.token())
 	q.Add(versionParam, service.Version)
 	q.Add(currentMillisParam, strconv.FormatInt(currentMillis, 10))
 	q.Add(bytesLeftParam, strconv.FormatInt(bytesLeft, 10))
@@ -54,7 +119,7 @@ func (service *WavefrontAPIService) GetConfig(currentMillis, bytesLeft, bytesPer
 	q.Add(currentQueueSizeParam, strconv.FormatInt(currentQueueSize, 10))
 	req.URL.RawQuery = q.Encode()
 
-	resp, err := client.Do(req)
+	resp, err := clientFor(service.ServerURL).Do(req)
 	if err != nil {
 		return &config.AgentConfig{}, err
 	}
@@ -66,6 +131,9 @@ func (service *WavefrontAPIService) GetConfig(currentMillis, bytesLeft, bytesPer
 }
 
 func (service *WavefrontAPIService) Checkin(currentMillis int64, localAgent, pushAgent, ephemeral bool, agentMetrics []byte) (*config.AgentConfig, error) {
+	if service.DryRun != nil || service.DirectIngestion {
+		return &config.AgentConfig{}, nil
+	}
 	apiURL := service.ServerURL + checkinSuffix
 	apiURL = fmt.Sprintf(apiURL, service.AgentID)
 
@@ -77,7 +145,7 @@ func (service *WavefrontAPIService) Checkin(currentMillis int64, localAgent, pus
 
 	q := req.URL.Query()
 	q.Add(hostnameParam, service.Hostname)
-	q.Add(tokenParam, service.Token)
+	q.Add(tokenParam, service.token())
 	q.Add(versionParam, service.Version)
 	q.Add(currentMillisParam, strconv.FormatInt(currentMillis, 10))
 	q.Add(localParam, strconv.FormatBool(localAgent))
@@ -85,7 +153,7 @@ func (service *WavefrontAPIService) Checkin(currentMillis int64, localAgent, pus
 	q.Add(ephemeralParam, strconv.FormatBool(ephemeral))
 	req.URL.RawQuery = q.Encode()
 
-	resp, err := client.Do(req)
+	resp, err := clientFor(service.ServerURL).Do(req)
 	if err != nil {
 		return &config.AgentConfig{}, err
 	}
@@ -100,29 +168,200 @@ func (service *WavefrontAPIService) PostData(workUnitId, format, pointLines stri
 	if pointLines == "" {
 		return &http.Response{}, pointError
 	}
+	if service.DryRun != nil {
+		// encode to measure the compression cost and ratio
+		if _, err := service.encodeBody(pointLines); err != nil {
+			return &http.Response{}, err
+		}
+		return service.DryRun.write(format, pointLines)
+	}
+	if service.Breaker != nil && !service.Breaker.allow() {
+		return &http.Response{}, ErrCircuitOpen
+	}
 
-	apiURL := service.ServerURL + postDataSuffix
-	apiURL = fmt.Sprintf(apiURL, service.AgentID, workUnitId, format)
+	apiURL := service.postURL(workUnitId, format)
 
-	req, err := http.NewRequest("POST", apiURL, bytes.NewBufferString(pointLines))
-	req.Header.Set(contentType, textPlain)
+	// the body is encoded once, the retries of a flush are sent with the same body and id
+	body, err := service.encodeBody(pointLines)
 	if err != nil {
 		return &http.Response{}, err
 	}
+	id := nextRequestId()
+	var resp *http.Response
+	for attempt := 0; ; attempt++ {
+		resp, err = service.postData(apiURL, id, body)
+		if !shouldRetry(resp, err) || attempt >= service.FlushRetries {
+			break
+		}
+		delay := getBackoff(attempt)
+		retriedBatches.Inc(1)
+		backoffDelay.Update(int64(delay / time.Millisecond))
+		logger.Warnf("Retrying post %s in %v (attempt %d of %d)", id, delay, attempt+1, service.FlushRetries)
+		time.Sleep(delay)
+	}
+	backoffDelay.Update(0)
+
+	// only batches the server rejected for their points are dropped, others are sent again later
+	if err == nil && !isSuccess(resp) && !isRejection(resp) && resp.StatusCode != NotAcceptableStatusCode {
+		err = fmt.Errorf("error posting data: %s", resp.Status)
+	}
+	logFlush(id, pointLines, resp, err)
+	if err != nil {
+		err = fmt.Errorf("request %s: %v", id, err)
+	}
+	if service.Breaker != nil {
+		service.Breaker.record(err == nil)
+	}
+	if err == nil && resp.StatusCode == http.StatusRequestEntityTooLarge {
+		if first, second, ok := splitBatch(pointLines); ok {
+			splitBatches.Inc(1)
+			return service.postHalves(workUnitId, format, first, second)
+		}
+	}
+	if err == nil && isRejection(resp) {
+		recordRejection(resp, id, pointLines)
+	}
+	return resp, err
+}
 
-	resp, err := client.Do(req)
+// Returns the URL points are posted to, in direct ingestion mode the /report endpoint of the
+// server, without the /api path of the proxy endpoints.
+func (service *WavefrontAPIService) postURL(workUnitId, format string) string {
+	if !service.DirectIngestion {
+		return fmt.Sprintf(service.ServerURL+postDataSuffix, service.AgentID, workUnitId, format)
+	}
+	base := strings.TrimSuffix(strings.TrimSuffix(service.ServerURL, "/"), "/api")
+	// the proxy format of point lines is the wavefront format of the direct ingestion API
+	if format == FormatGraphiteV2 {
+		format = directFormatWavefront
+	}
+	return fmt.Sprintf(base+directIngestionSuffix, format)
+}
+
+func (service *WavefrontAPIService) latencyTimer() metrics.Timer {
+	if service.latency != nil {
+		return service.latency
+	}
+	return flushLatency
+}
+
+func (service *WavefrontAPIService) postData(apiURL, id string, body []byte) (*http.Response, error) {
+	timeout := service.FlushTimeout
+	if timeout <= 0 {
+		timeout = DefaultFlushTimeout
+	}
+	// canceling the context closes the connection of a request still in flight
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewReader(body))
 	if err != nil {
-		return resp, err
+		return &http.Response{}, err
+	}
+	req.Header.Set(contentType, textPlain)
+	req.Header.Set(requestIdHeader, id)
+	if service.DirectIngestion {
+		req.Header.Set(authorizationHeader, "Bearer "+service.token())
+	}
+	if service.GzipUpload {
+		req.Header.Set(contentEncoding, gzipEncoding)
+	}
+
+	start := time.Now()
+	resp, err := clientFor(service.ServerURL).Do(req)
+	watcher.recordRequest(err)
+	if err != nil {
+		service.latencyTimer().UpdateSince(start)
+		return resp, timeoutError(ctx, timeout, err)
 	}
 	defer resp.Body.Close()
+	respBody, err := readResponse(resp)
+	service.latencyTimer().UpdateSince(start)
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+	if err != nil {
+		// the server may not have received all the points
+		return resp, fmt.Errorf("error reading response: %v", timeoutError(ctx, timeout, err))
+	}
+	if msg := responseError(respBody); msg != "" && isSuccess(resp) {
+		return resp, fmt.Errorf("error posting data: %s %s", resp.Status, msg)
+	}
 	return resp, nil
 }
 
+// Counts the post as timed out if the flush timeout passed before it completed.
+func timeoutError(ctx context.Context, timeout time.Duration, err error) error {
+	if ctx.Err() != context.DeadlineExceeded {
+		return err
+	}
+	timedOutFlushes.Inc(1)
+	return fmt.Errorf("flush timed out after %v: %v", timeout, err)
+}
+
+// Reads the response to the end, so the connection is reused, keeping the start of the body.
+// Fails if the body is shorter than its length or not terminated properly.
+func readResponse(resp *http.Response) ([]byte, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	if err != nil {
+		return body, err
+	}
+	_, err = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxDrainBody))
+	return body, err
+}
+
+// Returns the error of a JSON response body such as {"error": "..."}, empty if none.
+func responseError(body []byte) string {
+	var r struct {
+		Error string `json:"error"`
+	}
+	if len(body) == 0 || json.Unmarshal(body, &r) != nil {
+		return ""
+	}
+	return r.Error
+}
+
+func isSuccess(resp *http.Response) bool {
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+func (service *WavefrontAPIService) encodeBody(pointLines string) ([]byte, error) {
+	uncompressedBytes.Mark(int64(len(pointLines)))
+	if !service.GzipUpload {
+		return []byte(pointLines), nil
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write([]byte(pointLines)); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	compressedBytes.Mark(int64(buf.Len()))
+	return buf.Bytes(), nil
+}
+
+// Connection errors and server side errors are retried
+func shouldRetry(resp *http.Response, err error) bool {
+	return err != nil || resp.StatusCode >= 500
+}
+
+// Returns an exponential backoff delay with jitter for the given attempt
+func getBackoff(attempt int) time.Duration {
+	delay := retryBaseDelay << uint(attempt)
+	if delay <= 0 || delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)))
+}
+
 func (service *WavefrontAPIService) AgentError(details string) {
-	log.Println("AgentError")
+	logger.Error("AgentError")
 }
 
 func (service *WavefrontAPIService) AgentConfigProcessed() error {
+	if service.DryRun != nil || service.DirectIngestion {
+		return nil
+	}
 	apiURL := service.ServerURL + configProcessedSuffix
 	apiURL = fmt.Sprintf(apiURL, service.AgentID)
 
@@ -131,7 +370,7 @@ func (service *WavefrontAPIService) AgentConfigProcessed() error {
 		return err
 	}
 
-	resp, err := client.Do(req)
+	resp, err := clientFor(service.ServerURL).Do(req)
 	if err != nil {
 		return err
 	}
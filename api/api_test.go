@@ -0,0 +1,352 @@
+package api
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPostDataRetry(t *testing.T) {
+	defer func(delay time.Duration) { retryBaseDelay = delay }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	service := &WavefrontAPIService{ServerURL: server.URL, FlushRetries: 3}
+	timed := flushLatency.Count()
+	resp, err := service.PostData(GraphiteBlockWorkUnit, FormatGraphiteV2, "foo 1 source=bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusAccepted || requests != 3 {
+		t.Errorf("Expected success after 3 requests, found %d after %d", resp.StatusCode, requests)
+	}
+	if n := flushLatency.Count() - timed; n != 3 {
+		t.Errorf("Expected each attempt timed by flush.latency, found %d", n)
+	}
+
+	requests = 0
+	service.FlushRetries = 1
+	_, err = service.PostData(GraphiteBlockWorkUnit, FormatGraphiteV2, "foo 1 source=bar")
+	if err == nil || requests != 2 {
+		t.Errorf("Expected error after 2 requests, found %d requests", requests)
+	}
+}
+
+func TestPostDataRetryGzip(t *testing.T) {
+	defer func(delay time.Duration) { retryBaseDelay = delay }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	service := &WavefrontAPIService{ServerURL: server.URL, FlushRetries: 2, GzipUpload: true}
+	uncompressed, compressed := uncompressedBytes.Count(), compressedBytes.Count()
+	service.PostData(GraphiteBlockWorkUnit, FormatGraphiteV2, "foo 1 source=bar")
+	if len(bodies) != 3 || bodies[0] != bodies[1] || bodies[0] != bodies[2] {
+		t.Fatalf("Expected the retries sent with the same body, found %q", bodies)
+	}
+	if n := uncompressedBytes.Count() - uncompressed; n != int64(len("foo 1 source=bar")) {
+		t.Errorf("Expected the uncompressed bytes counted once, found %d", n)
+	}
+	if n := compressedBytes.Count() - compressed; n != int64(len(bodies[0])) {
+		t.Errorf("Expected the compressed bytes counted once, found %d", n)
+	}
+}
+
+func TestPostDataRequestId(t *testing.T) {
+	defer func(delay time.Duration) { retryBaseDelay = delay }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+	var ids []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ids = append(ids, r.Header.Get("X-WF-Proxy-Request-Id"))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	service := &WavefrontAPIService{ServerURL: server.URL, FlushRetries: 1}
+	_, err := service.PostData(GraphiteBlockWorkUnit, FormatGraphiteV2, "foo 1 source=bar")
+	if len(ids) != 2 || ids[0] == "" || ids[0] != ids[1] {
+		t.Fatalf("Expected the retry sent with the id of the flush, found %q", ids)
+	}
+	if err == nil || !strings.Contains(err.Error(), "request "+ids[0]+":") {
+		t.Errorf("Expected the error to include the request id %s, found %v", ids[0], err)
+	}
+
+	service.PostData(GraphiteBlockWorkUnit, FormatGraphiteV2, "foo 1 source=bar")
+	if len(ids) != 4 || ids[2] == ids[0] || !strings.HasPrefix(ids[2], requestIdNonce+"-") {
+		t.Errorf("Expected a new id with the nonce for the next flush, found %q", ids)
+	}
+}
+
+func TestPostDataGzip(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "gzip" {
+			t.Error("Expected gzip content encoding")
+		}
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(gz)
+		body = string(b)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	service := &WavefrontAPIService{ServerURL: server.URL, GzipUpload: true}
+	_, err := service.PostData(GraphiteBlockWorkUnit, FormatGraphiteV2, "foo 1 source=bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "foo 1 source=bar" {
+		t.Errorf("Unexpected body %q", body)
+	}
+}
+
+func TestPostDataDirectIngestion(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.RequestURI()+" "+r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	service := &WavefrontAPIService{ServerURL: server.URL + "/api/", AgentID: "agent", Token: "XXX", DirectIngestion: true}
+	if _, err := service.PostData(GraphiteBlockWorkUnit, FormatGraphiteV2, "foo 1 source=bar"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.PostData(GraphiteBlockWorkUnit, FormatHistogram, "!M 1 #1 1 foo source=bar"); err != nil {
+		t.Fatal(err)
+	}
+	// the proxy does not register or fetch its configuration
+	if _, err := service.Checkin(0, false, true, false, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.GetConfig(0, 0, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.AgentConfigProcessed(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"/report?f=wavefront Bearer XXX", "/report?f=histogram Bearer XXX"}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("Expected requests %q, found %q", expected, requests)
+	}
+}
+
+func TestPostDataRejected(t *testing.T) {
+	responses := map[string]struct {
+		status   int
+		body     string
+		rejected int64
+		failed   bool // sent again later
+	}{
+		"json":          {http.StatusBadRequest, `{"rejected": 2, "message": "invalid points"}`, 2, false},
+		"text":          {http.StatusBadRequest, "invalid points\n", 3, false},
+		"over count":    {http.StatusBadRequest, `{"rejected": 10}`, 3, false},
+		"single line":   {http.StatusRequestEntityTooLarge, "", 1, false},
+		"not accepted":  {NotAcceptableStatusCode, "", 0, false},
+		"accepted":      {http.StatusAccepted, "", 0, false},
+		"unauthorized":  {http.StatusUnauthorized, "", 0, true},
+		"forbidden":     {http.StatusForbidden, "", 0, true},
+		"timeout":       {http.StatusRequestTimeout, "", 0, true},
+		"too many":      {http.StatusTooManyRequests, "", 0, true},
+		"not supported": {http.StatusNotFound, "", 0, true},
+	}
+	for name, r := range responses {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(r.status)
+			w.Write([]byte(r.body))
+		}))
+		service := &WavefrontAPIService{ServerURL: server.URL}
+		rejected := rejectedPoints.Count()
+		points := "foo 1 source=a\nfoo 2 source=a\nfoo 3 source=a"
+		if r.status == http.StatusRequestEntityTooLarge {
+			points = "foo 1 source=a"
+		}
+		_, err := service.PostData(GraphiteBlockWorkUnit, FormatGraphiteV2, points)
+		if (err != nil) != r.failed {
+			t.Errorf("Expected the %s response failed %t, found %v", name, r.failed, err)
+		}
+		if n := rejectedPoints.Count() - rejected; n != r.rejected {
+			t.Errorf("Expected %d rejected points for the %s response, found %d", r.rejected, name, n)
+		}
+		server.Close()
+	}
+}
+
+func TestPostDataSplit(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		if strings.Count(string(b), "\n") > 0 {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		received = append(received, string(b))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	service := &WavefrontAPIService{ServerURL: server.URL}
+	resp, err := service.PostData(GraphiteBlockWorkUnit, FormatGraphiteV2, "foo 1 source=a\nfoo 2 source=a\nfoo 3 source=a")
+	if err != nil || resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected the halves to be accepted, found %v %v", resp.Status, err)
+	}
+	if strings.Join(received, "\n") != "foo 1 source=a\nfoo 2 source=a\nfoo 3 source=a" {
+		t.Errorf("Expected each point posted once in order, found %q", received)
+	}
+
+	// single points are too large to split
+	rejected := rejectedPoints.Count()
+	resp, err = service.PostData(GraphiteBlockWorkUnit, FormatGraphiteV2, "foo 1 source=a\n")
+	if err != nil || resp.StatusCode != http.StatusRequestEntityTooLarge || rejectedPoints.Count()-rejected != 1 {
+		t.Errorf("Expected 1 rejected point, found %d", rejectedPoints.Count()-rejected)
+	}
+}
+
+func TestSplitBatch(t *testing.T) {
+	tests := map[string][2]string{
+		"a\nb":        {"a", "b"},
+		"a\nb\nc":     {"a", "b\nc"},
+		"aaaaaa\nb":   {"aaaaaa", "b"},
+		"a\nbbbbbbbb": {"a", "bbbbbbbb"},
+	}
+	for lines, expected := range tests {
+		first, second, ok := splitBatch(lines)
+		if !ok || first != expected[0] || second != expected[1] {
+			t.Errorf("Expected %q split into %q, found %q %q", lines, expected, first, second)
+		}
+	}
+	for _, lines := range []string{"a", "a\n", "\na"} {
+		if _, _, ok := splitBatch(lines); ok {
+			t.Errorf("Expected %q not to be split", lines)
+		}
+	}
+}
+
+func TestPostDataResponses(t *testing.T) {
+	var connections int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		// the response is selected by the first path segment of the server URL
+		switch strings.Split(r.URL.Path, "/")[1] {
+		case "truncated":
+			// the connection is closed before the promised body is sent
+			conn, buf, _ := w.(http.Hijacker).Hijack()
+			buf.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\npartial")
+			buf.Flush()
+			conn.Close()
+		case "error":
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"error": "internal failure"}`))
+		case "redirect":
+			w.WriteHeader(http.StatusFound)
+		case "rejected":
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(strings.Repeat("ok", 10000)))
+		}
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&connections, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	service := &WavefrontAPIService{}
+	for _, failure := range []string{"truncated", "error", "redirect"} {
+		service.ServerURL = server.URL + "/" + failure
+		if _, err := service.PostData(GraphiteBlockWorkUnit, FormatGraphiteV2, "foo 1 source=a"); err == nil {
+			t.Errorf("Expected an error for the %s response", failure)
+		}
+	}
+
+	service.ServerURL = server.URL + "/rejected"
+	if resp, err := service.PostData(GraphiteBlockWorkUnit, FormatGraphiteV2, "foo 1 source=a"); err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected the rejection returned without an error, found %v", err)
+	}
+
+	// responses read to the end leave the connection to be reused
+	service.ServerURL = server.URL + "/accepted"
+	atomic.StoreInt32(&connections, 0)
+	for i := 0; i < 3; i++ {
+		if _, err := service.PostData(GraphiteBlockWorkUnit, FormatGraphiteV2, "foo 1 source=a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&connections); n > 1 {
+		t.Errorf("Expected the connection to be reused, found %d connections", n)
+	}
+}
+
+func TestPostDataTimeout(t *testing.T) {
+	torn := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		stall := strings.Split(r.URL.Path, "/")[1]
+		if stall == "body" {
+			// stalls once the response has started
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte("partial"))
+			w.(http.Flusher).Flush()
+		}
+		// the request context ends once the proxy closes the connection
+		select {
+		case <-r.Context().Done():
+			torn <- stall
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	service := &WavefrontAPIService{FlushTimeout: 100 * time.Millisecond}
+	timedOut := timedOutFlushes.Count()
+	for _, stall := range []string{"headers", "body"} {
+		service.ServerURL = server.URL + "/" + stall
+		start := time.Now()
+		_, err := service.PostData(GraphiteBlockWorkUnit, FormatGraphiteV2, "foo 1 source=a")
+		if err == nil || !strings.Contains(err.Error(), "timed out after 100ms") {
+			t.Errorf("Expected a timeout for a server stalling the %s, found %v", stall, err)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("Expected the post aborted after the flush timeout, took %v", elapsed)
+		}
+		select {
+		case s := <-torn:
+			if s != stall {
+				t.Errorf("Expected the request stalling the %s torn down, found %s", stall, s)
+			}
+		case <-time.After(2 * time.Second):
+			t.Errorf("Expected the request stalling the %s torn down", stall)
+		}
+	}
+	if n := timedOutFlushes.Count() - timedOut; n != 2 {
+		t.Errorf("Expected 2 timed out flushes, found %d", n)
+	}
+}
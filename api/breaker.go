@@ -0,0 +1,104 @@
+package api
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/logger"
+)
+
+// States of a circuit breaker, as reported by its gauge.
+const (
+	CircuitClosed = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+var circuitStates = []string{"closed", "open", "half-open"}
+
+var ErrCircuitOpen = errors.New("circuit open, not posting to the server")
+
+// Stops posting to a server after a number of consecutive failed posts, so flushes do not pile
+// up against a server that is down and points stay buffered instead. Once the cooldown elapses
+// a single post probes the server, closing the circuit if it succeeds and opening it again if not.
+type CircuitBreaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+	mtx       sync.Mutex
+	state     int
+	failures  int
+	openedAt  time.Time
+	gauge     metrics.Gauge
+	now       func() time.Time
+}
+
+// Opens the circuit of the server after threshold consecutive failures. The state is reported
+// by the push.circuit.<server host>.state gauge.
+func NewCircuitBreaker(serverURL string, threshold int, cooldown time.Duration) *CircuitBreaker {
+	name := destinationName(serverURL)
+	b := &CircuitBreaker{
+		name:      name,
+		threshold: threshold,
+		cooldown:  cooldown,
+		gauge:     metrics.GetOrRegisterGauge("push.circuit."+name+".state", nil),
+		now:       time.Now,
+	}
+	b.gauge.Update(CircuitClosed)
+	return b
+}
+
+func (b *CircuitBreaker) State() int {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.state
+}
+
+// Returns whether a post may be attempted. Only the probe is attempted while half open.
+func (b *CircuitBreaker) allow() bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	switch b.state {
+	case CircuitOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setState(CircuitHalfOpen)
+		return true
+	case CircuitHalfOpen:
+		return false
+	}
+	return true
+}
+
+// Records the result of a post.
+func (b *CircuitBreaker) record(ok bool) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if ok {
+		b.failures = 0
+		b.setState(CircuitClosed)
+		return
+	}
+	b.failures++
+	if b.state == CircuitHalfOpen || b.failures >= b.threshold {
+		b.openedAt = b.now()
+		b.setState(CircuitOpen)
+	}
+}
+
+func (b *CircuitBreaker) setState(state int) {
+	if state == b.state {
+		return
+	}
+	switch state {
+	case CircuitOpen:
+		logger.Warnf("%s: circuit open after %d consecutive failed posts, retrying in %v", b.name, b.failures, b.cooldown)
+	default:
+		logger.Infof("%s: circuit %s", b.name, circuitStates[state])
+	}
+	b.state = state
+	b.gauge.Update(int64(state))
+}
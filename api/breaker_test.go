@@ -0,0 +1,62 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	b := NewCircuitBreaker("https://breaker.wavefront.com/api", 2, 30*time.Second)
+	b.now = func() time.Time { return now }
+
+	b.record(false)
+	if !b.allow() || b.State() != CircuitClosed {
+		t.Errorf("Expected the circuit closed after 1 failure, found %d", b.State())
+	}
+	b.record(false)
+	if b.allow() || b.State() != CircuitOpen || b.gauge.Value() != CircuitOpen {
+		t.Errorf("Expected the circuit open after 2 failures, found %d", b.State())
+	}
+
+	// a single probe once the cooldown elapses, which reopens the circuit when it fails
+	now = now.Add(30 * time.Second)
+	if !b.allow() || b.allow() || b.State() != CircuitHalfOpen {
+		t.Errorf("Expected a single probe while half open, found %d", b.State())
+	}
+	b.record(false)
+	if b.allow() || b.State() != CircuitOpen {
+		t.Errorf("Expected the circuit open after a failed probe, found %d", b.State())
+	}
+
+	now = now.Add(30 * time.Second)
+	if !b.allow() {
+		t.Error("Expected a probe after the cooldown")
+	}
+	b.record(true)
+	if !b.allow() || b.State() != CircuitClosed || b.gauge.Value() != CircuitClosed {
+		t.Errorf("Expected the circuit closed after a successful probe, found %d", b.State())
+	}
+}
+
+func TestPostDataCircuitOpen(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	service := &WavefrontAPIService{ServerURL: server.URL, Breaker: NewCircuitBreaker(server.URL, 1, time.Minute)}
+	if _, err := service.PostData(GraphiteBlockWorkUnit, FormatGraphiteV2, "foo 1 source=bar"); err == nil {
+		t.Error("Expected the post to fail")
+	}
+	if _, err := service.PostData(GraphiteBlockWorkUnit, FormatGraphiteV2, "foo 1 source=bar"); err != ErrCircuitOpen {
+		t.Errorf("Expected %v, found %v", ErrCircuitOpen, err)
+	}
+	if requests != 1 {
+		t.Errorf("Expected 1 request, found %d", requests)
+	}
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/logger"
+)
+
+const (
+	DefaultMaxIdleConns    = 100
+	DefaultIdleConnTimeout = 90 * time.Second
+	DefaultTimeout         = 30 * time.Second
+)
+
+var (
+	client = &http.Client{Timeout: DefaultTimeout}
+	// client with the TLS settings of the server, used only for requests to the serverClientURL
+	serverClient    *http.Client
+	serverClientURL string
+
+	activeConnections = metrics.GetOrRegisterGauge("api.connections.active", nil)
+	openedConnections = metrics.GetOrRegisterCounter("api.connections.opened", nil)
+	connectionsMtx    sync.Mutex
+	connections       int64
+)
+
+// Settings for the HTTP client used to reach the Wavefront server.
+type ClientConfig struct {
+	ServerURL string
+	HttpProxy string // proxy URL, may include credentials. Uses the environment if empty.
+	// idle connections kept for reuse by later requests, DefaultMaxIdleConns if 0
+	MaxIdleConns int
+	// time idle connections are kept for, DefaultIdleConnTimeout if 0
+	IdleConnTimeout time.Duration
+	// time allowed for a request including reading the response, DefaultTimeout if 0
+	Timeout time.Duration
+	// interval at which the hosts connected to are resolved again, disabled if 0
+	DNSRefreshInterval time.Duration
+	// CA bundle the server certificate is verified with instead of the system CAs if set
+	CAFile string
+	// name sent with SNI and verified against the server certificate instead of the server host
+	ServerName string
+	// disables verifying the server certificate
+	InsecureSkipVerify bool
+}
+
+// Configures the HTTP client used for all requests to the Wavefront server. Connections are
+// reused by the flushes of all listeners, so enough idle connections should be kept for the
+// concurrent flushes to avoid new connections and TLS handshakes. The TLS settings only apply
+// to the ServerURL, not to the additional or internal metrics servers.
+func ConfigureClient(cfg ClientConfig) error {
+	if cfg.MaxIdleConns <= 0 {
+		cfg.MaxIdleConns = DefaultMaxIdleConns
+	}
+	if cfg.IdleConnTimeout <= 0 {
+		cfg.IdleConnTimeout = DefaultIdleConnTimeout
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	if cfg.HttpProxy != "" {
+		proxyURL, err := url.Parse(cfg.HttpProxy)
+		if err != nil {
+			return err
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	// all requests go to the server or the proxy, so the idle connections are for a single host
+	transport.MaxIdleConns = cfg.MaxIdleConns
+	transport.MaxIdleConnsPerHost = cfg.MaxIdleConns
+	transport.IdleConnTimeout = cfg.IdleConnTimeout
+	tlsConfig, err := newClientTLSConfig(cfg)
+	if err != nil {
+		return err
+	}
+	transports := []*http.Transport{transport}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	dns := newDNSWatcher(net.DefaultResolver.LookupHost, func() {
+		for _, transport := range transports {
+			transport.CloseIdleConnections()
+		}
+	})
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		openedConnections.Inc(1)
+		updateConnections(1)
+		dns.recordDial(addr, conn)
+		return &countedConn{Conn: conn}, nil
+	}
+
+	// stops resolving the hosts of the previous client
+	close(watcher.stop)
+	if cfg.DNSRefreshInterval > 0 {
+		go dns.run(cfg.DNSRefreshInterval)
+	}
+	watcher = dns
+
+	client.Transport = transport
+	client.Timeout = cfg.Timeout
+	serverClient, serverClientURL = nil, ""
+	if tlsConfig != nil {
+		serverTransport := transport.Clone()
+		serverTransport.TLSClientConfig = tlsConfig
+		transports = append(transports, serverTransport)
+		serverClient = &http.Client{Transport: serverTransport, Timeout: cfg.Timeout}
+		serverClientURL = cfg.ServerURL
+	}
+	logProxy(transport, cfg.ServerURL)
+	return nil
+}
+
+// Returns the TLS configuration for connections to the server, or nil to keep the defaults
+// verifying the server host with the system CAs.
+func newClientTLSConfig(cfg ClientConfig) (*tls.Config, error) {
+	if cfg.CAFile == "" && cfg.ServerName == "" && !cfg.InsecureSkipVerify {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{ServerName: cfg.ServerName, InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.CAFile != "" {
+		caCert, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, errors.New("no valid certificates found in " + cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.InsecureSkipVerify {
+		logger.Warn("Not verifying the certificate of", cfg.ServerURL)
+	}
+	return tlsConfig, nil
+}
+
+// Returns the client for requests to the server URL.
+func clientFor(serverURL string) *http.Client {
+	if serverClient != nil && serverURL == serverClientURL {
+		return serverClient
+	}
+	return client
+}
+
+func updateConnections(delta int64) {
+	connectionsMtx.Lock()
+	connections += delta
+	activeConnections.Update(connections)
+	connectionsMtx.Unlock()
+}
+
+// Connection counted in the active connections until closed.
+type countedConn struct {
+	net.Conn
+	closeOnce sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.closeOnce.Do(func() { updateConnections(-1) })
+	return c.Conn.Close()
+}
+
+func logProxy(transport *http.Transport, serverURL string) {
+	req, err := http.NewRequest("GET", serverURL, nil)
+	if err != nil {
+		return
+	}
+	proxyURL, err := transport.Proxy(req)
+	if err != nil {
+		logger.Error("Error resolving proxy:", err)
+		return
+	}
+	if proxyURL == nil {
+		logger.Info("Not using a proxy for", serverURL)
+		return
+	}
+	logger.Info("Using proxy", proxyURL.Redacted(), "for", serverURL)
+}
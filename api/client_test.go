@@ -0,0 +1,171 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHttpProxy(t *testing.T) {
+	var proxyAuth, host string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxyAuth = r.Header.Get("Proxy-Authorization")
+		host = r.URL.Host
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer proxy.Close()
+	defer func() { client.Transport = nil }()
+
+	serverURL := "http://wavefront.example.com/api"
+	proxyURL := strings.Replace(proxy.URL, "http://", "http://user:pass@", 1)
+	err := ConfigureClient(ClientConfig{ServerURL: serverURL, HttpProxy: proxyURL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := &WavefrontAPIService{ServerURL: serverURL}
+	_, err = service.PostData(GraphiteBlockWorkUnit, FormatGraphiteV2, "foo 1 source=bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host != "wavefront.example.com" || proxyAuth == "" {
+		t.Errorf("Expected request for wavefront.example.com with proxy credentials, found %q %q", host, proxyAuth)
+	}
+}
+
+func TestClientConnections(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("format") == "slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+	defer func() { client.Transport, client.Timeout = nil, DefaultTimeout }()
+
+	err := ConfigureClient(ClientConfig{ServerURL: server.URL, MaxIdleConns: 4, Timeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened := openedConnections.Count()
+	service := &WavefrontAPIService{ServerURL: server.URL}
+	for i := 0; i < 5; i++ {
+		if _, err := service.PostData(GraphiteBlockWorkUnit, FormatGraphiteV2, "foo 1 source=bar"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := openedConnections.Count() - opened; n != 1 || activeConnections.Value() != 1 {
+		t.Errorf("Expected 1 connection reused by all requests, found %d opened and %d active", n, activeConnections.Value())
+	}
+
+	if _, err := service.PostData(GraphiteBlockWorkUnit, "slow", "foo 1 source=bar"); err == nil {
+		t.Error("Expected the request to time out")
+	}
+	client.Transport.(*http.Transport).CloseIdleConnections()
+	if activeConnections.Value() != 0 {
+		t.Errorf("Expected no active connections once closed, found %d", activeConnections.Value())
+	}
+}
+
+func TestClientTLS(t *testing.T) {
+	// self-signed certificate for a name the server is not reached by, written as the CA bundle
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "wavefront.internal"},
+		DNSNames:              []string{"wavefront.internal"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	cert, err := tls.X509KeyPair(certPEM, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := ioutil.WriteFile(caFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	invalidFile := filepath.Join(t.TempDir(), "invalid.pem")
+	if err := ioutil.WriteFile(invalidFile, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var serverName string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serverName = r.TLS.ServerName
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.StartTLS()
+	defer server.Close()
+	saved := client.Transport
+	defer func() { client.Transport, serverClient, serverClientURL = saved, nil, "" }()
+
+	tests := []struct {
+		name string
+		cfg  ClientConfig
+		ok   bool
+	}{
+		{"system CAs", ClientConfig{}, false},
+		{"custom CA", ClientConfig{CAFile: caFile}, false},
+		{"server name", ClientConfig{ServerName: "wavefront.internal"}, false},
+		{"custom CA and server name", ClientConfig{CAFile: caFile, ServerName: "wavefront.internal"}, true},
+		{"insecure", ClientConfig{InsecureSkipVerify: true}, true},
+	}
+	service := &WavefrontAPIService{ServerURL: server.URL}
+	for _, test := range tests {
+		test.cfg.ServerURL = server.URL
+		if err := ConfigureClient(test.cfg); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		serverName = ""
+		resp, err := service.PostData(GraphiteBlockWorkUnit, FormatGraphiteV2, "foo 1 source=bar")
+		if ok := err == nil && resp.StatusCode == http.StatusAccepted; ok != test.ok {
+			t.Errorf("%s: expected success %t, found %v", test.name, test.ok, err)
+		}
+		if test.ok && serverName != test.cfg.ServerName {
+			t.Errorf("%s: expected SNI %q, found %q", test.name, test.cfg.ServerName, serverName)
+		}
+	}
+
+	// other servers keep verifying their own host with the system CAs
+	if err := ConfigureClient(ClientConfig{ServerURL: server.URL, CAFile: caFile, ServerName: "wavefront.internal"}); err != nil {
+		t.Fatal(err)
+	}
+	other := clientFor("https://other.example.com").Transport.(*http.Transport).TLSClientConfig
+	if other != nil && (other.ServerName != "" || other.RootCAs != nil) {
+		t.Errorf("Expected the TLS settings applied to the server only, found server name %q", other.ServerName)
+	}
+
+	if err := ConfigureClient(ClientConfig{ServerURL: server.URL, CAFile: invalidFile}); err == nil {
+		t.Error("Expected an error for a CA file without certificates")
+	}
+}
@@ -5,6 +5,8 @@ const (
 	postDataSuffix        = "/daemon/%s/pushdata/%s?format=%s"
 	checkinSuffix         = "/daemon/%s/checkin"
 	configProcessedSuffix = "/daemon/%s/config/processed"
+	directIngestionSuffix = "/report?f=%s"
+	directFormatWavefront = "wavefront"
 	hostnameParam         = "hostname"
 	tokenParam            = "token"
 	versionParam          = "version"
@@ -16,10 +18,15 @@ const (
 	pushParam             = "push"
 	ephemeralParam        = "ephemeral"
 	contentType           = "Content-Type"
+	contentEncoding       = "Content-Encoding"
+	gzipEncoding          = "gzip"
 	textPlain             = "text/plain"
 	applicationJSON       = "application/json"
+	requestIdHeader       = "X-WF-Proxy-Request-Id"
+	authorizationHeader   = "Authorization"
 
 	NotAcceptableStatusCode = 406
 	FormatGraphiteV2        = "graphite_v2"
+	FormatHistogram         = "histogram"
 	GraphiteBlockWorkUnit   = "12b37289-90b2-4b98-963f-75a27110b8da"
 )
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/logger"
+)
+
+const (
+	// consecutive failed requests after which the idle connections are closed
+	resetAfterFailures = 3
+
+	dnsLookupTimeout = 10 * time.Second
+)
+
+var (
+	dnsChanges       = metrics.GetOrRegisterCounter("api.dns.changes", nil)
+	connectionResets = metrics.GetOrRegisterCounter("api.connections.reset", nil)
+
+	// replaced by ConfigureClient
+	watcher = newDNSWatcher(net.DefaultResolver.LookupHost, func() {})
+)
+
+// Resolves the hosts the client connected to again, closing the idle connections once a host no
+// longer resolves to the address connected to, so new connections reach the new address after a
+// failover instead of reusing connections to the old one. The idle connections are also closed
+// after consecutive failed requests, as the server may have moved before the host is resolved again.
+type dnsWatcher struct {
+	mtx       sync.Mutex
+	addrs     map[string]string // host:port dialed to the IP last connected to
+	failures  int
+	lookup    func(ctx context.Context, host string) ([]string, error)
+	closeIdle func()
+	stop      chan struct{}
+}
+
+func newDNSWatcher(lookup func(ctx context.Context, host string) ([]string, error), closeIdle func()) *dnsWatcher {
+	return &dnsWatcher{
+		addrs:     make(map[string]string),
+		lookup:    lookup,
+		closeIdle: closeIdle,
+		stop:      make(chan struct{}),
+	}
+}
+
+// Resolves the hosts again every interval until stopped.
+func (w *dnsWatcher) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.check()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// Records the IP a new connection to the address reached.
+func (w *dnsWatcher) recordDial(addr string, conn net.Conn) {
+	ip, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return
+	}
+	w.mtx.Lock()
+	changed := w.addrs[addr] != ip
+	w.addrs[addr] = ip
+	w.mtx.Unlock()
+	if changed {
+		logger.Debugf("Connected to %s at %s", addr, ip)
+	}
+}
+
+// Closes the idle connections after resetAfterFailures consecutive failed requests.
+func (w *dnsWatcher) recordRequest(err error) {
+	w.mtx.Lock()
+	if err == nil {
+		w.failures = 0
+		w.mtx.Unlock()
+		return
+	}
+	w.failures++
+	reset := w.failures >= resetAfterFailures
+	if reset {
+		w.failures = 0
+	}
+	w.mtx.Unlock()
+
+	if reset {
+		logger.Warnf("%d consecutive requests failed, closing idle connections to the server", resetAfterFailures)
+		connectionResets.Inc(1)
+		w.closeIdle()
+	}
+}
+
+// Resolves the hosts connected to, closing the idle connections if any no longer resolves to
+// the IP connected to. Hosts that cannot be resolved are kept, the connections may still work.
+func (w *dnsWatcher) check() {
+	w.mtx.Lock()
+	addrs := make(map[string]string, len(w.addrs))
+	for addr, ip := range w.addrs {
+		addrs[addr] = ip
+	}
+	w.mtx.Unlock()
+
+	changed := false
+	for addr, ip := range addrs {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), dnsLookupTimeout)
+		ips, err := w.lookup(ctx, host)
+		cancel()
+		if err != nil {
+			logger.Warnf("Error resolving %s: %v", host, err)
+			continue
+		}
+		if containsString(ips, ip) {
+			continue
+		}
+		logger.Infof("%s now resolves to %s instead of %s, closing idle connections", host, strings.Join(ips, ","), ip)
+		dnsChanges.Inc(1)
+		changed = true
+		// recorded again once connected
+		w.mtx.Lock()
+		if w.addrs[addr] == ip {
+			delete(w.addrs, addr)
+		}
+		w.mtx.Unlock()
+	}
+	if changed {
+		w.closeIdle()
+	}
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
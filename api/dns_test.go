@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDNSChange(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+	defer func() { client.Transport = nil }()
+
+	// resolves localhost to the server
+	serverURL := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	if err := ConfigureClient(ClientConfig{ServerURL: serverURL}); err != nil {
+		t.Fatal(err)
+	}
+	resolved := []string{"127.0.0.1"}
+	watcher.lookup = func(ctx context.Context, host string) ([]string, error) {
+		if host != "localhost" {
+			t.Errorf("Expected localhost resolved, found %s", host)
+		}
+		return resolved, nil
+	}
+
+	service := &WavefrontAPIService{ServerURL: serverURL}
+	if _, err := service.PostData(GraphiteBlockWorkUnit, FormatGraphiteV2, "foo 1 source=bar"); err != nil {
+		t.Fatal(err)
+	}
+	changes := dnsChanges.Count()
+	watcher.check()
+	if activeConnections.Value() != 1 || dnsChanges.Count() != changes {
+		t.Errorf("Expected the connection kept while the address is unchanged, found %d active", activeConnections.Value())
+	}
+
+	resolved = []string{"10.0.0.1", "10.0.0.2"}
+	watcher.check()
+	if activeConnections.Value() != 0 || dnsChanges.Count() != changes+1 {
+		t.Errorf("Expected the idle connection closed once the address changed, found %d active", activeConnections.Value())
+	}
+	// not reported again until connected
+	watcher.check()
+	if dnsChanges.Count() != changes+1 {
+		t.Errorf("Expected a single change, found %d", dnsChanges.Count()-changes)
+	}
+}
+
+func TestConnectionReset(t *testing.T) {
+	closed := 0
+	w := newDNSWatcher(nil, func() { closed++ })
+	failed := errors.New("connection refused")
+	w.recordRequest(failed)
+	w.recordRequest(failed)
+	w.recordRequest(nil)
+	w.recordRequest(failed)
+	w.recordRequest(failed)
+	if closed != 0 {
+		t.Fatalf("Expected no reset before %d consecutive failures", resetAfterFailures)
+	}
+	w.recordRequest(failed)
+	if closed != 1 {
+		t.Errorf("Expected the idle connections closed once, found %d", closed)
+	}
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/logger"
+)
+
+var dryRunPoints = metrics.GetOrRegisterCounter("push.dryrun.points", nil)
+
+// Records the batches a service would have sent in dry run mode. Batches are written to
+// the output if set, otherwise a summary of each batch is logged with the points logged at debug level.
+type DryRunWriter struct {
+	mtx    sync.Mutex
+	output io.Writer
+}
+
+func NewDryRunWriter(output io.Writer) *DryRunWriter {
+	return &DryRunWriter{output: output}
+}
+
+func (d *DryRunWriter) write(format, pointLines string) (*http.Response, error) {
+	numPoints := strings.Count(pointLines, "\n") + 1
+	dryRunPoints.Inc(int64(numPoints))
+
+	if d.output == nil {
+		logger.Infof("Dry run, not sending %d %s points", numPoints, format)
+		logger.Debug(pointLines)
+		return &http.Response{StatusCode: http.StatusAccepted}, nil
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	_, err := io.WriteString(d.output, pointLines+"\n")
+	if err != nil {
+		return &http.Response{}, err
+	}
+	return &http.Response{StatusCode: http.StatusAccepted}, nil
+}
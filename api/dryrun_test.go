@@ -0,0 +1,37 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDryRun(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	service := &WavefrontAPIService{ServerURL: server.URL, GzipUpload: true, DryRun: NewDryRunWriter(&buf)}
+	if _, err := service.Checkin(0, false, true, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := service.PostData(GraphiteBlockWorkUnit, FormatGraphiteV2, "foo 1 source=a\nbar 2 source=b")
+	if err != nil || resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected dry run success, found %v %v", resp, err)
+	}
+	if err = service.AgentConfigProcessed(); err != nil {
+		t.Fatal(err)
+	}
+
+	if requests != 0 {
+		t.Errorf("Expected no requests to the server in dry run mode, found %d", requests)
+	}
+	if buf.String() != "foo 1 source=a\nbar 2 source=b\n" {
+		t.Errorf("Unexpected dry run output %q", buf.String())
+	}
+}
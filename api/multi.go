@@ -0,0 +1,243 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/config"
+	"github.com/wavefronthq/go-proxy/logger"
+)
+
+const (
+	// max batches buffered for each additional server before new batches are dropped
+	maxDestinationBatches = 1000
+	// attempts to post a batch to an additional server before it is dropped
+	maxDestinationAttempts = 10
+	// max lines remembered as sent to a server while the batch is posted again
+	maxResentLines = 100000
+)
+
+// Writes points to a primary server and a number of additional servers, e.g. while migrating
+// between clusters. Config and check-in responses come from the primary and its post results are
+// returned to the caller, so buffering and retries are unchanged. Additional servers are posted to
+// asynchronously, each from its own queue, so a slow or failing server does not hold up the others,
+// and retry their failed posts with their own backoff. Each batch is queued for them before it is
+// posted to the primary. The lines of batches the primary fails are remembered, so they are not sent
+// again when the primary retries them.
+// The latency of the posts to each server is reported by its flush.latency.<server host> timer.
+type MultiWavefrontAPI struct {
+	Primary      WavefrontAPI
+	destinations []*destination
+	resent       sentLines // lines sent to the additional servers and failed by the primary
+}
+
+type destination struct {
+	name           string
+	service        WavefrontAPI
+	batches        chan batch
+	done           chan struct{} // closed to stop retrying on shutdown
+	wg             sync.WaitGroup
+	batchesSent    metrics.Counter
+	batchesFailed  metrics.Counter
+	batchesLost    metrics.Counter
+	batchesRetried metrics.Counter
+}
+
+type batch struct {
+	workUnitId string
+	format     string
+	pointLines string
+}
+
+func NewMultiWavefrontAPI(primary WavefrontAPI, additional []*WavefrontAPIService) *MultiWavefrontAPI {
+	multi := &MultiWavefrontAPI{Primary: primary}
+	if service, ok := primary.(*WavefrontAPIService); ok {
+		service.latency = metrics.GetOrRegisterTimer("flush.latency."+destinationName(service.ServerURL), nil)
+	}
+	for _, service := range additional {
+		name := destinationName(service.ServerURL)
+		service.latency = metrics.GetOrRegisterTimer("flush.latency."+name, nil)
+		d := &destination{
+			name:           name,
+			service:        service,
+			batches:        make(chan batch, maxDestinationBatches),
+			done:           make(chan struct{}),
+			batchesSent:    metrics.GetOrRegisterCounter("push.destination."+name+".sent", nil),
+			batchesFailed:  metrics.GetOrRegisterCounter("push.destination."+name+".failed", nil),
+			batchesLost:    metrics.GetOrRegisterCounter("push.destination."+name+".dropped", nil),
+			batchesRetried: metrics.GetOrRegisterCounter("push.destination."+name+".retried", nil),
+		}
+		d.wg.Add(1)
+		go d.run()
+		multi.destinations = append(multi.destinations, d)
+	}
+	return multi
+}
+
+// Returns the server host as a single metric name segment.
+func destinationName(serverURL string) string {
+	host := serverURL
+	if u, err := url.Parse(serverURL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	return strings.NewReplacer(".", "_", ":", "_").Replace(host)
+}
+
+func (d *destination) run() {
+	defer d.wg.Done()
+	for b := range d.batches {
+		if d.post(b) {
+			d.batchesSent.Inc(1)
+		} else {
+			d.batchesFailed.Inc(1)
+		}
+	}
+}
+
+// Posts the batch, retrying with backoff up to maxDestinationAttempts times. Returns false if
+// the batch was not accepted.
+func (d *destination) post(b batch) bool {
+	for attempt := 0; ; attempt++ {
+		resp, err := d.service.PostData(b.workUnitId, b.format, b.pointLines)
+		if err == nil && resp.StatusCode != NotAcceptableStatusCode {
+			return true
+		}
+		if err == nil {
+			err = fmt.Errorf("points not accepted: %s", resp.Status)
+		}
+		if attempt+1 >= maxDestinationAttempts {
+			logger.Warnf("%s: error posting points, dropping the batch after %d attempts: %v", d.name, attempt+1, err)
+			return false
+		}
+		delay := getBackoff(attempt)
+		logger.Warnf("%s: error posting points, retrying in %v: %v", d.name, delay, err)
+		d.batchesRetried.Inc(1)
+		select {
+		case <-time.After(delay):
+		case <-d.done:
+			return false
+		}
+	}
+}
+
+func (multi *MultiWavefrontAPI) GetConfig(currentMillis, bytesLeft, bytesPerMinute, currentQueueSize int64) (*config.AgentConfig, error) {
+	return multi.Primary.GetConfig(currentMillis, bytesLeft, bytesPerMinute, currentQueueSize)
+}
+
+// Checks in with every server so the agent is registered with each of them.
+func (multi *MultiWavefrontAPI) Checkin(currentMillis int64, localAgent, pushAgent, ephemeral bool, agentMetrics []byte) (*config.AgentConfig, error) {
+	for _, d := range multi.destinations {
+		_, err := d.service.Checkin(currentMillis, localAgent, pushAgent, ephemeral, agentMetrics)
+		if err != nil {
+			logger.Warnf("%s: error checking in: %v", d.name, err)
+		}
+	}
+	return multi.Primary.Checkin(currentMillis, localAgent, pushAgent, ephemeral, agentMetrics)
+}
+
+// Queues the batch for the additional servers, without the lines already sent to them, then posts
+// it to the primary.
+func (multi *MultiWavefrontAPI) PostData(workUnitId, format, pointLines string) (*http.Response, error) {
+	if lines := strings.Join(multi.resent.unsent(strings.Split(pointLines, "\n")), "\n"); lines != "" {
+		for _, d := range multi.destinations {
+			select {
+			case d.batches <- batch{workUnitId: workUnitId, format: format, pointLines: lines}:
+			default:
+				d.batchesLost.Inc(1)
+			}
+		}
+	}
+
+	resp, err := multi.Primary.PostData(workUnitId, format, pointLines)
+	if err != nil || resp.StatusCode == NotAcceptableStatusCode {
+		// the caller posts the points again
+		multi.resent.remember(strings.Split(pointLines, "\n"))
+	}
+	return resp, err
+}
+
+// Lines already sent to a server as part of batches that failed elsewhere, so they are not sent to
+// it again when the caller posts the batch again.
+type sentLines struct {
+	mtx   sync.Mutex
+	lines map[string]int
+	count int
+}
+
+// Returns the lines not sent yet, forgetting the lines that were. The lines are filtered in place.
+func (s *sentLines) unsent(lines []string) []string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.count == 0 {
+		return lines
+	}
+	unsent := lines[:0]
+	for _, line := range lines {
+		if n := s.lines[line]; n > 0 {
+			if n == 1 {
+				delete(s.lines, line)
+			} else {
+				s.lines[line] = n - 1
+			}
+			s.count--
+			continue
+		}
+		unsent = append(unsent, line)
+	}
+	return unsent
+}
+
+// Remembers the lines as sent, up to maxResentLines. Lines over the limit are sent again.
+func (s *sentLines) remember(lines []string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.lines == nil {
+		s.lines = make(map[string]int)
+	}
+	for _, line := range lines {
+		if s.count >= maxResentLines {
+			return
+		}
+		s.lines[line]++
+		s.count++
+	}
+}
+
+func (multi *MultiWavefrontAPI) AgentError(details string) {
+	multi.Primary.AgentError(details)
+}
+
+func (multi *MultiWavefrontAPI) AgentConfigProcessed() error {
+	return multi.Primary.AgentConfigProcessed()
+}
+
+// Waits up to timeout for the additional servers to receive the queued batches.
+// PostData must not be called once closed.
+func (multi *MultiWavefrontAPI) Close(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		for _, d := range multi.destinations {
+			close(d.batches)
+		}
+		for _, d := range multi.destinations {
+			d.wg.Wait()
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		for _, d := range multi.destinations {
+			close(d.done)
+			if n := len(d.batches); n > 0 {
+				logger.Warnf("%s: %d batches not sent before shutdown", d.name, n)
+			}
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// Records the points posted to it, failing every post if status is a server error.
+type testServer struct {
+	*httptest.Server
+	mtx    sync.Mutex
+	status int
+	delay  time.Duration
+	points []string
+}
+
+func newTestServer(status int, delay time.Duration) *testServer {
+	s := &testServer{status: status, delay: delay}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(s.delay)
+		body, _ := ioutil.ReadAll(r.Body)
+		s.mtx.Lock()
+		status := s.status
+		if status < 500 {
+			s.points = append(s.points, string(body))
+		}
+		s.mtx.Unlock()
+		w.WriteHeader(status)
+	}))
+	return s
+}
+
+func (s *testServer) setStatus(status int) {
+	s.mtx.Lock()
+	s.status = status
+	s.mtx.Unlock()
+}
+
+func (s *testServer) received() []string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return append([]string(nil), s.points...)
+}
+
+func TestMultiPostData(t *testing.T) {
+	defer func(delay time.Duration) { retryBaseDelay = delay }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+	primary := newTestServer(http.StatusAccepted, 0)
+	failing := newTestServer(http.StatusServiceUnavailable, 0)
+	slow := newTestServer(http.StatusAccepted, 200*time.Millisecond)
+	defer primary.Close()
+	defer failing.Close()
+	defer slow.Close()
+
+	multi := NewMultiWavefrontAPI(&WavefrontAPIService{ServerURL: primary.URL}, []*WavefrontAPIService{
+		{ServerURL: failing.URL},
+		{ServerURL: slow.URL},
+	})
+
+	start := time.Now()
+	resp, err := multi.PostData(GraphiteBlockWorkUnit, FormatGraphiteV2, "foo 1 source=bar")
+	if err != nil || resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected the primary result, found %v %v", resp, err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Post was held up by the slow server for %v", elapsed)
+	}
+
+	multi.Close(time.Second)
+	for _, s := range []*testServer{primary, slow} {
+		if points := s.received(); len(points) != 1 || !strings.Contains(points[0], "foo 1") {
+			t.Errorf("Expected the points to be received, found %v", points)
+		}
+	}
+	if count := multi.destinations[0].batchesFailed.Count(); count != 1 {
+		t.Errorf("Expected 1 failed batch for the failing server, found %d", count)
+	}
+	if count := multi.destinations[1].batchesSent.Count(); count != 1 {
+		t.Errorf("Expected 1 sent batch for the slow server, found %d", count)
+	}
+
+	if count := multi.destinations[0].batchesRetried.Count(); count != maxDestinationAttempts-1 {
+		t.Errorf("Expected %d retries for the failing server, found %d", maxDestinationAttempts-1, count)
+	}
+
+	for s, posts := range map[*testServer]int64{primary: 1, failing: maxDestinationAttempts, slow: 1} {
+		name := "flush.latency." + destinationName(s.URL)
+		timer, ok := metrics.Get(name).(metrics.Timer)
+		if !ok || timer.Count() != posts {
+			t.Errorf("Expected %d posts timed by %s, found %v", posts, name, timer)
+		}
+	}
+	if timer := metrics.Get("flush.latency." + destinationName(slow.URL)).(metrics.Timer); timer.Min() < int64(200*time.Millisecond) {
+		t.Errorf("Expected the slow server latency to be timed, found %v", time.Duration(timer.Min()))
+	}
+}
+
+func TestMultiPostDataPrimaryRetries(t *testing.T) {
+	defer func(delay time.Duration) { retryBaseDelay = delay }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+	primary := newTestServer(http.StatusServiceUnavailable, 0)
+	additional := newTestServer(http.StatusServiceUnavailable, 0)
+	defer primary.Close()
+	defer additional.Close()
+
+	multi := NewMultiWavefrontAPI(&WavefrontAPIService{ServerURL: primary.URL}, []*WavefrontAPIService{
+		{ServerURL: additional.URL},
+	})
+	// the caller posts the points failed by the primary again, with the points flushed since
+	if _, err := multi.PostData(GraphiteBlockWorkUnit, FormatGraphiteV2, "foo 1 source=a\nfoo 2 source=a"); err == nil {
+		t.Fatal("Expected the error of the primary")
+	}
+	// fails the first posts to the additional server, before it recovers
+	time.Sleep(20 * time.Millisecond)
+	additional.setStatus(http.StatusAccepted)
+	if _, err := multi.PostData(GraphiteBlockWorkUnit, FormatGraphiteV2, "foo 1 source=a\nfoo 2 source=a"); err == nil {
+		t.Fatal("Expected the error of the primary")
+	}
+	primary.setStatus(http.StatusAccepted)
+	if _, err := multi.PostData(GraphiteBlockWorkUnit, FormatGraphiteV2, "foo 1 source=a\nfoo 2 source=a\nfoo 3 source=a"); err != nil {
+		t.Fatal(err)
+	}
+	multi.Close(time.Second)
+
+	if points := primary.received(); len(points) != 1 {
+		t.Errorf("Expected the primary to receive the points once, found %q", points)
+	}
+	points := additional.received()
+	if len(points) != 2 || points[0] != "foo 1 source=a\nfoo 2 source=a" || points[1] != "foo 3 source=a" {
+		t.Errorf("Expected the additional server to receive each point once, found %q", points)
+	}
+	if count := multi.destinations[0].batchesRetried.Count(); count == 0 {
+		t.Error("Expected the failed posts to the additional server to be retried")
+	}
+	if len(multi.resent.lines) != 0 || multi.resent.count != 0 {
+		t.Errorf("Expected the resent lines to be forgotten, found %v", multi.resent.lines)
+	}
+}
+
+func TestDestinationName(t *testing.T) {
+	if name := destinationName("https://try.wavefront.com:443/api"); name != "try_wavefront_com_443" {
+		t.Errorf("Unexpected destination name %s", name)
+	}
+}
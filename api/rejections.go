@@ -0,0 +1,98 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/logger"
+)
+
+// minimum time between logs of rejected batches
+const rejectionLogInterval = 10 * time.Second
+
+var (
+	rejectedPoints = metrics.GetOrRegisterCounter("push.points.rejected", nil)
+	splitBatches   = metrics.GetOrRegisterCounter("push.batches.split", nil)
+
+	lastRejectionLog int64 // unix time a rejection was last logged
+)
+
+// Points of a batch the server did not accept, as described by its error response.
+type rejection struct {
+	Points int    `json:"rejected"`
+	Reason string `json:"message"`
+}
+
+// Responses rejecting the points themselves, which would be rejected again if resent: 400, and
+// 413 for batches that cannot be split further. Other client errors, such as 401 and 403 while
+// a token is refreshed, 408 and 429, are failures and the points are sent again later.
+func isRejection(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusRequestEntityTooLarge
+}
+
+// Reads the rejected point count and reason from the body of an error response. JSON bodies
+// can give the count as "rejected" and the reason as "message", otherwise all the points of
+// the batch are rejected with the body, or the status, as the reason.
+func parseRejection(resp *http.Response, batchPoints int) rejection {
+	var body []byte
+	if resp.Body != nil {
+		body, _ = ioutil.ReadAll(resp.Body)
+	}
+
+	var r rejection
+	if err := json.Unmarshal(body, &r); err != nil {
+		r = rejection{Reason: strings.TrimSpace(string(body))}
+	}
+	if r.Points <= 0 || r.Points > batchPoints {
+		r.Points = batchPoints
+	}
+	if r.Reason == "" {
+		r.Reason = resp.Status
+	}
+	return r
+}
+
+// Counts the rejected points of the batch and logs the reason, at most once per interval.
+func recordRejection(resp *http.Response, id, pointLines string) {
+	r := parseRejection(resp, strings.Count(strings.TrimRight(pointLines, "\n"), "\n")+1)
+	rejectedPoints.Inc(int64(r.Points))
+
+	now, last := time.Now().Unix(), atomic.LoadInt64(&lastRejectionLog)
+	if now-last >= int64(rejectionLogInterval/time.Second) && atomic.CompareAndSwapInt64(&lastRejectionLog, last, now) {
+		logger.Warnf("%d points rejected by the server (%s, request %s): %s", r.Points, resp.Status, id, r.Reason)
+	}
+}
+
+// Splits the lines of a batch in two halves at the newline nearest the middle, false if the
+// batch is a single line.
+func splitBatch(pointLines string) (string, string, bool) {
+	mid := len(pointLines) / 2
+	i := strings.LastIndexByte(pointLines[:mid], '\n')
+	if i < 0 {
+		if i = strings.IndexByte(pointLines[mid:], '\n'); i < 0 {
+			return "", "", false
+		}
+		i += mid
+	}
+	first, second := pointLines[:i], pointLines[i+1:]
+	if first == "" || second == "" {
+		return "", "", false
+	}
+	return first, second, true
+}
+
+// Posts the halves of a batch too large for the server, each split further if still too large.
+// The second half is only posted if the first one succeeds, so a failure leaves at most the
+// points of the first half to be sent again, which the server stores once.
+func (service *WavefrontAPIService) postHalves(workUnitId, format, first, second string) (*http.Response, error) {
+	resp, err := service.PostData(workUnitId, format, first)
+	if err != nil || resp.StatusCode == NotAcceptableStatusCode {
+		return resp, err
+	}
+	return service.PostData(workUnitId, format, second)
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/wavefronthq/go-proxy/logger"
+)
+
+var (
+	// random per process so the ids of the flushes of different proxies differ
+	requestIdNonce = newRequestIdNonce()
+	requestCounter uint64
+)
+
+func newRequestIdNonce() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Returns the id sent with the posts of a flush, the nonce followed by a counter, e.g. 5f3a9c21-42.
+func nextRequestId() string {
+	return requestIdNonce + "-" + strconv.FormatUint(atomic.AddUint64(&requestCounter, 1), 10)
+}
+
+// Logs the batch size and outcome of a flush at debug level, failures are logged by the callers
+// with the id included in the error.
+func logFlush(id, pointLines string, resp *http.Response, err error) {
+	if !logger.Enabled(logger.DebugLevel) {
+		return
+	}
+	outcome := "failed"
+	if err == nil {
+		outcome = resp.Status
+	}
+	logger.Debugf("Flush %s: %d points, %d bytes, %s", id, strings.Count(strings.TrimRight(pointLines, "\n"), "\n")+1,
+		len(pointLines), outcome)
+}
@@ -0,0 +1,208 @@
+package api
+
+import (
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/config"
+	"github.com/wavefronthq/go-proxy/logger"
+)
+
+// Partitions the points of each batch across the primary and additional servers by the value
+// of a tag, so each server receives a consistent subset of the series, e.g. to spread the points
+// of very large deployments. Points are assigned by rendezvous hashing of the tag value with each
+// server URL, so when servers are added or removed only the points of those servers move. Points
+// without the tag are assigned as if the value were empty.
+//
+// The shards of a batch are posted concurrently and the batch fails if any shard fails, so it is
+// buffered and posted again. The lines of the shards that succeeded are remembered, so only the
+// lines of the failed shards are sent again. Config responses come from the primary.
+type ShardedWavefrontAPI struct {
+	Primary *WavefrontAPIService
+	tagKey  string
+	mtx     sync.RWMutex
+	shards  []*shard
+}
+
+type shard struct {
+	name    string
+	seed    uint64 // hash of the server URL
+	service *WavefrontAPIService
+	points  metrics.Counter
+	sent    sentLines // lines accepted by the shard in batches that failed on other shards
+}
+
+// Shards by the tagKey, the source when source or host, as the host tag is the source of points.
+func NewShardedWavefrontAPI(primary *WavefrontAPIService, additional []*WavefrontAPIService, tagKey string) *ShardedWavefrontAPI {
+	sharded := &ShardedWavefrontAPI{Primary: primary, tagKey: tagKey}
+	sharded.SetServers(additional)
+	return sharded
+}
+
+// Replaces the additional servers, moving the points of removed servers to the remaining ones
+// and points to added servers from the others.
+func (sharded *ShardedWavefrontAPI) SetServers(additional []*WavefrontAPIService) {
+	shards := make([]*shard, 0, 1+len(additional))
+	for _, service := range append([]*WavefrontAPIService{sharded.Primary}, additional...) {
+		name := destinationName(service.ServerURL)
+		service.latency = metrics.GetOrRegisterTimer("flush.latency."+name, nil)
+		shards = append(shards, &shard{
+			name:    name,
+			seed:    hashString(service.ServerURL),
+			service: service,
+			points:  metrics.GetOrRegisterCounter("push.shard."+name+".points", nil),
+		})
+	}
+
+	sharded.mtx.Lock()
+	sharded.shards = shards
+	sharded.mtx.Unlock()
+	logger.Infof("Sharding points by %s across %d servers", sharded.tagKey, len(shards))
+}
+
+func (sharded *ShardedWavefrontAPI) servers() []*shard {
+	sharded.mtx.RLock()
+	defer sharded.mtx.RUnlock()
+	return sharded.shards
+}
+
+func (sharded *ShardedWavefrontAPI) GetConfig(currentMillis, bytesLeft, bytesPerMinute, currentQueueSize int64) (*config.AgentConfig, error) {
+	return sharded.Primary.GetConfig(currentMillis, bytesLeft, bytesPerMinute, currentQueueSize)
+}
+
+// Checks in with every server so the agent is registered with each of them.
+func (sharded *ShardedWavefrontAPI) Checkin(currentMillis int64, localAgent, pushAgent, ephemeral bool, agentMetrics []byte) (*config.AgentConfig, error) {
+	for _, s := range sharded.servers()[1:] {
+		_, err := s.service.Checkin(currentMillis, localAgent, pushAgent, ephemeral, agentMetrics)
+		if err != nil {
+			logger.Warnf("%s: error checking in: %v", s.name, err)
+		}
+	}
+	return sharded.Primary.Checkin(currentMillis, localAgent, pushAgent, ephemeral, agentMetrics)
+}
+
+// Posts the points of each shard to its server, skipping the lines it accepted in an earlier post
+// of the batch. Returns the result of a failed shard if any, so the caller posts the batch again,
+// otherwise that of a shard rejecting its points, if any.
+func (sharded *ShardedWavefrontAPI) PostData(workUnitId, format, pointLines string) (*http.Response, error) {
+	shards := sharded.servers()
+	if len(shards) == 1 || pointLines == "" {
+		return sharded.Primary.PostData(workUnitId, format, pointLines)
+	}
+
+	batches := make([][]string, len(shards))
+	for _, line := range strings.Split(strings.TrimRight(pointLines, "\n"), "\n") {
+		i := shardOf(shards, lineTagValue(line, sharded.tagKey))
+		batches[i] = append(batches[i], line)
+	}
+
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+	results := make([]*result, len(shards))
+	var wg sync.WaitGroup
+	for i, s := range shards {
+		batches[i] = s.sent.unsent(batches[i])
+		if len(batches[i]) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, s *shard) {
+			defer wg.Done()
+			resp, err := s.service.PostData(workUnitId, format, strings.Join(batches[i], "\n"))
+			if err == nil && isSuccess(resp) {
+				s.points.Inc(int64(len(batches[i])))
+			}
+			results[i] = &result{resp, err}
+		}(i, s)
+	}
+	wg.Wait()
+
+	var failed, rejected, first *result
+	for _, r := range results {
+		switch {
+		case r == nil:
+		case r.err != nil || r.resp.StatusCode == NotAcceptableStatusCode:
+			failed = r
+		case !isSuccess(r.resp) && rejected == nil:
+			rejected = r
+		case first == nil:
+			first = r
+		}
+	}
+	if failed != nil {
+		for i, r := range results {
+			if r != nil && r.err == nil && r.resp.StatusCode != NotAcceptableStatusCode {
+				shards[i].sent.remember(batches[i])
+			}
+		}
+		return failed.resp, failed.err
+	}
+	if rejected != nil {
+		return rejected.resp, rejected.err
+	}
+	if first == nil {
+		// every line was accepted by an earlier post of the batch
+		return &http.Response{StatusCode: http.StatusAccepted, Status: "202 Accepted"}, nil
+	}
+	return first.resp, first.err
+}
+
+func (sharded *ShardedWavefrontAPI) AgentError(details string) {
+	sharded.Primary.AgentError(details)
+}
+
+func (sharded *ShardedWavefrontAPI) AgentConfigProcessed() error {
+	return sharded.Primary.AgentConfigProcessed()
+}
+
+// Returns the index of the shard with the highest hash of the value.
+func shardOf(shards []*shard, value string) int {
+	h := hashString(value)
+	best, bestWeight := 0, uint64(0)
+	for i, s := range shards {
+		if w := mix(s.seed ^ h); w > bestWeight || i == 0 {
+			best, bestWeight = i, w
+		}
+	}
+	return best
+}
+
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// Finalizer of splitmix64, so the weights of a value differ across servers.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+// Returns the value of a tag of a point line, as formatted by the handlers with quoted tag
+// keys and values, or an empty string if the point does not have the tag.
+func lineTagValue(line, key string) string {
+	pattern := ` "` + key + `"=`
+	if key == "source" || key == "host" {
+		pattern = " source="
+	}
+	i := strings.Index(line, pattern)
+	if i < 0 {
+		return ""
+	}
+	quoted, err := strconv.QuotedPrefix(line[i+len(pattern):])
+	if err != nil {
+		return ""
+	}
+	value, _ := strconv.Unquote(quoted)
+	return value
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestLineTagValue(t *testing.T) {
+	line := `"foo bar" 1 1505454047 source="web \"01\"" "env"="prod" "region"="us"`
+	cases := map[string]string{
+		"source": `web "01"`,
+		"host":   `web "01"`,
+		"env":    "prod",
+		"region": "us",
+		"zone":   "",
+	}
+	for key, expected := range cases {
+		if value := lineTagValue(line, key); value != expected {
+			t.Errorf("Expected %q for %s, found %q", expected, key, value)
+		}
+	}
+	histogram := `!M 1505454047 #2 1.5 "foo" source="a" "env"="dev"`
+	if value := lineTagValue(histogram, "env"); value != "dev" {
+		t.Errorf("Expected the histogram tag, found %q", value)
+	}
+}
+
+func TestShardOf(t *testing.T) {
+	urls := []string{"http://a/api", "http://b/api", "http://c/api", "http://d/api"}
+	var shards []*shard
+	for _, url := range urls {
+		shards = append(shards, &shard{name: url, seed: hashString(url)})
+	}
+
+	assigned := make(map[string]string)
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		value := fmt.Sprintf("host%d", i)
+		s := shards[shardOf(shards, value)].name
+		assigned[value] = s
+		counts[s]++
+	}
+	for _, url := range urls {
+		if counts[url] < 150 {
+			t.Errorf("Expected the values spread across the servers, found %v", counts)
+			break
+		}
+	}
+
+	// removing a server only moves its values
+	remaining := append(append([]*shard{}, shards[:2]...), shards[3:]...)
+	for value, s := range assigned {
+		moved := remaining[shardOf(remaining, value)].name
+		if s != urls[2] && moved != s {
+			t.Fatalf("Expected %s to stay on %s, moved to %s", value, s, moved)
+		}
+	}
+}
+
+func TestShardedPostData(t *testing.T) {
+	primary := newTestServer(http.StatusAccepted, 0)
+	other := newTestServer(http.StatusAccepted, 0)
+	defer primary.Close()
+	defer other.Close()
+
+	sharded := NewShardedWavefrontAPI(&WavefrontAPIService{ServerURL: primary.URL},
+		[]*WavefrontAPIService{{ServerURL: other.URL}}, "source")
+	var lines []string
+	for i := 0; i < 20; i++ {
+		lines = append(lines, fmt.Sprintf(`"foo" 1 1505454047 source="host%d"`, i))
+	}
+	resp, err := sharded.PostData(GraphiteBlockWorkUnit, FormatGraphiteV2, strings.Join(lines, "\n"))
+	if err != nil || resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected the points to be accepted, found %v %v", resp, err)
+	}
+
+	shards := sharded.servers()
+	for i, s := range []*testServer{primary, other} {
+		received := s.received()
+		if len(received) != 1 {
+			t.Fatalf("Expected a batch for each server, found %v", received)
+		}
+		for _, line := range strings.Split(strings.TrimSpace(received[0]), "\n") {
+			if shardOf(shards, lineTagValue(line, "source")) != i {
+				t.Errorf("Point %s sent to the wrong server", line)
+			}
+		}
+	}
+
+	// a failed shard fails the batch
+	failing := newTestServer(http.StatusServiceUnavailable, 0)
+	defer failing.Close()
+	sharded.SetServers([]*WavefrontAPIService{{ServerURL: failing.URL}})
+	_, err = sharded.PostData(GraphiteBlockWorkUnit, FormatGraphiteV2, strings.Join(lines, "\n"))
+	if err == nil {
+		t.Error("Expected an error when a shard fails")
+	}
+}
+
+func TestShardedPostDataFailedShard(t *testing.T) {
+	healthy := newTestServer(http.StatusAccepted, 0)
+	failing := newTestServer(http.StatusServiceUnavailable, 0)
+	defer healthy.Close()
+	defer failing.Close()
+
+	sharded := NewShardedWavefrontAPI(&WavefrontAPIService{ServerURL: healthy.URL},
+		[]*WavefrontAPIService{{ServerURL: failing.URL}}, "source")
+	var lines []string
+	for i := 0; i < 20; i++ {
+		lines = append(lines, fmt.Sprintf(`"foo" 1 1505454047 source="host%d"`, i))
+	}
+	pointLines := strings.Join(lines, "\n")
+	if _, err := sharded.PostData(GraphiteBlockWorkUnit, FormatGraphiteV2, pointLines); err == nil {
+		t.Fatal("Expected an error when a shard fails")
+	}
+
+	// the caller posts the batch again, only the lines of the failed shard are sent again
+	failing.setStatus(http.StatusAccepted)
+	resp, err := sharded.PostData(GraphiteBlockWorkUnit, FormatGraphiteV2, pointLines)
+	if err != nil || resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected the points to be accepted, found %v %v", resp, err)
+	}
+
+	received := make(map[string]int)
+	for _, s := range []*testServer{healthy, failing} {
+		for _, batch := range s.received() {
+			for _, line := range strings.Split(batch, "\n") {
+				received[line]++
+			}
+		}
+	}
+	for _, line := range lines {
+		if received[line] != 1 {
+			t.Errorf("Expected %s received once, found %d", line, received[line])
+		}
+	}
+	if n := len(healthy.received()); n != 1 {
+		t.Errorf("Expected the healthy shard to receive 1 batch, found %d", n)
+	}
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/logger"
+)
+
+// Returns the current token, such as by reading a file or running a command.
+type TokenSource func() (string, error)
+
+// Replaces the token of services with the one from a source, for short-lived tokens that
+// change without a restart. The current token is kept when the source fails.
+type TokenRefresher struct {
+	source   TokenSource
+	services []*WavefrontAPIService
+	mtx      sync.Mutex
+	current  string
+	ticker   *time.Ticker
+	done     chan struct{}
+	failures metrics.Counter
+}
+
+// Creates a refresher for the services, which start out with the current token.
+// The token is refreshed every interval, or only when Refresh is called if the interval is 0.
+func NewTokenRefresher(source TokenSource, interval time.Duration, current string, services ...*WavefrontAPIService) *TokenRefresher {
+	r := &TokenRefresher{
+		source:   source,
+		services: services,
+		current:  current,
+		done:     make(chan struct{}),
+		failures: metrics.GetOrRegisterCounter("token.refresh.failures", nil),
+	}
+	if interval > 0 {
+		r.ticker = time.NewTicker(interval)
+		go r.refresh()
+	}
+	return r
+}
+
+func (r *TokenRefresher) refresh() {
+	for {
+		select {
+		case <-r.ticker.C:
+			r.Refresh()
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// Reads the token from the source and applies it to the services if it changed.
+func (r *TokenRefresher) Refresh() error {
+	token, err := r.source()
+	if err != nil {
+		r.failures.Inc(1)
+		logger.Warn("Error refreshing token, keeping the current token:", err)
+		return err
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if token == r.current {
+		return nil
+	}
+	for _, service := range r.services {
+		service.SetToken(token)
+	}
+	r.current = token
+	logger.Info("Updated token")
+	return nil
+}
+
+// Replaces the services, which are given the current token.
+func (r *TokenRefresher) SetServices(services ...*WavefrontAPIService) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for _, service := range services {
+		service.SetToken(r.current)
+	}
+	r.services = services
+}
+
+func (r *TokenRefresher) Stop() {
+	if r.ticker != nil {
+		r.ticker.Stop()
+		close(r.done)
+	}
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTokenRefresher(t *testing.T) {
+	var mtx sync.Mutex
+	token, failing := "first", false
+	source := func() (string, error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if failing {
+			return "", errors.New("source unavailable")
+		}
+		return token, nil
+	}
+	set := func(newToken string, fail bool) {
+		mtx.Lock()
+		token, failing = newToken, fail
+		mtx.Unlock()
+	}
+
+	primary := &WavefrontAPIService{Token: "first"}
+	additional := &WavefrontAPIService{Token: "first"}
+	r := NewTokenRefresher(source, 10*time.Millisecond, "first", primary, additional)
+	defer r.Stop()
+
+	set("second", false)
+	time.Sleep(50 * time.Millisecond)
+	if primary.token() != "second" || additional.token() != "second" {
+		t.Errorf("Expected refreshed token, found %s and %s", primary.token(), additional.token())
+	}
+
+	// failed refreshes keep the current token
+	set("third", true)
+	if err := r.Refresh(); err == nil {
+		t.Error("Expected refresh error")
+	}
+	if primary.token() != "second" {
+		t.Errorf("Expected token kept after failed refresh, found %s", primary.token())
+	}
+	if r.failures.Count() == 0 {
+		t.Error("Expected failed refreshes to be counted")
+	}
+}
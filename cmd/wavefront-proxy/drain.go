@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wavefronthq/go-proxy/logger"
+	"github.com/wavefronthq/go-proxy/points"
+)
+
+// stops the listeners on POST /drain, once
+var drain = &drainer{}
+
+// Drain of all listeners before the proxy is stopped, e.g. by blue/green deploys waiting for the
+// buffered points to be flushed before sending SIGTERM.
+type drainer struct {
+	mtx       sync.Mutex
+	started   int64 // epoch millis, 0 until started, read atomically
+	listeners []points.PointListener
+	done      chan struct{} // closed once all listeners are stopped
+}
+
+type drainStatus struct {
+	Draining       bool  `json:"draining"`
+	Done           bool  `json:"done"`              // all listeners stopped
+	Started        int64 `json:"started,omitempty"` // epoch millis
+	BufferedPoints int   `json:"bufferedPoints"`    // not flushed yet, spooled or lost once done
+	SpooledPoints  int64 `json:"spooledPoints"`     // in the disk buffer, flushed once restarted if done
+}
+
+// Starts draining on POST /drain, then waits until the listeners are stopped, or for at most
+// ?timeout=<seconds>, returning 200 once no points are left buffered in memory or in the disk
+// buffer and 503 otherwise. Each listener stops accepting connections and flushes its buffered
+// points within the drainTimeout and shutdownTimeout, spooling those not flushed. Requests once
+// started wait for the same drain.
+func serveDrain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var timeout <-chan time.Time
+	if value := r.FormValue("timeout"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			http.Error(w, "invalid timeout "+value, http.StatusBadRequest)
+			return
+		}
+		timeout = time.After(time.Duration(seconds) * time.Second)
+	}
+
+	select {
+	case <-drain.start():
+	case <-timeout:
+	case <-r.Context().Done():
+		return
+	}
+	status := drain.status()
+	writeDrainStatus(w, status, status.Done && status.BufferedPoints == 0 && status.SpooledPoints == 0)
+}
+
+// Returns the drain status on GET /drain/status, with the points buffered by the running
+// listeners when not draining.
+func serveDrainStatus(w http.ResponseWriter, r *http.Request) {
+	writeDrainStatus(w, drain.status(), true)
+}
+
+// Stops the running listeners in the background unless already started, moving them to the
+// stopped listeners so reloads do not start them again. Returns the channel closed once done.
+func (d *drainer) start() <-chan struct{} {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.done != nil {
+		return d.done
+	}
+
+	listenersMtx.Lock()
+	for key, listener := range listeners {
+		d.listeners = append(d.listeners, listener)
+		stoppedListeners[key] = listenerConfigs[key]
+		delete(listeners, key)
+		delete(listenerConfigs, key)
+	}
+	publishListeners()
+	listenersMtx.Unlock()
+
+	atomic.StoreInt64(&d.started, time.Now().UnixNano()/int64(time.Millisecond))
+	d.done = make(chan struct{})
+	logger.Infof("Draining %d listeners from the health server", len(d.listeners))
+	go func(listeners []points.PointListener, done chan struct{}) {
+		var wg sync.WaitGroup
+		for _, listener := range listeners {
+			wg.Add(1)
+			go func(listener points.PointListener) {
+				defer wg.Done()
+				listener.Stop()
+			}(listener)
+		}
+		wg.Wait()
+		close(done)
+		status := d.status()
+		logger.Infof("Drained listeners, %d points not flushed and %d spooled", status.BufferedPoints, status.SpooledPoints)
+	}(d.listeners, d.done)
+	return d.done
+}
+
+// Returns true once draining, so no listeners are started by reloads.
+func (d *drainer) draining() bool {
+	return atomic.LoadInt64(&d.started) != 0
+}
+
+// Waits for the listeners to be stopped if draining.
+func (d *drainer) wait() {
+	d.mtx.Lock()
+	done := d.done
+	d.mtx.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+func (d *drainer) status() drainStatus {
+	d.mtx.Lock()
+	started, done, drained := d.started, d.done, d.listeners
+	d.mtx.Unlock()
+	if done == nil {
+		status := drainStatus{}
+		listenersMtx.RLock()
+		for _, listener := range listeners {
+			status.add(listener.Status())
+		}
+		listenersMtx.RUnlock()
+		return status
+	}
+
+	status := drainStatus{Draining: true, Started: started}
+	select {
+	case <-done:
+		status.Done = true
+	default:
+	}
+	for _, listener := range drained {
+		status.add(listener.Status())
+	}
+	return status
+}
+
+func (s *drainStatus) add(listener points.ListenerStatus) {
+	s.BufferedPoints += listener.BufferedPoints
+	s.SpooledPoints += listener.SpooledPoints
+}
+
+func writeDrainStatus(w http.ResponseWriter, status drainStatus, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
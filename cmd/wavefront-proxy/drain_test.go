@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/wavefronthq/go-proxy/api"
+	"github.com/wavefronthq/go-proxy/points"
+	"github.com/wavefronthq/go-proxy/points/decoder"
+)
+
+func TestDrain(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	cfg := listenerConfig{group: "opentsdbPorts", host: "127.0.0.1", port: port, protocol: points.ProtocolTCP,
+		format: api.FormatGraphiteV2, builder: decoder.OpenTSDBBuilder{}}
+	service := &api.WavefrontAPIService{DryRun: api.NewDryRunWriter(ioutil.Discard)}
+	key := listenerKey(cfg.protocol, cfg.host, cfg.port)
+	listenersMtx.Lock()
+	err = startListener(key, cfg, service)
+	listenersMtx.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		drain.wait()
+		drain = &drainer{}
+		listenersMtx.Lock()
+		stoppedListeners = make(map[string]listenerConfig)
+		listenersMtx.Unlock()
+	})
+
+	serve := func(method, path string) (int, drainStatus) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, nil)
+		if path == "/drain/status" {
+			serveDrainStatus(w, r)
+		} else {
+			serveDrain(w, r)
+		}
+		var status drainStatus
+		if w.Code == http.StatusOK || w.Code == http.StatusServiceUnavailable {
+			if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+				t.Fatalf("%s %s: %v", method, path, err)
+			}
+		}
+		return w.Code, status
+	}
+
+	// rejected without starting the drain
+	if code, _ := serve("GET", "/drain"); code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for GET /drain, found %d", code)
+	}
+	if code, _ := serve("POST", "/drain?timeout=soon"); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid timeout, found %d", code)
+	}
+	if code, status := serve("GET", "/drain/status"); code != http.StatusOK || status.Draining {
+		t.Errorf("Expected not draining, found %d %+v", code, status)
+	}
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(conn, "put cpu.idle %d 42 host=web01\n", time.Now().Unix())
+	conn.Close()
+
+	code, status := serve("POST", "/drain")
+	if code != http.StatusOK || !status.Draining || !status.Done || status.BufferedPoints != 0 || status.Started == 0 {
+		t.Errorf("Expected the listeners drained, found %d %+v", code, status)
+	}
+	if _, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), 100*time.Millisecond); err == nil {
+		t.Error("Expected connections refused once drained")
+	}
+	listenersMtx.RLock()
+	_, stopped := stoppedListeners[key]
+	running := len(listeners)
+	listenersMtx.RUnlock()
+	if !stopped || running != 0 {
+		t.Errorf("Expected the listener moved to the stopped listeners, found %d running", running)
+	}
+
+	// later requests report the same drain
+	if code, again := serve("POST", "/drain?timeout=1"); code != http.StatusOK || again != status {
+		t.Errorf("Expected %+v, found %d %+v", status, code, again)
+	}
+	if code, again := serve("GET", "/drain/status"); code != http.StatusOK || again != status {
+		t.Errorf("Expected %+v, found %d %+v", status, code, again)
+	}
+
+	// the drained listeners are not started again
+	w := httptest.NewRecorder()
+	serveListenerAction(w, httptest.NewRequest("POST", fmt.Sprintf("/listeners/%d/start", port), nil), service)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 starting a listener while draining, found %d", w.Code)
+	}
+}
+
+// WavefrontAPI failing every post
+type failingAPI struct {
+	api.WavefrontAPI
+}
+
+func (failingAPI) PostData(workUnitId, format, pointLines string) (*http.Response, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestDrainSpooled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wavefront-drain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(bufferDir *string) { fBufferFilePtr = bufferDir }(fBufferFilePtr)
+	fBufferFilePtr = &dir
+
+	// points spooled by a previous run are not replayed while the server fails
+	port := freePort(t)
+	writeSegment(t, filepath.Join(dir, strconv.Itoa(port), "00000000000000000001.spool"), 2,
+		`"a" 1 1505454047 source="x"`, `"b" 2 1505454047 source="x"`)
+	cfg := listenerConfig{group: "pushListenerPorts", host: "127.0.0.1", port: port, protocol: points.ProtocolTCP,
+		format: api.FormatGraphiteV2, builder: decoder.GraphiteBuilder{}}
+	listenersMtx.Lock()
+	err = startListener(listenerKey(cfg.protocol, cfg.host, cfg.port), cfg, failingAPI{})
+	listenersMtx.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		drain.wait()
+		drain = &drainer{}
+		listenersMtx.Lock()
+		stoppedListeners = make(map[string]listenerConfig)
+		listenersMtx.Unlock()
+	})
+
+	w := httptest.NewRecorder()
+	serveDrain(w, httptest.NewRequest("POST", "/drain", nil))
+	var status drainStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusServiceUnavailable || !status.Done || status.BufferedPoints != 0 || status.SpooledPoints != 2 {
+		t.Errorf("Expected 503 with 2 points spooled, found %d %+v", w.Code, status)
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/wavefronthq/go-proxy/agent"
+	"github.com/wavefronthq/go-proxy/api"
+	"github.com/wavefronthq/go-proxy/logger"
+	"github.com/wavefronthq/go-proxy/points"
+)
+
+const (
+	listenerRunning = "running"
+	listenerStopped = "stopped"
+)
+
+// serializes the listener actions, so a port is not started while its listeners are still stopping
+var listenerActionsMtx sync.Mutex
+
+// the running listeners checked by /healthz, published whenever the listeners change, so health
+// checks do not wait for a reload holding the listenersMtx
+var (
+	healthListeners    []points.PointListener
+	healthListenersMtx sync.RWMutex
+)
+
+type healthStatus struct {
+	Healthy        bool                    `json:"healthy"`
+	Registered     bool                    `json:"registered"`
+	BufferedPoints int                     `json:"bufferedPoints"`
+	LastFlush      int64                   `json:"lastFlush"`
+	Listeners      []points.ListenerStatus `json:"listeners"`
+}
+
+// A listener as listed on /listeners.
+type listenerInfo struct {
+	Port           int    `json:"port"`
+	Host           string `json:"host,omitempty"`
+	Path           string `json:"path,omitempty"`
+	Protocol       string `json:"protocol"`
+	Group          string `json:"group"` // the setting listing the port
+	Decoder        string `json:"decoder"`
+	Status         string `json:"status"` // running or stopped
+	BufferedPoints int    `json:"bufferedPoints"`
+}
+
+type logLevelStatus struct {
+	Level    string `json:"level"`
+	Previous string `json:"previous,omitempty"` // set when the level is changed
+}
+
+// Serves /healthz, which succeeds while all listeners are running, /ready, which
+// succeeds once the agent has registered and points have been flushed to Wavefront,
+// and the internal metrics in Prometheus format on /metrics. The admin endpoints are
+// served on the adminAddr if set, otherwise on the health port to loopback clients only.
+func startHealthServer(port int, adminAddr string, proxyAgent *agent.DefaultAgent, service api.WavefrontAPI) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", agent.PrometheusHandler)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		status := getHealthStatus(proxyAgent)
+		writeHealthStatus(w, status, status.Healthy)
+	})
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		status := getHealthStatus(proxyAgent)
+		writeHealthStatus(w, status, status.Registered && status.LastFlush > 0)
+	})
+
+	admin := newAdminMux(service)
+	if adminAddr == "" {
+		for _, path := range adminPaths {
+			mux.Handle(path, loopbackOnly(admin))
+		}
+	} else {
+		serveHealth("admin", adminAddr, admin)
+	}
+	serveHealth("health", fmt.Sprintf(":%d", port), mux)
+}
+
+// paths of the admin endpoints, only served to loopback clients on the health port
+var adminPaths = []string{"/blocked", "/loglevel", "/listeners", "/listeners/", "/drain", "/drain/status"}
+
+// Serves the last points dropped by the filters on /blocked when logBlockedSamples is set. The log
+// level is changed on /loglevel, and the listeners are listed on /listeners and stopped and started
+// on /listeners/<port>/stop and /listeners/<port>/start. All listeners are drained before a deploy
+// stops the proxy on /drain, reporting the points left buffered on /drain/status.
+func newAdminMux(service api.WavefrontAPI) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/blocked", func(w http.ResponseWriter, r *http.Request) {
+		if !*fLogBlockedSamplesPtr {
+			http.Error(w, "logBlockedSamples is not enabled", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(points.BlockedSamples())
+	})
+	mux.HandleFunc("/loglevel", serveLogLevel)
+	mux.HandleFunc("/listeners", serveListeners)
+	mux.HandleFunc("/listeners/", func(w http.ResponseWriter, r *http.Request) {
+		serveListenerAction(w, r, service)
+	})
+	mux.HandleFunc("/drain", serveDrain)
+	mux.HandleFunc("/drain/status", serveDrainStatus)
+	return mux
+}
+
+// Rejects requests not sent from a loopback address, so the admin endpoints on the health port
+// cannot stop the listeners from other hosts.
+func loopbackOnly(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+			http.Error(w, "admin endpoints are only served to loopback clients, set adminAddr to serve them elsewhere", http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func serveHealth(name, addr string, handler http.Handler) {
+	go func() {
+		logger.Infof("Starting %s server at: %s", name, addr)
+		if err := http.ListenAndServe(addr, handler); err != nil {
+			logger.Fatal(err.Error())
+		}
+	}()
+}
+
+// Returns the log level, or sets it on POST /loglevel?level=debug returning the previous level, so
+// debug messages can be logged without restarting the proxy. The level is reset to logLevel when
+// the configuration is reloaded.
+func serveLogLevel(w http.ResponseWriter, r *http.Request) {
+	status := logLevelStatus{Level: logger.GetLevel().String()}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		level, err := logger.ParseLevel(r.FormValue("level"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.SetLevel(level)
+		status.Previous, status.Level = status.Level, level.String()
+		logger.Infof("Log level changed from %s to %s", status.Previous, status.Level)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func serveListeners(w http.ResponseWriter, r *http.Request) {
+	writeListeners(w, getListeners(0))
+}
+
+// Stops the listeners of a port on POST /listeners/<port>/stop, e.g. during maintenance of the
+// clients sending to it, and starts them again on POST /listeners/<port>/start, returning the
+// listeners of the port. Stopped listeners flush their buffered points and are not started by
+// configuration reloads, nor checked by /healthz, until started again. No listeners are started
+// once draining.
+func serveListenerAction(w http.ResponseWriter, r *http.Request, service api.WavefrontAPI) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/listeners/"), "/")
+	if len(parts) != 2 || (parts[1] != "stop" && parts[1] != "start") {
+		http.NotFound(w, r)
+		return
+	}
+	port, err := strconv.Atoi(parts[0])
+	if err != nil || port <= 0 {
+		http.Error(w, "invalid port "+parts[0], http.StatusBadRequest)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	listenerActionsMtx.Lock()
+	defer listenerActionsMtx.Unlock()
+	if parts[1] == "start" && drain.draining() {
+		// /drain/status would report done while the listener accepts points again
+		http.Error(w, "listeners cannot be started while draining", http.StatusConflict)
+		return
+	}
+	if parts[1] == "stop" {
+		if stopListenersOn(port) == 0 {
+			http.Error(w, fmt.Sprintf("no running listener on port %d", port), http.StatusNotFound)
+			return
+		}
+	} else if started, err := startListenersOn(port, service); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if started == 0 {
+		http.Error(w, fmt.Sprintf("no stopped listener on port %d", port), http.StatusNotFound)
+		return
+	}
+	writeListeners(w, getListeners(port))
+}
+
+// Stops the running listeners of the port, returning how many were stopped. The listeners are
+// moved to the stopped listeners first, so health checks and reloads do not wait for them to flush.
+func stopListenersOn(port int) int {
+	var stopping []points.PointListener
+	listenersMtx.Lock()
+	for key, listener := range listeners {
+		cfg := listenerConfigs[key]
+		if cfg.port != port {
+			continue
+		}
+		logger.Infof("Stopping %s listener on port %d from the health server", cfg.group, port)
+		stopping = append(stopping, listener)
+		delete(listeners, key)
+		delete(listenerConfigs, key)
+		stoppedListeners[key] = cfg
+	}
+	publishListeners()
+	listenersMtx.Unlock()
+
+	for _, listener := range stopping {
+		listener.Stop()
+	}
+	return len(stopping)
+}
+
+// Starts the stopped listeners of the port, returning how many were started. Listeners failing
+// to start stay stopped, the error of the last one is returned.
+func startListenersOn(port int, service api.WavefrontAPI) (int, error) {
+	listenersMtx.Lock()
+	defer listenersMtx.Unlock()
+	started := 0
+	var lastErr error
+	for key, cfg := range stoppedListeners {
+		if cfg.port != port {
+			continue
+		}
+		logger.Infof("Starting %s listener on port %d from the health server", cfg.group, port)
+		if err := startListener(key, cfg, service); err != nil {
+			logger.Errorf("Error starting %s listener on port %d: %v", cfg.group, port, err)
+			lastErr = fmt.Errorf("error starting %s listener on port %d: %v", cfg.group, port, err)
+			continue
+		}
+		delete(stoppedListeners, key)
+		started++
+	}
+	return started, lastErr
+}
+
+// Returns the running and stopped listeners sorted by port, only those of the port if not 0.
+func getListeners(port int) []listenerInfo {
+	listenersMtx.RLock()
+	var infos []listenerInfo
+	for key, listener := range listeners {
+		status := listener.Status()
+		info := newListenerInfo(listenerConfigs[key], listenerStopped)
+		if status.Running {
+			info.Status = listenerRunning
+		}
+		info.BufferedPoints = status.BufferedPoints
+		infos = append(infos, info)
+	}
+	for _, cfg := range stoppedListeners {
+		infos = append(infos, newListenerInfo(cfg, listenerStopped))
+	}
+	listenersMtx.RUnlock()
+
+	filtered := infos[:0]
+	for _, info := range infos {
+		if port == 0 || info.Port == port {
+			filtered = append(filtered, info)
+		}
+	}
+	sort.Slice(filtered, func(i, j int) bool {
+		if filtered[i].Port != filtered[j].Port {
+			return filtered[i].Port < filtered[j].Port
+		}
+		return filtered[i].Protocol < filtered[j].Protocol
+	})
+	return filtered
+}
+
+func newListenerInfo(cfg listenerConfig, status string) listenerInfo {
+	return listenerInfo{
+		Port:     cfg.port,
+		Host:     cfg.host,
+		Path:     cfg.socketPath,
+		Protocol: cfg.protocol,
+		Group:    cfg.group,
+		Decoder:  decoderName(cfg),
+		Status:   status,
+	}
+}
+
+// Returns the name of the decoder of the listener, e.g. opentsdb for the OpenTSDBBuilder.
+func decoderName(cfg listenerConfig) string {
+	if cfg.builder == nil {
+		// the remote write listener decodes its requests itself
+		return "promwrite"
+	}
+	t := reflect.TypeOf(cfg.builder)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return strings.ToLower(strings.TrimSuffix(t.Name(), "Builder"))
+}
+
+func writeListeners(w http.ResponseWriter, infos []listenerInfo) {
+	if infos == nil {
+		infos = []listenerInfo{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(infos)
+}
+
+// Publishes the running listeners to the health checks, the listenersMtx must be locked.
+func publishListeners() {
+	running := make([]points.PointListener, 0, len(listeners))
+	for _, listener := range listeners {
+		running = append(running, listener)
+	}
+	healthListenersMtx.Lock()
+	healthListeners = running
+	healthListenersMtx.Unlock()
+}
+
+func getHealthStatus(proxyAgent *agent.DefaultAgent) healthStatus {
+	// there is no agent to register in direct ingestion mode
+	status := healthStatus{Registered: proxyAgent == nil || proxyAgent.Registered()}
+
+	healthListenersMtx.RLock()
+	for _, listener := range healthListeners {
+		status.Listeners = append(status.Listeners, listener.Status())
+	}
+	healthListenersMtx.RUnlock()
+	sort.Slice(status.Listeners, func(i, j int) bool {
+		return status.Listeners[i].Port < status.Listeners[j].Port
+	})
+
+	status.Healthy = len(status.Listeners) > 0
+	for _, listener := range status.Listeners {
+		status.Healthy = status.Healthy && listener.Running
+		status.BufferedPoints += listener.BufferedPoints
+		if listener.LastFlush > status.LastFlush {
+			status.LastFlush = listener.LastFlush
+		}
+	}
+	return status
+}
+
+func writeHealthStatus(w http.ResponseWriter, status healthStatus, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
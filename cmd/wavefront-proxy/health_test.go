@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/wavefronthq/go-proxy/api"
+	"github.com/wavefronthq/go-proxy/logger"
+	"github.com/wavefronthq/go-proxy/points"
+	"github.com/wavefronthq/go-proxy/points/decoder"
+)
+
+func TestServeLogLevel(t *testing.T) {
+	saved := logger.GetLevel()
+	defer logger.SetLevel(saved)
+	logger.SetLevel(logger.InfoLevel)
+
+	tests := []struct {
+		method, url string
+		code        int
+		expected    logLevelStatus
+	}{
+		{"GET", "/loglevel", http.StatusOK, logLevelStatus{Level: "info"}},
+		{"POST", "/loglevel?level=debug", http.StatusOK, logLevelStatus{Level: "debug", Previous: "info"}},
+		{"POST", "/loglevel?level=verbose", http.StatusBadRequest, logLevelStatus{}},
+		{"PUT", "/loglevel?level=warn", http.StatusMethodNotAllowed, logLevelStatus{}},
+		{"GET", "/loglevel", http.StatusOK, logLevelStatus{Level: "debug"}},
+		{"POST", "/loglevel?level=WARN", http.StatusOK, logLevelStatus{Level: "warn", Previous: "debug"}},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		serveLogLevel(w, httptest.NewRequest(test.method, test.url, nil))
+		if w.Code != test.code {
+			t.Errorf("%s %s: expected %d, found %d", test.method, test.url, test.code, w.Code)
+			continue
+		}
+		if test.code != http.StatusOK {
+			continue
+		}
+		var status logLevelStatus
+		if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil || status != test.expected {
+			t.Errorf("%s %s: expected %+v, found %+v (%v)", test.method, test.url, test.expected, status, err)
+		}
+	}
+	if level := logger.GetLevel(); level != logger.WarnLevel {
+		t.Errorf("Expected the warn level set, found %s", level)
+	}
+}
+
+func TestListenerActions(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	cfg := listenerConfig{group: "opentsdbPorts", host: "127.0.0.1", port: port, protocol: points.ProtocolTCP,
+		format: api.FormatGraphiteV2, builder: decoder.OpenTSDBBuilder{}}
+	service := &api.WavefrontAPIService{DryRun: api.NewDryRunWriter(ioutil.Discard)}
+	listenersMtx.Lock()
+	err = startListener(listenerKey(cfg.protocol, cfg.host, cfg.port), cfg, service)
+	listenersMtx.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		stopListenersOn(port)
+		listenersMtx.Lock()
+		stoppedListeners = make(map[string]listenerConfig)
+		listenersMtx.Unlock()
+	})
+
+	serve := func(method, path string) (int, []listenerInfo) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, nil)
+		if path == "/listeners" {
+			serveListeners(w, r)
+		} else {
+			serveListenerAction(w, r, service)
+		}
+		var infos []listenerInfo
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &infos); err != nil {
+				t.Fatalf("%s %s: %v", method, path, err)
+			}
+		}
+		return w.Code, infos
+	}
+	accepts := func() bool {
+		conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", cfg.host, port), 100*time.Millisecond)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}
+	portPath := fmt.Sprintf("/listeners/%d", port)
+
+	// the listener flushes its points and refuses connections once stopped
+	code, infos := serve("POST", portPath+"/stop")
+	if code != http.StatusOK || len(infos) != 1 || infos[0].Status != listenerStopped || accepts() {
+		t.Errorf("Expected the listener stopped, found %d %+v", code, infos)
+	}
+	if code, _ := serve("POST", portPath+"/stop"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 stopping a stopped listener, found %d", code)
+	}
+	_, infos = serve("GET", "/listeners")
+	expected := listenerInfo{Port: port, Host: "127.0.0.1", Protocol: "tcp", Group: "opentsdbPorts",
+		Decoder: "opentsdb", Status: listenerStopped}
+	if len(infos) != 1 || infos[0] != expected {
+		t.Errorf("Expected %+v listed, found %+v", expected, infos)
+	}
+
+	code, infos = serve("POST", portPath+"/start")
+	if code != http.StatusOK || len(infos) != 1 || infos[0].Status != listenerRunning || !accepts() {
+		t.Errorf("Expected the listener running, found %d %+v", code, infos)
+	}
+	if code, _ := serve("POST", portPath+"/start"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 starting a running listener, found %d", code)
+	}
+
+	invalid := []struct {
+		method, path string
+		code         int
+	}{
+		{"GET", portPath + "/stop", http.StatusMethodNotAllowed},
+		{"POST", "/listeners/opentsdb/stop", http.StatusBadRequest},
+		{"POST", portPath + "/pause", http.StatusNotFound},
+		{"POST", portPath, http.StatusNotFound},
+	}
+	for _, test := range invalid {
+		if code, _ := serve(test.method, test.path); code != test.code {
+			t.Errorf("%s %s: expected %d, found %d", test.method, test.path, test.code, code)
+		}
+	}
+}
+
+// A listener whose Stop blocks until released.
+type blockingListener struct {
+	points.PointListener
+	release chan struct{}
+}
+
+func (l *blockingListener) Stop() { <-l.release }
+
+func (l *blockingListener) Status() points.ListenerStatus {
+	return points.ListenerStatus{Port: 4242, Running: true}
+}
+
+func TestStopListenersUnlocked(t *testing.T) {
+	listener := &blockingListener{release: make(chan struct{})}
+	listenersMtx.Lock()
+	listeners["tcp:4242"] = listener
+	listenerConfigs["tcp:4242"] = listenerConfig{group: "opentsdbPorts", port: 4242}
+	publishListeners()
+	listenersMtx.Unlock()
+	t.Cleanup(func() {
+		listenersMtx.Lock()
+		stoppedListeners = make(map[string]listenerConfig)
+		listenersMtx.Unlock()
+	})
+
+	stopped := make(chan int)
+	go func() { stopped <- stopListenersOn(4242) }()
+
+	// health checks do not wait for the listener to stop
+	checked := make(chan struct{})
+	go func() {
+		getHealthStatus(nil)
+		close(checked)
+	}()
+	select {
+	case <-checked:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the health check not to wait for the listener to stop")
+	}
+
+	close(listener.release)
+	if n := <-stopped; n != 1 {
+		t.Errorf("Expected 1 listener stopped, found %d", n)
+	}
+}
+
+func TestHealthStatusDuringReload(t *testing.T) {
+	listener := &blockingListener{release: make(chan struct{})}
+	listenersMtx.Lock()
+	listeners["tcp:4242"] = listener
+	publishListeners()
+	listenersMtx.Unlock()
+	t.Cleanup(func() {
+		listenersMtx.Lock()
+		delete(listeners, "tcp:4242")
+		publishListeners()
+		listenersMtx.Unlock()
+	})
+
+	// a reload holds the listenersMtx while it stops and starts listeners
+	listenersMtx.Lock()
+	defer listenersMtx.Unlock()
+	checked := make(chan healthStatus)
+	go func() { checked <- getHealthStatus(nil) }()
+	select {
+	case status := <-checked:
+		if !status.Healthy || len(status.Listeners) != 1 {
+			t.Errorf("Expected the published listener healthy, found %+v", status)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the health check not to wait for the reload")
+	}
+}
+
+func TestLoopbackOnly(t *testing.T) {
+	handler := loopbackOnly(newAdminMux(nil))
+	tests := []struct {
+		remoteAddr string
+		code       int
+	}{
+		{"127.0.0.1:4242", http.StatusOK},
+		{"[::1]:4242", http.StatusOK},
+		{"10.0.0.1:4242", http.StatusForbidden},
+		{"[2001:db8::1]:4242", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/drain/status", nil)
+		r.RemoteAddr = test.remoteAddr
+		handler.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("Expected %d for a request from %s, found %d", test.code, test.remoteAddr, w.Code)
+		}
+	}
+}
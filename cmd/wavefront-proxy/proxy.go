@@ -1,13 +1,20 @@
 package main
 
 import (
+	"crypto/tls"
+	"errors"
 	"flag"
 	"log"
 	"os"
 	"os/signal"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"net"
 	"net/http"
 	_ "net/http/pprof"
 
@@ -15,82 +22,616 @@ import (
 	"github.com/wavefronthq/go-proxy/agent"
 	"github.com/wavefronthq/go-proxy/api"
 	"github.com/wavefronthq/go-proxy/config"
+	"github.com/wavefronthq/go-proxy/logger"
 	"github.com/wavefronthq/go-proxy/points"
 	"github.com/wavefronthq/go-proxy/points/decoder"
+	"github.com/wavefronthq/go-proxy/points/parser"
 )
 
 // flags
 var (
-	fCfgPtr            = flag.String("config", "", "Proxy configuration file")
-	fTokenPtr          = flag.String("token", "", "Wavefront API token")
-	fServerPtr         = flag.String("server", "", "Wavefront Server URL")
-	fHostnamePtr       = flag.String("host", "", "Hostname for the agent. Defaults to machine hostname")
-	fWavefrontPortsPtr = flag.String("pushListenerPorts", "2878",
+	fCfgPtr               = flag.String("config", "", "Proxy configuration file, or comma-separated files merged in order")
+	fTokenPtr             = flag.String("token", "", "Wavefront API token")
+	fTokenFilePtr         = flag.String("tokenFile", "", "File to read the Wavefront API token from instead of token, re-read on SIGHUP")
+	fTokenCommandPtr      = flag.String("tokenCommand", "", "Command printing the Wavefront API token to use instead of token, re-run on SIGHUP")
+	fTokenRefreshPtr      = flag.Int("tokenRefreshInterval", 0, "Seconds between re-reading the tokenFile or re-running the tokenCommand, disabled if 0")
+	fServerPtr            = flag.String("server", "", "Wavefront Server URL")
+	fDirectIngestionPtr   = flag.Bool("directIngestion", false, "Post points to the direct ingestion API of the server with the token as a bearer token, without registering the proxy")
+	fAdditionalServersPtr = flag.String("additionalServers", "", "Comma-separated list of additional Wavefront Server URLs to also send points to")
+	fAdditionalTokensPtr  = flag.String("additionalTokens", "", "Comma-separated list of API tokens for the additional servers, defaults to the token")
+	fShardByTagPtr        = flag.String("shardByTag", "", "Tag key to partition the points across the server and additionalServers by, instead of sending every point to each")
+	fInternalServerPtr    = flag.String("internalMetricsServer", "", "Wavefront Server URL to report the proxy's own metrics to instead of the server")
+	fInternalTokenPtr     = flag.String("internalMetricsToken", "", "API token for the internalMetricsServer, defaults to the token")
+	fInternalPrefixPtr    = flag.String("internalMetricsPrefix", "", "Prefix prepended to the names of the proxy's own metrics")
+	fHostnamePtr          = flag.String("host", "", "Hostname for the agent. Defaults to machine hostname")
+	fWavefrontPortsPtr    = flag.String("pushListenerPorts", "2878",
 		"Comma-separated list of ports to listen on for Wavefront formatted data")
+	fGraphiteTaggedPtr = flag.Bool("graphiteTaggedNames", false,
+		"Parse Graphite tagged series names, e.g. metric;tag1=v1;tag2=v2, into the metric and point tags on the pushListenerPorts")
 	fOpenTSDBPortsPtr = flag.String("opentsdbPorts", "4242",
 		"Comma-separated list of ports to listen on for OpenTSDB formatted data")
-	fFlushThreadsPtr   = flag.Int("flushThreads", config.DefaultFlushThreads, "Number of threads that flush to the server")
-	fFlushIntervalPtr  = flag.Int("pushFlushInterval", config.DefaultFlushInterval, "Milliseconds between flushes to the Wavefront server")
-	fFlushMaxPointsPtr = flag.Int("pushFlushMaxPoints", config.DefaultFlushMaxPoints, "Max points per flush")
-	fMaxBufferSizePtr  = flag.Int("pushMemoryBufferLimit", config.DefaultMemoryBufferLimit, "Max points to retain in memory")
-	fIdFilePtr         = flag.String("idFile", ".wavefront_id", "The agentId file")
-	fLogFilePtr        = flag.String("logFile", "", "Output log file")
-	fPprofAddr         = flag.String("pprof-addr", "", "pprof address to listen on, disabled if empty")
-	fVersionPtr        = flag.Bool("version", false, "Display the version and exit")
+	fStatsDPortsPtr = flag.String("statsdPorts", "8125",
+		"Comma-separated list of UDP ports to listen on for StatsD formatted data")
+	fStatsDGaugeExpiryPtr = flag.Int("statsdGaugeExpiry", config.DefaultStatsDGaugeExpiry,
+		"Flushes a StatsD gauge is reported for after its last update, reported until restart if 0")
+	fCollectdPortsPtr = flag.String("collectdPorts", "",
+		"Comma-separated list of UDP ports to listen on for packets of the collectd network plugin")
+	fInfluxPortsPtr = flag.String("influxPorts", "",
+		"Comma-separated list of ports to listen on for InfluxDB line protocol data")
+	fCSVPortsPtr = flag.String("csvPorts", "",
+		"Comma-separated list of ports to listen on for delimited metric,value,timestamp[,tag=value...] lines")
+	fCSVDelimiterPtr         = flag.String("csvDelimiter", ",", "Delimiter of the csvPorts fields, a single character or tab")
+	fCSVColumnsPtr           = flag.String("csvColumns", decoder.DefaultCSVColumns, "Comma-separated names of the csvPorts columns: metric, value, timestamp or a tag key")
+	fCSVHeaderPtr            = flag.Bool("csvHeader", false, "Read the csvColumns from the first line of each connection or request")
+	fAutoDetectPortsPtr      = flag.String("autoDetectPorts", "", "Comma-separated list of ports to listen on for both Wavefront and OpenTSDB formatted data, detected per line")
+	fHistogramMinutePortsPtr = flag.String("histogramMinutePort", "", "Comma-separated list of ports to aggregate points into minute histograms on")
+	fHistogramHourPortsPtr   = flag.String("histogramHourPort", "", "Comma-separated list of ports to aggregate points into hour histograms on")
+	fHistogramDayPortsPtr    = flag.String("histogramDayPort", "", "Comma-separated list of ports to aggregate points into day histograms on")
+	fHistogramDistPortsPtr   = flag.String("histogramDistPort", "", "Comma-separated list of ports to forward histogram distributions computed by clients on")
+	fHttpPortPtr             = flag.Int("httpPort", 0, "Port to accept Wavefront formatted data POSTed over HTTP, disabled if 0")
+	fPromWritePortPtr        = flag.Int("promWritePort", 0, "Port to accept Prometheus remote_write requests, disabled if 0")
+	fBindAddressPtr          = flag.String("bindAddress", "", "Interface the listener ports are bound to unless given as host:port, all interfaces if empty")
+	fFlushThreadsPtr         = flag.Int("flushThreads", config.DefaultFlushThreads, "Number of threads that flush to the server")
+	fFlushRetriesPtr         = flag.Int("flushRetries", config.DefaultFlushRetries, "Max retries for a failed flush to the Wavefront server")
+	fFlushTimeoutPtr         = flag.Int("flushTimeout", int(api.DefaultFlushTimeout/time.Second), "Seconds allowed for each flush to the Wavefront server before it is aborted and retried")
+	fCircuitThresholdPtr     = flag.Int("circuitBreakerThreshold", 0, "Consecutive failed flushes after which flushes to a server are paused, disabled if 0")
+	fCircuitCooldownPtr      = flag.Int("circuitBreakerCooldown", config.DefaultCircuitCooldown, "Seconds flushes are paused before a flush probes the server again")
+	fGzipUploadPtr           = flag.Bool("gzipUpload", true, "Gzip compress points sent to the Wavefront server")
+	fFlushIntervalPtr        = flag.Int("pushFlushInterval", config.DefaultFlushInterval, "Milliseconds between flushes to the Wavefront server")
+	fFlushMaxPointsPtr       = flag.Int("pushFlushMaxPoints", config.DefaultFlushMaxPoints, "Max points per flush, batches adapt to server latency up to this size")
+	fFlushTriggerPtr         = flag.Int("pushFlushTriggerPercent", config.DefaultFlushTrigger, "Percent of pushFlushMaxPoints buffered by a flush thread that flushes at once, disabled if 0")
+	fMaxFlushBytesPtr        = flag.Int("maxFlushBytes", 0, "Max bytes of the uncompressed body of a flush, larger batches are sent in several requests, unlimited if 0")
+	fSortByTimestampPtr      = flag.Bool("sortByTimestamp", false, "Sort the points of each flush by timestamp before sending them")
+	fMaxBufferSizePtr        = flag.Int("pushMemoryBufferLimit", config.DefaultMemoryBufferLimit, "Max points to retain in memory")
+	fMaxBufferBytesPtr       = flag.Int("pushMemoryBufferBytes", 0, "Max approximate bytes of points each listener retains in memory, unlimited if 0")
+	fDropPolicyPtr           = flag.String("dropPolicy", config.DropPolicyNewest, "Points dropped when a memory buffer overflows without a bufferFile: oldest or newest")
+	fPushRateLimitPtr        = flag.Int("pushRateLimit", 0, "Max points per second pushed to the Wavefront server, unlimited if 0")
+	fMaxFlushesPtr           = flag.Int("maxConcurrentFlushes", 0, "Max flushes in flight to the Wavefront server across all listeners, unlimited if 0")
+	fSharedFlushWindowPtr    = flag.Int("sharedFlushWindow", 0, "Milliseconds flushes of all listeners are collected for to be posted in one request, disabled if 0")
+	fBufferFilePtr           = flag.String("bufferFile", "", "Directory to spool points to when the memory buffer is full, disabled if empty")
+	fBufferDiskLimitPtr      = flag.Int("bufferDiskLimit", config.DefaultBufferDiskLimit, "Max megabytes of points to spool to disk")
+	fBufferCompressPtr       = flag.Bool("bufferCompress", false, "Gzip compress the points spooled to disk")
+	fShutdownTimeoutPtr      = flag.Int("shutdownTimeout", config.DefaultShutdownTimeout, "Seconds allowed to flush buffered points on shutdown")
+	fDrainTimeoutPtr         = flag.Int("drainTimeout", config.DefaultDrainTimeout, "Seconds allowed for open connections to finish sending when a listener stops, closed at once if 0")
+	fCheckinIntervalPtr      = flag.Int("checkinInterval", 60, "Seconds between check-ins fetching configuration from the Wavefront server")
+	fRuntimeStatsPtr         = flag.Int("runtimeStatsInterval", config.DefaultRuntimeStats, "Seconds between captures of the goroutine, memory and GC stats, captured at check-in only if 0")
+	fStartupGracePtr         = flag.Int("startupGrace", 0, "Seconds to wait for the proxy to register before starting the listeners anyway, waits until registered if 0")
+	fIdFilePtr               = flag.String("idFile", ".wavefront_id", "The agentId file")
+	fAgentIdPtr              = flag.String("agentId", "", "Explicit agentId to use instead of the agentId file")
+	fAgentIdSaltPtr          = flag.String("agentIdSalt", "", "Salt to derive the agentId from the hostname with instead of using the agentId file")
+	fLogFilePtr              = flag.String("logFile", "", "Output log file")
+	fLogLevelPtr             = flag.String("logLevel", config.DefaultLogLevel, "Minimum level of messages logged: debug, info, warn or error")
+	fLogFormatPtr            = flag.String("logFormat", logger.FormatText, "Log output format: text or json")
+	fPprofAddr               = flag.String("pprof-addr", "", "pprof address to listen on, disabled if empty")
+	fHealthPortPtr           = flag.Int("healthPort", 0, "Port to serve the /healthz, /ready and Prometheus /metrics endpoints on, disabled if 0")
+	fAdminAddrPtr            = flag.String("adminAddr", "", "Address to serve the /loglevel, /listeners, /drain and /blocked endpoints on, served on the healthPort to loopback clients only if empty")
+	fHttpProxyPtr            = flag.String("httpProxy", "", "Proxy URL for requests to the Wavefront server, may include user:password. Defaults to HTTP_PROXY/HTTPS_PROXY")
+	fApiMaxIdleConnsPtr      = flag.Int("apiMaxIdleConns", api.DefaultMaxIdleConns, "Idle connections to the Wavefront server kept for reuse by later flushes")
+	fApiIdleConnTimeoutPtr   = flag.Int("apiIdleConnTimeout", int(api.DefaultIdleConnTimeout/time.Second), "Seconds idle connections to the Wavefront server are kept for")
+	fApiTimeoutPtr           = flag.Int("apiTimeout", int(api.DefaultTimeout/time.Second), "Seconds allowed for each request to the Wavefront server")
+	fApiDnsRefreshPtr        = flag.Int("apiDnsRefreshInterval", config.DefaultDnsRefresh, "Seconds between resolving the Wavefront server again, idle connections are closed when its address changes, disabled if 0")
+	fApiCaFilePtr            = flag.String("apiCaFile", "", "CA bundle used to verify the Wavefront server certificate instead of the system CAs")
+	fApiServerNamePtr        = flag.String("apiServerName", "", "Name sent with SNI and verified against the Wavefront server certificate instead of the server host")
+	fApiSkipVerifyPtr        = flag.Bool("apiInsecureSkipVerify", false, "Do not verify the Wavefront server certificate")
+	fTlsCertFilePtr          = flag.String("tlsCertFile", "", "TLS certificate file, enables TLS on TCP listeners when set with tlsKeyFile")
+	fTlsKeyFilePtr           = flag.String("tlsKeyFile", "", "TLS private key file")
+	fTlsCaFilePtr            = flag.String("tlsCaFile", "", "CA file used to verify client certificates, enables mutual TLS")
+	fMaxConnectionsPtr       = flag.Int("maxConnections", 0, "Max concurrent connections per TCP listener, unlimited if 0")
+	fAcceptGoroutinesPtr     = flag.Int("acceptGoroutines", 1, "Goroutines accepting the connections of each TCP and Unix socket listener")
+	fConnIdleTimeoutPtr      = flag.Int("connectionIdleTimeout", 0, "Seconds after which idle TCP connections are closed, disabled if 0")
+	fTcpKeepAlivePtr         = flag.Int("tcpKeepAlive", config.DefaultTcpKeepAlive, "Seconds between TCP keepalive probes of idle connections detecting dead clients, disabled if 0")
+	fReusePortPtr            = flag.Bool("reusePort", false, "Set SO_REUSEPORT on the TCP and UDP listeners so several proxy processes can listen on the same ports, Linux and BSD only")
+	fAbuseThresholdPtr       = flag.Int("abuseThreshold", 0, "Max points per second read from a single TCP or Unix socket connection, disabled if 0")
+	fAbuseActionPtr          = flag.String("abuseAction", config.AbuseActionThrottle, "Action on connections exceeding abuseThreshold: throttle or close")
+	fSocketPathPtr           = flag.String("socketPath", "", "Path of a Unix domain socket to listen for points on, disabled if empty")
+	fSocketFormatPtr         = flag.String("socketFormat", config.SocketFormatWavefront, "Format of the points received on socketPath: wavefront or opentsdb")
+	fSocketModePtr           = flag.String("socketMode", config.DefaultSocketMode, "Octal permissions of the socketPath file")
+	fMaxLineLengthPtr        = flag.Int("maxLineLength", points.DefaultMaxLineLength, "Max bytes in a line received on TCP and Unix socket listeners, longer lines are skipped")
+	fReadBufferSizePtr       = flag.Int("readBufferSize", points.DefaultReadBufferSize, "Bytes read from each TCP or Unix socket connection at a time")
+	fDedupPtr                = flag.Bool("dedup", false, "Drop points with the same metric, source, tags and timestamp as a point received earlier in the flush interval")
+	fBackpressurePtr         = flag.Bool("backpressure", false, "Pause reading from TCP and Unix socket connections while the memory buffer is full instead of dropping points")
+	fHighWatermarkPtr        = flag.Int("backpressureHighWatermark", config.DefaultHighWatermark, "Percent of pushMemoryBufferLimit above which reading from connections is paused")
+	fLowWatermarkPtr         = flag.Int("backpressureLowWatermark", config.DefaultLowWatermark, "Percent of pushMemoryBufferLimit below which reading from connections resumes")
+	fOpenTSDBThrottlePtr     = flag.Bool("opentsdbThrottle", false, "Ask OpenTSDB clients to throttle writes and pause reading from their connections while the memory buffer is above the backpressureHighWatermark")
+	fOpenTSDBStripTagsPtr    = flag.String("opentsdbStripTags", "", "Comma-separated OpenTSDB tag keys removed from the opentsdbPorts points, such as _aggregate, each renamed instead if given as key=newKey")
+	fTagSourceIpPtr          = flag.Bool("tagSourceIp", false, "Tag points received on TCP and UDP listeners without a source of their own with the IP address they were sent from")
+	fSourceTagNamePtr        = flag.String("sourceTagName", config.DefaultSourceTagName, "Name of the tag set by tagSourceIp")
+	fTagAllowListPtr         = flag.String("tagAllowList", "", "Comma-separated list of regexes for point tag keys to keep, all others are stripped")
+	fTagDenyListPtr          = flag.String("tagDenyList", "", "Comma-separated list of regexes for point tag keys to strip")
+	fTagFilterDropPtr        = flag.Bool("tagFilterDropPoints", false, "Drop points with filtered tags instead of stripping the tags")
+	fMaxTagsPerPointPtr      = flag.Int("maxTagsPerPoint", 0, "Max point tags per point, points with more are trimmed to the first tags by key, unlimited if 0")
+	fMaxTagsDropPtr          = flag.Bool("maxTagsDropPoints", false, "Drop points over maxTagsPerPoint instead of trimming their tags")
+	fWhitelistRegexPtr       = flag.String("whitelistRegex", "", "Regex that metric names must match, all other points are dropped")
+	fBlacklistRegexPtr       = flag.String("blacklistRegex", "", "Regex for metric names to drop")
+	fPreprocessorConfigPtr   = flag.String("preprocessorConfig", "", "JSON file of rules to rename and rewrite metric names, sources and tags")
+	fSampleRulesPtr          = flag.String("sampleRules", "", "Comma-separated list of metric name regex=rate rules, keeping that fraction of the matching series")
+	fValueTransformPtr       = flag.String("valueTransform", "", "Comma-separated list of metric name regex:op:factor rules converting the values of the matching metrics, op is multiply, divide or offset")
+	fPointTagsPtr            = flag.String("pointTags", "", "Comma-separated list of key=value tags added to every point that does not set them")
+	fMetricPrefixPtr         = flag.String("metricPrefix", "", "Prefix prepended to the metric name of every point")
+	fPrefixSeparatorPtr      = flag.String("metricPrefixSeparator", config.DefaultPrefixSeparator, "Separator between the metricPrefix and metric names")
+	fMaxFutureSkewPtr        = flag.Int("maxFutureSkew", config.DefaultMaxFutureSkew, "Max seconds a point timestamp may be ahead of the proxy clock")
+	fMaxPastSkewPtr          = flag.Int("maxPastSkew", config.DefaultMaxPastSkew, "Max seconds a point timestamp may be behind the proxy clock")
+	fRejectNegativePtr       = flag.Bool("rejectNegative", false, "Drop points with negative values")
+	fClampTimestampsPtr      = flag.Bool("clampTimestamps", false, "Clamp timestamps outside maxFutureSkew and maxPastSkew instead of dropping the points")
+	fTimestampUnitPtr        = flag.String("timestampUnit", config.TimestampUnitAuto, "Unit of the Wavefront and OpenTSDB point timestamps: s, ms or auto to infer it from the number of digits")
+	fUseProxyTimePtr         = flag.Bool("useProxyTime", false, "Set the timestamp of every point to the time the proxy received it instead of keeping the client timestamp")
+	fLogBlockedSamplesPtr    = flag.Bool("logBlockedSamples", false, "Log samples of the points dropped by the filters and keep the last ones for the /blocked admin endpoint")
+	fPerSourceRateLimitPtr   = flag.Int("perSourceRateLimit", 0, "Max points per second accepted from each source, disabled if 0")
+	fCardinalityThresholdPtr = flag.Int("cardinalityThreshold", 0, "Series of a metric name above which it is logged, disabled if 0")
+	fCardinalityLimitPtr     = flag.Int("cardinalityLimit", 0, "Series of a metric name at which its new series are dropped, disabled if 0")
+	fDryRunPtr               = flag.Bool("dryRun", false, "Run the full pipeline without sending points to or checking in with the Wavefront server")
+	fDryRunFilePtr           = flag.String("dryRunFile", "", "File to write the points that would have been sent in dry run mode, logged if empty")
+	fVersionPtr              = flag.Bool("version", false, "Display the version and exit")
+	fDumpSpoolPtr            = flag.String("dumpSpool", "", "Print the points of a spool segment file to stdout and exit")
+	fStdinPtr                = flag.Bool("stdin", false, "Read points from stdin as well as the listeners, exiting once all are read and flushed")
+	fStdinFormatPtr          = flag.String("stdinFormat", config.SocketFormatWavefront, "Format of the points read from stdin: wavefront or opentsdb")
 )
 
 var (
-	version   string
-	commit    string
-	branch    string
-	tag       string
-	listeners []points.PointListener
+	version      string
+	commit       string
+	branch       string
+	tag          string
+	listeners    = make(map[string]points.PointListener)
+	listenersMtx sync.RWMutex
+	// configuration of each running listener, for its flush settings overrides and /listeners
+	listenerConfigs = make(map[string]listenerConfig)
+	// listeners stopped on the health server, not started by reloads until started there again
+	stoppedListeners = make(map[string]listenerConfig)
+	// flush settings overridden per port group, only set from a config file
+	fListenersPtr = new(config.ListenerOverrides)
+	tlsConfig     *tls.Config
+	preprocessor  points.PreprocessorChain
+	metricFilter  *points.MetricFilter
+	// services using the token, updated from the tokenFile or tokenCommand
+	tokenServices  []*api.WavefrontAPIService
+	tokenRefresher *api.TokenRefresher
+	// service of the internalMetricsServer, nil if not set
+	internalMetricsService *api.WavefrontAPIService
 )
 
-func parseCfg(filename string) {
-	proxyConfig, err := config.LoadConfig(filename)
+type listenerConfig struct {
+	group      string // the setting listing the port
+	host       string // interface to listen on, all if empty
+	port       int
+	socketPath string // set for unix listeners
+	protocol   string
+	format     string
+	builder    decoder.DecoderBuilder
+	// decoders for the format query parameter of http listeners
+	formatBuilders map[string]decoder.DecoderBuilder
+}
+
+// config settings whose flag names differ from the setting names
+var flagSettings = map[string]string{
+	"host":       "hostname",
+	"pprof-addr": "pprofAddr",
+}
+
+// Loads the settings, flags take precedence over WAVEFRONT_<SETTING> environment variables,
+// which take precedence over the configuration file and then the defaults.
+func loadConfig() (*config.ProxyConfig, error) {
+	var proxyConfig *config.ProxyConfig
+	var err error
+	if *fCfgPtr != "" {
+		proxyConfig, err = config.LoadConfig(*fCfgPtr)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// without a file the flag defaults are the base settings
+		proxyConfig = &config.ProxyConfig{}
+		flag.VisitAll(func(f *flag.Flag) {
+			setFromFlag(proxyConfig, f)
+		})
+		err = config.ApplyEnv(proxyConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		if err == nil {
+			err = setFromFlag(proxyConfig, f)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := proxyConfig.ResolveToken(); err != nil {
+		return nil, err
+	}
+	return proxyConfig, proxyConfig.Validate()
+}
+
+// Copies a flag value to its config setting, flags without a setting are ignored.
+func setFromFlag(proxyConfig *config.ProxyConfig, f *flag.Flag) error {
+	name := f.Name
+	if setting, ok := flagSettings[name]; ok {
+		name = setting
+	}
+	err := proxyConfig.Set(name, f.Value.String())
+	if err == config.ErrUnknownSetting {
+		return nil
+	}
+	return err
+}
+
+func parseCfg() {
+	proxyConfig, err := loadConfig()
 	if err != nil {
-		log.Fatal("Error loading config file: ", err)
+		logger.Fatal("Error loading config: ", err)
 	}
 
 	fTokenPtr = &proxyConfig.Token
+	fTokenFilePtr = &proxyConfig.TokenFile
+	fCSVDelimiterPtr = &proxyConfig.CSVDelimiter
+	fCSVColumnsPtr = &proxyConfig.CSVColumns
+	fCSVHeaderPtr = &proxyConfig.CSVHeader
+	fTokenCommandPtr = &proxyConfig.TokenCommand
+	fTokenRefreshPtr = &proxyConfig.TokenRefreshInterval
 	fServerPtr = &proxyConfig.Server
+	fDirectIngestionPtr = &proxyConfig.DirectIngestion
+	fAdditionalServersPtr = &proxyConfig.AdditionalServers
+	fAdditionalTokensPtr = &proxyConfig.AdditionalTokens
+	fShardByTagPtr = &proxyConfig.ShardByTag
+	fInternalServerPtr = &proxyConfig.InternalMetricsServer
+	fInternalTokenPtr = &proxyConfig.InternalMetricsToken
+	fInternalPrefixPtr = &proxyConfig.InternalMetricsPrefix
 	fHostnamePtr = &proxyConfig.Hostname
 	fWavefrontPortsPtr = &proxyConfig.PushListenerPorts
+	fGraphiteTaggedPtr = &proxyConfig.GraphiteTaggedNames
 	fOpenTSDBPortsPtr = &proxyConfig.OpenTSDBPorts
+	fStatsDPortsPtr = &proxyConfig.StatsDPorts
+	fStatsDGaugeExpiryPtr = &proxyConfig.StatsDGaugeExpiry
+	fCollectdPortsPtr = &proxyConfig.CollectdPorts
+	fInfluxPortsPtr = &proxyConfig.InfluxPorts
+	fCSVPortsPtr = &proxyConfig.CSVPorts
+	fAutoDetectPortsPtr = &proxyConfig.AutoDetectPorts
+	fHttpPortPtr = &proxyConfig.HttpPort
+	fPromWritePortPtr = &proxyConfig.PromWritePort
+	fBindAddressPtr = &proxyConfig.BindAddress
+	fHistogramMinutePortsPtr = &proxyConfig.HistogramMinutePort
+	fHistogramHourPortsPtr = &proxyConfig.HistogramHourPort
+	fHistogramDayPortsPtr = &proxyConfig.HistogramDayPort
+	fHistogramDistPortsPtr = &proxyConfig.HistogramDistPort
 	fFlushThreadsPtr = &proxyConfig.FlushThreads
+	fFlushRetriesPtr = &proxyConfig.FlushRetries
+	fFlushTimeoutPtr = &proxyConfig.FlushTimeout
+	fCircuitThresholdPtr = &proxyConfig.CircuitBreakerThreshold
+	fCircuitCooldownPtr = &proxyConfig.CircuitBreakerCooldown
+	fGzipUploadPtr = &proxyConfig.GzipUpload
 	fFlushIntervalPtr = &proxyConfig.PushFlushInterval
 	fFlushMaxPointsPtr = &proxyConfig.PushFlushMaxPoints
+	fFlushTriggerPtr = &proxyConfig.PushFlushTriggerPercent
+	fSortByTimestampPtr = &proxyConfig.SortByTimestamp
+	fMaxFlushBytesPtr = &proxyConfig.MaxFlushBytes
 	fMaxBufferSizePtr = &proxyConfig.PushMemoryBufferLimit
+	fMaxBufferBytesPtr = &proxyConfig.PushMemoryBufferBytes
+	fDropPolicyPtr = &proxyConfig.DropPolicy
+	fPushRateLimitPtr = &proxyConfig.PushRateLimit
+	fMaxFlushesPtr = &proxyConfig.MaxConcurrentFlushes
+	fSharedFlushWindowPtr = &proxyConfig.SharedFlushWindow
+	fBufferFilePtr = &proxyConfig.BufferFile
+	fBufferDiskLimitPtr = &proxyConfig.BufferDiskLimit
+	fBufferCompressPtr = &proxyConfig.BufferCompress
+	fShutdownTimeoutPtr = &proxyConfig.ShutdownTimeout
+	fDrainTimeoutPtr = &proxyConfig.DrainTimeout
+	fCheckinIntervalPtr = &proxyConfig.CheckinInterval
+	fRuntimeStatsPtr = &proxyConfig.RuntimeStatsInterval
+	fStartupGracePtr = &proxyConfig.StartupGrace
 	fIdFilePtr = &proxyConfig.IdFile
+	fAgentIdPtr = &proxyConfig.AgentId
+	fAgentIdSaltPtr = &proxyConfig.AgentIdSalt
 	fLogFilePtr = &proxyConfig.LogFile
+	fLogLevelPtr = &proxyConfig.LogLevel
+	fLogFormatPtr = &proxyConfig.LogFormat
 	fPprofAddr = &proxyConfig.PprofAddr
+	fHealthPortPtr = &proxyConfig.HealthPort
+	fAdminAddrPtr = &proxyConfig.AdminAddr
+	fHttpProxyPtr = &proxyConfig.HttpProxy
+	fApiMaxIdleConnsPtr = &proxyConfig.ApiMaxIdleConns
+	fApiIdleConnTimeoutPtr = &proxyConfig.ApiIdleConnTimeout
+	fApiDnsRefreshPtr = &proxyConfig.ApiDnsRefreshInterval
+	fApiTimeoutPtr = &proxyConfig.ApiTimeout
+	fApiCaFilePtr = &proxyConfig.ApiCaFile
+	fApiServerNamePtr = &proxyConfig.ApiServerName
+	fApiSkipVerifyPtr = &proxyConfig.ApiInsecureSkipVerify
+	fTlsCertFilePtr = &proxyConfig.TlsCertFile
+	fTlsKeyFilePtr = &proxyConfig.TlsKeyFile
+	fTlsCaFilePtr = &proxyConfig.TlsCaFile
+	fMaxConnectionsPtr = &proxyConfig.MaxConnections
+	fAcceptGoroutinesPtr = &proxyConfig.AcceptGoroutines
+	fAbuseThresholdPtr = &proxyConfig.AbuseThreshold
+	fAbuseActionPtr = &proxyConfig.AbuseAction
+	fConnIdleTimeoutPtr = &proxyConfig.ConnectionIdleTimeout
+	fTcpKeepAlivePtr = &proxyConfig.TcpKeepAlive
+	fReusePortPtr = &proxyConfig.ReusePort
+	fSocketPathPtr = &proxyConfig.SocketPath
+	fSocketFormatPtr = &proxyConfig.SocketFormat
+	fSocketModePtr = &proxyConfig.SocketMode
+	fMaxLineLengthPtr = &proxyConfig.MaxLineLength
+	fReadBufferSizePtr = &proxyConfig.ReadBufferSize
+	fDedupPtr = &proxyConfig.Dedup
+	fBackpressurePtr = &proxyConfig.Backpressure
+	fHighWatermarkPtr = &proxyConfig.BackpressureHighWatermark
+	fLowWatermarkPtr = &proxyConfig.BackpressureLowWatermark
+	fOpenTSDBThrottlePtr = &proxyConfig.OpenTSDBThrottle
+	fOpenTSDBStripTagsPtr = &proxyConfig.OpenTSDBStripTags
+	fTagSourceIpPtr = &proxyConfig.TagSourceIp
+	fSourceTagNamePtr = &proxyConfig.SourceTagName
+	fTagAllowListPtr = &proxyConfig.TagAllowList
+	fTagDenyListPtr = &proxyConfig.TagDenyList
+	fTagFilterDropPtr = &proxyConfig.TagFilterDropPoints
+	fMaxTagsPerPointPtr = &proxyConfig.MaxTagsPerPoint
+	fMaxTagsDropPtr = &proxyConfig.MaxTagsDropPoints
+	fWhitelistRegexPtr = &proxyConfig.WhitelistRegex
+	fBlacklistRegexPtr = &proxyConfig.BlacklistRegex
+	fPerSourceRateLimitPtr = &proxyConfig.PerSourceRateLimit
+	fLogBlockedSamplesPtr = &proxyConfig.LogBlockedSamples
+	fCardinalityThresholdPtr = &proxyConfig.CardinalityThreshold
+	fCardinalityLimitPtr = &proxyConfig.CardinalityLimit
+	fPreprocessorConfigPtr = &proxyConfig.PreprocessorConfig
+	fSampleRulesPtr = &proxyConfig.SampleRules
+	fValueTransformPtr = &proxyConfig.ValueTransform
+	fPointTagsPtr = &proxyConfig.PointTags
+	fMetricPrefixPtr = &proxyConfig.MetricPrefix
+	fPrefixSeparatorPtr = &proxyConfig.MetricPrefixSeparator
+	fDryRunPtr = &proxyConfig.DryRun
+	fDryRunFilePtr = &proxyConfig.DryRunFile
+	fMaxFutureSkewPtr = &proxyConfig.MaxFutureSkew
+	fMaxPastSkewPtr = &proxyConfig.MaxPastSkew
+	fClampTimestampsPtr = &proxyConfig.ClampTimestamps
+	fUseProxyTimePtr = &proxyConfig.UseProxyTime
+	fTimestampUnitPtr = &proxyConfig.TimestampUnit
+	fRejectNegativePtr = &proxyConfig.RejectNegative
+	fListenersPtr = &proxyConfig.Listeners
 }
 
-func waitForShutdown() {
+// Reloads the flush and listener settings from the configuration file.
+// Settings which require a restart are ignored.
+func reloadCfg(service api.WavefrontAPI) {
+	if *fCfgPtr == "" {
+		if tokenRefresher != nil {
+			tokenRefresher.Refresh()
+		} else {
+			logger.Info("No configuration file to reload")
+		}
+		return
+	}
+
+	logger.Info("Reloading configuration from", *fCfgPtr)
+	proxyConfig, err := loadConfig()
+	if err != nil {
+		logger.Error("Error reloading config file:", err)
+		return
+	}
+
+	warnIfChanged("server", *fServerPtr, proxyConfig.Server)
+	warnIfChanged("directIngestion", *fDirectIngestionPtr, proxyConfig.DirectIngestion)
+	warnIfChanged("tokenFile", *fTokenFilePtr, proxyConfig.TokenFile)
+	warnIfChanged("csvDelimiter", *fCSVDelimiterPtr, proxyConfig.CSVDelimiter)
+	warnIfChanged("graphiteTaggedNames", *fGraphiteTaggedPtr, proxyConfig.GraphiteTaggedNames)
+	warnIfChanged("csvColumns", *fCSVColumnsPtr, proxyConfig.CSVColumns)
+	warnIfChanged("csvHeader", *fCSVHeaderPtr, proxyConfig.CSVHeader)
+	warnIfChanged("tokenCommand", *fTokenCommandPtr, proxyConfig.TokenCommand)
+	warnIfChanged("tokenRefreshInterval", *fTokenRefreshPtr, proxyConfig.TokenRefreshInterval)
+	if tokenRefresher != nil {
+		tokenRefresher.Refresh()
+	} else {
+		warnIfChanged("token", *fTokenPtr, proxyConfig.Token)
+	}
+	warnIfChanged("shardByTag", *fShardByTagPtr, proxyConfig.ShardByTag)
+	warnIfChanged("internalMetricsServer", *fInternalServerPtr, proxyConfig.InternalMetricsServer)
+	warnIfChanged("internalMetricsToken", *fInternalTokenPtr, proxyConfig.InternalMetricsToken)
+	warnIfChanged("internalMetricsPrefix", *fInternalPrefixPtr, proxyConfig.InternalMetricsPrefix)
+	if sharded, ok := service.(*api.ShardedWavefrontAPI); ok {
+		reshard(sharded, proxyConfig)
+	} else {
+		warnIfChanged("additionalServers", *fAdditionalServersPtr, proxyConfig.AdditionalServers)
+		warnIfChanged("additionalTokens", *fAdditionalTokensPtr, proxyConfig.AdditionalTokens)
+	}
+	if proxyConfig.Hostname != "" {
+		warnIfChanged("hostname", *fHostnamePtr, proxyConfig.Hostname)
+	}
+	warnIfChanged("statsdGaugeExpiry", *fStatsDGaugeExpiryPtr, proxyConfig.StatsDGaugeExpiry)
+	warnIfChanged("flushRetries", *fFlushRetriesPtr, proxyConfig.FlushRetries)
+	warnIfChanged("flushTimeout", *fFlushTimeoutPtr, proxyConfig.FlushTimeout)
+	warnIfChanged("circuitBreakerThreshold", *fCircuitThresholdPtr, proxyConfig.CircuitBreakerThreshold)
+	warnIfChanged("circuitBreakerCooldown", *fCircuitCooldownPtr, proxyConfig.CircuitBreakerCooldown)
+	warnIfChanged("gzipUpload", *fGzipUploadPtr, proxyConfig.GzipUpload)
+	warnIfChanged("bufferFile", *fBufferFilePtr, proxyConfig.BufferFile)
+	warnIfChanged("bufferDiskLimit", *fBufferDiskLimitPtr, proxyConfig.BufferDiskLimit)
+	fBufferCompressPtr = &proxyConfig.BufferCompress
+	warnIfChanged("shutdownTimeout", *fShutdownTimeoutPtr, proxyConfig.ShutdownTimeout)
+	warnIfChanged("drainTimeout", *fDrainTimeoutPtr, proxyConfig.DrainTimeout)
+	warnIfChanged("checkinInterval", *fCheckinIntervalPtr, proxyConfig.CheckinInterval)
+	warnIfChanged("runtimeStatsInterval", *fRuntimeStatsPtr, proxyConfig.RuntimeStatsInterval)
+	warnIfChanged("startupGrace", *fStartupGracePtr, proxyConfig.StartupGrace)
+	warnIfChanged("idFile", *fIdFilePtr, proxyConfig.IdFile)
+	warnIfChanged("agentId", *fAgentIdPtr, proxyConfig.AgentId)
+	warnIfChanged("agentIdSalt", *fAgentIdSaltPtr, proxyConfig.AgentIdSalt)
+	warnIfChanged("logFile", *fLogFilePtr, proxyConfig.LogFile)
+	warnIfChanged("logFormat", *fLogFormatPtr, proxyConfig.LogFormat)
+	warnIfChanged("pprofAddr", *fPprofAddr, proxyConfig.PprofAddr)
+	warnIfChanged("healthPort", *fHealthPortPtr, proxyConfig.HealthPort)
+	warnIfChanged("adminAddr", *fAdminAddrPtr, proxyConfig.AdminAddr)
+	warnIfChanged("httpProxy", *fHttpProxyPtr, proxyConfig.HttpProxy)
+	warnIfChanged("apiMaxIdleConns", *fApiMaxIdleConnsPtr, proxyConfig.ApiMaxIdleConns)
+	warnIfChanged("apiIdleConnTimeout", *fApiIdleConnTimeoutPtr, proxyConfig.ApiIdleConnTimeout)
+	warnIfChanged("apiTimeout", *fApiTimeoutPtr, proxyConfig.ApiTimeout)
+	warnIfChanged("apiDnsRefreshInterval", *fApiDnsRefreshPtr, proxyConfig.ApiDnsRefreshInterval)
+	warnIfChanged("apiCaFile", *fApiCaFilePtr, proxyConfig.ApiCaFile)
+	warnIfChanged("apiServerName", *fApiServerNamePtr, proxyConfig.ApiServerName)
+	warnIfChanged("apiInsecureSkipVerify", *fApiSkipVerifyPtr, proxyConfig.ApiInsecureSkipVerify)
+	warnIfChanged("tlsCertFile", *fTlsCertFilePtr, proxyConfig.TlsCertFile)
+	warnIfChanged("tlsKeyFile", *fTlsKeyFilePtr, proxyConfig.TlsKeyFile)
+	warnIfChanged("tlsCaFile", *fTlsCaFilePtr, proxyConfig.TlsCaFile)
+	warnIfChanged("maxConnections", *fMaxConnectionsPtr, proxyConfig.MaxConnections)
+	warnIfChanged("acceptGoroutines", *fAcceptGoroutinesPtr, proxyConfig.AcceptGoroutines)
+	warnIfChanged("abuseThreshold", *fAbuseThresholdPtr, proxyConfig.AbuseThreshold)
+	warnIfChanged("abuseAction", *fAbuseActionPtr, proxyConfig.AbuseAction)
+	warnIfChanged("connectionIdleTimeout", *fConnIdleTimeoutPtr, proxyConfig.ConnectionIdleTimeout)
+	warnIfChanged("tcpKeepAlive", *fTcpKeepAlivePtr, proxyConfig.TcpKeepAlive)
+	warnIfChanged("reusePort", *fReusePortPtr, proxyConfig.ReusePort)
+	warnIfChanged("socketFormat", *fSocketFormatPtr, proxyConfig.SocketFormat)
+	warnIfChanged("socketMode", *fSocketModePtr, proxyConfig.SocketMode)
+	warnIfChanged("maxLineLength", *fMaxLineLengthPtr, proxyConfig.MaxLineLength)
+	warnIfChanged("readBufferSize", *fReadBufferSizePtr, proxyConfig.ReadBufferSize)
+	warnIfChanged("dedup", *fDedupPtr, proxyConfig.Dedup)
+	warnIfChanged("backpressure", *fBackpressurePtr, proxyConfig.Backpressure)
+	warnIfChanged("backpressureHighWatermark", *fHighWatermarkPtr, proxyConfig.BackpressureHighWatermark)
+	warnIfChanged("backpressureLowWatermark", *fLowWatermarkPtr, proxyConfig.BackpressureLowWatermark)
+	warnIfChanged("opentsdbThrottle", *fOpenTSDBThrottlePtr, proxyConfig.OpenTSDBThrottle)
+	warnIfChanged("opentsdbStripTags", *fOpenTSDBStripTagsPtr, proxyConfig.OpenTSDBStripTags)
+	warnIfChanged("tagSourceIp", *fTagSourceIpPtr, proxyConfig.TagSourceIp)
+	warnIfChanged("sourceTagName", *fSourceTagNamePtr, proxyConfig.SourceTagName)
+	warnIfChanged("tagAllowList", *fTagAllowListPtr, proxyConfig.TagAllowList)
+	warnIfChanged("tagDenyList", *fTagDenyListPtr, proxyConfig.TagDenyList)
+	warnIfChanged("tagFilterDropPoints", *fTagFilterDropPtr, proxyConfig.TagFilterDropPoints)
+	warnIfChanged("maxTagsPerPoint", *fMaxTagsPerPointPtr, proxyConfig.MaxTagsPerPoint)
+	warnIfChanged("maxTagsDropPoints", *fMaxTagsDropPtr, proxyConfig.MaxTagsDropPoints)
+	warnIfChanged("whitelistRegex", *fWhitelistRegexPtr, proxyConfig.WhitelistRegex)
+	warnIfChanged("blacklistRegex", *fBlacklistRegexPtr, proxyConfig.BlacklistRegex)
+	warnIfChanged("perSourceRateLimit", *fPerSourceRateLimitPtr, proxyConfig.PerSourceRateLimit)
+	warnIfChanged("cardinalityThreshold", *fCardinalityThresholdPtr, proxyConfig.CardinalityThreshold)
+	warnIfChanged("cardinalityLimit", *fCardinalityLimitPtr, proxyConfig.CardinalityLimit)
+	warnIfChanged("preprocessorConfig", *fPreprocessorConfigPtr, proxyConfig.PreprocessorConfig)
+	warnIfChanged("sampleRules", *fSampleRulesPtr, proxyConfig.SampleRules)
+	warnIfChanged("valueTransform", *fValueTransformPtr, proxyConfig.ValueTransform)
+	warnIfChanged("pointTags", *fPointTagsPtr, proxyConfig.PointTags)
+	warnIfChanged("metricPrefix", *fMetricPrefixPtr, proxyConfig.MetricPrefix)
+	warnIfChanged("metricPrefixSeparator", *fPrefixSeparatorPtr, proxyConfig.MetricPrefixSeparator)
+	warnIfChanged("dryRun", *fDryRunPtr, proxyConfig.DryRun)
+	warnIfChanged("dryRunFile", *fDryRunFilePtr, proxyConfig.DryRunFile)
+	warnIfChanged("maxFutureSkew", *fMaxFutureSkewPtr, proxyConfig.MaxFutureSkew)
+	warnIfChanged("maxPastSkew", *fMaxPastSkewPtr, proxyConfig.MaxPastSkew)
+	warnIfChanged("clampTimestamps", *fClampTimestampsPtr, proxyConfig.ClampTimestamps)
+	warnIfChanged("useProxyTime", *fUseProxyTimePtr, proxyConfig.UseProxyTime)
+	warnIfChanged("rejectNegative", *fRejectNegativePtr, proxyConfig.RejectNegative)
+
+	fWavefrontPortsPtr = &proxyConfig.PushListenerPorts
+	fOpenTSDBPortsPtr = &proxyConfig.OpenTSDBPorts
+	fStatsDPortsPtr = &proxyConfig.StatsDPorts
+	fCollectdPortsPtr = &proxyConfig.CollectdPorts
+	fInfluxPortsPtr = &proxyConfig.InfluxPorts
+	fCSVPortsPtr = &proxyConfig.CSVPorts
+	fAutoDetectPortsPtr = &proxyConfig.AutoDetectPorts
+	fHttpPortPtr = &proxyConfig.HttpPort
+	fPromWritePortPtr = &proxyConfig.PromWritePort
+	fBindAddressPtr = &proxyConfig.BindAddress
+	fHistogramMinutePortsPtr = &proxyConfig.HistogramMinutePort
+	fHistogramHourPortsPtr = &proxyConfig.HistogramHourPort
+	fHistogramDayPortsPtr = &proxyConfig.HistogramDayPort
+	fHistogramDistPortsPtr = &proxyConfig.HistogramDistPort
+	fSocketPathPtr = &proxyConfig.SocketPath
+	fFlushThreadsPtr = &proxyConfig.FlushThreads
+	fFlushIntervalPtr = &proxyConfig.PushFlushInterval
+	fFlushMaxPointsPtr = &proxyConfig.PushFlushMaxPoints
+	fFlushTriggerPtr = &proxyConfig.PushFlushTriggerPercent
+	fSortByTimestampPtr = &proxyConfig.SortByTimestamp
+	fMaxFlushBytesPtr = &proxyConfig.MaxFlushBytes
+	fMaxBufferSizePtr = &proxyConfig.PushMemoryBufferLimit
+	fMaxBufferBytesPtr = &proxyConfig.PushMemoryBufferBytes
+	fDropPolicyPtr = &proxyConfig.DropPolicy
+	fListenersPtr = &proxyConfig.Listeners
+	fPushRateLimitPtr = &proxyConfig.PushRateLimit
+	fMaxFlushesPtr = &proxyConfig.MaxConcurrentFlushes
+	fSharedFlushWindowPtr = &proxyConfig.SharedFlushWindow
+	fLogLevelPtr = &proxyConfig.LogLevel
+	fLogBlockedSamplesPtr = &proxyConfig.LogBlockedSamples
+	fTimestampUnitPtr = &proxyConfig.TimestampUnit
+
+	if level, err := logger.ParseLevel(*fLogLevelPtr); err == nil {
+		logger.SetLevel(level)
+	}
+	points.SetPushRateLimit(*fPushRateLimitPtr)
+	points.SetFlushTriggerPercent(*fFlushTriggerPtr)
+	points.SetDropPolicy(*fDropPolicyPtr)
+	points.SetSortByTimestamp(*fSortByTimestampPtr)
+	points.SetMaxFlushBytes(*fMaxFlushBytesPtr)
+	points.SetMaxConcurrentFlushes(*fMaxFlushesPtr)
+	points.SetSpoolCompression(*fBufferCompressPtr)
+	points.SetSharedFlush(time.Duration(*fSharedFlushWindowPtr) * time.Millisecond)
+	points.SetLogBlockedSamples(*fLogBlockedSamplesPtr)
+	parser.SetTimestampUnit(timestampUnit(*fTimestampUnitPtr))
+	err = updateListeners(service)
+	if err != nil {
+		logger.Error("Error updating listeners:", err)
+	}
+}
+
+// Refreshes the token of the services using it from the tokenFile or tokenCommand, if set.
+func startTokenRefresh() {
+	source := config.TokenSource(*fTokenFilePtr, *fTokenCommandPtr)
+	if source == nil {
+		return
+	}
+	interval := time.Duration(*fTokenRefreshPtr) * time.Second
+	tokenRefresher = api.NewTokenRefresher(api.TokenSource(source), interval, *fTokenPtr, tokenServices...)
+}
+
+func warnIfChanged(name string, current, updated interface{}) {
+	if current != updated {
+		logger.Warnf("Ignoring change to %s, a restart is required", name)
+	}
+}
+
+// Reloads the configuration on SIGHUP and stops the proxy on SIGINT or SIGTERM, or once stdin
+// has been read in stdin mode.
+func waitForShutdown(service api.WavefrontAPI) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	inputDone := stdinDone()
 	for {
-		signals := make(chan os.Signal)
-		signal.Notify(signals, os.Interrupt)
 		select {
 		case sig := <-signals:
-			if sig == os.Interrupt {
-				log.Println("Stopping Wavefront Proxy")
-				stopListeners()
-				os.Exit(0)
+			if sig == syscall.SIGHUP {
+				reloadCfg(service)
+				continue
 			}
+		case <-inputDone:
+			logger.Info("Finished reading stdin")
+		}
+		logger.Info("Stopping Wavefront Proxy")
+		stopListeners()
+		drain.wait()
+		if multi, ok := service.(*api.MultiWavefrontAPI); ok {
+			multi.Close(time.Duration(*fShutdownTimeoutPtr) * time.Second)
 		}
+		os.Exit(0)
+	}
+}
+
+// Closed once the stdin listener has read all of stdin, nil if not reading stdin.
+func stdinDone() <-chan struct{} {
+	listenersMtx.RLock()
+	defer listenersMtx.RUnlock()
+	if listener, ok := listeners[points.ProtocolStdin].(*points.DefaultPointListener); ok {
+		return listener.InputDone()
 	}
+	return nil
 }
 
+// Stops the listeners in parallel, each flushing its buffered points within the shutdown timeout.
 func stopListeners() {
+	listenersMtx.RLock()
+	defer listenersMtx.RUnlock()
+
+	var wg sync.WaitGroup
 	for _, listener := range listeners {
-		listener.Stop()
+		wg.Add(1)
+		go func(listener points.PointListener) {
+			defer wg.Done()
+			listener.Stop()
+		}(listener)
 	}
+	wg.Wait()
 }
 
 func checkRequiredFlag(val string, msg string) {
 	if val == "" {
-		log.Println(msg)
+		logger.Error(msg)
 		flag.Usage()
 		os.Exit(1)
 	}
@@ -100,18 +641,30 @@ func checkHostname() {
 	if *fHostnamePtr == "" {
 		hostname, err := os.Hostname()
 		if err != nil {
-			log.Fatal("Error resolving hostname")
+			logger.Fatal("Error resolving hostname")
 		}
 		fHostnamePtr = &hostname
 	}
 }
 
 func setupLogger() {
+	level, err := logger.ParseLevel(*fLogLevelPtr)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	logger.SetLevel(level)
+	err = logger.SetFormat(*fLogFormatPtr)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
 	if *fLogFilePtr != "" {
 		f, err := os.Create(*fLogFilePtr)
 		if err != nil {
 			panic(err)
 		}
+		logger.SetOutput(f)
+		// for messages logged by the standard library, such as the HTTP server
 		log.SetOutput(f)
 	}
 }
@@ -129,95 +682,762 @@ func checkFlags() {
 	// check for flags which do something and exit immediately
 	switch {
 	case *fVersionPtr:
-		log.Printf("wavefront-proxy v%s (git: %s %s)\n", getVersion(), branch, commit)
+		logger.Infof("wavefront-proxy v%s (git: %s %s)", getVersion(), branch, commit)
 		os.Exit(0)
+	case *fDumpSpoolPtr != "":
+		os.Exit(dumpSpool(*fDumpSpoolPtr))
+	}
+	if *fStdinFormatPtr != config.SocketFormatWavefront && *fStdinFormatPtr != config.SocketFormatOpenTSDB {
+		logger.Fatalf("stdinFormat %q must be wavefront or opentsdb", *fStdinFormatPtr)
 	}
 
-	if *fCfgPtr != "" {
-		parseCfg(*fCfgPtr)
+	parseCfg()
+	// the server is not contacted in dry run mode
+	if !*fDryRunPtr {
+		checkRequiredFlag(*fTokenPtr, "Missing token")
+		checkRequiredFlag(*fServerPtr, "Missing server")
 	}
-	checkRequiredFlag(*fTokenPtr, "Missing token")
-	checkRequiredFlag(*fServerPtr, "Missing server")
 	checkHostname()
 	setupLogger()
+	setupTLS()
+	setupPreprocessor()
+	if *fReusePortPtr && !points.ReusePortSupported {
+		logger.Warnf("reusePort is not supported on %s, the listeners do not set SO_REUSEPORT", runtime.GOOS)
+	}
 }
 
-func startPointListener(listener points.PointListener, service api.WavefrontAPI) {
-	listener.Start(*fFlushThreadsPtr, *fFlushIntervalPtr, *fMaxBufferSizePtr, *fFlushMaxPointsPtr,
-		api.FormatGraphiteV2, api.GraphiteBlockWorkUnit, service)
+func setupPreprocessor() {
+	// first so that the rules and filters see the names sent
+	if *fMetricPrefixPtr != "" {
+		prefixer, err := points.NewMetricPrefixer(*fMetricPrefixPtr, *fPrefixSeparatorPtr)
+		if err != nil {
+			logger.Fatal("Invalid metric prefix: ", err)
+		}
+		preprocessor = append(preprocessor, prefixer)
+	}
+	// before the value filter, which then checks the converted values
+	if *fValueTransformPtr != "" {
+		transformer, err := points.NewValueTransformer(*fValueTransformPtr)
+		if err != nil {
+			logger.Fatal("Invalid value transform: ", err)
+		}
+		preprocessor = append(preprocessor, transformer)
+	}
+	preprocessor = append(preprocessor, points.NewValueFilter(*fRejectNegativePtr))
+	preprocessor = append(preprocessor, points.NewTimestampFilter(*fMaxFutureSkewPtr, *fMaxPastSkewPtr, *fClampTimestampsPtr))
+
+	// rules apply before the filters so that they see the rewritten points
+	if *fPreprocessorConfigPtr != "" {
+		rules, err := points.LoadRules(*fPreprocessorConfigPtr)
+		if err != nil {
+			logger.Fatal("Invalid preprocessor rules: ", err)
+		}
+		preprocessor = append(preprocessor, rules)
+	}
+	// always installed as the server may push a whitelist or blacklist at check-in
+	var err error
+	metricFilter, err = points.NewMetricFilter(*fWhitelistRegexPtr, *fBlacklistRegexPtr)
+	if err != nil {
+		logger.Fatal("Invalid metric filter: ", err)
+	}
+	preprocessor = append(preprocessor, metricFilter)
+	allow, deny := config.SplitList(*fTagAllowListPtr), config.SplitList(*fTagDenyListPtr)
+	if len(allow) > 0 || len(deny) > 0 {
+		tagFilter, err := points.NewTagFilter(allow, deny, *fTagFilterDropPtr)
+		if err != nil {
+			logger.Fatal("Invalid tag filter: ", err)
+		}
+		preprocessor = append(preprocessor, tagFilter)
+	}
+	// after the tag filter so that only the tags kept are counted
+	if *fMaxTagsPerPointPtr > 0 {
+		preprocessor = append(preprocessor, points.NewTagLimiter(*fMaxTagsPerPointPtr, *fMaxTagsDropPtr))
+	}
+	if *fSampleRulesPtr != "" {
+		sampler, err := points.NewSampler(*fSampleRulesPtr)
+		if err != nil {
+			logger.Fatal("Invalid sample rules: ", err)
+		}
+		preprocessor = append(preprocessor, sampler)
+	}
+	if *fPerSourceRateLimitPtr > 0 {
+		preprocessor = append(preprocessor, points.NewSourceRateLimiter(*fPerSourceRateLimitPtr))
+	}
+	// after the filters so that only the series of points kept are counted
+	if *fCardinalityThresholdPtr > 0 || *fCardinalityLimitPtr > 0 {
+		preprocessor = append(preprocessor, points.NewCardinalityTracker(*fCardinalityThresholdPtr, *fCardinalityLimitPtr))
+	}
+	// last so that the tags are not filtered and only added to points kept
+	if *fPointTagsPtr != "" {
+		tagger, err := points.NewPointTagger(*fPointTagsPtr)
+		if err != nil {
+			logger.Fatal("Invalid point tags: ", err)
+		}
+		preprocessor = append(preprocessor, tagger)
+	}
+}
+
+func setupTLS() {
+	if *fTlsCertFilePtr == "" && *fTlsKeyFilePtr == "" {
+		return
+	}
+	checkRequiredFlag(*fTlsCertFilePtr, "Missing tlsCertFile")
+	checkRequiredFlag(*fTlsKeyFilePtr, "Missing tlsKeyFile")
+
+	var err error
+	tlsConfig, err = points.NewTLSConfig(*fTlsCertFilePtr, *fTlsKeyFilePtr, *fTlsCaFilePtr)
+	if err != nil {
+		logger.Fatal("Error loading TLS configuration: ", err)
+	}
+}
+
+// Returns the flush settings of the listeners in the port group.
+func flushSettings(group string) config.FlushSettings {
+	global := config.FlushSettings{
+		FlushThreads:          *fFlushThreadsPtr,
+		PushFlushInterval:     *fFlushIntervalPtr,
+		PushFlushMaxPoints:    *fFlushMaxPointsPtr,
+		PushMemoryBufferLimit: *fMaxBufferSizePtr,
+		PushMemoryBufferBytes: *fMaxBufferBytesPtr,
+		UseProxyTime:          fUseProxyTimePtr,
+	}
+	return global.Override(fListenersPtr.Get(group))
 }
 
-func startPointListeners(service api.WavefrontAPI, portsList string, builder decoder.DecoderBuilder) {
+func startPointListener(listener points.PointListener, cfg listenerConfig, service api.WavefrontAPI) error {
+	s := flushSettings(cfg.group)
+	if fListenersPtr.Get(cfg.group) != (config.FlushSettings{}) {
+		logger.Infof("Using %s flush settings %+v", cfg.group, s)
+	}
+	return listener.Start(s.FlushThreads, s.PushFlushInterval, s.PushMemoryBufferLimit, s.PushMemoryBufferBytes,
+		s.PushFlushMaxPoints, cfg.format, api.GraphiteBlockWorkUnit, service)
+}
+
+func updatePointListener(listener points.PointListener, group string) {
+	s := flushSettings(group)
+	listener.Update(s.FlushThreads, s.PushFlushInterval, s.PushMemoryBufferLimit, s.PushMemoryBufferBytes,
+		s.PushFlushMaxPoints)
+}
+
+func addListenerConfigs(configs map[string]listenerConfig, group, portsList, protocol, format string, builder decoder.DecoderBuilder) error {
+	if portsList == "" {
+		return nil
+	}
 	ports := strings.Split(portsList, ",")
 	for _, portStr := range ports {
-		port, err := strconv.Atoi(portStr)
+		host, port, err := config.SplitListenAddr(portStr, *fBindAddressPtr)
 		if err != nil {
-			log.Fatal("Invalid port " + portStr)
+			return errors.New("Invalid port " + portStr)
 		}
-		listener := &points.DefaultPointListener{Port: port, Builder: builder}
-		listeners = append(listeners, listener)
-		startPointListener(listener, service)
+		configs[listenerKey(protocol, host, port)] = listenerConfig{
+			group: group, host: host, port: port, protocol: protocol, format: format, builder: builder}
 	}
+	return nil
+}
+
+// Listeners are keyed by the protocol and address, so changing the address restarts them.
+func listenerKey(protocol, host string, port int) string {
+	return protocol + ":" + net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// Returns the configured listeners keyed by protocol and address.
+func getListenerConfigs() (map[string]listenerConfig, error) {
+	configs := make(map[string]listenerConfig)
+	err := addListenerConfigs(configs, "pushListenerPorts", *fWavefrontPortsPtr, points.ProtocolTCP, api.FormatGraphiteV2,
+		decoder.GraphiteBuilder{Tagged: *fGraphiteTaggedPtr})
+	if err != nil {
+		return nil, err
+	}
+
+	stripTags, err := decoder.ParseOpenTSDBStripTags(*fOpenTSDBStripTagsPtr)
+	if err != nil {
+		return nil, err
+	}
+	err = addListenerConfigs(configs, "opentsdbPorts", *fOpenTSDBPortsPtr, points.ProtocolTCP, api.FormatGraphiteV2,
+		decoder.OpenTSDBBuilder{Version: getVersion(), StripTags: stripTags})
+	if err != nil {
+		return nil, err
+	}
+
+	if *fInfluxPortsPtr != "" {
+		err = addListenerConfigs(configs, "influxPorts", *fInfluxPortsPtr, points.ProtocolTCP, api.FormatGraphiteV2, decoder.InfluxDBBuilder{})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if *fStatsDPortsPtr != "" {
+		err = addListenerConfigs(configs, "statsdPorts", *fStatsDPortsPtr, points.ProtocolUDP, api.FormatGraphiteV2, decoder.NewStatsDBuilder(*fHostnamePtr, *fStatsDGaugeExpiryPtr))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = addListenerConfigs(configs, "collectdPorts", *fCollectdPortsPtr, points.ProtocolUDP, api.FormatGraphiteV2, decoder.CollectdBuilder{})
+	if err != nil {
+		return nil, err
+	}
+
+	histogramPorts := []struct {
+		group, granularity, ports string
+	}{
+		{"histogramMinutePort", decoder.HistogramMinute, *fHistogramMinutePortsPtr},
+		{"histogramHourPort", decoder.HistogramHour, *fHistogramHourPortsPtr},
+		{"histogramDayPort", decoder.HistogramDay, *fHistogramDayPortsPtr},
+	}
+	for _, h := range histogramPorts {
+		if h.ports == "" {
+			continue
+		}
+		builder, err := decoder.NewHistogramBuilder(h.granularity)
+		if err != nil {
+			return nil, err
+		}
+		err = addListenerConfigs(configs, h.group, h.ports, points.ProtocolTCP, api.FormatHistogram, builder)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = addListenerConfigs(configs, "histogramDistPort", *fHistogramDistPortsPtr, points.ProtocolTCP, api.FormatHistogram, decoder.DistributionBuilder{})
+	if err != nil {
+		return nil, err
+	}
+
+	// the csv settings also apply to lines POSTed to the http port with format=csv
+	csvBuilder, err := decoder.NewCSVBuilder(*fCSVDelimiterPtr, *fCSVColumnsPtr, *fCSVHeaderPtr)
+	if err != nil {
+		return nil, err
+	}
+	err = addListenerConfigs(configs, "csvPorts", *fCSVPortsPtr, points.ProtocolTCP, api.FormatGraphiteV2, csvBuilder)
+	if err != nil {
+		return nil, err
+	}
+
+	err = addListenerConfigs(configs, "autoDetectPorts", *fAutoDetectPortsPtr, points.ProtocolTCP, api.FormatGraphiteV2, decoder.AutoDetectBuilder{})
+	if err != nil {
+		return nil, err
+	}
+
+	if *fHttpPortPtr != 0 {
+		host := strings.Trim(*fBindAddressPtr, "[]")
+		configs[listenerKey(points.ProtocolHTTP, host, *fHttpPortPtr)] = listenerConfig{
+			group:          "httpPort",
+			host:           host,
+			port:           *fHttpPortPtr,
+			protocol:       points.ProtocolHTTP,
+			format:         api.FormatGraphiteV2,
+			builder:        decoder.GraphiteBuilder{},
+			formatBuilders: map[string]decoder.DecoderBuilder{"csv": csvBuilder},
+		}
+	}
+
+	if *fPromWritePortPtr != 0 {
+		host := strings.Trim(*fBindAddressPtr, "[]")
+		// keyed apart from the http listener, a port set for both fails to bind
+		configs[listenerKey("promwrite", host, *fPromWritePortPtr)] = listenerConfig{
+			group:    "promWritePort",
+			host:     host,
+			port:     *fPromWritePortPtr,
+			protocol: points.ProtocolHTTP,
+			format:   api.FormatGraphiteV2,
+		}
+	}
+
+	if *fSocketPathPtr != "" {
+		builder := formatBuilder(*fSocketFormatPtr)
+		configs[points.ProtocolUnix+":"+*fSocketPathPtr] = listenerConfig{
+			group: "socketPath", socketPath: *fSocketPathPtr, protocol: points.ProtocolUnix, format: api.FormatGraphiteV2, builder: builder}
+	}
+
+	if *fStdinPtr {
+		configs[points.ProtocolStdin] = listenerConfig{
+			group: "stdin", protocol: points.ProtocolStdin, format: api.FormatGraphiteV2, builder: formatBuilder(*fStdinFormatPtr)}
+	}
+	return configs, err
+}
+
+// Returns the parser unit of the timestampUnit setting.
+func timestampUnit(unit string) int {
+	switch unit {
+	case config.TimestampUnitSeconds:
+		return parser.UnitSeconds
+	case config.TimestampUnitMillis:
+		return parser.UnitMillis
+	}
+	return parser.UnitAuto
+}
+
+// Returns the decoder of the socketFormat and stdinFormat values.
+func formatBuilder(format string) decoder.DecoderBuilder {
+	if format == config.SocketFormatOpenTSDB {
+		return decoder.OpenTSDBBuilder{Version: getVersion()}
+	}
+	return decoder.GraphiteBuilder{}
+}
+
+// Starts configured listeners that are not running, stops running listeners that are
+// no longer configured and applies the current flush settings to the others.
+func updateListeners(service api.WavefrontAPI) error {
+	configs, err := getListenerConfigs()
+	if err != nil {
+		return err
+	}
+
+	listenersMtx.Lock()
+	defer listenersMtx.Unlock()
+
+	// the listeners no longer configured are unpublished before stopping, so health checks
+	// do not fail while they flush
+	var removed []points.PointListener
+	for key, listener := range listeners {
+		if _, ok := configs[key]; !ok {
+			removed = append(removed, listener)
+			delete(listeners, key)
+			delete(listenerConfigs, key)
+		}
+	}
+	publishListeners()
+	for _, listener := range removed {
+		listener.Stop()
+	}
+	for key := range stoppedListeners {
+		if _, ok := configs[key]; !ok {
+			delete(stoppedListeners, key)
+		}
+	}
+
+	for key, cfg := range configs {
+		if listener, ok := listeners[key]; ok {
+			updatePointListener(listener, cfg.group)
+			continue
+		}
+		if _, ok := stoppedListeners[key]; ok {
+			// started with the current configuration
+			stoppedListeners[key] = cfg
+			continue
+		}
+		if drain.draining() {
+			stoppedListeners[key] = cfg
+			continue
+		}
+		// retried on the next reload
+		if err := startListener(key, cfg, service); err != nil {
+			logger.Errorf("Error starting %s listener on port %d: %v", cfg.group, cfg.port, err)
+		}
+	}
+	return nil
+}
+
+// Creates and starts a listener, the listenersMtx must be locked. The listener is not added if
+// it fails to start.
+func startListener(key string, cfg listenerConfig, service api.WavefrontAPI) error {
+	listener := newListener(cfg)
+	if err := startPointListener(listener, cfg, service); err != nil {
+		return err
+	}
+	listeners[key] = listener
+	listenerConfigs[key] = cfg
+	publishListeners()
+	return nil
+}
+
+func newListener(cfg listenerConfig) points.PointListener {
+	diskLimit := int64(*fBufferDiskLimitPtr) * 1024 * 1024
+	shutdownTimeout := time.Duration(*fShutdownTimeoutPtr) * time.Second
+	drainTimeout := time.Duration(*fDrainTimeoutPtr) * time.Second
+	if cfg.group == "promWritePort" {
+		return &points.PromWriteListener{
+			Port:            cfg.port,
+			Host:            cfg.host,
+			Decoder:         decoder.PromWriteDecoder{Hostname: *fHostnamePtr},
+			BufferDir:       *fBufferFilePtr,
+			DiskLimit:       diskLimit,
+			Preprocessor:    preprocessor,
+			ShutdownTimeout: shutdownTimeout,
+			DrainTimeout:    drainTimeout,
+			Dedup:           *fDedupPtr,
+			UseProxyTime:    *flushSettings(cfg.group).UseProxyTime,
+		}
+	}
+	if cfg.protocol == points.ProtocolHTTP {
+		return &points.HTTPPointListener{
+			Port:            cfg.port,
+			Host:            cfg.host,
+			Builder:         cfg.builder,
+			BufferDir:       *fBufferFilePtr,
+			DiskLimit:       diskLimit,
+			Preprocessor:    preprocessor,
+			ShutdownTimeout: shutdownTimeout,
+			DrainTimeout:    drainTimeout,
+			Dedup:           *fDedupPtr,
+			FormatBuilders:  cfg.formatBuilders,
+			UseProxyTime:    *flushSettings(cfg.group).UseProxyTime,
+		}
+	}
+
+	listener := &points.DefaultPointListener{
+		Port:            cfg.port,
+		Host:            cfg.host,
+		Protocol:        cfg.protocol,
+		SocketPath:      cfg.socketPath,
+		Builder:         cfg.builder,
+		BufferDir:       *fBufferFilePtr,
+		DiskLimit:       diskLimit,
+		Preprocessor:    preprocessor,
+		ShutdownTimeout: shutdownTimeout,
+		DrainTimeout:    drainTimeout,
+		Dedup:           *fDedupPtr,
+		UseProxyTime:    *flushSettings(cfg.group).UseProxyTime,
+	}
+	if *fTagSourceIpPtr {
+		listener.SourceIPTag = *fSourceTagNamePtr
+	}
+	if cfg.protocol == points.ProtocolTCP {
+		listener.TLSConfig = tlsConfig
+		listener.KeepAlive = time.Duration(*fTcpKeepAlivePtr) * time.Second
+	}
+	if cfg.protocol == points.ProtocolTCP || cfg.protocol == points.ProtocolUDP {
+		listener.ReusePort = *fReusePortPtr
+	}
+	if cfg.protocol == points.ProtocolUnix {
+		// validated with the config
+		mode, _ := strconv.ParseUint(*fSocketModePtr, 8, 32)
+		listener.SocketMode = os.FileMode(mode)
+	}
+	if cfg.protocol == points.ProtocolStdin {
+		listener.Input = os.Stdin
+		listener.MaxLineLength = *fMaxLineLengthPtr
+		listener.ReadBufferSize = *fReadBufferSizePtr
+		// pauses reading instead of dropping points when bulk loading faster than they are flushed
+		if *fBackpressurePtr {
+			listener.HighWatermark = *fHighWatermarkPtr
+			listener.LowWatermark = *fLowWatermarkPtr
+		}
+	}
+	if cfg.protocol == points.ProtocolTCP || cfg.protocol == points.ProtocolUnix {
+		listener.MaxConnections = *fMaxConnectionsPtr
+		listener.AcceptGoroutines = *fAcceptGoroutinesPtr
+		listener.IdleTimeout = time.Duration(*fConnIdleTimeoutPtr) * time.Second
+		listener.MaxLineLength = *fMaxLineLengthPtr
+		listener.ReadBufferSize = *fReadBufferSizePtr
+		listener.AbuseThreshold = *fAbuseThresholdPtr
+		listener.CloseAbusive = *fAbuseActionPtr == config.AbuseActionClose
+		if *fBackpressurePtr {
+			listener.HighWatermark = *fHighWatermarkPtr
+			listener.LowWatermark = *fLowWatermarkPtr
+		}
+	}
+	if *fOpenTSDBThrottlePtr && cfg.group == "opentsdbPorts" {
+		listener.HighWatermark = *fHighWatermarkPtr
+		listener.LowWatermark = *fLowWatermarkPtr
+		listener.ThrottleReply = decoder.OpenTSDBThrottleReply
+	}
+	return listener
 }
 
 func startListeners(service api.WavefrontAPI) {
-	if *fWavefrontPortsPtr != "" {
-		startPointListeners(service, *fWavefrontPortsPtr, decoder.GraphiteBuilder{})
+	err := updateListeners(service)
+	if err != nil {
+		logger.Fatal(err)
 	}
+}
 
-	if *fOpenTSDBPortsPtr != "" {
-		startPointListeners(service, *fOpenTSDBPortsPtr, decoder.OpenTSDBBuilder{})
+// Waits for the agent to register before the listeners accept points, as points flushed before
+// fail. Gives up after startupGrace seconds if set, starting the listeners unregistered.
+func waitForRegistration(proxyAgent *agent.DefaultAgent) {
+	// nil in direct ingestion mode
+	if proxyAgent == nil || proxyAgent.Registered() {
+		return
+	}
+	grace := time.Duration(*fStartupGracePtr) * time.Second
+	logger.Info("Waiting for the proxy to register before starting listeners")
+	if !proxyAgent.WaitRegistered(grace) {
+		logger.Warnf("Starting listeners unregistered after the startup grace of %v", grace)
 	}
 }
 
-func initAgent(agentID, serverURL string, service api.WavefrontAPI) {
-	agent := &agent.DefaultAgent{AgentID: agentID, ApiService: service, ServerURL: serverURL}
+func initAgent(agentID, serverURL string, service, metricsService api.WavefrontAPI) *agent.DefaultAgent {
+	agent := &agent.DefaultAgent{
+		AgentID:              agentID,
+		ApiService:           service,
+		ServerURL:            serverURL,
+		CheckinInterval:      time.Duration(*fCheckinIntervalPtr) * time.Second,
+		OnConfig:             applyAgentConfig,
+		MetricsService:       metricsService,
+		MetricsPrefix:        *fInternalPrefixPtr,
+		RuntimeStatsInterval: time.Duration(*fRuntimeStatsPtr) * time.Second,
+	}
 	agent.InitAgent()
+	return agent
+}
+
+// Applies the settings sent by the Wavefront server at check-in, settings not sent are unchanged.
+func applyAgentConfig(agentConfig *config.AgentConfig) {
+	if agentConfig.WhitelistRegex != nil || agentConfig.BlacklistRegex != nil {
+		whitelist, blacklist := *fWhitelistRegexPtr, *fBlacklistRegexPtr
+		if agentConfig.WhitelistRegex != nil {
+			whitelist = *agentConfig.WhitelistRegex
+		}
+		if agentConfig.BlacklistRegex != nil {
+			blacklist = *agentConfig.BlacklistRegex
+		}
+		if whitelist != *fWhitelistRegexPtr || blacklist != *fBlacklistRegexPtr {
+			err := metricFilter.Update(whitelist, blacklist)
+			if err != nil {
+				logger.Warn("Ignoring invalid metric filter from server:", err)
+			} else {
+				logger.Infof("Applying whitelistRegex %q and blacklistRegex %q from server", whitelist, blacklist)
+				fWhitelistRegexPtr, fBlacklistRegexPtr = &whitelist, &blacklist
+			}
+		}
+	}
+
+	if interval := agentConfig.PushFlushInterval; interval != nil && *interval != *fFlushIntervalPtr {
+		if *interval <= 0 {
+			logger.Warn("Ignoring invalid pushFlushInterval from server:", *interval)
+			return
+		}
+		logger.Info("Applying pushFlushInterval from server:", *interval)
+		listenersMtx.Lock()
+		defer listenersMtx.Unlock()
+		fFlushIntervalPtr = interval
+		for key, listener := range listeners {
+			updatePointListener(listener, listenerConfigs[key].group)
+		}
+	}
 }
 
+// Encodes a major.minor.patch version as major*1e6 + minor*1e3 + patch for the build.version gauge.
+// Pre-release and build suffixes are ignored, as are segments past the patch. Missing and
+// non-numeric segments count as 0, and segments above 999 as 999.
 func buildVersion(v string) int64 {
-	version := 0
-	s := strings.Split(v, ".")
-	for i := 0; i < len(s); i++ {
-		version *= 1e3
-		intVersion, _ := strconv.Atoi(s[i])
-		version += intVersion
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
 	}
-	if len(s) == 2 {
+	s := strings.Split(v, ".")
+	var version int64
+	for i := 0; i < 3; i++ {
 		version *= 1e3
-	} else {
-		version *= 1e6
+		if i >= len(s) {
+			continue
+		}
+		segment, err := strconv.Atoi(s[i])
+		if err != nil || segment < 0 {
+			segment = 0
+		}
+		version += int64(min(segment, 999))
 	}
-	return int64(version)
+	return version
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(replaySpool(os.Args[2:]))
+	}
 	checkFlags()
 
-	log.Printf("Starting Wavefront Proxy Version %s", version)
+	logger.Infof("Starting Wavefront Proxy Version %s", version)
 
 	versionMetric := metrics.GetOrRegisterGauge("build.version", nil)
 	versionMetric.Update(buildVersion(version))
 
 	if *fPprofAddr != "" {
 		go func() {
-			log.Printf("Starting pprof HTTP server at: %s", *fPprofAddr)
+			logger.Infof("Starting pprof HTTP server at: %s", *fPprofAddr)
 			if err := http.ListenAndServe(*fPprofAddr, nil); err != nil {
-				log.Fatal(err.Error())
+				logger.Fatal(err.Error())
 			}
 		}()
 	}
 
-	agentID := agent.CreateOrGetAgentId(*fIdFilePtr)
+	err := api.ConfigureClient(api.ClientConfig{
+		ServerURL:          *fServerPtr,
+		HttpProxy:          *fHttpProxyPtr,
+		MaxIdleConns:       *fApiMaxIdleConnsPtr,
+		IdleConnTimeout:    time.Duration(*fApiIdleConnTimeoutPtr) * time.Second,
+		Timeout:            time.Duration(*fApiTimeoutPtr) * time.Second,
+		DNSRefreshInterval: time.Duration(*fApiDnsRefreshPtr) * time.Second,
+		CAFile:             *fApiCaFilePtr,
+		ServerName:         *fApiServerNamePtr,
+		InsecureSkipVerify: *fApiSkipVerifyPtr,
+	})
+	if err != nil {
+		logger.Fatal("Error configuring HTTP client: ", err)
+	}
+
+	// the proxy is not registered in direct ingestion mode, so has no agent id
+	var agentID string
+	if !*fDirectIngestionPtr {
+		agentID, err = agent.ResolveAgentId(agent.AgentIdConfig{
+			AgentId:  *fAgentIdPtr,
+			Hostname: *fHostnamePtr,
+			Salt:     *fAgentIdSaltPtr,
+			IdFile:   *fIdFilePtr,
+		})
+		if err != nil {
+			logger.Fatal("Error resolving agentId: ", err)
+		}
+	}
 	apiService := &api.WavefrontAPIService{
-		ServerURL: *fServerPtr,
-		AgentID:   agentID,
-		Hostname:  *fHostnamePtr,
-		Token:     *fTokenPtr,
-		Version:   version,
+		ServerURL:       *fServerPtr,
+		AgentID:         agentID,
+		Hostname:        *fHostnamePtr,
+		Token:           *fTokenPtr,
+		Version:         version,
+		FlushRetries:    *fFlushRetriesPtr,
+		FlushTimeout:    time.Duration(*fFlushTimeoutPtr) * time.Second,
+		GzipUpload:      *fGzipUploadPtr,
+		DryRun:          newDryRunWriter(),
+		DirectIngestion: *fDirectIngestionPtr,
+		Breaker:         newCircuitBreaker(*fServerPtr),
+	}
+
+	tokenServices = []*api.WavefrontAPIService{apiService}
+	service := newAPIService(apiService)
+	metricsService := newInternalMetricsService(apiService)
+	startTokenRefresh()
+
+	var proxyAgent *agent.DefaultAgent
+	if *fDirectIngestionPtr {
+		logger.Info("Direct ingestion, posting points to", *fServerPtr, "without registering the proxy")
+	} else {
+		proxyAgent = initAgent(agentID, *fServerPtr, service, metricsService)
+	}
+	points.SetPushRateLimit(*fPushRateLimitPtr)
+	points.SetFlushTriggerPercent(*fFlushTriggerPtr)
+	points.SetDropPolicy(*fDropPolicyPtr)
+	points.SetSortByTimestamp(*fSortByTimestampPtr)
+	points.SetMaxFlushBytes(*fMaxFlushBytesPtr)
+	points.SetMaxConcurrentFlushes(*fMaxFlushesPtr)
+	points.SetSpoolCompression(*fBufferCompressPtr)
+	points.SetSharedFlush(time.Duration(*fSharedFlushWindowPtr) * time.Millisecond)
+	points.SetLogBlockedSamples(*fLogBlockedSamplesPtr)
+	parser.SetTimestampUnit(timestampUnit(*fTimestampUnitPtr))
+	if *fHealthPortPtr != 0 {
+		startHealthServer(*fHealthPortPtr, *fAdminAddrPtr, proxyAgent, service)
+	}
+	waitForRegistration(proxyAgent)
+	startListeners(service)
+	waitForShutdown(service)
+}
+
+func newDryRunWriter() *api.DryRunWriter {
+	if !*fDryRunPtr {
+		return nil
 	}
+	if *fDryRunFilePtr == "" {
+		logger.Info("Dry run, points will be logged instead of sent")
+		return api.NewDryRunWriter(nil)
+	}
+	f, err := os.OpenFile(*fDryRunFilePtr, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		logger.Fatal("Error opening dryRunFile: ", err)
+	}
+	logger.Info("Dry run, points will be written to", *fDryRunFilePtr, "instead of sent")
+	return api.NewDryRunWriter(f)
+}
 
-	initAgent(agentID, *fServerPtr, apiService)
-	startListeners(apiService)
-	waitForShutdown()
+// Returns the circuit breaker for posts to the server, nil if disabled.
+func newCircuitBreaker(server string) *api.CircuitBreaker {
+	if *fCircuitThresholdPtr <= 0 {
+		return nil
+	}
+	return api.NewCircuitBreaker(server, *fCircuitThresholdPtr, time.Duration(*fCircuitCooldownPtr)*time.Second)
+}
+
+// Wraps the primary service to also write to the additional servers, if any, or to shard the
+// points across the servers with shardByTag.
+func newAPIService(primary *api.WavefrontAPIService) api.WavefrontAPI {
+	servers := config.SplitList(*fAdditionalServersPtr)
+	if len(servers) == 0 && *fShardByTagPtr == "" {
+		return primary
+	}
+	if primary.DryRun != nil {
+		logger.Info("Dry run, ignoring additionalServers and shardByTag")
+		return primary
+	}
+
+	additional := newAdditionalServices(primary, servers, config.SplitList(*fAdditionalTokensPtr))
+	if *fShardByTagPtr != "" {
+		return api.NewShardedWavefrontAPI(primary, additional, *fShardByTagPtr)
+	}
+	for _, service := range additional {
+		logger.Info("Also sending points to", service.ServerURL)
+	}
+	return api.NewMultiWavefrontAPI(primary, additional)
+}
+
+// Returns the service the agent metrics are reported to, nil to report them to the server. Added
+// to the tokenServices when using the token of the primary.
+func newInternalMetricsService(primary *api.WavefrontAPIService) api.WavefrontAPI {
+	if *fInternalServerPtr == "" {
+		return nil
+	}
+	service := &api.WavefrontAPIService{
+		ServerURL:    *fInternalServerPtr,
+		AgentID:      primary.AgentID,
+		Hostname:     primary.Hostname,
+		Token:        *fInternalTokenPtr,
+		Version:      primary.Version,
+		FlushRetries: primary.FlushRetries,
+		FlushTimeout: primary.FlushTimeout,
+		GzipUpload:   primary.GzipUpload,
+	}
+	if service.Token == "" {
+		service.Token = primary.CurrentToken()
+		tokenServices = append(tokenServices, service)
+	}
+	internalMetricsService = service
+	logger.Info("Reporting the proxy metrics to", service.ServerURL)
+	return service
+}
+
+// Returns the services of the additional servers, adding those using the token of the primary
+// to the tokenServices.
+func newAdditionalServices(primary *api.WavefrontAPIService, servers, tokens []string) []*api.WavefrontAPIService {
+	var additional []*api.WavefrontAPIService
+	for i, server := range servers {
+		service := &api.WavefrontAPIService{
+			ServerURL:       server,
+			AgentID:         primary.AgentID,
+			Hostname:        primary.Hostname,
+			Token:           primary.CurrentToken(),
+			Version:         primary.Version,
+			FlushRetries:    primary.FlushRetries,
+			FlushTimeout:    primary.FlushTimeout,
+			GzipUpload:      primary.GzipUpload,
+			DirectIngestion: primary.DirectIngestion,
+			Breaker:         newCircuitBreaker(server),
+		}
+		if len(tokens) == 1 {
+			service.Token = tokens[0]
+		} else if len(tokens) > i {
+			service.Token = tokens[i]
+		} else {
+			tokenServices = append(tokenServices, service)
+		}
+		additional = append(additional, service)
+	}
+	return additional
+}
+
+// Applies changes to the additionalServers and additionalTokens to the shards, restarting the
+// token refresh of the services using the token of the primary.
+func reshard(sharded *api.ShardedWavefrontAPI, proxyConfig *config.ProxyConfig) {
+	if proxyConfig.AdditionalServers == *fAdditionalServersPtr && proxyConfig.AdditionalTokens == *fAdditionalTokensPtr {
+		return
+	}
+	fAdditionalServersPtr = &proxyConfig.AdditionalServers
+	fAdditionalTokensPtr = &proxyConfig.AdditionalTokens
+
+	tokenServices = []*api.WavefrontAPIService{sharded.Primary}
+	if internalMetricsService != nil && *fInternalTokenPtr == "" {
+		tokenServices = append(tokenServices, internalMetricsService)
+	}
+	additional := newAdditionalServices(sharded.Primary, config.SplitList(*fAdditionalServersPtr), config.SplitList(*fAdditionalTokensPtr))
+	sharded.SetServers(additional)
+	if tokenRefresher != nil {
+		tokenRefresher.SetServices(tokenServices...)
+	}
 }
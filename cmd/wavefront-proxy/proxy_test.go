@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wavefronthq/go-proxy/api"
+	"github.com/wavefronthq/go-proxy/config"
+	"github.com/wavefronthq/go-proxy/points"
+)
+
+func TestBuildVersion(t *testing.T) {
+	tests := []struct {
+		version  string
+		expected int64
+	}{
+		{"1.2", 1002000},
+		{"1.2.3", 1002003},
+		{"1.2.3-rc1", 1002003},
+		{"1.2.3+build5", 1002003},
+		{"1.2.3.4", 1002003},
+		{"4", 4000000},
+		{"1.x.3", 1000003},
+		{"1.2.1000", 1002999},
+		{"", 0},
+	}
+	for _, test := range tests {
+		if v := buildVersion(test.version); v != test.expected {
+			t.Errorf("Expected %d for %q, found %d", test.expected, test.version, v)
+		}
+	}
+}
+
+func TestListenerKey(t *testing.T) {
+	// listeners on the same port of different interfaces are distinct
+	keys := map[string]bool{
+		listenerKey("tcp", "", 2878):         true,
+		listenerKey("tcp", "10.0.0.1", 2878): true,
+		listenerKey("tcp", "10.0.0.2", 2878): true,
+		listenerKey("tcp", "::1", 2878):      true,
+		listenerKey("udp", "10.0.0.1", 2878): true,
+	}
+	if len(keys) != 5 {
+		t.Errorf("Expected 5 distinct keys, found %v", keys)
+	}
+}
+
+// WavefrontAPI that records the posted point lines
+type recordingAPI struct {
+	api.WavefrontAPI
+	mtx   sync.Mutex
+	lines []string
+}
+
+func (a *recordingAPI) PostData(workUnitId, format, pointLines string) (*http.Response, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	a.lines = append(a.lines, strings.Split(pointLines, "\n")...)
+	return &http.Response{StatusCode: http.StatusAccepted}, nil
+}
+
+func (a *recordingAPI) posted(prefix string) int {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	n := 0
+	for _, line := range a.lines {
+		if strings.HasPrefix(line, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestReloadListeners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wavefront-reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "wavefront.conf")
+	reload := func(service api.WavefrontAPI, ports []int, flushInterval int) {
+		var addrs []string
+		for _, port := range ports {
+			addrs = append(addrs, fmt.Sprintf("127.0.0.1:%d", port))
+		}
+		contents := fmt.Sprintf("pushListenerPorts=%s\npushFlushInterval=%d\npushFlushMaxPoints=100\n"+
+			"pushMemoryBufferLimit=1000\n", strings.Join(addrs, ","), flushInterval)
+		if err := ioutil.WriteFile(filename, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		reloadCfg(service)
+	}
+
+	// the reload replaces the settings of the flags
+	defer func(cfg, ports *string, threads, interval, maxPoints, bufferSize, bufferBytes *int,
+		overrides *config.ListenerOverrides) {
+		fCfgPtr, fWavefrontPortsPtr = cfg, ports
+		fFlushThreadsPtr, fFlushIntervalPtr, fFlushMaxPointsPtr = threads, interval, maxPoints
+		fMaxBufferSizePtr, fMaxBufferBytesPtr, fListenersPtr = bufferSize, bufferBytes, overrides
+	}(fCfgPtr, fWavefrontPortsPtr, fFlushThreadsPtr, fFlushIntervalPtr, fFlushMaxPointsPtr, fMaxBufferSizePtr,
+		fMaxBufferBytesPtr, fListenersPtr)
+	fCfgPtr = &filename
+
+	removedPort, keptPort, addedPort := freePort(t), freePort(t), freePort(t)
+	t.Cleanup(func() {
+		for _, port := range []int{removedPort, keptPort, addedPort} {
+			stopListenersOn(port)
+		}
+		listenersMtx.Lock()
+		stoppedListeners = make(map[string]listenerConfig)
+		listenersMtx.Unlock()
+	})
+	accepts := func(port int) bool {
+		conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), 100*time.Millisecond)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}
+	send := func(port int, name string) {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(conn, "%s 1 source=a\n%s 2 source=a\n", name, name)
+		conn.Close()
+	}
+
+	// points are buffered for a minute before the reload
+	service := &recordingAPI{}
+	reload(service, []int{removedPort, keptPort}, 60000)
+	send(removedPort, "removed.metric")
+	send(keptPort, "kept.metric")
+	time.Sleep(100 * time.Millisecond)
+	if n := service.posted(""); n != 0 {
+		t.Fatalf("Expected the points buffered, found %d posted", n)
+	}
+	listenersMtx.RLock()
+	kept := listeners[listenerKey(points.ProtocolTCP, "127.0.0.1", keptPort)]
+	listenersMtx.RUnlock()
+
+	// the removed listener flushes its points when stopped, the kept one keeps its points and
+	// flushes them at the new interval
+	reload(service, []int{keptPort, addedPort}, 1000)
+	if n := service.posted("\"removed.metric\""); n != 2 {
+		t.Errorf("Expected 2 points of the removed listener posted, found %d", n)
+	}
+	if accepts(removedPort) || !accepts(addedPort) {
+		t.Error("Expected the removed listener stopped and the added one started")
+	}
+	listenersMtx.RLock()
+	running := len(listeners)
+	same := listeners[listenerKey(points.ProtocolTCP, "127.0.0.1", keptPort)] == kept
+	listenersMtx.RUnlock()
+	if running != 2 || !same {
+		t.Errorf("Expected 2 listeners with the kept one unchanged, found %d running", running)
+	}
+	for i := 0; i < 30 && service.posted("\"kept.metric\"") < 2; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if n := service.posted("\"kept.metric\""); n != 2 {
+		t.Errorf("Expected 2 points of the kept listener posted, found %d", n)
+	}
+}
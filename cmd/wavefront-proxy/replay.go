@@ -0,0 +1,288 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wavefronthq/go-proxy/agent"
+	"github.com/wavefronthq/go-proxy/api"
+	"github.com/wavefronthq/go-proxy/config"
+	"github.com/wavefronthq/go-proxy/logger"
+	"github.com/wavefronthq/go-proxy/points"
+)
+
+const (
+	// suffix a segment is renamed with once replayed, so it is neither replayed again nor
+	// picked up by a proxy spooling to the directory
+	ackedSuffix = ".acked"
+
+	spoolSuffix = ".spool"
+
+	replayProgressInterval = 10 * time.Second
+)
+
+// Sends spooled points to a server outside of a running proxy, e.g. the bufferFile directory of
+// a proxy lost in an incident:
+//
+//	wavefront-proxy replay -config wavefront.conf /var/spool/wavefront-proxy
+//
+// The segments are sent oldest first. Each is renamed with the .acked suffix once all its points
+// are accepted. The replay stops at the first segment that fails, so running it again resumes
+// with that segment. Points of a segment sent again overwrite those already received.
+type replayer struct {
+	service   api.WavefrontAPI
+	batchSize int
+	rate      int // points per second, unlimited if 0
+	start     time.Time
+	sent      int64
+	segment   int // of the segments replayed
+	segments  int
+	lastLog   time.Time
+	now       func() time.Time
+	sleep     func(time.Duration)
+}
+
+// Runs the replay subcommand with its arguments, returning the exit code.
+func replaySpool(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: wavefront-proxy replay [flags] <segment or directory>...")
+		fs.PrintDefaults()
+	}
+	cfgFile := fs.String("config", "", "Proxy configuration file to read the server, token and agent settings from")
+	server := fs.String("server", "", "Wavefront Server URL, overrides the configuration file")
+	token := fs.String("token", "", "Wavefront API token, overrides the configuration file")
+	rate := fs.Int("rate", 10000, "Max points per second sent, unlimited if 0")
+	batchSize := fs.Int("batchSize", config.DefaultFlushMaxPoints, "Max points sent per request")
+	verifyOnly := fs.Bool("verifyOnly", false, "Validate the segments without sending them")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 || *batchSize <= 0 || *rate < 0 {
+		fs.Usage()
+		return 2
+	}
+
+	segments, skipped, err := findSegments(fs.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error finding segments:", err)
+		return 1
+	}
+	if skipped > 0 {
+		logger.Infof("Skipping %d segments already replayed", skipped)
+	}
+	if *verifyOnly {
+		return verifySegments(segments)
+	}
+
+	service, err := newReplayService(*cfgFile, *server, *token)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	r := &replayer{service: service, batchSize: *batchSize, rate: *rate, now: time.Now, sleep: time.Sleep}
+	if err := r.replay(segments); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	return 0
+}
+
+// Returns the segments of the files and directories, searched recursively as the bufferFile
+// holds a directory per port, sorted oldest first, and the number of acked segments skipped.
+func findSegments(paths []string) ([]string, int, error) {
+	var segments []string
+	skipped := 0
+	for _, path := range paths {
+		err := filepath.Walk(path, func(name string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			switch {
+			case info.IsDir():
+			case strings.HasSuffix(name, ackedSuffix):
+				skipped++
+			case strings.HasSuffix(name, spoolSuffix) || name == path:
+				segments = append(segments, name)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	// segment names are their creation time
+	sort.SliceStable(segments, func(i, j int) bool {
+		return filepath.Base(segments[i]) < filepath.Base(segments[j])
+	})
+	return segments, skipped, nil
+}
+
+// Reads every segment, reporting those that cannot be read or hold fewer points than their
+// header counts, such as segments cut short by a crash.
+func verifySegments(segments []string) int {
+	code := 0
+	var total int64
+	for _, name := range segments {
+		header, lines, err := points.ReadSegment(name)
+		if err == nil && int64(len(lines)) != header.Points {
+			err = fmt.Errorf("%d points, the header counts %d", len(lines), header.Points)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: invalid: %v\n", name, err)
+			code = 1
+			continue
+		}
+		total += int64(len(lines))
+		fmt.Fprintf(os.Stderr, "%s: ok, %d points\n", name, len(lines))
+	}
+	fmt.Fprintf(os.Stderr, "Verified %d segments, %d points\n", len(segments), total)
+	return code
+}
+
+// Creates the service from the configuration file, if any, and the server and token given.
+func newReplayService(cfgFile, server, token string) (*api.WavefrontAPIService, error) {
+	cfg := &config.ProxyConfig{FlushRetries: config.DefaultFlushRetries, GzipUpload: true}
+	if cfgFile != "" {
+		var err error
+		if cfg, err = config.LoadConfig(cfgFile); err != nil {
+			return nil, err
+		}
+		if err := cfg.ResolveToken(); err != nil {
+			return nil, err
+		}
+	}
+	if server != "" {
+		cfg.Server = server
+	}
+	if token != "" {
+		cfg.Token = token
+	}
+	if cfg.Server == "" || cfg.Token == "" {
+		return nil, errors.New("a server and token are required, from -config or -server and -token")
+	}
+	if cfg.Hostname == "" {
+		cfg.Hostname, _ = os.Hostname()
+	}
+	if cfg.IdFile == "" {
+		// the flag default, as the proxy flags are not parsed
+		cfg.IdFile = *fIdFilePtr
+	}
+
+	var agentID string
+	if !cfg.DirectIngestion {
+		var err error
+		agentID, err = agent.ResolveAgentId(agent.AgentIdConfig{
+			AgentId:  cfg.AgentId,
+			Hostname: cfg.Hostname,
+			Salt:     cfg.AgentIdSalt,
+			IdFile:   cfg.IdFile,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &api.WavefrontAPIService{
+		ServerURL:       cfg.Server,
+		AgentID:         agentID,
+		Hostname:        cfg.Hostname,
+		Token:           cfg.Token,
+		Version:         getVersion(),
+		FlushRetries:    cfg.FlushRetries,
+		FlushTimeout:    time.Duration(cfg.FlushTimeout) * time.Second,
+		GzipUpload:      cfg.GzipUpload,
+		DirectIngestion: cfg.DirectIngestion,
+	}, nil
+}
+
+func (r *replayer) replay(segments []string) error {
+	r.start = r.now()
+	r.lastLog = r.start
+	r.segments = len(segments)
+	logger.Infof("Replaying %d segments", len(segments))
+	for i, name := range segments {
+		r.segment = i + 1
+		_, lines, err := points.ReadSegment(name)
+		if err != nil {
+			return fmt.Errorf("reading %s: %v", name, err)
+		}
+		if err := r.replaySegment(lines); err != nil {
+			return fmt.Errorf("replaying %s, %d of %d segments replayed: %v", name, i, len(segments), err)
+		}
+		if err := os.Rename(name, name+ackedSuffix); err != nil {
+			return fmt.Errorf("marking %s replayed: %v", name, err)
+		}
+		logger.Infof("Replayed %s: %d points", name, len(lines))
+	}
+	logger.Infof("Replayed %d segments, %d points in %v", len(segments), r.sent, r.now().Sub(r.start).Round(time.Millisecond))
+	return nil
+}
+
+// Sends the points of a segment in batches, histograms separately from the other points.
+func (r *replayer) replaySegment(lines []string) error {
+	var wavefront, histograms []string
+	for _, line := range lines {
+		if isHistogramLine(line) {
+			histograms = append(histograms, line)
+		} else {
+			wavefront = append(wavefront, line)
+		}
+	}
+	if err := r.send(api.FormatGraphiteV2, wavefront); err != nil {
+		return err
+	}
+	return r.send(api.FormatHistogram, histograms)
+}
+
+func (r *replayer) send(format string, lines []string) error {
+	for start := 0; start < len(lines); start += r.batchSize {
+		end := start + r.batchSize
+		if end > len(lines) {
+			end = len(lines)
+		}
+		batch := lines[start:end]
+		r.wait(len(batch))
+		resp, err := r.service.PostData(api.GraphiteBlockWorkUnit, format, strings.Join(batch, "\n"))
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("points not accepted: %s", resp.Status)
+		}
+		r.sent += int64(len(batch))
+		r.progress()
+	}
+	return nil
+}
+
+// Waits until sending n more points keeps to the rate since the replay started.
+func (r *replayer) wait(n int) {
+	if r.rate <= 0 {
+		return
+	}
+	due := r.start.Add(time.Duration(r.sent+int64(n)) * time.Second / time.Duration(r.rate))
+	if delay := due.Sub(r.now()); delay > 0 {
+		r.sleep(delay)
+	}
+}
+
+func (r *replayer) progress() {
+	now := r.now()
+	if now.Sub(r.lastLog) < replayProgressInterval {
+		return
+	}
+	r.lastLog = now
+	logger.Infof("Replayed %d points, at segment %d of %d, %.0f points/s", r.sent, r.segment, r.segments,
+		float64(r.sent)/now.Sub(r.start).Seconds())
+}
+
+// Histogram lines start with their granularity, e.g. !M for minute histograms.
+func isHistogramLine(line string) bool {
+	return len(line) > 2 && line[0] == '!' && line[2] == ' '
+}
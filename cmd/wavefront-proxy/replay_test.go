@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wavefronthq/go-proxy/api"
+)
+
+func writeSegment(t *testing.T, name string, headerPoints int, lines ...string) {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	data := fmt.Sprintf("WFSPOOL 1 none %012d\n%s\n", headerPoints, strings.Join(lines, "\n"))
+	if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplaySpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wavefront-replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	first := filepath.Join(dir, "2878", "00000000000000000001.spool")
+	second := filepath.Join(dir, "2879", "00000000000000000002.spool")
+	writeSegment(t, first, 3, `"a" 1 1505454047 source="x"`, `"b" 2 1505454047 source="x"`, `!M 1505454047 #1 1.5 "h" source="x"`)
+	writeSegment(t, second, 1, `"c" 3 1505454047 source="y"`)
+	writeSegment(t, filepath.Join(dir, "2878", "00000000000000000000.spool.acked"), 1, `"old" 1 1505454047 source="x"`)
+
+	segments, skipped, err := findSegments([]string{dir})
+	if err != nil || skipped != 1 || len(segments) != 2 || segments[0] != first || segments[1] != second {
+		t.Fatalf("Unexpected segments %v, %d skipped, %v", segments, skipped, err)
+	}
+	if code := verifySegments(segments); code != 0 {
+		t.Errorf("Expected the segments to verify, exit code %d", code)
+	}
+
+	var mtx sync.Mutex
+	var posts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mtx.Lock()
+		posts = append(posts, r.URL.Query().Get("format")+": "+string(body))
+		mtx.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	now := time.Unix(1505454047, 0)
+	var slept time.Duration
+	r := &replayer{
+		service:   &api.WavefrontAPIService{ServerURL: server.URL},
+		batchSize: 1,
+		rate:      2,
+		now:       func() time.Time { return now },
+		sleep:     func(d time.Duration) { slept += d; now = now.Add(d) },
+	}
+	if err := r.replay(segments); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`graphite_v2: "a" 1 1505454047 source="x"`,
+		`graphite_v2: "b" 2 1505454047 source="x"`,
+		`histogram: !M 1505454047 #1 1.5 "h" source="x"`,
+		`graphite_v2: "c" 3 1505454047 source="y"`,
+	}
+	if strings.Join(posts, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected posts %q", posts)
+	}
+	// 4 points at 2 per second
+	if slept != 2*time.Second {
+		t.Errorf("Expected the replay paced to 2 seconds, found %v", slept)
+	}
+	for _, name := range segments {
+		if _, err := os.Stat(name + ackedSuffix); err != nil {
+			t.Errorf("Expected %s to be marked replayed: %v", name, err)
+		}
+	}
+	if segments, skipped, _ := findSegments([]string{dir}); len(segments) != 0 || skipped != 3 {
+		t.Errorf("Expected the replayed segments to be skipped, found %v", segments)
+	}
+}
+
+func TestReplayFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wavefront-replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "00000000000000000001.spool")
+	writeSegment(t, name, 2, `"a" 1 1505454047 source="x"`)
+	if code := verifySegments([]string{name}); code != 1 {
+		t.Error("Expected a segment with fewer points than its header to fail verification")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotAcceptable)
+	}))
+	defer server.Close()
+	r := &replayer{service: &api.WavefrontAPIService{ServerURL: server.URL}, batchSize: 10, now: time.Now, sleep: time.Sleep}
+	if err := r.replay([]string{name}); err == nil {
+		t.Error("Expected an error when the points are not accepted")
+	}
+	if _, err := os.Stat(name); err != nil {
+		t.Errorf("Expected the failed segment to be kept: %v", err)
+	}
+}
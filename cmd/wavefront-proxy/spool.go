@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"github.com/wavefronthq/go-proxy/points"
+)
+
+// Prints the points of a spool segment to stdout and its header to stderr, returning the exit code.
+func dumpSpool(name string) int {
+	header, lines, err := points.ReadSegment(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", name, err)
+		return 1
+	}
+	encoding := "plain"
+	if header.Compressed {
+		encoding = "gzip"
+	}
+	fmt.Fprintf(os.Stderr, "%s: version %d, %s, %d points\n", name, header.Version, encoding, header.Points)
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	for _, line := range lines {
+		w.WriteString(line)
+		w.WriteByte('\n')
+	}
+	return 0
+}
@@ -5,5 +5,19 @@ type Point struct {
 	Value     string
 	Timestamp int64
 	Source    string
-	Tags      map[string]string
+	// set if the source is a default such as the proxy hostname, not one of the point itself
+	DefaultSource bool
+	Tags          map[string]string
+	Histogram     *Histogram // set for histogram distributions, Value is unused
+}
+
+// Distribution of values aggregated over a minute, hour or day.
+type Histogram struct {
+	Granularity string // !M, !H or !D
+	Centroids   []Centroid
+}
+
+type Centroid struct {
+	Value float64
+	Count int
 }
@@ -6,4 +6,9 @@ type AgentConfig struct {
 	Targets          []string
 	WorkUnits        []string
 	PointsPerBatch   int
+
+	// settings applied live when sent by the server, nil if not set
+	PushFlushInterval *int
+	WhitelistRegex    *string
+	BlacklistRegex    *string
 }
@@ -1,51 +1,456 @@
 package config
 
 import (
-	"log"
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 
 	"github.com/spf13/viper"
+	"github.com/wavefronthq/go-proxy/logger"
 )
 
 const (
 	DefaultFlushThreads      = 4
 	DefaultFlushInterval     = 1000
 	DefaultFlushMaxPoints    = 40000
+	DefaultFlushTrigger      = 100
 	DefaultMemoryBufferLimit = 640000
+	DefaultBufferDiskLimit   = 1024
+	DefaultFlushRetries      = 3
+	DefaultCircuitCooldown   = 30
+	DefaultShutdownTimeout   = 10
+	DefaultDrainTimeout      = 5
+	DefaultDnsRefresh        = 60
+	DefaultRuntimeStats      = 10
+	DefaultTcpKeepAlive      = 30
+	DefaultMaxFutureSkew     = 24 * 60 * 60
+	DefaultMaxPastSkew       = 365 * 24 * 60 * 60
+	DefaultLogLevel          = "info"
+	DefaultSourceTagName     = "_remote_ip"
+	DefaultSocketMode        = "0660"
+	DefaultHighWatermark     = 90
+	DefaultLowWatermark      = 70
+	DefaultCSVDelimiter      = ","
+	DefaultCSVColumns        = "metric,value,timestamp"
+	DefaultPrefixSeparator   = "."
+	DefaultStatsDGaugeExpiry = 60
+)
+
+// Actions taken on connections exceeding the abuseThreshold
+const (
+	AbuseActionThrottle = "throttle"
+	AbuseActionClose    = "close"
+)
+
+// Units of the timestamps of Wavefront and OpenTSDB points
+const (
+	TimestampUnitAuto    = "auto"
+	TimestampUnitSeconds = "s"
+	TimestampUnitMillis  = "ms"
+)
+
+// Points dropped when a memory buffer overflows without a disk buffer
+const (
+	DropPolicyOldest = "oldest"
+	DropPolicyNewest = "newest"
+)
+
+// Formats of the points received on the socketPath listener
+const (
+	SocketFormatWavefront = "wavefront"
+	SocketFormatOpenTSDB  = "opentsdb"
 )
 
 type ProxyConfig struct {
-	Server                string
-	Hostname              string
-	Token                 string
-	PushListenerPorts     string
-	OpenTSDBPorts         string
-	FlushThreads          int
-	PushFlushInterval     int
-	PushFlushMaxPoints    int
-	PushMemoryBufferLimit int
-	IdFile                string
-	LogFile               string
-	PprofAddr             string
+	Server                    string
+	DirectIngestion           bool
+	AdditionalServers         string
+	AdditionalTokens          string
+	ShardByTag                string
+	InternalMetricsServer     string
+	InternalMetricsToken      string
+	InternalMetricsPrefix     string
+	Hostname                  string
+	Token                     string
+	TokenFile                 string
+	TokenCommand              string
+	TokenRefreshInterval      int
+	PushListenerPorts         string
+	GraphiteTaggedNames       bool
+	OpenTSDBPorts             string
+	StatsDPorts               string
+	StatsDGaugeExpiry         int
+	CollectdPorts             string
+	InfluxPorts               string
+	CSVPorts                  string
+	CSVDelimiter              string
+	CSVColumns                string
+	CSVHeader                 bool
+	AutoDetectPorts           string
+	HttpPort                  int
+	PromWritePort             int
+	HistogramMinutePort       string
+	HistogramHourPort         string
+	HistogramDayPort          string
+	HistogramDistPort         string
+	FlushThreads              int
+	FlushRetries              int
+	FlushTimeout              int
+	CircuitBreakerThreshold   int
+	CircuitBreakerCooldown    int
+	GzipUpload                bool
+	PushFlushInterval         int
+	PushFlushMaxPoints        int
+	PushFlushTriggerPercent   int
+	MaxFlushBytes             int
+	SortByTimestamp           bool
+	PushMemoryBufferLimit     int
+	PushMemoryBufferBytes     int
+	DropPolicy                string
+	PushRateLimit             int
+	MaxConcurrentFlushes      int
+	SharedFlushWindow         int
+	BufferFile                string
+	BufferDiskLimit           int
+	BufferCompress            bool
+	ShutdownTimeout           int
+	DrainTimeout              int
+	CheckinInterval           int
+	RuntimeStatsInterval      int
+	StartupGrace              int
+	IdFile                    string
+	AgentId                   string
+	AgentIdSalt               string
+	LogFile                   string
+	LogLevel                  string
+	LogFormat                 string
+	PprofAddr                 string
+	BindAddress               string
+	HealthPort                int
+	AdminAddr                 string
+	HttpProxy                 string
+	ApiMaxIdleConns           int
+	ApiIdleConnTimeout        int
+	ApiDnsRefreshInterval     int
+	ApiTimeout                int
+	ApiCaFile                 string
+	ApiServerName             string
+	ApiInsecureSkipVerify     bool
+	TlsCertFile               string
+	TlsKeyFile                string
+	TlsCaFile                 string
+	MaxConnections            int
+	AcceptGoroutines          int
+	ConnectionIdleTimeout     int
+	TcpKeepAlive              int
+	ReusePort                 bool
+	AbuseThreshold            int
+	AbuseAction               string
+	SocketPath                string
+	SocketFormat              string
+	SocketMode                string
+	Dedup                     bool
+	MaxLineLength             int
+	ReadBufferSize            int
+	Backpressure              bool
+	BackpressureHighWatermark int
+	BackpressureLowWatermark  int
+	OpenTSDBThrottle          bool
+	OpenTSDBStripTags         string
+	TagSourceIp               bool
+	SourceTagName             string
+	TagAllowList              string
+	TagDenyList               string
+	TagFilterDropPoints       bool
+	MaxTagsPerPoint           int
+	MaxTagsDropPoints         bool
+	WhitelistRegex            string
+	BlacklistRegex            string
+	PerSourceRateLimit        int
+	LogBlockedSamples         bool
+	UseProxyTime              bool
+	CardinalityThreshold      int
+	CardinalityLimit          int
+	PreprocessorConfig        string
+	SampleRules               string
+	ValueTransform            string
+	PointTags                 string
+	MetricPrefix              string
+	MetricPrefixSeparator     string
+	MaxFutureSkew             int
+	MaxPastSkew               int
+	ClampTimestamps           bool
+	TimestampUnit             string
+	RejectNegative            bool
+	DryRun                    bool
+	DryRunFile                string
+	Listeners                 ListenerOverrides
 }
 
-func LoadConfig(filename string) (*ProxyConfig, error) {
-	log.Println("Loading configuration from", filename)
-
-	viper.SetConfigType("properties")
-	viper.SetConfigFile(filename)
+// LoadConfig reads the configuration files, applies environment variable overrides and defaults,
+// and validates the result. Environment variables take precedence over the files.
+// Files ending in .yaml, .yml or .json are read in that format, all others as properties.
+//
+// A comma separated list of files, e.g. a base file shared by all proxies followed by a per host
+// file, is merged in order. Settings in a later file override the same settings in earlier ones,
+// settings it omits keep their earlier values.
+func LoadConfig(filenames string) (*ProxyConfig, error) {
+	v := viper.New()
+	v.SetDefault("gzipUpload", true)
+	// 0 closes connections at once, so unset is distinguished from 0
+	v.SetDefault("drainTimeout", DefaultDrainTimeout)
+	// 0 disables keepalives
+	v.SetDefault("tcpKeepAlive", DefaultTcpKeepAlive)
+	// 0 reports gauges until restart
+	v.SetDefault("statsdGaugeExpiry", DefaultStatsDGaugeExpiry)
+	// 0 disables resolving the server again
+	v.SetDefault("apiDnsRefreshInterval", DefaultDnsRefresh)
+	// 0 captures the runtime stats at check-in only
+	v.SetDefault("runtimeStatsInterval", DefaultRuntimeStats)
+	// 0 disables flushing before the interval elapses
+	v.SetDefault("pushFlushTriggerPercent", DefaultFlushTrigger)
+	// an empty separator joins the prefix and metric names directly
+	v.SetDefault("metricPrefixSeparator", DefaultPrefixSeparator)
+	// 0 disables retries
+	v.SetDefault("flushRetries", DefaultFlushRetries)
 
-	err := viper.ReadInConfig()
-	if err != nil {
-		return &ProxyConfig{}, err
+	for _, filename := range strings.Split(filenames, ",") {
+		if filename = strings.TrimSpace(filename); filename == "" {
+			continue
+		}
+		if err := mergeConfigFile(v, filename); err != nil {
+			return &ProxyConfig{}, err
+		}
 	}
+	warnUnknownKeys(v.AllKeys())
 
 	proxyConfig := &ProxyConfig{}
-	err = viper.Unmarshal(&proxyConfig)
+	err := v.Unmarshal(&proxyConfig)
+	if err != nil {
+		return proxyConfig, err
+	}
+	err = ApplyEnv(proxyConfig)
 	if err != nil {
 		return proxyConfig, err
 	}
 	setDefaults(proxyConfig)
-	return proxyConfig, nil
+	return proxyConfig, proxyConfig.Validate()
+}
+
+func mergeConfigFile(v *viper.Viper, filename string) error {
+	logger.Info("Loading configuration from", filename)
+	file := viper.New()
+	file.SetConfigType(configType(filename))
+	file.SetConfigFile(filename)
+	if err := file.ReadInConfig(); err != nil {
+		return err
+	}
+	return v.MergeConfigMap(file.AllSettings())
+}
+
+func configType(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".json":
+		return "json"
+	default:
+		return "properties"
+	}
+}
+
+// Logs unrecognized settings, which are ignored rather than rejected to ease upgrades.
+func warnUnknownKeys(keys []string) {
+	known := make(map[string]bool)
+	t := reflect.TypeOf(ProxyConfig{})
+	for i := 0; i < t.NumField(); i++ {
+		known[strings.ToLower(t.Field(i).Name)] = true
+	}
+	for _, key := range keys {
+		// nested keys such as listeners.opentsdbports.pushflushinterval belong to their section
+		if !known[strings.SplitN(key, ".", 2)[0]] {
+			logger.Warnf("Ignoring unknown setting %s", key)
+		}
+	}
+}
+
+// Lists every invalid setting found in a configuration.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration: " + strings.Join(e.Problems, "; ")
+}
+
+// Validate checks the configured values, returning a ValidationError listing every problem found.
+func (cfg *ProxyConfig) Validate() error {
+	v := &ValidationError{}
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			v.Problems = append(v.Problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	if cfg.Server != "" {
+		u, err := url.Parse(cfg.Server)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+			"server %q must be an http or https URL", cfg.Server)
+	}
+	if cfg.LogLevel != "" {
+		_, err := logger.ParseLevel(cfg.LogLevel)
+		check(err == nil, "logLevel %q must be one of debug, info, warn or error", cfg.LogLevel)
+	}
+	check(cfg.LogFormat == "" || cfg.LogFormat == logger.FormatText || cfg.LogFormat == logger.FormatJSON,
+		"logFormat %q must be text or json", cfg.LogFormat)
+	check(cfg.AbuseAction == "" || cfg.AbuseAction == AbuseActionThrottle || cfg.AbuseAction == AbuseActionClose,
+		"abuseAction %q must be throttle or close", cfg.AbuseAction)
+	check(cfg.TimestampUnit == "" || cfg.TimestampUnit == TimestampUnitAuto || cfg.TimestampUnit == TimestampUnitSeconds ||
+		cfg.TimestampUnit == TimestampUnitMillis, "timestampUnit %q must be auto, s or ms", cfg.TimestampUnit)
+	check(cfg.SocketFormat == "" || cfg.SocketFormat == SocketFormatWavefront || cfg.SocketFormat == SocketFormatOpenTSDB,
+		"socketFormat %q must be wavefront or opentsdb", cfg.SocketFormat)
+	check(cfg.DropPolicy == "" || cfg.DropPolicy == DropPolicyOldest || cfg.DropPolicy == DropPolicyNewest,
+		"dropPolicy %q must be oldest or newest", cfg.DropPolicy)
+	if cfg.SocketMode != "" {
+		mode, err := strconv.ParseUint(cfg.SocketMode, 8, 32)
+		check(err == nil && mode <= 0777, "socketMode %q must be octal permissions such as 0660", cfg.SocketMode)
+	}
+	if cfg.BindAddress != "" {
+		_, _, err := net.SplitHostPort(cfg.BindAddress)
+		check(err != nil, "bindAddress %q must be a host without a port", cfg.BindAddress)
+	}
+	if cfg.Backpressure || cfg.OpenTSDBThrottle {
+		check(cfg.BackpressureHighWatermark > 0 && cfg.BackpressureHighWatermark <= 100,
+			"backpressureHighWatermark must be between 1 and 100, found %d", cfg.BackpressureHighWatermark)
+		check(cfg.BackpressureLowWatermark > 0 && cfg.BackpressureLowWatermark < cfg.BackpressureHighWatermark,
+			"backpressureLowWatermark must be between 1 and backpressureHighWatermark (%d), found %d",
+			cfg.BackpressureHighWatermark, cfg.BackpressureLowWatermark)
+	}
+	servers, tokens := SplitList(cfg.AdditionalServers), SplitList(cfg.AdditionalTokens)
+	for _, server := range servers {
+		u, err := url.Parse(server)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+			"additionalServers entry %q must be an http or https URL", server)
+	}
+	check(len(tokens) <= 1 || len(tokens) == len(servers),
+		"additionalTokens must have one token or one per additional server, found %d for %d servers", len(tokens), len(servers))
+	if cfg.InternalMetricsServer != "" {
+		u, err := url.Parse(cfg.InternalMetricsServer)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+			"internalMetricsServer %q must be an http or https URL", cfg.InternalMetricsServer)
+	}
+	check(cfg.InternalMetricsToken == "" || cfg.InternalMetricsServer != "",
+		"internalMetricsToken requires an internalMetricsServer")
+	check(!cfg.DirectIngestion || cfg.InternalMetricsServer == "",
+		"internalMetricsServer receives the check-ins of the proxy, which does not check in with directIngestion")
+	check(cfg.FlushThreads >= 1, "flushThreads must be at least 1, found %d", cfg.FlushThreads)
+	check(cfg.FlushRetries >= 0, "flushRetries must not be negative, found %d", cfg.FlushRetries)
+	check(cfg.PushFlushInterval > 0, "pushFlushInterval must be greater than 0, found %d", cfg.PushFlushInterval)
+	check(cfg.PushFlushMaxPoints > 0, "pushFlushMaxPoints must be greater than 0, found %d", cfg.PushFlushMaxPoints)
+	check(cfg.PushMemoryBufferLimit >= cfg.PushFlushMaxPoints,
+		"pushMemoryBufferLimit must be at least pushFlushMaxPoints (%d), found %d",
+		cfg.PushFlushMaxPoints, cfg.PushMemoryBufferLimit)
+	for _, group := range cfg.overriddenGroups() {
+		overrides := cfg.Listeners[group]
+		s := cfg.globalFlushSettings().Override(overrides)
+		check(knownListenerGroup(group), "listeners section %q must be one of %s", group, strings.Join(ListenerGroups, ", "))
+		check(!overrides.negative(), "listeners.%s settings must not be negative", group)
+		check(s.PushMemoryBufferLimit >= s.PushFlushMaxPoints,
+			"listeners.%s pushMemoryBufferLimit must be at least its pushFlushMaxPoints (%d), found %d",
+			group, s.PushFlushMaxPoints, s.PushMemoryBufferLimit)
+	}
+
+	nonNegative := []struct {
+		name  string
+		value int
+	}{
+		{"circuitBreakerThreshold", cfg.CircuitBreakerThreshold},
+		{"circuitBreakerCooldown", cfg.CircuitBreakerCooldown},
+		{"pushFlushTriggerPercent", cfg.PushFlushTriggerPercent},
+		{"pushMemoryBufferBytes", cfg.PushMemoryBufferBytes},
+		{"pushRateLimit", cfg.PushRateLimit},
+		{"maxConcurrentFlushes", cfg.MaxConcurrentFlushes},
+		{"maxFlushBytes", cfg.MaxFlushBytes},
+		{"sharedFlushWindow", cfg.SharedFlushWindow},
+		{"bufferDiskLimit", cfg.BufferDiskLimit},
+		{"shutdownTimeout", cfg.ShutdownTimeout},
+		{"drainTimeout", cfg.DrainTimeout},
+		{"statsdGaugeExpiry", cfg.StatsDGaugeExpiry},
+		{"checkinInterval", cfg.CheckinInterval},
+		{"runtimeStatsInterval", cfg.RuntimeStatsInterval},
+		{"startupGrace", cfg.StartupGrace},
+		{"tokenRefreshInterval", cfg.TokenRefreshInterval},
+		{"maxConnections", cfg.MaxConnections},
+		{"acceptGoroutines", cfg.AcceptGoroutines},
+		{"abuseThreshold", cfg.AbuseThreshold},
+		{"connectionIdleTimeout", cfg.ConnectionIdleTimeout},
+		{"apiMaxIdleConns", cfg.ApiMaxIdleConns},
+		{"apiIdleConnTimeout", cfg.ApiIdleConnTimeout},
+		{"apiDnsRefreshInterval", cfg.ApiDnsRefreshInterval},
+		{"apiTimeout", cfg.ApiTimeout},
+		{"flushTimeout", cfg.FlushTimeout},
+		{"tcpKeepAlive", cfg.TcpKeepAlive},
+		{"maxLineLength", cfg.MaxLineLength},
+		{"readBufferSize", cfg.ReadBufferSize},
+		{"maxTagsPerPoint", cfg.MaxTagsPerPoint},
+		{"perSourceRateLimit", cfg.PerSourceRateLimit},
+		{"cardinalityThreshold", cfg.CardinalityThreshold},
+		{"cardinalityLimit", cfg.CardinalityLimit},
+		{"maxFutureSkew", cfg.MaxFutureSkew},
+		{"maxPastSkew", cfg.MaxPastSkew},
+	}
+	for _, setting := range nonNegative {
+		check(setting.value >= 0, "%s must not be negative, found %d", setting.name, setting.value)
+	}
+
+	portLists := []struct {
+		name  string
+		ports string
+	}{
+		{"pushListenerPorts", cfg.PushListenerPorts},
+		{"opentsdbPorts", cfg.OpenTSDBPorts},
+		{"statsdPorts", cfg.StatsDPorts},
+		{"collectdPorts", cfg.CollectdPorts},
+		{"influxPorts", cfg.InfluxPorts},
+		{"csvPorts", cfg.CSVPorts},
+		{"autoDetectPorts", cfg.AutoDetectPorts},
+		{"histogramMinutePort", cfg.HistogramMinutePort},
+		{"histogramHourPort", cfg.HistogramHourPort},
+		{"histogramDayPort", cfg.HistogramDayPort},
+		{"histogramDistPort", cfg.HistogramDistPort},
+		{"httpPort", strconv.Itoa(cfg.HttpPort)},
+		{"promWritePort", strconv.Itoa(cfg.PromWritePort)},
+		{"healthPort", strconv.Itoa(cfg.HealthPort)},
+	}
+	for _, setting := range portLists {
+		for _, port := range strings.Split(setting.ports, ",") {
+			// 0 disables optional listeners
+			if port = strings.TrimSpace(port); port != "" && port != "0" {
+				_, _, err := SplitListenAddr(port, "")
+				check(err == nil, "%s contains invalid port %q", setting.name, port)
+			}
+		}
+	}
+
+	if len(v.Problems) > 0 {
+		return v
+	}
+	return nil
+}
+
+// Splits a comma separated list ignoring empty entries
+func SplitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
 
 func setDefaults(cfg *ProxyConfig) {
@@ -53,6 +458,10 @@ func setDefaults(cfg *ProxyConfig) {
 		cfg.FlushThreads = DefaultFlushThreads
 	}
 
+	if cfg.CircuitBreakerCooldown == 0 {
+		cfg.CircuitBreakerCooldown = DefaultCircuitCooldown
+	}
+
 	if cfg.PushFlushInterval == 0 {
 		cfg.PushFlushInterval = DefaultFlushInterval
 	}
@@ -64,4 +473,56 @@ func setDefaults(cfg *ProxyConfig) {
 	if cfg.PushMemoryBufferLimit == 0 {
 		cfg.PushMemoryBufferLimit = DefaultMemoryBufferLimit
 	}
+
+	if cfg.BufferDiskLimit == 0 {
+		cfg.BufferDiskLimit = DefaultBufferDiskLimit
+	}
+
+	if cfg.ShutdownTimeout == 0 {
+		cfg.ShutdownTimeout = DefaultShutdownTimeout
+	}
+
+	if cfg.MaxFutureSkew == 0 {
+		cfg.MaxFutureSkew = DefaultMaxFutureSkew
+	}
+
+	if cfg.MaxPastSkew == 0 {
+		cfg.MaxPastSkew = DefaultMaxPastSkew
+	}
+
+	if cfg.LogLevel == "" {
+		cfg.LogLevel = DefaultLogLevel
+	}
+
+	if cfg.SourceTagName == "" {
+		cfg.SourceTagName = DefaultSourceTagName
+	}
+
+	if cfg.AbuseAction == "" {
+		cfg.AbuseAction = AbuseActionThrottle
+	}
+
+	if cfg.SocketFormat == "" {
+		cfg.SocketFormat = SocketFormatWavefront
+	}
+
+	if cfg.SocketMode == "" {
+		cfg.SocketMode = DefaultSocketMode
+	}
+
+	if cfg.BackpressureHighWatermark == 0 {
+		cfg.BackpressureHighWatermark = DefaultHighWatermark
+	}
+
+	if cfg.BackpressureLowWatermark == 0 {
+		cfg.BackpressureLowWatermark = DefaultLowWatermark
+	}
+
+	if cfg.CSVDelimiter == "" {
+		cfg.CSVDelimiter = DefaultCSVDelimiter
+	}
+
+	if cfg.CSVColumns == "" {
+		cfg.CSVColumns = DefaultCSVColumns
+	}
 }
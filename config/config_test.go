@@ -0,0 +1,435 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func validConfig() *ProxyConfig {
+	cfg := &ProxyConfig{
+		Server:            "https://try.wavefront.com/api",
+		PushListenerPorts: "2878",
+		OpenTSDBPorts:     "4242",
+	}
+	setDefaults(cfg)
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+	if err := validConfig().Validate(); err != nil {
+		t.Fatalf("Unexpected error for valid config: %v", err)
+	}
+
+	invalid := []struct {
+		problem string
+		modify  func(cfg *ProxyConfig)
+	}{
+		{"server", func(cfg *ProxyConfig) { cfg.Server = "try.wavefront.com" }},
+		{"server", func(cfg *ProxyConfig) { cfg.Server = "http://%zz" }},
+		{"additionalServers", func(cfg *ProxyConfig) { cfg.AdditionalServers = "https://a.wavefront.com,b.wavefront.com" }},
+		{"additionalTokens", func(cfg *ProxyConfig) {
+			cfg.AdditionalServers = "https://a.wavefront.com"
+			cfg.AdditionalTokens = "a,b"
+		}},
+		{"internalMetricsServer", func(cfg *ProxyConfig) { cfg.InternalMetricsServer = "ops.wavefront.com" }},
+		{"internalMetricsToken", func(cfg *ProxyConfig) { cfg.InternalMetricsToken = "XXX" }},
+		{"directIngestion", func(cfg *ProxyConfig) {
+			cfg.DirectIngestion = true
+			cfg.InternalMetricsServer = "https://ops.wavefront.com/api"
+		}},
+		{"flushThreads", func(cfg *ProxyConfig) { cfg.FlushThreads = -1 }},
+		{"acceptGoroutines", func(cfg *ProxyConfig) { cfg.AcceptGoroutines = -1 }},
+		{"flushRetries", func(cfg *ProxyConfig) { cfg.FlushRetries = -1 }},
+		{"pushFlushInterval", func(cfg *ProxyConfig) { cfg.PushFlushInterval = -1000 }},
+		{"pushFlushMaxPoints", func(cfg *ProxyConfig) { cfg.PushFlushMaxPoints = -1 }},
+		{"pushMemoryBufferLimit", func(cfg *ProxyConfig) { cfg.PushMemoryBufferLimit = cfg.PushFlushMaxPoints - 1 }},
+		{"circuitBreakerThreshold", func(cfg *ProxyConfig) { cfg.CircuitBreakerThreshold = -1 }},
+		{"circuitBreakerCooldown", func(cfg *ProxyConfig) { cfg.CircuitBreakerCooldown = -5 }},
+		{"pushFlushTriggerPercent", func(cfg *ProxyConfig) { cfg.PushFlushTriggerPercent = -1 }},
+		{"pushMemoryBufferBytes", func(cfg *ProxyConfig) { cfg.PushMemoryBufferBytes = -1 }},
+		{"pushRateLimit", func(cfg *ProxyConfig) { cfg.PushRateLimit = -1 }},
+		{"maxConcurrentFlushes", func(cfg *ProxyConfig) { cfg.MaxConcurrentFlushes = -1 }},
+		{"maxFlushBytes", func(cfg *ProxyConfig) { cfg.MaxFlushBytes = -1 }},
+		{"sharedFlushWindow", func(cfg *ProxyConfig) { cfg.SharedFlushWindow = -1 }},
+		{"bufferDiskLimit", func(cfg *ProxyConfig) { cfg.BufferDiskLimit = -1 }},
+		{"shutdownTimeout", func(cfg *ProxyConfig) { cfg.ShutdownTimeout = -1 }},
+		{"drainTimeout", func(cfg *ProxyConfig) { cfg.DrainTimeout = -1 }},
+		{"tcpKeepAlive", func(cfg *ProxyConfig) { cfg.TcpKeepAlive = -1 }},
+		{"apiMaxIdleConns", func(cfg *ProxyConfig) { cfg.ApiMaxIdleConns = -1 }},
+		{"apiIdleConnTimeout", func(cfg *ProxyConfig) { cfg.ApiIdleConnTimeout = -1 }},
+		{"apiTimeout", func(cfg *ProxyConfig) { cfg.ApiTimeout = -1 }},
+		{"flushTimeout", func(cfg *ProxyConfig) { cfg.FlushTimeout = -1 }},
+		{"runtimeStatsInterval", func(cfg *ProxyConfig) { cfg.RuntimeStatsInterval = -1 }},
+		{"apiDnsRefreshInterval", func(cfg *ProxyConfig) { cfg.ApiDnsRefreshInterval = -1 }},
+		{"checkinInterval", func(cfg *ProxyConfig) { cfg.CheckinInterval = -1 }},
+		{"startupGrace", func(cfg *ProxyConfig) { cfg.StartupGrace = -1 }},
+		{"logLevel", func(cfg *ProxyConfig) { cfg.LogLevel = "verbose" }},
+		{"logFormat", func(cfg *ProxyConfig) { cfg.LogFormat = "xml" }},
+		{"maxFutureSkew", func(cfg *ProxyConfig) { cfg.MaxFutureSkew = -1 }},
+		{"maxPastSkew", func(cfg *ProxyConfig) { cfg.MaxPastSkew = -1 }},
+		{"socketFormat", func(cfg *ProxyConfig) { cfg.SocketFormat = "influx" }},
+		{"timestampUnit", func(cfg *ProxyConfig) { cfg.TimestampUnit = "us" }},
+		{"backpressureHighWatermark", func(cfg *ProxyConfig) { cfg.Backpressure, cfg.BackpressureHighWatermark = true, 101 }},
+		{"backpressureLowWatermark", func(cfg *ProxyConfig) { cfg.Backpressure, cfg.BackpressureLowWatermark = true, 95 }},
+		{"backpressureHighWatermark", func(cfg *ProxyConfig) { cfg.OpenTSDBThrottle, cfg.BackpressureHighWatermark = true, 0 }},
+		{"socketMode", func(cfg *ProxyConfig) { cfg.SocketMode = "rw-rw-rw-" }},
+		{"socketMode", func(cfg *ProxyConfig) { cfg.SocketMode = "1777" }},
+		{"maxConnections", func(cfg *ProxyConfig) { cfg.MaxConnections = -1 }},
+		{"abuseThreshold", func(cfg *ProxyConfig) { cfg.AbuseThreshold = -1 }},
+		{"abuseAction", func(cfg *ProxyConfig) { cfg.AbuseAction = "drop" }},
+		{"dropPolicy", func(cfg *ProxyConfig) { cfg.DropPolicy = "random" }},
+		{"connectionIdleTimeout", func(cfg *ProxyConfig) { cfg.ConnectionIdleTimeout = -1 }},
+		{"maxLineLength", func(cfg *ProxyConfig) { cfg.MaxLineLength = -1 }},
+		{"readBufferSize", func(cfg *ProxyConfig) { cfg.ReadBufferSize = -1 }},
+		{"maxTagsPerPoint", func(cfg *ProxyConfig) { cfg.MaxTagsPerPoint = -1 }},
+		{"perSourceRateLimit", func(cfg *ProxyConfig) { cfg.PerSourceRateLimit = -1 }},
+		{"cardinalityThreshold", func(cfg *ProxyConfig) { cfg.CardinalityThreshold = -1 }},
+		{"cardinalityLimit", func(cfg *ProxyConfig) { cfg.CardinalityLimit = -1 }},
+		{"pushListenerPorts", func(cfg *ProxyConfig) { cfg.PushListenerPorts = "2878,abc" }},
+		{"opentsdbPorts", func(cfg *ProxyConfig) { cfg.OpenTSDBPorts = "70000" }},
+		{"statsdPorts", func(cfg *ProxyConfig) { cfg.StatsDPorts = "-1" }},
+		{"collectdPorts", func(cfg *ProxyConfig) { cfg.CollectdPorts = "25826x" }},
+		{"influxPorts", func(cfg *ProxyConfig) { cfg.InfluxPorts = "8094x" }},
+		{"bindAddress", func(cfg *ProxyConfig) { cfg.BindAddress = "127.0.0.1:2878" }},
+		{"csvPorts", func(cfg *ProxyConfig) { cfg.CSVPorts = "::1:3878" }},
+		{"csvPorts", func(cfg *ProxyConfig) { cfg.CSVPorts = "127.0.0.1:" }},
+		{"autoDetectPorts", func(cfg *ProxyConfig) { cfg.AutoDetectPorts = "2881,x" }},
+		{"histogramMinutePort", func(cfg *ProxyConfig) { cfg.HistogramMinutePort = "x" }},
+		{"histogramDistPort", func(cfg *ProxyConfig) { cfg.HistogramDistPort = "40004,0x" }},
+		{"httpPort", func(cfg *ProxyConfig) { cfg.HttpPort = 65536 }},
+		{"promWritePort", func(cfg *ProxyConfig) { cfg.PromWritePort = -1 }},
+		{"healthPort", func(cfg *ProxyConfig) { cfg.HealthPort = -1 }},
+		{"listeners section", func(cfg *ProxyConfig) {
+			cfg.Listeners = ListenerOverrides{"graphitePorts": {FlushThreads: 2}}
+		}},
+		{"listeners.opentsdbPorts settings", func(cfg *ProxyConfig) {
+			cfg.Listeners = ListenerOverrides{"opentsdbPorts": {PushFlushInterval: -1}}
+		}},
+		{"listeners.opentsdbPorts pushMemoryBufferLimit", func(cfg *ProxyConfig) {
+			cfg.Listeners = ListenerOverrides{"opentsdbPorts": {PushFlushMaxPoints: cfg.PushMemoryBufferLimit + 1}}
+		}},
+	}
+	for _, c := range invalid {
+		cfg := validConfig()
+		c.modify(cfg)
+		err := cfg.Validate()
+		if err == nil || !strings.Contains(err.Error(), c.problem) {
+			t.Errorf("Expected error for %s, found %v", c.problem, err)
+		}
+	}
+}
+
+func TestValidateListsAllProblems(t *testing.T) {
+	cfg := validConfig()
+	cfg.FlushThreads = -1
+	cfg.PushFlushInterval = -1
+	err := cfg.Validate()
+	validationErr, ok := err.(*ValidationError)
+	if !ok || len(validationErr.Problems) != 2 {
+		t.Errorf("Expected 2 problems, found %v", err)
+	}
+}
+
+func TestLoadInvalidConfig(t *testing.T) {
+	file, err := ioutil.TempFile("", "wavefront-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("server=https://try.wavefront.com/api\npushFlushInterval=-5\nflushThreads=-2\n")
+	file.Close()
+
+	_, err = LoadConfig(file.Name())
+	if err == nil || !strings.Contains(err.Error(), "pushFlushInterval") || !strings.Contains(err.Error(), "flushThreads") {
+		t.Errorf("Expected errors for pushFlushInterval and flushThreads, found %v", err)
+	}
+}
+
+func TestEnvOverridesFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "wavefront-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("server=https://try.wavefront.com/api\ntoken=file-token\npushFlushInterval=2000\nflushThreads=8\n")
+	file.Close()
+
+	os.Setenv("WAVEFRONT_TOKEN", "env-token")
+	os.Setenv("WAVEFRONT_PUSHFLUSHINTERVAL", "5000")
+	os.Setenv("WAVEFRONT_GZIPUPLOAD", "false")
+	defer os.Unsetenv("WAVEFRONT_TOKEN")
+	defer os.Unsetenv("WAVEFRONT_PUSHFLUSHINTERVAL")
+	defer os.Unsetenv("WAVEFRONT_GZIPUPLOAD")
+
+	cfg, err := LoadConfig(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Token != "env-token" || cfg.PushFlushInterval != 5000 || cfg.GzipUpload {
+		t.Errorf("Expected environment to override the file, found %s %d %v", cfg.Token, cfg.PushFlushInterval, cfg.GzipUpload)
+	}
+	if cfg.FlushThreads != 8 || cfg.PushFlushMaxPoints != DefaultFlushMaxPoints || cfg.FlushRetries != DefaultFlushRetries {
+		t.Errorf("Expected file and default values, found %d %d %d", cfg.FlushThreads, cfg.PushFlushMaxPoints, cfg.FlushRetries)
+	}
+
+	os.Setenv("WAVEFRONT_FLUSHTHREADS", "many")
+	defer os.Unsetenv("WAVEFRONT_FLUSHTHREADS")
+	if _, err = LoadConfig(file.Name()); err == nil {
+		t.Error("Expected error for invalid environment value")
+	}
+}
+
+func TestLoadConfigMerge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wavefront-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	base := filepath.Join(dir, "base.conf")
+	host := filepath.Join(dir, "host.yaml")
+	ioutil.WriteFile(base, []byte("server=https://try.wavefront.com/api\ntoken=base-token\npushListenerPorts=2878\n"+
+		"flushThreads=6\npushFlushInterval=2000\ngzipUpload=false\nlisteners.opentsdbPorts.pushFlushInterval=100\n"+
+		"listeners.pushListenerPorts.useProxyTime=true\n"), 0644)
+	ioutil.WriteFile(host, []byte("token: host-token\nflushThreads: 2\nflushRetries: 0\nlisteners:\n  opentsdbPorts:\n    flushThreads: 1\n"), 0644)
+
+	cfg, err := LoadConfig(base + ", " + host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Token != "host-token" || cfg.FlushThreads != 2 {
+		t.Errorf("Expected the host file to override the base file, found %s %d", cfg.Token, cfg.FlushThreads)
+	}
+	if cfg.FlushRetries != 0 {
+		t.Errorf("Expected retries disabled by the host file, found %d", cfg.FlushRetries)
+	}
+	if cfg.Server != "https://try.wavefront.com/api" || cfg.PushFlushInterval != 2000 || cfg.GzipUpload {
+		t.Errorf("Expected the base settings the host file omits, found %s %d %v", cfg.Server, cfg.PushFlushInterval, cfg.GzipUpload)
+	}
+	if cfg.PushFlushMaxPoints != DefaultFlushMaxPoints || cfg.DrainTimeout != DefaultDrainTimeout {
+		t.Errorf("Expected defaults for settings in neither file, found %d %d", cfg.PushFlushMaxPoints, cfg.DrainTimeout)
+	}
+	if s := cfg.Listeners.Get("opentsdbPorts"); s != (FlushSettings{FlushThreads: 1, PushFlushInterval: 100}) {
+		t.Errorf("Expected the listeners sections to be merged, found %+v", s)
+	}
+	if s := cfg.Listeners.Get("pushListenerPorts"); s.UseProxyTime == nil || !*s.UseProxyTime {
+		t.Errorf("Expected useProxyTime set for pushListenerPorts, found %v", s)
+	}
+
+	// in reverse order the base file overrides the host file
+	cfg, err = LoadConfig(host + "," + base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Token != "base-token" || cfg.FlushThreads != 6 {
+		t.Errorf("Expected the last file to take precedence, found %s %d", cfg.Token, cfg.FlushThreads)
+	}
+
+	if _, err = LoadConfig(base + "," + filepath.Join(dir, "missing.conf")); err == nil {
+		t.Error("Expected error for a missing file")
+	}
+}
+
+func TestSet(t *testing.T) {
+	cfg := &ProxyConfig{}
+	if err := cfg.Set("pushListenerPorts", "2878,2879"); err != nil || cfg.PushListenerPorts != "2878,2879" {
+		t.Errorf("Unexpected result setting a string: %v %s", err, cfg.PushListenerPorts)
+	}
+	if err := cfg.Set("FLUSHTHREADS", "6"); err != nil || cfg.FlushThreads != 6 {
+		t.Errorf("Unexpected result setting an int: %v %d", err, cfg.FlushThreads)
+	}
+	if err := cfg.Set("gzipUpload", "true"); err != nil || !cfg.GzipUpload {
+		t.Errorf("Unexpected result setting a bool: %v %v", err, cfg.GzipUpload)
+	}
+	if err := cfg.Set("unknown", "1"); err != ErrUnknownSetting {
+		t.Errorf("Expected ErrUnknownSetting, found %v", err)
+	}
+}
+
+var configFormats = map[string]string{
+	"wavefront.conf": `server=https://try.wavefront.com/api
+token=abc
+hostname=proxy-1
+pushListenerPorts=2878,2879
+gzipUpload=false
+flushThreads=6
+pushFlushInterval=2000
+listeners.opentsdbPorts.pushFlushInterval=100
+unknownSetting=1
+`,
+	"wavefront.yaml": `server: https://try.wavefront.com/api
+token: abc
+hostname: proxy-1
+pushListenerPorts: "2878,2879"
+gzipUpload: false
+flushThreads: 6
+pushFlushInterval: 2000
+listeners:
+  opentsdbPorts:
+    pushFlushInterval: 100
+unknownSetting: 1
+`,
+	"wavefront.json": `{
+  "server": "https://try.wavefront.com/api",
+  "token": "abc",
+  "hostname": "proxy-1",
+  "pushListenerPorts": "2878,2879",
+  "gzipUpload": false,
+  "flushThreads": 6,
+  "listeners": {"opentsdbPorts": {"pushFlushInterval": 100}},
+  "pushFlushInterval": 2000,
+  "unknownSetting": 1
+}
+`,
+}
+
+func TestConfigFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wavefront-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	expected := &ProxyConfig{
+		Server:                  "https://try.wavefront.com/api",
+		Token:                   "abc",
+		Hostname:                "proxy-1",
+		PushListenerPorts:       "2878,2879",
+		FlushThreads:            6,
+		PushFlushInterval:       2000,
+		FlushRetries:            DefaultFlushRetries,
+		DrainTimeout:            DefaultDrainTimeout,
+		StatsDGaugeExpiry:       DefaultStatsDGaugeExpiry,
+		TcpKeepAlive:            DefaultTcpKeepAlive,
+		ApiDnsRefreshInterval:   DefaultDnsRefresh,
+		RuntimeStatsInterval:    DefaultRuntimeStats,
+		PushFlushTriggerPercent: DefaultFlushTrigger,
+		MetricPrefixSeparator:   DefaultPrefixSeparator,
+		Listeners:               ListenerOverrides{"opentsdbports": {PushFlushInterval: 100}},
+	}
+	setDefaults(expected)
+
+	for name, contents := range configFormats {
+		filename := filepath.Join(dir, name)
+		err = ioutil.WriteFile(filename, []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := LoadConfig(filename)
+		if err != nil {
+			t.Errorf("Error loading %s: %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(cfg, expected) {
+			t.Errorf("Unexpected config loaded from %s: %+v", name, cfg)
+		}
+	}
+}
+
+func TestResolveToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wavefront-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("  file-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &ProxyConfig{TokenFile: tokenFile}
+	if err := cfg.ResolveToken(); err != nil || cfg.Token != "file-token" {
+		t.Errorf("Expected token read from file, found %q and %v", cfg.Token, err)
+	}
+
+	cfg = &ProxyConfig{Token: "token", TokenFile: tokenFile}
+	if err := cfg.ResolveToken(); err != ErrTokenConflict {
+		t.Errorf("Expected conflict with both token and tokenFile, found %v", err)
+	}
+
+	ioutil.WriteFile(tokenFile, []byte("\n"), 0600)
+	cfg = &ProxyConfig{TokenFile: tokenFile}
+	if err := cfg.ResolveToken(); err == nil {
+		t.Error("Expected error for empty tokenFile")
+	}
+	cfg = &ProxyConfig{TokenFile: filepath.Join(dir, "missing")}
+	if err := cfg.ResolveToken(); err == nil {
+		t.Error("Expected error for missing tokenFile")
+	}
+}
+
+func TestTokenCommand(t *testing.T) {
+	cfg := &ProxyConfig{TokenCommand: "echo ' command-token '"}
+	if err := cfg.ResolveToken(); err != nil || cfg.Token != "command-token" {
+		t.Errorf("Expected token from command, found %q and %v", cfg.Token, err)
+	}
+
+	cfg = &ProxyConfig{TokenCommand: "exit 1"}
+	if err := cfg.ResolveToken(); err == nil {
+		t.Error("Expected error for failing tokenCommand")
+	}
+	cfg = &ProxyConfig{TokenFile: "token", TokenCommand: "echo token"}
+	if err := cfg.ResolveToken(); err != ErrTokenConflict {
+		t.Errorf("Expected conflict with both tokenFile and tokenCommand, found %v", err)
+	}
+}
+
+func TestListenerOverrides(t *testing.T) {
+	cfg := validConfig()
+	cfg.Listeners = ListenerOverrides{"opentsdbports": {FlushThreads: 1, PushFlushInterval: 100}}
+
+	s := cfg.globalFlushSettings().Override(cfg.Listeners.Get("opentsdbPorts"))
+	expected := FlushSettings{FlushThreads: 1, PushFlushInterval: 100,
+		PushFlushMaxPoints: DefaultFlushMaxPoints, PushMemoryBufferLimit: DefaultMemoryBufferLimit}
+	if s != expected {
+		t.Errorf("Expected %+v for opentsdbPorts, found %+v", expected, s)
+	}
+	if s = cfg.globalFlushSettings().Override(cfg.Listeners.Get("pushListenerPorts")); s != cfg.globalFlushSettings() {
+		t.Errorf("Expected the global settings for pushListenerPorts, found %+v", s)
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	tests := map[string][]string{
+		"":                  nil,
+		" , ":               nil,
+		"a":                 {"a"},
+		" a, b ,,c,":        {"a", "b", "c"},
+		"https://x/api, y ": {"https://x/api", "y"},
+	}
+	for list, expected := range tests {
+		if items := SplitList(list); !reflect.DeepEqual(items, expected) {
+			t.Errorf("Expected %q for %q, found %q", expected, list, items)
+		}
+	}
+}
+
+func TestSplitListenAddr(t *testing.T) {
+	tests := []struct {
+		entry, defaultHost, host string
+		port                     int
+	}{
+		{"2878", "", "", 2878},
+		{"2878", "10.0.0.1", "10.0.0.1", 2878},
+		{"2878", "[::1]", "::1", 2878},
+		{"127.0.0.1:2878", "10.0.0.1", "127.0.0.1", 2878},
+		{"[::1]:2878", "", "::1", 2878},
+		{":2878", "10.0.0.1", "", 2878},
+	}
+	for _, test := range tests {
+		host, port, err := SplitListenAddr(test.entry, test.defaultHost)
+		if err != nil || host != test.host || port != test.port {
+			t.Errorf("Expected %s:%d for %q, found %s:%d %v", test.host, test.port, test.entry, host, port, err)
+		}
+	}
+
+	for _, entry := range []string{"abc", "0", "::1", "[::1]", "localhost:abc", "[::1]:70000"} {
+		if _, _, err := SplitListenAddr(entry, ""); err == nil {
+			t.Errorf("Expected an error for %q", entry)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/wavefronthq/go-proxy/logger"
+)
+
+// Prefix of the environment variables overriding config settings, e.g. WAVEFRONT_TOKEN
+// or WAVEFRONT_PUSHFLUSHINTERVAL for the pushFlushInterval setting.
+const EnvPrefix = "WAVEFRONT_"
+
+var (
+	ErrUnknownSetting = errors.New("unknown setting")
+)
+
+// Set assigns the named setting, matched case insensitively, from its string value.
+func (cfg *ProxyConfig) Set(key, value string) error {
+	v := reflect.ValueOf(cfg).Elem()
+	field := v.FieldByNameFunc(func(name string) bool {
+		return strings.EqualFold(name, key)
+	})
+	if !field.IsValid() {
+		return ErrUnknownSetting
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		i, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %v", key, err)
+		}
+		field.SetInt(int64(i))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %v", key, err)
+		}
+		field.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported type for %s", key)
+	}
+	return nil
+}
+
+// ApplyEnv overrides settings with WAVEFRONT_<SETTING> environment variables.
+// Only the names of the variables used are logged, never their values.
+func ApplyEnv(cfg *ProxyConfig) error {
+	t := reflect.TypeOf(*cfg)
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Name
+		value, ok := os.LookupEnv(EnvPrefix + strings.ToUpper(name))
+		if !ok {
+			continue
+		}
+		logger.Infof("Using %s%s from the environment", EnvPrefix, strings.ToUpper(name))
+		err := cfg.Set(name, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Settings of the port groups, named by the setting listing their ports, which may override
+// the flush settings, e.g. listeners.opentsdbPorts.pushFlushInterval=100 in a properties file.
+var ListenerGroups = []string{
+	"pushListenerPorts",
+	"opentsdbPorts",
+	"statsdPorts",
+	"collectdPorts",
+	"influxPorts",
+	"csvPorts",
+	"autoDetectPorts",
+	"histogramMinutePort",
+	"histogramHourPort",
+	"histogramDayPort",
+	"histogramDistPort",
+	"httpPort",
+	"promWritePort",
+	"socketPath",
+}
+
+// Flush settings of the listeners in a port group. Unset (zero) settings use the global value.
+type FlushSettings struct {
+	FlushThreads          int
+	PushFlushInterval     int
+	PushFlushMaxPoints    int
+	PushMemoryBufferLimit int
+	PushMemoryBufferBytes int
+	// set if the group overrides useProxyTime, applied when its listeners start
+	UseProxyTime *bool
+}
+
+// Returns the settings with the non-zero settings of the override applied.
+func (s FlushSettings) Override(o FlushSettings) FlushSettings {
+	if o.FlushThreads != 0 {
+		s.FlushThreads = o.FlushThreads
+	}
+	if o.PushFlushInterval != 0 {
+		s.PushFlushInterval = o.PushFlushInterval
+	}
+	if o.PushFlushMaxPoints != 0 {
+		s.PushFlushMaxPoints = o.PushFlushMaxPoints
+	}
+	if o.PushMemoryBufferLimit != 0 {
+		s.PushMemoryBufferLimit = o.PushMemoryBufferLimit
+	}
+	if o.PushMemoryBufferBytes != 0 {
+		s.PushMemoryBufferBytes = o.PushMemoryBufferBytes
+	}
+	if o.UseProxyTime != nil {
+		s.UseProxyTime = o.UseProxyTime
+	}
+	return s
+}
+
+func (s FlushSettings) String() string {
+	str := fmt.Sprintf("{FlushThreads:%d PushFlushInterval:%d PushFlushMaxPoints:%d PushMemoryBufferLimit:%d PushMemoryBufferBytes:%d",
+		s.FlushThreads, s.PushFlushInterval, s.PushFlushMaxPoints, s.PushMemoryBufferLimit, s.PushMemoryBufferBytes)
+	if s.UseProxyTime != nil {
+		str += fmt.Sprintf(" UseProxyTime:%t", *s.UseProxyTime)
+	}
+	return str + "}"
+}
+
+// Flush settings overridden per port group, keyed by the setting listing the ports.
+type ListenerOverrides map[string]FlushSettings
+
+// Returns the overrides of the port group, matched case insensitively as setting keys are.
+func (o ListenerOverrides) Get(group string) FlushSettings {
+	for name, overrides := range o {
+		if strings.EqualFold(name, group) {
+			return overrides
+		}
+	}
+	return FlushSettings{}
+}
+
+func (cfg *ProxyConfig) globalFlushSettings() FlushSettings {
+	return FlushSettings{
+		FlushThreads:          cfg.FlushThreads,
+		PushFlushInterval:     cfg.PushFlushInterval,
+		PushFlushMaxPoints:    cfg.PushFlushMaxPoints,
+		PushMemoryBufferLimit: cfg.PushMemoryBufferLimit,
+		PushMemoryBufferBytes: cfg.PushMemoryBufferBytes,
+	}
+}
+
+// Returns the overridden port groups in a stable order.
+func (cfg *ProxyConfig) overriddenGroups() []string {
+	groups := make([]string, 0, len(cfg.Listeners))
+	for group := range cfg.Listeners {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// Splits a port list entry, a port or host:port with IPv6 hosts in brackets, e.g. [::1]:2878.
+// Bare ports are bound to the default host, all interfaces if empty.
+func SplitListenAddr(entry, defaultHost string) (string, int, error) {
+	host, portStr := strings.Trim(defaultHost, "[]"), entry
+	if strings.Contains(entry, ":") {
+		var err error
+		if host, portStr, err = net.SplitHostPort(entry); err != nil {
+			return "", 0, err
+		}
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return "", 0, errors.New("invalid port " + portStr)
+	}
+	return host, port, nil
+}
+
+func knownListenerGroup(group string) bool {
+	for _, name := range ListenerGroups {
+		if strings.EqualFold(name, group) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s FlushSettings) negative() bool {
+	return s.FlushThreads < 0 || s.PushFlushInterval < 0 || s.PushFlushMaxPoints < 0 || s.PushMemoryBufferLimit < 0 ||
+		s.PushMemoryBufferBytes < 0
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// max time a tokenCommand may run
+const tokenCommandTimeout = 30 * time.Second
+
+var ErrTokenConflict = errors.New("only one of token, tokenFile and tokenCommand may be set")
+
+// Reads the token from the file, ignoring surrounding whitespace.
+func ReadTokenFile(filename string) (string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", fmt.Errorf("error reading tokenFile: %v", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("tokenFile %s is empty", filename)
+	}
+	return token, nil
+}
+
+// Runs the command with the shell and returns its output, ignoring surrounding whitespace.
+func RunTokenCommand(command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tokenCommandTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("error running tokenCommand: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	token := strings.TrimSpace(string(out))
+	if token == "" {
+		return "", errors.New("tokenCommand returned no token")
+	}
+	return token, nil
+}
+
+// Returns a function reading the token from the file or command, nil if neither is set.
+func TokenSource(tokenFile, tokenCommand string) func() (string, error) {
+	switch {
+	case tokenFile != "":
+		return func() (string, error) { return ReadTokenFile(tokenFile) }
+	case tokenCommand != "":
+		return func() (string, error) { return RunTokenCommand(tokenCommand) }
+	}
+	return nil
+}
+
+// Sets the token from the tokenFile or tokenCommand, if any. Setting more than one of them
+// is an error rather than silently preferring one.
+func (cfg *ProxyConfig) ResolveToken() error {
+	set := 0
+	for _, setting := range []string{cfg.Token, cfg.TokenFile, cfg.TokenCommand} {
+		if setting != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return ErrTokenConflict
+	}
+	source := TokenSource(cfg.TokenFile, cfg.TokenCommand)
+	if source == nil {
+		return nil
+	}
+	token, err := source()
+	if err != nil {
+		return err
+	}
+	cfg.Token = token
+	return nil
+}
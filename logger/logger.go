@@ -0,0 +1,148 @@
+// Package logger provides leveled logging in text or JSON format.
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Level int32
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+var (
+	level      = int32(InfoLevel)
+	mtx        sync.Mutex
+	output     io.Writer = os.Stderr
+	jsonFormat bool
+	now        = time.Now
+)
+
+func (l Level) String() string {
+	if l < DebugLevel || l > ErrorLevel {
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return InfoLevel, fmt.Errorf("invalid log level %q, expected one of %s", s, strings.Join(levelNames, ", "))
+}
+
+// Sets the minimum level logged, messages below it are discarded.
+func SetLevel(l Level) {
+	atomic.StoreInt32(&level, int32(l))
+}
+
+func GetLevel() Level {
+	return Level(atomic.LoadInt32(&level))
+}
+
+func Enabled(l Level) bool {
+	return l >= GetLevel()
+}
+
+func SetOutput(w io.Writer) {
+	mtx.Lock()
+	defer mtx.Unlock()
+	output = w
+}
+
+// Sets the output format to FormatText or FormatJSON.
+func SetFormat(format string) error {
+	mtx.Lock()
+	defer mtx.Unlock()
+	switch format {
+	case FormatText, "":
+		jsonFormat = false
+	case FormatJSON:
+		jsonFormat = true
+	default:
+		return fmt.Errorf("invalid log format %q, expected %s or %s", format, FormatText, FormatJSON)
+	}
+	return nil
+}
+
+func write(l Level, msg string) {
+	if Enabled(l) {
+		emit(l, msg)
+	}
+}
+
+func emit(l Level, msg string) {
+	t := now()
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	if jsonFormat {
+		writeJSON(t, l, msg)
+		return
+	}
+	fmt.Fprintf(output, "%s [%s] %s\n", t.Format("2006/01/02 15:04:05"), strings.ToUpper(l.String()), msg)
+}
+
+func writeJSON(t time.Time, l Level, msg string) {
+	entry := struct {
+		Time  string `json:"time"`
+		Level string `json:"level"`
+		Msg   string `json:"msg"`
+	}{t.Format(time.RFC3339Nano), l.String(), msg}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		fmt.Fprintf(output, "%s [ERROR] error encoding log entry: %v\n", t.Format("2006/01/02 15:04:05"), err)
+		return
+	}
+	output.Write(append(b, '\n'))
+}
+
+// formats arguments like log.Println without the trailing newline
+func sprint(args ...interface{}) string {
+	return strings.TrimSuffix(fmt.Sprintln(args...), "\n")
+}
+
+func Debug(args ...interface{}) { write(DebugLevel, sprint(args...)) }
+func Info(args ...interface{})  { write(InfoLevel, sprint(args...)) }
+func Warn(args ...interface{})  { write(WarnLevel, sprint(args...)) }
+func Error(args ...interface{}) { write(ErrorLevel, sprint(args...)) }
+
+func Debugf(format string, args ...interface{}) { write(DebugLevel, fmt.Sprintf(format, args...)) }
+func Infof(format string, args ...interface{})  { write(InfoLevel, fmt.Sprintf(format, args...)) }
+func Warnf(format string, args ...interface{})  { write(WarnLevel, fmt.Sprintf(format, args...)) }
+func Errorf(format string, args ...interface{}) { write(ErrorLevel, fmt.Sprintf(format, args...)) }
+
+// Logs at error level, regardless of the configured level, and exits.
+func Fatal(args ...interface{}) {
+	fatal(fmt.Sprint(args...))
+}
+
+func Fatalf(format string, args ...interface{}) {
+	fatal(fmt.Sprintf(format, args...))
+}
+
+func fatal(msg string) {
+	emit(ErrorLevel, msg)
+	os.Exit(1)
+}
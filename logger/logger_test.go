@@ -0,0 +1,67 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setupTest(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	now = func() time.Time { return time.Date(2017, 9, 15, 5, 40, 47, 0, time.UTC) }
+	t.Cleanup(func() {
+		SetOutput(os.Stderr)
+		SetLevel(InfoLevel)
+		SetFormat(FormatText)
+		now = time.Now
+	})
+	return &buf
+}
+
+func TestLevels(t *testing.T) {
+	buf := setupTest(t)
+	SetLevel(WarnLevel)
+	Debug("debug")
+	Infof("info %d", 1)
+	Warn("warn", 2)
+	Errorf("error %d", 3)
+
+	expected := "2017/09/15 05:40:47 [WARN] warn 2\n2017/09/15 05:40:47 [ERROR] error 3\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q, found %q", expected, buf.String())
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	buf := setupTest(t)
+	if err := SetFormat(FormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	Info("starting", "proxy")
+
+	var entry map[string]string
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["level"] != "info" || entry["msg"] != "starting proxy" || entry["time"] != "2017-09-15T05:40:47Z" {
+		t.Errorf("Unexpected entry %v", entry)
+	}
+	if err := SetFormat("xml"); err == nil {
+		t.Error("Error expected for invalid format")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for name, expected := range map[string]Level{"debug": DebugLevel, "INFO": InfoLevel, "Warn": WarnLevel, "error": ErrorLevel} {
+		if level, err := ParseLevel(name); err != nil || level != expected {
+			t.Errorf("Expected %v for %s, found %v %v", expected, name, level, err)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil || !strings.Contains(err.Error(), "verbose") {
+		t.Errorf("Expected error for invalid level, found %v", err)
+	}
+}
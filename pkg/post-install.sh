@@ -4,6 +4,7 @@ USER=wavefront
 GROUP=wavefront
 BIN_DIR=/usr/bin
 LOG_DIR=/var/log/wavefront
+SPOOL_DIR=/var/spool/wavefront-proxy
 SCRIPT_DIR=/usr/lib/wavefront-proxy/scripts
 WKG_DIR=/etc/wavefront/wavefront-proxy
 LOGROTATE_DIR=/etc/logrotate.d
@@ -39,6 +40,10 @@ test -d $LOG_DIR || mkdir -p $LOG_DIR
 chown -R -L ${USER}:${GROUP} $LOG_DIR
 chmod 755 $LOG_DIR
 
+test -d $SPOOL_DIR || mkdir -p $SPOOL_DIR
+chown -R -L ${USER}:${GROUP} $SPOOL_DIR
+chmod 755 $SPOOL_DIR
+
 chown -R -L ${USER}:${GROUP} $WKG_DIR
 chmod 755 $WKG_DIR
 
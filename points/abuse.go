@@ -0,0 +1,52 @@
+package points
+
+import (
+	"time"
+)
+
+// window of the per-connection point rate
+const abuseWindow = time.Second
+
+// longest a connection is paused at a time when throttled
+const maxAbuseDelay = time.Second
+
+// Sliding window counter of the points read from a single connection. The rate is estimated
+// from the counts of the current and previous windows, weighting the previous count by how much
+// of it still overlaps the last second, so no per-point timestamps are kept.
+type connRate struct {
+	limit    float64 // points per second
+	start    time.Time
+	current  int
+	previous int
+	exceeded bool // the limit was exceeded before, so the connection was logged and counted
+	now      func() time.Time
+}
+
+func newConnRate(limit int) *connRate {
+	return &connRate{limit: float64(limit), now: time.Now}
+}
+
+// Counts the points and returns the estimated points read in the last second.
+func (r *connRate) add(points int) float64 {
+	now := r.now()
+	if elapsed := now.Sub(r.start); elapsed >= 2*abuseWindow {
+		r.start, r.current, r.previous = now, 0, 0
+	} else if elapsed >= abuseWindow {
+		r.start, r.current, r.previous = r.start.Add(abuseWindow), 0, r.current
+	}
+	r.current += points
+	overlap := 1 - float64(now.Sub(r.start))/float64(abuseWindow)
+	return float64(r.previous)*overlap + float64(r.current)
+}
+
+// Time to pause reading for the rate to fall back to the limit, 0 if it is not exceeded.
+func (r *connRate) delay(rate float64) time.Duration {
+	if rate <= r.limit {
+		return 0
+	}
+	delay := time.Duration((rate - r.limit) / r.limit * float64(time.Second))
+	if delay > maxAbuseDelay {
+		delay = maxAbuseDelay
+	}
+	return delay
+}
@@ -0,0 +1,68 @@
+package points
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wavefronthq/go-proxy/points/decoder"
+)
+
+func TestConnRate(t *testing.T) {
+	now := time.Unix(1000, 0)
+	r := newConnRate(100)
+	r.now = func() time.Time { return now }
+
+	if rate := r.add(80); rate != 80 || r.delay(rate) != 0 {
+		t.Errorf("Expected 80 points within the limit, found %f", rate)
+	}
+	now = now.Add(500 * time.Millisecond)
+	if rate := r.add(70); rate != 150 || r.delay(rate) != 500*time.Millisecond {
+		t.Errorf("Expected 150 points and a 500ms delay, found %f and %v", rate, r.delay(rate))
+	}
+
+	// half of the previous window still overlaps the last second
+	now = now.Add(time.Second)
+	if rate := r.add(10); rate != 85 {
+		t.Errorf("Expected 85 points, found %f", rate)
+	}
+
+	// windows more than a second old are forgotten
+	now = now.Add(3 * time.Second)
+	if rate := r.add(1); rate != 1 {
+		t.Errorf("Expected 1 point, found %f", rate)
+	}
+	if delay := r.delay(10000); delay != maxAbuseDelay {
+		t.Errorf("Expected the delay to be capped at %v, found %v", maxAbuseDelay, delay)
+	}
+}
+
+func TestAbusiveConnections(t *testing.T) {
+	for _, closeAbusive := range []bool{false, true} {
+		handler := &testPointHandler{}
+		l := &DefaultPointListener{Builder: decoder.GraphiteBuilder{}, AbuseThreshold: 5, CloseAbusive: closeAbusive, handler: handler}
+		l.startTCPServer("127.0.0.1:0")
+
+		conn, err := net.Dial("tcp", l.tcpListener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte(strings.Repeat("foo.metric 1 source=a\n", 10)))
+
+		closed := closedWithin(conn, 500*time.Millisecond)
+		handler.mtx.Lock()
+		received := len(handler.points)
+		handler.mtx.Unlock()
+		if closed != closeAbusive || l.connsAbusive.Count() != 1 {
+			t.Errorf("Expected closed %t and 1 abusive connection, found %t and %d", closeAbusive, closed, l.connsAbusive.Count())
+		}
+		// closed connections read nothing past the threshold, throttled ones are slowed down
+		if closeAbusive && received != 6 || received < 6 || received >= 10 {
+			t.Errorf("Expected 6 points before the connection was limited, found %d", received)
+		}
+		conn.Close()
+		l.tcpListener.Close()
+		l.connsAbusive.Clear()
+	}
+}
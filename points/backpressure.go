@@ -0,0 +1,110 @@
+package points
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/logger"
+)
+
+const backpressureInterval = 100 * time.Millisecond
+
+// Pauses reading from connections while the points buffered by a listener are above the high
+// watermark, until they fall below the low watermark. TCP flow control then slows down clients
+// instead of points being dropped when the buffer overflows.
+type backpressure struct {
+	name        string
+	highPercent int
+	lowPercent  int
+	high        int64 // points, updated atomically
+	low         int64 // points, updated atomically
+	active      int32
+	gauge       metrics.Gauge
+	ticker      *time.Ticker
+	done        chan struct{} // closed on stop
+	buffered    func() int
+}
+
+func newBackpressure(name string, highPercent, lowPercent, capacity int, buffered func() int) *backpressure {
+	b := &backpressure{
+		name:        name,
+		highPercent: highPercent,
+		lowPercent:  lowPercent,
+		gauge:       metrics.GetOrRegisterGauge("backpressure."+name+".active", nil),
+		buffered:    buffered,
+	}
+	b.setCapacity(capacity)
+	return b
+}
+
+// Sets the watermarks in points for a buffer of the given capacity.
+func (b *backpressure) setCapacity(capacity int) {
+	atomic.StoreInt64(&b.high, int64(capacity*b.highPercent/100))
+	atomic.StoreInt64(&b.low, int64(capacity*b.lowPercent/100))
+}
+
+func (b *backpressure) start() {
+	b.ticker = time.NewTicker(backpressureInterval)
+	b.done = make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-b.ticker.C:
+				b.check()
+			case <-b.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stops the monitoring and releases waiting connections.
+func (b *backpressure) stop() {
+	if b.ticker != nil {
+		b.ticker.Stop()
+		close(b.done)
+	}
+	b.setActive(false, 0)
+}
+
+func (b *backpressure) check() {
+	buffered := int64(b.buffered())
+	if atomic.LoadInt32(&b.active) == 1 {
+		if buffered <= atomic.LoadInt64(&b.low) {
+			b.setActive(false, buffered)
+		}
+	} else if buffered >= atomic.LoadInt64(&b.high) {
+		b.setActive(true, buffered)
+	}
+}
+
+func (b *backpressure) setActive(active bool, buffered int64) {
+	value := int32(0)
+	if active {
+		value = 1
+	}
+	if atomic.SwapInt32(&b.active, value) == value {
+		return
+	}
+	b.gauge.Update(int64(value))
+	if active {
+		logger.Warnf("%s-listener: %d points buffered, pausing reads until below %d", b.name, buffered, atomic.LoadInt64(&b.low))
+	} else {
+		logger.Infof("%s-listener: resuming reads", b.name)
+	}
+}
+
+func (b *backpressure) paused() bool {
+	return atomic.LoadInt32(&b.active) == 1
+}
+
+// Blocks while backpressure is applied. Returns true if it blocked.
+func (b *backpressure) wait() bool {
+	waited := false
+	for atomic.LoadInt32(&b.active) == 1 {
+		time.Sleep(backpressureInterval)
+		waited = true
+	}
+	return waited
+}
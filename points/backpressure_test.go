@@ -0,0 +1,69 @@
+package points
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackpressureWatermarks(t *testing.T) {
+	var buffered int64
+	b := newBackpressure("test", 90, 50, 100, func() int { return int(atomic.LoadInt64(&buffered)) })
+
+	steps := []struct {
+		buffered int64
+		active   int64
+	}{
+		{80, 0},
+		{90, 1},
+		{60, 1}, // stays applied until below the low watermark
+		{50, 0},
+		{80, 0},
+	}
+	for _, step := range steps {
+		atomic.StoreInt64(&buffered, step.buffered)
+		b.check()
+		if b.gauge.Value() != step.active {
+			t.Errorf("Expected backpressure %d with %d points buffered, found %d", step.active, step.buffered, b.gauge.Value())
+		}
+	}
+
+	// the watermarks follow the buffer capacity
+	b.setCapacity(200)
+	atomic.StoreInt64(&buffered, 150)
+	b.check()
+	if b.gauge.Value() != 0 {
+		t.Error("Expected no backpressure below the high watermark of the new capacity")
+	}
+}
+
+func TestBackpressureWait(t *testing.T) {
+	var buffered int64 = 100
+	b := newBackpressure("test-wait", 90, 50, 100, func() int { return int(atomic.LoadInt64(&buffered)) })
+	b.start()
+	defer b.stop()
+	time.Sleep(2 * backpressureInterval)
+
+	released := make(chan bool)
+	go func() {
+		released <- b.wait()
+	}()
+	select {
+	case <-released:
+		t.Fatal("Expected wait to block while backpressure is applied")
+	case <-time.After(2 * backpressureInterval):
+	}
+
+	atomic.StoreInt64(&buffered, 10)
+	select {
+	case waited := <-released:
+		if !waited {
+			t.Error("Expected wait to report blocking")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected wait to return below the low watermark")
+	}
+	if b.wait() {
+		t.Error("Expected wait not to block without backpressure")
+	}
+}
@@ -0,0 +1,61 @@
+package points
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	minFlushBatchSize = 100
+	// flushes slower than this shrink the batch size
+	slowFlushLatency = 2 * time.Second
+	// fraction of the max batch size added after each fast flush
+	batchGrowthFactor = 10
+)
+
+// Adjusts the number of points per flush based on flush latency, growing additively
+// up to the max flush size while flushes are fast and halving on slow or failed flushes.
+type adaptiveBatchSize struct {
+	mtx   sync.Mutex
+	size  int
+	max   int
+	gauge metrics.Gauge
+}
+
+func newAdaptiveBatchSize(name string, maxFlushSize int) *adaptiveBatchSize {
+	b := &adaptiveBatchSize{
+		size:  maxFlushSize,
+		max:   maxFlushSize,
+		gauge: metrics.GetOrRegisterGauge("push."+name+".batch.size", nil),
+	}
+	b.gauge.Update(int64(b.size))
+	return b
+}
+
+func (b *adaptiveBatchSize) current() int {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.size
+}
+
+// Sets the max batch size, shrinking the current size if needed.
+func (b *adaptiveBatchSize) setMax(maxFlushSize int) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.max = maxFlushSize
+	b.size = min(b.size, b.max)
+	b.gauge.Update(int64(b.size))
+}
+
+func (b *adaptiveBatchSize) record(latency time.Duration, failed bool) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if failed || latency > slowFlushLatency {
+		b.size = max(b.size/2, min(minFlushBatchSize, b.max))
+	} else {
+		b.size = min(b.size+max(b.max/batchGrowthFactor, 1), b.max)
+	}
+	b.gauge.Update(int64(b.size))
+}
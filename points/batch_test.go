@@ -0,0 +1,41 @@
+package points
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdaptiveBatchSize(t *testing.T) {
+	b := newAdaptiveBatchSize("test", 1000)
+	if b.current() != 1000 {
+		t.Errorf("Expected initial size 1000, found %d", b.current())
+	}
+
+	b.record(time.Millisecond, true)
+	b.record(slowFlushLatency+time.Millisecond, false)
+	if b.current() != 250 {
+		t.Errorf("Expected size 250 after a failed and a slow flush, found %d", b.current())
+	}
+
+	for i := 0; i < 10; i++ {
+		b.record(time.Millisecond, false)
+	}
+	if b.current() != 1000 {
+		t.Errorf("Expected size capped at 1000, found %d", b.current())
+	}
+	if b.gauge.Value() != 1000 {
+		t.Errorf("Expected gauge 1000, found %d", b.gauge.Value())
+	}
+
+	for i := 0; i < 10; i++ {
+		b.record(time.Millisecond, true)
+	}
+	if b.current() != minFlushBatchSize {
+		t.Errorf("Expected size floored at %d, found %d", minFlushBatchSize, b.current())
+	}
+
+	b.setMax(50)
+	if b.current() != 50 {
+		t.Errorf("Expected size capped at the new max, found %d", b.current())
+	}
+}
@@ -0,0 +1,104 @@
+package points
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wavefronthq/go-proxy/common"
+	"github.com/wavefronthq/go-proxy/logger"
+)
+
+const (
+	// blocked points kept for the /blocked endpoint
+	maxBlockedSamples = 100
+
+	// min time between logging blocked points
+	blockedLogInterval = 10 * time.Second
+)
+
+// keeps the last points dropped by the filters when enabled
+var blockedSamples = &blockedSampler{now: time.Now}
+
+// A point dropped by a filter, or a line that could not be decoded, with the rule that blocked it.
+type BlockedSample struct {
+	Time   int64             `json:"time"` // epoch millis
+	Metric string            `json:"metric,omitempty"`
+	Source string            `json:"source,omitempty"`
+	Tags   map[string]string `json:"tags,omitempty"`
+	Line   string            `json:"line,omitempty"` // set for lines that could not be decoded
+	Rule   string            `json:"rule"`
+}
+
+// Ring buffer of the last blocked points, each logged unless another was logged within the interval.
+type blockedSampler struct {
+	enabled int32
+	mtx     sync.Mutex
+	samples []BlockedSample
+	next    int   // index the next sample is written to once full
+	lastLog int64 // unix time a blocked point was last logged
+	now     func() time.Time
+}
+
+// Enables keeping and logging samples of the points blocked by the filters.
+func SetLogBlockedSamples(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&blockedSamples.enabled, v)
+}
+
+// Returns the last blocked points, oldest first.
+func BlockedSamples() []BlockedSample {
+	return blockedSamples.list()
+}
+
+func recordBlocked(point *common.Point, rule string) {
+	if atomic.LoadInt32(&blockedSamples.enabled) == 0 {
+		return
+	}
+	tags := make(map[string]string, len(point.Tags))
+	for k, v := range point.Tags {
+		tags[k] = v
+	}
+	blockedSamples.add(BlockedSample{Metric: point.Name, Source: point.Source, Tags: tags, Rule: rule})
+}
+
+func recordBlockedLine(line, rule string) {
+	if atomic.LoadInt32(&blockedSamples.enabled) == 0 {
+		return
+	}
+	blockedSamples.add(BlockedSample{Line: line, Rule: rule})
+}
+
+func (s *blockedSampler) add(sample BlockedSample) {
+	now := s.now()
+	sample.Time = now.UnixNano() / int64(time.Millisecond)
+
+	s.mtx.Lock()
+	if len(s.samples) < maxBlockedSamples {
+		s.samples = append(s.samples, sample)
+	} else {
+		s.samples[s.next] = sample
+		s.next = (s.next + 1) % maxBlockedSamples
+	}
+	s.mtx.Unlock()
+
+	last := atomic.LoadInt64(&s.lastLog)
+	if now.Unix()-last >= int64(blockedLogInterval/time.Second) && atomic.CompareAndSwapInt64(&s.lastLog, last, now.Unix()) {
+		if sample.Line != "" {
+			logger.Infof("Blocked line by %s: %s", sample.Rule, sample.Line)
+		} else {
+			logger.Infof("Blocked point by %s: %s source=%s tags=%v", sample.Rule, sample.Metric, sample.Source, sample.Tags)
+		}
+	}
+}
+
+func (s *blockedSampler) list() []BlockedSample {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	samples := make([]BlockedSample, 0, len(s.samples))
+	samples = append(samples, s.samples[s.next:]...)
+	return append(samples, s.samples[:s.next]...)
+}
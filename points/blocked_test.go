@@ -0,0 +1,67 @@
+package points
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Replaces the global sampler for the duration of a test.
+func newTestBlockedSampler(t *testing.T, enabled bool) *blockedSampler {
+	saved := blockedSamples
+	now := time.Unix(1505454047, 0)
+	blockedSamples = &blockedSampler{now: func() time.Time { return now }}
+	SetLogBlockedSamples(enabled)
+	t.Cleanup(func() { blockedSamples = saved })
+	return blockedSamples
+}
+
+func TestBlockedSamplesDisabled(t *testing.T) {
+	newTestBlockedSampler(t, false)
+	filter, _ := NewMetricFilter("", "^debug\\.")
+	filter.Process(newTestPoint("debug.foo", nil))
+	recordBlockedLine("bad line", "invalid point")
+	if samples := BlockedSamples(); len(samples) != 0 {
+		t.Errorf("Expected no samples when disabled, found %v", samples)
+	}
+}
+
+func TestBlockedSamples(t *testing.T) {
+	newTestBlockedSampler(t, true)
+	filter, _ := NewMetricFilter("^prod\\.", "^prod\\.test\\.")
+	filter.Process(newTestPoint("dev.cpu", map[string]string{"env": "dev"}))
+	filter.Process(newTestPoint("prod.test.cpu", nil))
+	filter.Process(newTestPoint("prod.cpu", nil))
+	recordBlockedLine("bad line", "invalid point")
+
+	samples := BlockedSamples()
+	if len(samples) != 3 {
+		t.Fatalf("Expected 3 samples, found %v", samples)
+	}
+	if s := samples[0]; s.Metric != "dev.cpu" || s.Source != "test" || s.Tags["env"] != "dev" ||
+		s.Rule != "whitelistRegex ^prod\\." || s.Time != 1505454047000 {
+		t.Errorf("Unexpected whitelist sample %+v", s)
+	}
+	if s := samples[1]; s.Metric != "prod.test.cpu" || s.Rule != "blacklistRegex ^prod\\.test\\." {
+		t.Errorf("Unexpected blacklist sample %+v", s)
+	}
+	if s := samples[2]; s.Line != "bad line" || s.Rule != "invalid point" {
+		t.Errorf("Unexpected line sample %+v", s)
+	}
+}
+
+func TestBlockedSamplesRing(t *testing.T) {
+	newTestBlockedSampler(t, true)
+	for i := 0; i < maxBlockedSamples+5; i++ {
+		recordBlockedLine(strconv.Itoa(i), "invalid point")
+	}
+	samples := BlockedSamples()
+	if len(samples) != maxBlockedSamples {
+		t.Fatalf("Expected %d samples, found %d", maxBlockedSamples, len(samples))
+	}
+	for i, s := range samples {
+		if s.Line != strconv.Itoa(i+5) {
+			t.Fatalf("Expected sample %d to be line %d, found %q", i, i+5, s.Line)
+		}
+	}
+}
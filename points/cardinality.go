@@ -0,0 +1,184 @@
+package points
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/common"
+	"github.com/wavefronthq/go-proxy/logger"
+)
+
+const (
+	// series are counted per window, the cardinality of a metric is the larger count of the
+	// current and previous windows
+	cardinalityWindow = 10 * time.Minute
+
+	// interval at which the gauges of the metrics with the most series are updated
+	cardinalityReportInterval = time.Minute
+
+	// number of metrics with a cardinality gauge
+	topCardinalityMetrics = 10
+
+	// max series remembered across all metrics, new series past it are not counted
+	maxCardinalitySeries = 1000000
+)
+
+// Series of a metric seen in the current and previous windows.
+type metricSeries struct {
+	current  map[uint64]struct{}
+	previous map[uint64]struct{}
+	warned   bool  // exceeded the threshold in this window
+	dropped  int64 // new series dropped in this window
+}
+
+func (m *metricSeries) cardinality() int {
+	return max(len(m.current), len(m.previous))
+}
+
+// Counts the distinct series, the source and tag values, of each metric name. Metrics with
+// more series than the threshold are logged, and new series of metrics at the limit are
+// dropped when one is set. The metrics with the most series are reported as cardinality.<metric>
+// gauges.
+type CardinalityTracker struct {
+	mtx         sync.Mutex
+	threshold   int // logged above, disabled if 0
+	limit       int // new series dropped at, disabled if 0
+	metrics     map[string]*metricSeries
+	series      int // series remembered across metrics and windows
+	full        bool
+	windowStart time.Time
+	lastReport  time.Time
+	gauges      map[string]metrics.Gauge
+	dropped     metrics.Counter
+	now         func() time.Time
+}
+
+func NewCardinalityTracker(threshold, limit int) *CardinalityTracker {
+	now := time.Now()
+	return &CardinalityTracker{
+		threshold:   threshold,
+		limit:       limit,
+		metrics:     make(map[string]*metricSeries),
+		windowStart: now,
+		lastReport:  now,
+		gauges:      make(map[string]metrics.Gauge),
+		dropped:     metrics.GetOrRegisterCounter("cardinality.series.dropped", nil),
+		now:         time.Now,
+	}
+}
+
+func (t *CardinalityTracker) Process(point *common.Point) bool {
+	h := fnv.New64a()
+	h.Write([]byte(seriesKey(point)))
+	key := h.Sum64()
+	now := t.now()
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	t.refresh(now)
+	m, ok := t.metrics[point.Name]
+	if !ok {
+		m = &metricSeries{current: make(map[uint64]struct{})}
+		t.metrics[point.Name] = m
+	}
+	if _, ok := m.current[key]; ok {
+		return true
+	}
+	_, known := m.previous[key]
+	if !known && t.limit > 0 && m.cardinality() >= t.limit {
+		if m.dropped == 0 {
+			logger.Warnf("Metric %s reached the cardinality limit of %d series, dropping new series", point.Name, t.limit)
+		}
+		m.dropped++
+		t.dropped.Inc(1)
+		return false
+	}
+	if t.series >= maxCardinalitySeries {
+		if !t.full {
+			logger.Warnf("Cardinality tracker full at %d series, new series are not counted", maxCardinalitySeries)
+			t.full = true
+		}
+		return true
+	}
+	m.current[key] = struct{}{}
+	t.series++
+	if t.threshold > 0 && !m.warned && m.cardinality() > t.threshold {
+		logger.Warnf("Metric %s exceeded %d series", point.Name, t.threshold)
+		m.warned = true
+	}
+	return true
+}
+
+// Rotates the window and updates the gauges once due, also while no points are processed, so the
+// gauges do not keep the last cardinalities once the traffic stops.
+func (t *CardinalityTracker) tick() {
+	now := t.now()
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.refresh(now)
+}
+
+func (t *CardinalityTracker) refresh(now time.Time) {
+	if now.Sub(t.windowStart) >= cardinalityWindow {
+		t.rotate(now)
+	}
+	if now.Sub(t.lastReport) >= cardinalityReportInterval {
+		t.report(now)
+	}
+}
+
+// Starts a new window, forgetting the series of the previous one and metrics not seen since.
+func (t *CardinalityTracker) rotate(now time.Time) {
+	t.series = 0
+	for name, m := range t.metrics {
+		if len(m.current) == 0 {
+			delete(t.metrics, name)
+			continue
+		}
+		m.previous, m.current = m.current, make(map[uint64]struct{})
+		m.warned, m.dropped = false, 0
+		t.series += len(m.previous)
+	}
+	t.full = false
+	t.windowStart = now
+}
+
+// Updates the gauges of the metrics with the most series, removing those of other metrics.
+func (t *CardinalityTracker) report(now time.Time) {
+	t.lastReport = now
+	names := make([]string, 0, len(t.metrics))
+	for name := range t.metrics {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		ci, cj := t.metrics[names[i]].cardinality(), t.metrics[names[j]].cardinality()
+		return ci > cj || ci == cj && names[i] < names[j]
+	})
+	if len(names) > topCardinalityMetrics {
+		names = names[:topCardinalityMetrics]
+	}
+
+	top := make(map[string]metrics.Gauge, len(names))
+	for _, name := range names {
+		gauge := t.gauges[name]
+		if gauge == nil {
+			gauge = metrics.GetOrRegisterGauge(cardinalityGaugeName(name), nil)
+		}
+		gauge.Update(int64(t.metrics[name].cardinality()))
+		top[name] = gauge
+	}
+	for name := range t.gauges {
+		if _, ok := top[name]; !ok {
+			metrics.Unregister(cardinalityGaugeName(name))
+		}
+	}
+	t.gauges = top
+}
+
+func cardinalityGaugeName(metric string) string {
+	return "cardinality." + metric
+}
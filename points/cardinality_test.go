@@ -0,0 +1,104 @@
+package points
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/common"
+)
+
+func seriesPoint(name string, series int) *common.Point {
+	return &common.Point{Name: name, Source: "a", Tags: map[string]string{"id": strconv.Itoa(series)}}
+}
+
+func newTestTracker(threshold, limit int, now *time.Time) *CardinalityTracker {
+	tracker := NewCardinalityTracker(threshold, limit)
+	tracker.now = func() time.Time { return *now }
+	tracker.windowStart, tracker.lastReport = *now, *now
+	return tracker
+}
+
+func TestCardinalityLimit(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tracker := newTestTracker(2, 3, &now)
+
+	for i := 0; i < 5; i++ {
+		if kept := tracker.Process(seriesPoint("foo", i)); kept != (i < 3) {
+			t.Errorf("Expected series %d kept %t", i, i < 3)
+		}
+	}
+	// known series are kept at the limit, as are other metrics
+	if !tracker.Process(seriesPoint("foo", 1)) || !tracker.Process(seriesPoint("bar", 10)) {
+		t.Error("Expected known series and other metrics to be kept")
+	}
+
+	// series of the previous window are still known, new ones are dropped until it ends
+	now = now.Add(cardinalityWindow)
+	if !tracker.Process(seriesPoint("foo", 0)) || tracker.Process(seriesPoint("foo", 4)) {
+		t.Error("Expected the series of the previous window to count")
+	}
+	now = now.Add(cardinalityWindow)
+	if !tracker.Process(seriesPoint("foo", 4)) {
+		t.Error("Expected new series once the previous window ended")
+	}
+	if _, ok := tracker.metrics["bar"]; ok {
+		t.Error("Expected metrics not seen for a window to be forgotten")
+	}
+}
+
+func TestCardinalityGauges(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tracker := newTestTracker(0, 0, &now)
+
+	for m := 0; m <= topCardinalityMetrics; m++ {
+		for i := 0; i <= m; i++ {
+			tracker.Process(seriesPoint("metric"+strconv.Itoa(m), i))
+		}
+	}
+	now = now.Add(cardinalityReportInterval)
+	tracker.Process(seriesPoint("metric0", 0))
+
+	if gauge, ok := metrics.Get("cardinality.metric10").(metrics.Gauge); !ok || gauge.Value() != 11 {
+		t.Errorf("Expected a gauge of 11 series for the top metric, found %v", metrics.Get("cardinality.metric10"))
+	}
+	if metrics.Get("cardinality.metric0") != nil {
+		t.Error("Expected no gauge for metrics outside the top metrics")
+	}
+
+	// metrics falling out of the top metrics lose their gauges
+	for i := 0; i < 20; i++ {
+		tracker.Process(seriesPoint("metric0", i))
+	}
+	now = now.Add(cardinalityReportInterval)
+	tracker.Process(seriesPoint("metric0", 0))
+	if metrics.Get("cardinality.metric0") == nil || metrics.Get("cardinality.metric1") != nil {
+		t.Error("Expected the gauges to follow the top metrics")
+	}
+}
+
+func TestCardinalityTick(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tracker := newTestTracker(0, 0, &now)
+	for i := 0; i < 3; i++ {
+		tracker.Process(seriesPoint("idle", i))
+	}
+	defer metrics.Unregister("cardinality.idle")
+
+	// the gauges are updated without points once the traffic stops
+	now = now.Add(cardinalityReportInterval)
+	PreprocessorChain{tracker}.tick()
+	if gauge, ok := metrics.Get("cardinality.idle").(metrics.Gauge); !ok || gauge.Value() != 3 {
+		t.Errorf("Expected a gauge of 3 series, found %v", metrics.Get("cardinality.idle"))
+	}
+
+	// and removed once the metric is forgotten
+	now = now.Add(cardinalityWindow)
+	tracker.tick()
+	now = now.Add(cardinalityWindow)
+	tracker.tick()
+	if metrics.Get("cardinality.idle") != nil {
+		t.Error("Expected the gauge removed once the metric was not seen for a window")
+	}
+}
@@ -0,0 +1,113 @@
+package points
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	// sources tracked per listener, the connections of further sources are only counted
+	maxTrackedSources = 10000
+
+	// period the connections of each source are recorded over
+	sourceConnsInterval = time.Minute
+)
+
+// Tracks the connections of each source IP of a listener, recording the connections each source
+// had open during every interval, so a few sources with many connections can be told apart from
+// many sources with a few. Sources without connections for an interval are evicted. Intervals
+// end on a ticker of the listener, so the sources are swept while no connections open or close.
+type sourceConns struct {
+	mtx       sync.Mutex
+	sources   map[string]*sourceConnCount
+	max       int
+	perSource metrics.Histogram // connections of each source per interval
+	tracked   metrics.Gauge
+	untracked metrics.Counter // connections of sources not tracked as the limit was reached
+	ticker    *time.Ticker
+	done      chan struct{} // closed on stop
+}
+
+type sourceConnCount struct {
+	open int
+	seen int // connections open during the current interval
+}
+
+func newSourceConns(name string) *sourceConns {
+	return &sourceConns{
+		sources:   make(map[string]*sourceConnCount),
+		max:       maxTrackedSources,
+		perSource: metrics.GetOrRegisterHistogram("connections."+name+".per.source", nil, metrics.NewExpDecaySample(1028, 0.015)),
+		tracked:   metrics.GetOrRegisterGauge("connections."+name+".sources", nil),
+		untracked: metrics.GetOrRegisterCounter("connections."+name+".sources.untracked", nil),
+	}
+}
+
+// Starts sweeping the sources every interval.
+func (s *sourceConns) start() {
+	s.ticker = time.NewTicker(sourceConnsInterval)
+	s.done = make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-s.ticker.C:
+				s.sweep()
+			case <-s.done:
+				return
+			}
+		}
+	}()
+}
+
+func (s *sourceConns) stop() {
+	if s.ticker != nil {
+		s.ticker.Stop()
+		close(s.done)
+	}
+}
+
+// Counts a connection opened by the source, returning false if the source is not tracked.
+func (s *sourceConns) open(ip string) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	count, ok := s.sources[ip]
+	if !ok {
+		if len(s.sources) >= s.max {
+			s.untracked.Inc(1)
+			return false
+		}
+		count = &sourceConnCount{}
+		s.sources[ip] = count
+		s.tracked.Update(int64(len(s.sources)))
+	}
+	count.open++
+	count.seen++
+	return true
+}
+
+// Counts a connection of a tracked source closed.
+func (s *sourceConns) close(ip string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if count, ok := s.sources[ip]; ok && count.open > 0 {
+		count.open--
+	}
+}
+
+// Records the connections of the sources seen since the last sweep, and evicts the sources
+// without connections since then.
+func (s *sourceConns) sweep() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for ip, count := range s.sources {
+		if count.seen == 0 {
+			delete(s.sources, ip)
+			continue
+		}
+		s.perSource.Update(int64(count.seen))
+		count.seen = count.open
+	}
+	s.tracked.Update(int64(len(s.sources)))
+}
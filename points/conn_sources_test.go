@@ -0,0 +1,43 @@
+package points
+
+import (
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestSourceConns(t *testing.T) {
+	s := newSourceConns("test")
+	s.max = 2
+	s.perSource = metrics.NewHistogram(metrics.NewUniformSample(100))
+	s.tracked = metrics.NewGauge()
+	s.untracked = metrics.NewCounter()
+
+	if !s.open("10.0.0.1") || !s.open("10.0.0.1") || !s.open("10.0.0.2") {
+		t.Fatal("Expected the sources tracked")
+	}
+	// connections of further sources are only counted once the limit is reached
+	if s.open("10.0.0.3") || s.untracked.Count() != 1 || s.tracked.Value() != 2 {
+		t.Errorf("Expected 2 sources tracked and 1 untracked, found %d and %d", s.tracked.Value(), s.untracked.Count())
+	}
+	s.close("10.0.0.2")
+
+	// the connections of each source during the interval are recorded once it ends
+	s.sweep()
+	s.close("10.0.0.1")
+	if s.perSource.Count() != 2 || s.perSource.Max() != 2 || s.perSource.Min() != 1 {
+		t.Errorf("Expected 2 and 1 connections recorded, found %v", s.perSource.Sample().Values())
+	}
+
+	// sources without connections during an interval are evicted
+	s.sweep()
+	if !s.open("10.0.0.3") {
+		t.Error("Expected the source tracked once idle sources are evicted")
+	}
+	if _, ok := s.sources["10.0.0.2"]; ok || s.tracked.Value() != 2 {
+		t.Errorf("Expected the idle source evicted, found %d sources", s.tracked.Value())
+	}
+	if count := s.sources["10.0.0.1"]; count == nil || count.open != 1 {
+		t.Error("Expected the source with an open connection kept")
+	}
+}
@@ -0,0 +1,44 @@
+package decoder
+
+import (
+	"bytes"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/common"
+)
+
+var invalidAutoDetectLines = metrics.GetOrRegisterCounter("autodetect.lines.invalid", nil)
+
+// Builds decoders for ports receiving both Wavefront (graphite) and OpenTSDB telnet lines.
+type AutoDetectBuilder struct{}
+
+// Decodes lines starting with put as OpenTSDB and other lines as Wavefront, each counted
+// under the decode metrics of its format. Lines that fail to decode as the detected format
+// are also counted as autodetect.lines.invalid.
+type AutoDetectDecoder struct {
+	graphite PointDecoder
+	openTSDB PointDecoder
+}
+
+func (AutoDetectBuilder) Build() PointDecoder {
+	return &AutoDetectDecoder{
+		graphite: GraphiteBuilder{}.Build(),
+		openTSDB: OpenTSDBBuilder{}.Build(),
+	}
+}
+
+func (d *AutoDetectDecoder) Decode(b []byte) ([]*common.Point, error) {
+	decoder := d.graphite
+	if isOpenTSDBPut(b) {
+		decoder = d.openTSDB
+	}
+	points, err := decoder.Decode(b)
+	if err != nil {
+		invalidAutoDetectLines.Inc(1)
+	}
+	return points, err
+}
+
+func isOpenTSDBPut(b []byte) bool {
+	return len(b) > 3 && bytes.HasPrefix(b, []byte("put")) && (b[3] == ' ' || b[3] == '\t')
+}
@@ -0,0 +1,46 @@
+package decoder
+
+import (
+	"testing"
+)
+
+func TestAutoDetectDecode(t *testing.T) {
+	decoder := AutoDetectBuilder{}.Build()
+	graphite := graphiteCounters.successes.Count()
+	openTSDB := openTSDBCounters.successes.Count()
+	invalid := invalidAutoDetectLines.Count()
+
+	cases := []struct {
+		line, name, value string
+	}{
+		{"foo.bar 1 1505454047 source=a", "foo.bar", "1"},
+		{"put foo.bar 1505454047 2 host=a", "foo.bar", "2"},
+		{"put\tfoo.bar 1505454047 3 host=a", "foo.bar", "3"},
+		{"putter 4 1505454047 source=a", "putter", "4"},
+	}
+	for _, c := range cases {
+		points, err := decoder.Decode([]byte(c.line))
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", c.line, err)
+			continue
+		}
+		if len(points) != 1 || points[0].Name != c.name || points[0].Value != c.value || points[0].Source != "a" {
+			t.Errorf("Unexpected points %v for %q", points, c.line)
+		}
+	}
+	if n := graphiteCounters.successes.Count() - graphite; n != 2 {
+		t.Errorf("Expected 2 graphite lines, found %d", n)
+	}
+	if n := openTSDBCounters.successes.Count() - openTSDB; n != 2 {
+		t.Errorf("Expected 2 opentsdb lines, found %d", n)
+	}
+
+	for _, line := range []string{"put foo.bar 1 source=a", "foo.bar", "put"} {
+		if _, err := decoder.Decode([]byte(line)); err == nil {
+			t.Errorf("Expected an error for %q", line)
+		}
+	}
+	if n := invalidAutoDetectLines.Count() - invalid; n != 3 {
+		t.Errorf("Expected 3 invalid lines, found %d", n)
+	}
+}
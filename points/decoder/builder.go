@@ -13,17 +13,17 @@ type DecoderBuilder interface {
 	Build() PointDecoder
 }
 
-type GraphiteBuilder struct{}
-type OpenTSDBBuilder struct{}
-
-func (GraphiteBuilder) Build() PointDecoder {
-	decoder := &DefaultDecoder{}
-	decoder.parser = &parser.PointParser{Elements: graphiteElements}
-	return decoder
+// Builds decoders of Wavefront point lines. When Tagged, metric names may be Graphite tagged
+// series names, such as metric;tag1=v1;tag2=v2.
+type GraphiteBuilder struct {
+	Tagged bool
 }
 
-func (OpenTSDBBuilder) Build() PointDecoder {
-	decoder := &DefaultDecoder{}
-	decoder.parser = &parser.PointParser{Elements: openTSDBElements}
+func (b GraphiteBuilder) Build() PointDecoder {
+	decoder := &DefaultDecoder{counters: graphiteCounters}
+	decoder.parser = &parser.PointParser{Elements: graphiteElements}
+	if b.Tagged {
+		return &GraphiteTaggedDecoder{DefaultDecoder: *decoder}
+	}
 	return decoder
 }
@@ -0,0 +1,212 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/common"
+)
+
+// Part types of the collectd binary network protocol
+const (
+	collectdHost           = 0x0000
+	collectdTime           = 0x0001
+	collectdPlugin         = 0x0002
+	collectdPluginInstance = 0x0003
+	collectdType           = 0x0004
+	collectdTypeInstance   = 0x0005
+	collectdValues         = 0x0006
+	collectdInterval       = 0x0007
+	collectdTimeHR         = 0x0008
+	collectdIntervalHR     = 0x0009
+)
+
+// Data source types of the values in a values part
+const (
+	collectdCounter  = 0
+	collectdGauge    = 1
+	collectdDerive   = 2
+	collectdAbsolute = 3
+)
+
+// header of each part: the part type and the length of the part including the header
+const collectdHeaderLength = 4
+
+// tag of points whose plugin has an instance, e.g. the interface of the interface plugin
+const PluginInstanceTag = "plugin_instance"
+
+var (
+	ErrInvalidCollectd = errors.New("DecodeError: incorrect collectd packet")
+
+	collectdUnknownParts  = metrics.GetOrRegisterCounter("collectd.parts.unknown", nil)
+	collectdInvalidPoints = metrics.GetOrRegisterCounter("collectd.points.invalid", nil)
+)
+
+type CollectdBuilder struct{}
+
+// Decodes packets of the collectd network plugin. Parts set the host, time, plugin and type
+// of the values parts that follow them in the packet, as the protocol omits unchanged parts.
+type CollectdDecoder struct{}
+
+func (CollectdBuilder) Build() PointDecoder {
+	return &CollectdDecoder{}
+}
+
+func (d *CollectdDecoder) DecodesPackets() {}
+
+// Decodes the values parts of a packet into points named plugin.type[.type_instance] with
+// the host as source. Values parts with several values add the index of each value to the
+// name. Unknown parts, such as notifications and signatures, and values that do not make valid
+// points are skipped and counted, so they do not fail the other values of the packet.
+func (d *CollectdDecoder) Decode(b []byte) ([]*common.Point, error) {
+	points, err := d.decode(b)
+	return collectdCounters.count(b, points, err)
+}
+
+// values set by the parts preceding a values part
+type collectdState struct {
+	host, plugin, pluginInstance, typ, typeInstance string
+	timestamp                                       int64
+}
+
+func (d *CollectdDecoder) decode(b []byte) ([]*common.Point, error) {
+	if len(b) < collectdHeaderLength {
+		return nil, ErrInvalidCollectd
+	}
+
+	var state collectdState
+	var points []*common.Point
+	for len(b) > 0 {
+		if len(b) < collectdHeaderLength {
+			return nil, ErrInvalidCollectd
+		}
+		partType := binary.BigEndian.Uint16(b)
+		length := int(binary.BigEndian.Uint16(b[2:]))
+		if length < collectdHeaderLength || length > len(b) {
+			return nil, ErrInvalidCollectd
+		}
+		part := b[collectdHeaderLength:length]
+		b = b[length:]
+
+		var err error
+		switch partType {
+		case collectdHost:
+			state.host, err = collectdString(part)
+		case collectdPlugin:
+			state.plugin, err = collectdString(part)
+		case collectdPluginInstance:
+			state.pluginInstance, err = collectdString(part)
+		case collectdType:
+			state.typ, err = collectdString(part)
+		case collectdTypeInstance:
+			state.typeInstance, err = collectdString(part)
+		case collectdTime:
+			var seconds uint64
+			seconds, err = collectdNumber(part)
+			state.timestamp = int64(seconds)
+		case collectdTimeHR:
+			var hr uint64
+			hr, err = collectdNumber(part)
+			// in units of 2^-30 seconds
+			state.timestamp = int64(hr >> 30)
+		case collectdInterval, collectdIntervalHR:
+			// not needed for the points
+		case collectdValues:
+			var values []*common.Point
+			values, err = state.points(part)
+			points = append(points, values...)
+		default:
+			collectdUnknownParts.Inc(1)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return points, nil
+}
+
+// Decodes a values part: the number of values, a data source type per value, then the values,
+// gauges as little endian doubles and the other types as big endian integers.
+func (s *collectdState) points(part []byte) ([]*common.Point, error) {
+	if len(part) < 2 {
+		return nil, ErrInvalidCollectd
+	}
+	n := int(binary.BigEndian.Uint16(part))
+	if len(part) != 2+n*9 {
+		return nil, ErrInvalidCollectd
+	}
+	if s.plugin == "" || s.typ == "" {
+		return nil, ErrInvalidCollectd
+	}
+	types, values := part[2:2+n], part[2+n:]
+
+	name := s.plugin + "." + s.typ
+	if s.typeInstance != "" {
+		name += "." + s.typeInstance
+	}
+	timestamp := s.timestamp
+	if timestamp == 0 {
+		timestamp = time.Now().Unix()
+	}
+
+	points := make([]*common.Point, 0, n)
+	for i := 0; i < n; i++ {
+		raw := values[i*8 : i*8+8]
+		var value string
+		switch types[i] {
+		case collectdGauge:
+			gauge := math.Float64frombits(binary.LittleEndian.Uint64(raw))
+			if math.IsNaN(gauge) || math.IsInf(gauge, 0) {
+				// collectd sends NaN for gauges without a value
+				continue
+			}
+			value = strconv.FormatFloat(gauge, 'f', -1, 64)
+		case collectdDerive:
+			value = strconv.FormatInt(int64(binary.BigEndian.Uint64(raw)), 10)
+		case collectdCounter, collectdAbsolute:
+			value = strconv.FormatUint(binary.BigEndian.Uint64(raw), 10)
+		default:
+			return nil, ErrInvalidCollectd
+		}
+
+		point := &common.Point{
+			Name:      name,
+			Value:     value,
+			Timestamp: timestamp,
+			Source:    s.host,
+			Tags:      map[string]string{},
+		}
+		if n > 1 {
+			point.Name += "." + strconv.Itoa(i)
+		}
+		if s.pluginInstance != "" {
+			point.Tags[PluginInstanceTag] = s.pluginInstance
+		}
+		if err := validate(point); err != nil {
+			collectdInvalidPoints.Inc(1)
+			continue
+		}
+		points = append(points, point)
+	}
+	return points, nil
+}
+
+// Strings are null terminated.
+func collectdString(part []byte) (string, error) {
+	if len(part) == 0 || part[len(part)-1] != 0 {
+		return "", ErrInvalidCollectd
+	}
+	return string(bytes.TrimRight(part, "\x00")), nil
+}
+
+func collectdNumber(part []byte) (uint64, error) {
+	if len(part) != 8 {
+		return 0, ErrInvalidCollectd
+	}
+	return binary.BigEndian.Uint64(part), nil
+}
@@ -0,0 +1,121 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// Builds collectd packets part by part, each part returning a new packet.
+type collectdPacket []byte
+
+func (p collectdPacket) part(partType uint16, data []byte) collectdPacket {
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint16(header, partType)
+	binary.BigEndian.PutUint16(header[2:], uint16(4+len(data)))
+	packet := append(collectdPacket{}, p...)
+	return append(append(packet, header...), data...)
+}
+
+func (p collectdPacket) str(partType uint16, s string) collectdPacket {
+	return p.part(partType, append([]byte(s), 0))
+}
+
+func (p collectdPacket) number(partType uint16, n uint64) collectdPacket {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, n)
+	return p.part(partType, data)
+}
+
+// Adds a values part, the values are float64 for gauges and uint64 for the other types.
+func (p collectdPacket) values(types []byte, values ...interface{}) collectdPacket {
+	data := make([]byte, 2+len(types)+8*len(values))
+	binary.BigEndian.PutUint16(data, uint16(len(types)))
+	copy(data[2:], types)
+	for i, value := range values {
+		raw := data[2+len(types)+8*i:]
+		switch v := value.(type) {
+		case float64:
+			binary.LittleEndian.PutUint64(raw, math.Float64bits(v))
+		case uint64:
+			binary.BigEndian.PutUint64(raw, v)
+		}
+	}
+	return p.part(collectdValues, data)
+}
+
+func TestCollectdDecode(t *testing.T) {
+	packet := collectdPacket{}.
+		str(collectdHost, "web01").
+		number(collectdTimeHR, 1505454047<<30).
+		number(collectdIntervalHR, 10<<30).
+		str(collectdPlugin, "cpu").
+		str(collectdPluginInstance, "0").
+		str(collectdType, "cpu").
+		str(collectdTypeInstance, "idle").
+		values([]byte{collectdDerive}, uint64(12345)).
+		part(0x0100, []byte("notification\x00")).
+		str(collectdTypeInstance, "user").
+		values([]byte{collectdGauge}, 2.5).
+		str(collectdPlugin, "interface").
+		str(collectdPluginInstance, "").
+		str(collectdType, "if_octets").
+		str(collectdTypeInstance, "").
+		values([]byte{collectdCounter, collectdAbsolute}, uint64(100), uint64(200)).
+		values([]byte{collectdGauge}, math.NaN())
+
+	unknown := collectdUnknownParts.Count()
+	points, err := CollectdBuilder{}.Build().Decode(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		name, value, instance string
+	}{
+		{"cpu.cpu.idle", "12345", "0"},
+		{"cpu.cpu.user", "2.5", "0"},
+		{"interface.if_octets.0", "100", ""},
+		{"interface.if_octets.1", "200", ""},
+	}
+	if len(points) != len(expected) {
+		t.Fatalf("Expected %d points, found %d", len(expected), len(points))
+	}
+	for i, e := range expected {
+		p := points[i]
+		if p.Name != e.name || p.Value != e.value || p.Tags[PluginInstanceTag] != e.instance ||
+			p.Source != "web01" || p.Timestamp != 1505454047 {
+			t.Errorf("Unexpected point %+v, expected %s %s with instance %q", p, e.name, e.value, e.instance)
+		}
+	}
+	if n := collectdUnknownParts.Count() - unknown; n != 1 {
+		t.Errorf("Expected 1 unknown part counted, found %d", n)
+	}
+}
+
+func TestInvalidCollectdPackets(t *testing.T) {
+	valid := collectdPacket{}.str(collectdHost, "web01").str(collectdPlugin, "load").str(collectdType, "load")
+	invalid := map[string][]byte{
+		"empty":              {},
+		"truncated header":   {0, 0, 0},
+		"length past end":    collectdPacket{}.str(collectdHost, "web01")[:8],
+		"short length":       {0, 0, 0, 2},
+		"unterminated":       collectdPacket{}.part(collectdHost, []byte("web01")),
+		"bad time":           collectdPacket{}.part(collectdTime, []byte{1, 2}),
+		"no plugin":          collectdPacket{}.str(collectdHost, "web01").values([]byte{collectdGauge}, 1.0),
+		"value count":        valid.part(collectdValues, []byte{0, 2, collectdGauge}),
+		"unknown value type": valid.values([]byte{7}, uint64(1)),
+	}
+	decoder := CollectdBuilder{}.Build()
+	for name, packet := range invalid {
+		if _, err := decoder.Decode(packet); err == nil {
+			t.Errorf("Expected an error for the %s packet", name)
+		}
+	}
+
+	// values with invalid names are skipped
+	points, err := decoder.Decode(valid.str(collectdTypeInstance, "a b").values([]byte{collectdGauge}, 1.0).
+		str(collectdTypeInstance, "shortterm").values([]byte{collectdGauge}, 0.5))
+	if err != nil || len(points) != 1 || points[0].Name != "load.load.shortterm" {
+		t.Errorf("Expected the valid point only, found %v %v", points, err)
+	}
+}
@@ -0,0 +1,198 @@
+package decoder
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/common"
+)
+
+const (
+	CSVMetricColumn    = "metric"
+	CSVValueColumn     = "value"
+	CSVTimestampColumn = "timestamp"
+
+	DefaultCSVColumns = "metric,value,timestamp"
+)
+
+var (
+	ErrInvalidCSV       = errors.New("DecodeError: incorrect csv line format")
+	ErrInvalidCSVHeader = errors.New("DecodeError: csv header must name metric and value columns")
+
+	invalidCSVLines = metrics.GetOrRegisterCounter("csv.lines.invalid", nil)
+)
+
+// Builds decoders for delimited lines such as metric,value,timestamp,tag=value.
+// Columns names the field held by each column: metric, value, timestamp or a tag key,
+// such as source. Fields past the named columns hold key=value tags. With Header set,
+// the first line decoded names the columns instead.
+type CSVBuilder struct {
+	Delimiter rune
+	Columns   []string
+	Header    bool
+}
+
+// Parses the delimiter, a single character or "tab", and the comma separated column names.
+func NewCSVBuilder(delimiter, columns string, header bool) (*CSVBuilder, error) {
+	b := &CSVBuilder{Delimiter: ',', Header: header}
+	switch delimiter {
+	case "":
+	case "tab", `\t`:
+		b.Delimiter = '\t'
+	default:
+		r, size := utf8.DecodeRuneInString(delimiter)
+		if size != len(delimiter) || r == '"' || r == '\n' || r == '\r' {
+			return nil, fmt.Errorf("invalid csv delimiter %q, expected a single character or tab", delimiter)
+		}
+		b.Delimiter = r
+	}
+
+	if columns == "" {
+		columns = DefaultCSVColumns
+	}
+	b.Columns = parseCSVColumns(columns)
+	if !header && !validCSVColumns(b.Columns) {
+		return nil, fmt.Errorf("invalid csv columns %q, expected metric and value columns", columns)
+	}
+	return b, nil
+}
+
+func (b *CSVBuilder) Build() PointDecoder {
+	return &CSVDecoder{delimiter: b.Delimiter, columns: b.Columns, header: b.Header}
+}
+
+type CSVDecoder struct {
+	delimiter rune
+	columns   []string
+	header    bool // set until the header line is decoded
+}
+
+// Decodes a line, or reads the column names from the first line if the builder expects a header.
+// Invalid lines are counted.
+func (d *CSVDecoder) Decode(b []byte) ([]*common.Point, error) {
+	points, err := d.decode(b)
+	if err != nil {
+		invalidCSVLines.Inc(1)
+	}
+	return csvCounters.count(b, points, err)
+}
+
+func (d *CSVDecoder) decode(b []byte) ([]*common.Point, error) {
+	fields, err := d.split(b)
+	if err != nil {
+		return nil, err
+	}
+	if d.header {
+		columns := make([]string, len(fields))
+		for i, field := range fields {
+			columns[i] = strings.TrimSpace(field)
+		}
+		if !validCSVColumns(columns) {
+			return nil, ErrInvalidCSVHeader
+		}
+		d.columns, d.header = columns, false
+		return nil, nil
+	}
+
+	point := &common.Point{Tags: make(map[string]string)}
+	for i, field := range fields {
+		field = strings.TrimSpace(field)
+		if i >= len(d.columns) {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+				return nil, ErrInvalidCSV
+			}
+			point.Tags[kv[0]] = kv[1]
+			continue
+		}
+
+		switch column := d.columns[i]; column {
+		case CSVMetricColumn:
+			point.Name = field
+		case CSVValueColumn:
+			if _, err := strconv.ParseFloat(field, 64); err != nil {
+				return nil, ErrInvalidCSV
+			}
+			point.Value = field
+		case CSVTimestampColumn:
+			if field != "" {
+				if point.Timestamp, err = parseCSVTimestamp(field); err != nil {
+					return nil, err
+				}
+			}
+		case "":
+			// unnamed columns are ignored
+		default:
+			if field != "" {
+				point.Tags[column] = field
+			}
+		}
+	}
+	if point.Name == "" || point.Value == "" {
+		return nil, ErrInvalidCSV
+	}
+	if point.Timestamp == 0 {
+		point.Timestamp = time.Now().Unix()
+	}
+
+	err = handleSource(point)
+	if err != nil {
+		return nil, err
+	}
+	err = validate(point)
+	if err != nil {
+		return nil, err
+	}
+	return []*common.Point{point}, nil
+}
+
+// Splits a line into fields, fields may be double quoted.
+func (d *CSVDecoder) split(b []byte) ([]string, error) {
+	r := csv.NewReader(strings.NewReader(string(b)))
+	r.Comma = d.delimiter
+	r.FieldsPerRecord = -1
+	fields, err := r.Read()
+	if err != nil {
+		return nil, ErrInvalidCSV
+	}
+	return fields, nil
+}
+
+// Converts a timestamp in seconds, milliseconds, microseconds or nanoseconds to seconds.
+func parseCSVTimestamp(field string) (int64, error) {
+	ts, err := strconv.ParseInt(field, 10, 64)
+	switch {
+	case err != nil || ts <= 0:
+		return 0, ErrInvalidCSV
+	case ts >= 1e17:
+		return ts / 1e9, nil
+	case ts >= 1e14:
+		return ts / 1e6, nil
+	case ts >= 1e11:
+		return ts / 1e3, nil
+	}
+	return ts, nil
+}
+
+func parseCSVColumns(columns string) []string {
+	names := strings.Split(columns, ",")
+	for i, name := range names {
+		names[i] = strings.TrimSpace(name)
+	}
+	return names
+}
+
+func validCSVColumns(columns []string) bool {
+	metric, value := false, false
+	for _, column := range columns {
+		metric = metric || column == CSVMetricColumn
+		value = value || column == CSVValueColumn
+	}
+	return metric && value
+}
@@ -0,0 +1,101 @@
+package decoder
+
+import (
+	"testing"
+)
+
+func TestNewCSVBuilder(t *testing.T) {
+	if b, err := NewCSVBuilder("tab", "", false); err != nil || b.Delimiter != '\t' {
+		t.Errorf("Expected tab delimiter, found %q and %v", b.Delimiter, err)
+	}
+	invalid := []struct {
+		delimiter, columns string
+	}{
+		{",,", ""},
+		{`"`, ""},
+		{",", "metric,timestamp"},
+	}
+	for _, c := range invalid {
+		if _, err := NewCSVBuilder(c.delimiter, c.columns, false); err == nil {
+			t.Errorf("Expected error for delimiter %q and columns %q", c.delimiter, c.columns)
+		}
+	}
+	if _, err := NewCSVBuilder(",", "", true); err != nil {
+		t.Errorf("Unexpected error with header: %v", err)
+	}
+}
+
+func TestCSVDecode(t *testing.T) {
+	builder, err := NewCSVBuilder(",", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder := builder.Build()
+	points, err := decoder.Decode([]byte(`foo.metric,1.5,1505454047000,source=a,"path=/home, user"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	point := points[0]
+	if point.Name != "foo.metric" || point.Value != "1.5" || point.Timestamp != 1505454047 || point.Source != "a" {
+		t.Errorf("Unexpected point %+v", point)
+	}
+	if point.Tags["path"] != "/home, user" {
+		t.Errorf("Expected quoted tag, found %v", point.Tags)
+	}
+
+	invalid := []string{
+		"",
+		"foo.metric",
+		"foo.metric,abc,1505454047,source=a",
+		"foo.metric,1,abc,source=a",
+		"foo.metric,1,1505454047,source",
+		"foo.metric,1,1505454047",
+	}
+	before := invalidCSVLines.Count()
+	for _, line := range invalid {
+		if _, err := decoder.Decode([]byte(line)); err == nil {
+			t.Errorf("Error expected but not detected for line: %q", line)
+		}
+	}
+	if counted := invalidCSVLines.Count() - before; counted != int64(len(invalid)) {
+		t.Errorf("Expected %d invalid lines counted, found %d", len(invalid), counted)
+	}
+}
+
+func TestCSVColumns(t *testing.T) {
+	builder, err := NewCSVBuilder("\t", "timestamp,source,metric,,value,env", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	points, err := builder.Build().Decode([]byte("1505454047\thost-a\tfoo.metric\tignored\t2\tdev\tregion=us"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	point := points[0]
+	if point.Name != "foo.metric" || point.Value != "2" || point.Source != "host-a" ||
+		point.Tags["env"] != "dev" || point.Tags["region"] != "us" || len(point.Tags) != 2 {
+		t.Errorf("Unexpected point %+v", point)
+	}
+}
+
+func TestCSVHeader(t *testing.T) {
+	builder, err := NewCSVBuilder(",", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder := builder.Build()
+	if _, err := decoder.Decode([]byte("name,source")); err != ErrInvalidCSVHeader {
+		t.Errorf("Expected invalid header error, found %v", err)
+	}
+	points, err := decoder.Decode([]byte("value,metric,source"))
+	if err != nil || points != nil {
+		t.Fatalf("Expected header to be consumed, found %v and %v", points, err)
+	}
+	points, err = decoder.Decode([]byte("3,foo.metric,a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if points[0].Name != "foo.metric" || points[0].Value != "3" || points[0].Source != "a" {
+		t.Errorf("Unexpected point %+v", points[0])
+	}
+}
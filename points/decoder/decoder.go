@@ -12,33 +12,56 @@ var (
 	ErrInvalidPoint = errors.New("DecodeError: incorrect point format")
 )
 
-// Interface for decoding a point line
+// Interface for decoding a point line.
+// A single line may decode to zero (aggregated), one or more points.
 type PointDecoder interface {
-	Decode(b []byte) (*common.Point, error)
+	Decode(b []byte) ([]*common.Point, error)
+}
+
+// Interface for decoders of binary packets, which UDP listeners decode whole instead of
+// splitting them into lines.
+type PacketDecoder interface {
+	PointDecoder
+	DecodesPackets()
 }
 
 type DefaultDecoder struct {
-	parser *parser.PointParser
+	parser   *parser.PointParser
+	counters *decodeCounters
+}
+
+func (d *DefaultDecoder) Decode(b []byte) ([]*common.Point, error) {
+	points, err := d.decode(b)
+	return d.counters.count(b, points, err)
 }
 
-func (d *DefaultDecoder) Decode(b []byte) (*common.Point, error) {
+func (d *DefaultDecoder) decode(b []byte) ([]*common.Point, error) {
 	if b == nil {
-		return &common.Point{}, ErrInvalidPoint
+		return nil, ErrInvalidPoint
 	}
 
 	pointLine := string(b)
 	pointLine = strings.TrimSpace(pointLine)
 	if pointLine == "" {
-		return &common.Point{}, ErrInvalidPoint
+		return nil, ErrInvalidPoint
 	}
 
 	point, err := d.parser.Parse(b)
 	if err != nil {
-		return point, err
+		return nil, err
+	}
+	return checkPoint(point)
+}
+
+// Sets the source of a parsed point from its tags and validates it.
+func checkPoint(point *common.Point) ([]*common.Point, error) {
+	err := handleSource(point)
+	if err != nil {
+		return nil, err
 	}
-	err = handleSource(point)
+	err = validate(point)
 	if err != nil {
-		return point, err
+		return nil, err
 	}
-	return point, validate(point)
+	return []*common.Point{point}, nil
 }
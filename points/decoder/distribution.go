@@ -0,0 +1,59 @@
+package decoder
+
+import (
+	"strings"
+	"time"
+
+	"github.com/wavefronthq/go-proxy/common"
+	"github.com/wavefronthq/go-proxy/points/parser"
+)
+
+// Builds decoders for distributions computed by clients, which are forwarded as received
+// instead of aggregated, e.g.
+// !M 1493773500 #20 30.0 #10 5.1 request.latency source=app-1 region=us-west
+type DistributionBuilder struct{}
+
+type DistributionDecoder struct {
+	headerParser *parser.PointParser
+	now          func() time.Time
+}
+
+func (DistributionBuilder) Build() PointDecoder {
+	return &DistributionDecoder{
+		headerParser: &parser.PointParser{Elements: histogramElements},
+		now:          time.Now,
+	}
+}
+
+// Decodes a distribution line, plain points are rejected. Distributions without a
+// timestamp are given the current time.
+func (d *DistributionDecoder) Decode(b []byte) ([]*common.Point, error) {
+	points, err := d.decode(b)
+	return distributionCounters.count(b, points, err)
+}
+
+func (d *DistributionDecoder) decode(b []byte) ([]*common.Point, error) {
+	line := strings.TrimSpace(string(b))
+	if line == "" || line[0] != '!' {
+		return nil, ErrInvalidHistogram
+	}
+
+	point, centroids, err := parseDistribution(d.headerParser, line)
+	if err != nil {
+		return nil, err
+	}
+	err = handleSource(point)
+	if err != nil {
+		return nil, err
+	}
+	err = validate(point)
+	if err != nil {
+		return nil, err
+	}
+
+	if point.Timestamp == 0 {
+		point.Timestamp = d.now().Unix()
+	}
+	point.Histogram = &common.Histogram{Granularity: line[:2], Centroids: centroids}
+	return []*common.Point{point}, nil
+}
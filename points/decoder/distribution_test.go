@@ -0,0 +1,51 @@
+package decoder
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDistributionDecode(t *testing.T) {
+	pd := DistributionBuilder{}.Build()
+	points, err := pd.Decode([]byte("!H 1505451600 #20 30.0 #10 5.1 request.latency source=app-1 region=us-west"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 1 {
+		t.Fatalf("Expected 1 point, found %d", len(points))
+	}
+	point := points[0]
+	if point.Name != "request.latency" || point.Source != "app-1" || point.Tags["region"] != "us-west" ||
+		point.Timestamp != 1505451600 {
+		t.Errorf("Unexpected point %+v", point)
+	}
+	// centroids are forwarded in the order received, without aggregation
+	h := point.Histogram
+	if h == nil || h.Granularity != HistogramHour || len(h.Centroids) != 2 ||
+		h.Centroids[0].Value != 30 || h.Centroids[0].Count != 20 || h.Centroids[1].Value != 5.1 {
+		t.Errorf("Unexpected histogram %+v", h)
+	}
+}
+
+func TestDistributionTimestamp(t *testing.T) {
+	pd := DistributionBuilder{}.Build().(*DistributionDecoder)
+	pd.now = func() time.Time { return time.Unix(1505454047, 0) }
+	points, err := pd.Decode([]byte("!M #1 10 foo.latency source=a"))
+	if err != nil || len(points) != 1 || points[0].Timestamp != 1505454047 {
+		t.Errorf("Expected the current time, found %v %v", points, err)
+	}
+}
+
+func TestInvalidDistributionLines(t *testing.T) {
+	pd := DistributionBuilder{}.Build()
+	failures := distributionCounters.failures.Count()
+	lines := append(invalidHistogramLines[:], "foo.latency 10 source=a", "!M 1505454000 #1 10 foo latency")
+	for _, line := range lines {
+		if _, err := pd.Decode([]byte(line)); err == nil {
+			t.Errorf("Error expected but not detected for line: %q", line)
+		}
+	}
+	if n := distributionCounters.failures.Count() - failures; n != int64(len(lines)) {
+		t.Errorf("Expected %d failures, found %d", len(lines), n)
+	}
+}
@@ -0,0 +1,70 @@
+package decoder
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/wavefronthq/go-proxy/common"
+)
+
+// Decodes Wavefront point lines whose metric names may be Graphite tagged series names, e.g.
+//
+//	disk.used;host=web01;mount=%2Fvar 42 1505454047
+//
+// The tags of the name, with URL encoded values, become point tags and the name before them
+// the metric. They are added to the tags after the value, replacing any with the same key, and
+// the source is taken from the tags as for other lines. Lines without tagged names are decoded
+// as by the DefaultDecoder.
+type GraphiteTaggedDecoder struct {
+	DefaultDecoder
+}
+
+func (d *GraphiteTaggedDecoder) Decode(b []byte) ([]*common.Point, error) {
+	points, err := d.decodeTagged(b)
+	return d.counters.count(b, points, err)
+}
+
+func (d *GraphiteTaggedDecoder) decodeTagged(b []byte) ([]*common.Point, error) {
+	line := bytes.TrimSpace(b)
+	end := bytes.IndexAny(line, " \t")
+	if end < 0 || line[0] == '"' {
+		return d.decode(b)
+	}
+	i := bytes.IndexByte(line[:end], ';')
+	if i < 0 {
+		return d.decode(b)
+	}
+	// the base name with the rest of the line and the tags of the name quoted at the end, so
+	// they are parsed last
+	var buf bytes.Buffer
+	buf.Write(line[:i])
+	buf.Write(line[end:])
+	for _, pair := range strings.Split(string(line[i+1:end]), ";") {
+		k, v, err := parseGraphiteTag(pair)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buf, ` "%s"="%s"`, k, v)
+	}
+	point, err := d.parser.Parse(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return checkPoint(point)
+}
+
+// Parses a tag of a Graphite tagged series name, key=value with the value URL encoded. Values
+// with quotes are rejected as they cannot be quoted in a point line.
+func parseGraphiteTag(pair string) (string, string, error) {
+	k, v, ok := strings.Cut(pair, "=")
+	if !ok || k == "" || v == "" || strings.Contains(k, `"`) {
+		return "", "", fmt.Errorf("invalid graphite tag %q, expected key=value", pair)
+	}
+	value, err := url.PathUnescape(v)
+	if err != nil || strings.Contains(value, `"`) {
+		return "", "", fmt.Errorf("invalid graphite tag value %q", v)
+	}
+	return k, value, nil
+}
@@ -1,9 +1,64 @@
 package decoder
 
 import (
+	"reflect"
 	"testing"
 )
 
+func TestGraphiteTaggedNames(t *testing.T) {
+	cases := []struct {
+		line   string
+		name   string
+		source string
+		tags   map[string]string
+	}{
+		{"disk.used 42 1505454047 source=web01", "disk.used", "web01", nil},
+		{"\"disk.used\" 42 source=web01 \"mount\"=\"/var\"", "disk.used", "web01", map[string]string{"mount": "/var"}},
+		{"disk.used;mount=%2Fvar;host=web01 42 1505454047", "disk.used", "web01", map[string]string{"mount": "/var"}},
+		{"cpu.idle;host=web01 99.5", "cpu.idle", "web01", nil},
+		{"disk.used;mount=/var%20log 42 source=web01", "disk.used", "web01", map[string]string{"mount": "/var log"}},
+		// the tags of the name replace the tags after the value
+		{"disk.used;env=dev;source=a 42 source=b env=prod", "disk.used", "a", map[string]string{"env": "dev"}},
+	}
+	tagged := GraphiteBuilder{Tagged: true}.Build()
+	for _, c := range cases {
+		points, err := tagged.Decode([]byte(c.line))
+		if err != nil {
+			t.Errorf("Error decoding %q: %v", c.line, err)
+			continue
+		}
+		p := points[0]
+		tags := p.Tags
+		if len(tags) == 0 {
+			tags = nil
+		}
+		if p.Name != c.name || p.Source != c.source || !reflect.DeepEqual(tags, c.tags) {
+			t.Errorf("Expected %s source=%s %v for %q, found %s source=%s %v", c.name, c.source, c.tags, c.line, p.Name, p.Source, p.Tags)
+		}
+	}
+
+	for _, line := range []string{
+		"disk.used;mount 42 source=a",
+		"disk.used;=x 42 source=a",
+		"disk.used;mount=%zz 42 source=a",
+		"disk.used;mount=/var 42",
+		"disk.used;mount=%22var%22;host=a 42",
+	} {
+		if _, err := tagged.Decode([]byte(line)); err == nil {
+			t.Errorf("Expected an error decoding %q", line)
+		}
+	}
+
+	// tagged names are not parsed unless enabled
+	plain := GraphiteBuilder{}.Build()
+	if _, ok := plain.(*GraphiteTaggedDecoder); ok {
+		t.Error("Expected the default decoder without Tagged")
+	}
+	if points, err := plain.Decode([]byte("disk.used;host=web01 42 source=a")); err == nil && points[0].Name == "disk.used" {
+		t.Errorf("Expected the tagged name not parsed, found %s %v", points[0].Name, points[0].Tags)
+	}
+}
+
 func BenchmarkDecodeBase(b *testing.B) {
 	var builder DecoderBuilder = GraphiteBuilder{}
 	decoder := builder.Build()
@@ -0,0 +1,302 @@
+package decoder
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/common"
+	"github.com/wavefronthq/go-proxy/points/parser"
+)
+
+const (
+	HistogramMinute = "!M"
+	HistogramHour   = "!H"
+	HistogramDay    = "!D"
+
+	// centroids retained per distribution, nearby centroids are merged past this
+	maxCentroids = 100
+	// distributions retained per aggregator, new series are dropped past this
+	maxHistogramSeries = 100000
+)
+
+var (
+	ErrInvalidHistogram   = errors.New("DecodeError: incorrect histogram format")
+	ErrInvalidGranularity = errors.New("DecodeError: unsupported histogram granularity")
+	ErrTooManyHistograms  = errors.New("DecodeError: too many histogram distributions")
+
+	histogramElements = parser.NewHistogramElements()
+
+	histogramIntervals = map[string]int64{
+		HistogramMinute: 60,
+		HistogramHour:   3600,
+		HistogramDay:    86400,
+	}
+)
+
+// Aggregates point values into distributions per metric, source and tags over
+// minute, hour or day intervals.
+type HistogramAggregator struct {
+	granularity   string
+	interval      int64 // seconds
+	mtx           sync.Mutex
+	distributions map[string]*distribution
+	dropped       metrics.Counter
+	now           func() time.Time
+}
+
+type distribution struct {
+	point     *common.Point
+	centroids map[float64]int
+}
+
+type HistogramBuilder struct {
+	Aggregator *HistogramAggregator
+}
+
+type HistogramDecoder struct {
+	aggregator   *HistogramAggregator
+	pointParser  *parser.PointParser
+	headerParser *parser.PointParser
+}
+
+// Returns a builder aggregating points into distributions of the given granularity
+// (HistogramMinute, HistogramHour or HistogramDay).
+func NewHistogramBuilder(granularity string) (HistogramBuilder, error) {
+	interval, ok := histogramIntervals[granularity]
+	if !ok {
+		return HistogramBuilder{}, ErrInvalidGranularity
+	}
+	aggregator := &HistogramAggregator{
+		granularity:   granularity,
+		interval:      interval,
+		distributions: make(map[string]*distribution),
+		dropped:       metrics.GetOrRegisterCounter("histogram.points.dropped", nil),
+		now:           time.Now,
+	}
+	return HistogramBuilder{Aggregator: aggregator}, nil
+}
+
+func (b HistogramBuilder) Build() PointDecoder {
+	return &HistogramDecoder{
+		aggregator:   b.Aggregator,
+		pointParser:  &parser.PointParser{Elements: graphiteElements},
+		headerParser: &parser.PointParser{Elements: histogramElements},
+	}
+}
+
+func (b HistogramBuilder) Flush() []*common.Point {
+	return b.Aggregator.Flush()
+}
+
+func (b HistogramBuilder) FlushAll() []*common.Point {
+	return b.Aggregator.FlushAll()
+}
+
+// Decodes a Wavefront point or a histogram distribution line, e.g.
+// !M 1493773500 #20 30.0 #10 5.1 request.latency source=app-1 region=us-west
+// Values are aggregated and only emitted once their interval has ended.
+func (d *HistogramDecoder) Decode(b []byte) ([]*common.Point, error) {
+	points, err := d.decode(b)
+	return histogramCounters.count(b, points, err)
+}
+
+func (d *HistogramDecoder) decode(b []byte) ([]*common.Point, error) {
+	line := strings.TrimSpace(string(b))
+	if line == "" {
+		return nil, ErrInvalidPoint
+	}
+
+	var point *common.Point
+	var centroids []common.Centroid
+	var err error
+	if line[0] == '!' {
+		point, centroids, err = parseDistribution(d.headerParser, line)
+	} else {
+		point, err = d.pointParser.Parse([]byte(line))
+		if err == nil {
+			var value float64
+			value, err = strconv.ParseFloat(point.Value, 64)
+			centroids = []common.Centroid{{Value: value, Count: 1}}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = handleSource(point)
+	if err != nil {
+		return nil, err
+	}
+	err = validate(point)
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.aggregator.add(point, centroids)
+	if err != nil {
+		d.aggregator.dropped.Inc(1)
+		return nil, err
+	}
+	return nil, nil
+}
+
+// Parses a distribution line, the timestamp is 0 if not set.
+func parseDistribution(headerParser *parser.PointParser, line string) (*common.Point, []common.Centroid, error) {
+	fields := strings.Fields(line)
+	if _, ok := histogramIntervals[fields[0]]; !ok {
+		return nil, nil, ErrInvalidGranularity
+	}
+
+	var ts int64
+	i := 1
+	if i < len(fields) && !strings.HasPrefix(fields[i], "#") {
+		var err error
+		ts, err = strconv.ParseInt(fields[i], 10, 64)
+		if err != nil {
+			return nil, nil, ErrInvalidHistogram
+		}
+		i++
+	}
+
+	var centroids []common.Centroid
+	for ; i+1 < len(fields) && strings.HasPrefix(fields[i], "#"); i += 2 {
+		count, err := strconv.Atoi(fields[i][1:])
+		if err != nil || count <= 0 {
+			return nil, nil, ErrInvalidHistogram
+		}
+		value, err := strconv.ParseFloat(fields[i+1], 64)
+		if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+			return nil, nil, ErrInvalidHistogram
+		}
+		centroids = append(centroids, common.Centroid{Value: value, Count: count})
+	}
+	if len(centroids) == 0 || i >= len(fields) {
+		return nil, nil, ErrInvalidHistogram
+	}
+
+	point, err := headerParser.Parse([]byte(strings.Join(fields[i:], " ")))
+	if err != nil {
+		return nil, nil, err
+	}
+	point.Timestamp = ts
+	return point, centroids, nil
+}
+
+func (a *HistogramAggregator) add(point *common.Point, centroids []common.Centroid) error {
+	if point.Timestamp == 0 {
+		point.Timestamp = a.now().Unix()
+	}
+	point.Timestamp -= point.Timestamp % a.interval
+	key := histogramKey(point)
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	dist, ok := a.distributions[key]
+	if !ok {
+		if len(a.distributions) >= maxHistogramSeries {
+			return ErrTooManyHistograms
+		}
+		point.Value = ""
+		dist = &distribution{point: point, centroids: make(map[float64]int)}
+		a.distributions[key] = dist
+	}
+	for _, centroid := range centroids {
+		dist.centroids[centroid.Value] += centroid.Count
+	}
+	if len(dist.centroids) > maxCentroids {
+		dist.compress()
+	}
+	return nil
+}
+
+// Flush returns the distributions whose interval has ended and evicts them.
+func (a *HistogramAggregator) Flush() []*common.Point {
+	return a.flush(a.now().Unix())
+}
+
+// FlushAll returns all distributions, including those whose interval has not ended.
+func (a *HistogramAggregator) FlushAll() []*common.Point {
+	return a.flush(math.MaxInt64)
+}
+
+func (a *HistogramAggregator) flush(now int64) []*common.Point {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	var points []*common.Point
+	for key, dist := range a.distributions {
+		if now != math.MaxInt64 && dist.point.Timestamp+a.interval > now {
+			continue
+		}
+		delete(a.distributions, key)
+		dist.point.Histogram = &common.Histogram{Granularity: a.granularity, Centroids: dist.sortedCentroids()}
+		points = append(points, dist.point)
+	}
+	return points
+}
+
+func (d *distribution) sortedCentroids() []common.Centroid {
+	centroids := make([]common.Centroid, 0, len(d.centroids))
+	for value, count := range d.centroids {
+		centroids = append(centroids, common.Centroid{Value: value, Count: count})
+	}
+	sort.Slice(centroids, func(i, j int) bool {
+		return centroids[i].Value < centroids[j].Value
+	})
+	return centroids
+}
+
+// Merges the closest adjacent centroids until at most half of maxCentroids remain.
+func (d *distribution) compress() {
+	centroids := d.sortedCentroids()
+	for len(centroids) > maxCentroids/2 {
+		closest := 0
+		for i := 1; i < len(centroids)-1; i++ {
+			if centroids[i+1].Value-centroids[i].Value < centroids[closest+1].Value-centroids[closest].Value {
+				closest = i
+			}
+		}
+		left, right := centroids[closest], centroids[closest+1]
+		count := left.Count + right.Count
+		merged := common.Centroid{
+			Value: (left.Value*float64(left.Count) + right.Value*float64(right.Count)) / float64(count),
+			Count: count,
+		}
+		centroids = append(centroids[:closest], append([]common.Centroid{merged}, centroids[closest+2:]...)...)
+	}
+
+	d.centroids = make(map[float64]int, len(centroids))
+	for _, centroid := range centroids {
+		d.centroids[centroid.Value] += centroid.Count
+	}
+}
+
+// Returns a key identifying the distribution for a point's interval, metric, source and tags.
+func histogramKey(point *common.Point) string {
+	keys := make([]string, 0, len(point.Tags))
+	for k := range point.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf strings.Builder
+	buf.WriteString(strconv.FormatInt(point.Timestamp, 10))
+	buf.WriteByte(0)
+	buf.WriteString(point.Name)
+	buf.WriteByte(0)
+	buf.WriteString(point.Source)
+	for _, k := range keys {
+		buf.WriteByte(0)
+		buf.WriteString(k)
+		buf.WriteByte('=')
+		buf.WriteString(point.Tags[k])
+	}
+	return buf.String()
+}
@@ -0,0 +1,111 @@
+package decoder
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+var invalidHistogramLines = [...]string{
+	"",
+	"!X 1505454000 #1 10 foo.latency source=a",
+	"!M 1505454000 foo.latency source=a",
+	"!M 1505454000 #0 10 foo.latency source=a",
+	"!M 1505454000 #1 abc foo.latency source=a",
+	"!M 1505454000 #1 10",
+	"foo.latency abc source=a",
+	"foo.latency 10",
+}
+
+func TestInvalidHistogramLines(t *testing.T) {
+	builder, _ := NewHistogramBuilder(HistogramMinute)
+	decoder := builder.Build()
+	for _, line := range invalidHistogramLines {
+		if _, err := decoder.Decode([]byte(line)); err == nil {
+			t.Errorf("Error expected but not detected for line: %q", line)
+		}
+	}
+
+	if _, err := NewHistogramBuilder("!X"); err == nil {
+		t.Error("Error expected for invalid granularity")
+	}
+}
+
+func TestHistogramAggregation(t *testing.T) {
+	now := time.Unix(1505454000, 0)
+	builder, _ := NewHistogramBuilder(HistogramMinute)
+	builder.Aggregator.now = func() time.Time { return now }
+	decoder := builder.Build()
+
+	lines := []string{
+		"foo.latency 10 1505454001 source=a env=dev",
+		"foo.latency 20 1505454030 source=a env=dev",
+		"!M 1505454000 #2 10 #1 30 foo.latency source=a env=dev",
+		"foo.latency 5 1505454001 source=b env=dev",
+		"foo.latency 5 1505454061 source=a env=dev",
+	}
+	for _, line := range lines {
+		points, err := decoder.Decode([]byte(line))
+		if err != nil {
+			t.Fatal(line, err)
+		}
+		if len(points) != 0 {
+			t.Errorf("Expected no points before flush for line: %s", line)
+		}
+	}
+
+	// distributions are only flushed once their interval ends
+	if points := builder.Flush(); len(points) != 0 {
+		t.Errorf("Expected no points before the interval ends, found %d", len(points))
+	}
+
+	now = now.Add(time.Minute)
+	points := builder.Flush()
+	if len(points) != 2 {
+		t.Fatalf("Expected 2 distributions, found %d", len(points))
+	}
+	for _, point := range points {
+		if point.Timestamp != 1505454000 || point.Histogram.Granularity != HistogramMinute {
+			t.Errorf("Unexpected timestamp %d or granularity %s", point.Timestamp, point.Histogram.Granularity)
+		}
+		centroids := fmt.Sprint(point.Histogram.Centroids)
+		if point.Source == "a" && centroids != "[{10 3} {20 1} {30 1}]" {
+			t.Errorf("Unexpected centroids %s for source a", centroids)
+		}
+		if point.Source == "b" && centroids != "[{5 1}]" {
+			t.Errorf("Unexpected centroids %s for source b", centroids)
+		}
+	}
+
+	// flushed distributions are evicted, FlushAll includes the current interval
+	points = builder.FlushAll()
+	if len(points) != 1 || points[0].Timestamp != 1505454060 {
+		t.Errorf("Expected the current interval's distribution, found %v", points)
+	}
+}
+
+func TestHistogramCompression(t *testing.T) {
+	builder, _ := NewHistogramBuilder(HistogramHour)
+	decoder := builder.Build()
+	for i := 0; i < 1000; i++ {
+		if _, err := decoder.Decode([]byte(fmt.Sprintf("foo.latency %d source=a", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	points := builder.FlushAll()
+	if len(points) != 1 {
+		t.Fatalf("Expected 1 distribution, found %d", len(points))
+	}
+	centroids := points[0].Histogram.Centroids
+	if len(centroids) > maxCentroids {
+		t.Errorf("Expected at most %d centroids, found %d", maxCentroids, len(centroids))
+	}
+	count := 0
+	for _, centroid := range centroids {
+		count += centroid.Count
+	}
+	if count != 1000 {
+		t.Errorf("Expected a total count of 1000, found %d", count)
+	}
+}
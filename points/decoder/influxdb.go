@@ -0,0 +1,196 @@
+package decoder
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wavefronthq/go-proxy/common"
+)
+
+var (
+	ErrInvalidInfluxDB = errors.New("DecodeError: incorrect influxdb line format")
+	ErrNoNumericFields = errors.New("DecodeError: no numeric influxdb fields")
+)
+
+type InfluxDBBuilder struct{}
+
+type InfluxDBDecoder struct{}
+
+func (InfluxDBBuilder) Build() PointDecoder {
+	return &InfluxDBDecoder{}
+}
+
+// Decodes a measurement[,tag=val...] field=val[,field=val...] [timestamp] line.
+// Each numeric field is reported as a separate point named measurement.field,
+// string fields are ignored.
+func (d *InfluxDBDecoder) Decode(b []byte) ([]*common.Point, error) {
+	points, err := d.decode(b)
+	return influxDBCounters.count(b, points, err)
+}
+
+func (d *InfluxDBDecoder) decode(b []byte) ([]*common.Point, error) {
+	line := strings.TrimSpace(string(b))
+	if line == "" {
+		return nil, ErrInvalidPoint
+	}
+
+	sections := splitEscaped(line, ' ', true)
+	if len(sections) < 2 || len(sections) > 3 {
+		return nil, ErrInvalidInfluxDB
+	}
+
+	series := splitEscaped(sections[0], ',', false)
+	measurement := unescapeInflux(series[0])
+	if measurement == "" {
+		return nil, ErrInvalidInfluxDB
+	}
+	tags := make(map[string]string, len(series)-1)
+	for _, tag := range series[1:] {
+		kv := splitEscaped(tag, '=', false)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, ErrInvalidInfluxDB
+		}
+		tags[unescapeInflux(kv[0])] = unescapeInflux(kv[1])
+	}
+
+	ts := time.Now().Unix()
+	if len(sections) == 3 {
+		var err error
+		ts, err = parseInfluxTimestamp(sections[2])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var points []*common.Point
+	for _, field := range splitEscaped(sections[1], ',', true) {
+		kv := splitEscaped(field, '=', true)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, ErrInvalidInfluxDB
+		}
+		value, ok, err := parseInfluxValue(kv[1])
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		point := &common.Point{
+			Name:      measurement + "." + unescapeInflux(kv[0]),
+			Value:     value,
+			Timestamp: ts,
+			Tags:      make(map[string]string, len(tags)),
+		}
+		for k, v := range tags {
+			point.Tags[k] = v
+		}
+		err = handleSource(point)
+		if err != nil {
+			return nil, err
+		}
+		err = validate(point)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, point)
+	}
+	if len(points) == 0 {
+		return nil, ErrNoNumericFields
+	}
+	return points, nil
+}
+
+// Splits s on sep, ignoring escaped separators and, if quoted is set, separators within double quotes.
+// Consecutive separators are treated as one.
+func splitEscaped(s string, sep byte, quoted bool) []string {
+	var parts []string
+	start, inQuotes := 0, false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case quoted && s[i] == '"':
+			inQuotes = !inQuotes
+		case s[i] == sep && !inQuotes:
+			if i > start || sep != ' ' {
+				parts = append(parts, s[start:i])
+			}
+			start = i + 1
+		}
+	}
+	if start < len(s) || sep != ' ' {
+		parts = append(parts, s[start:])
+	}
+	return parts
+}
+
+func unescapeInflux(s string) string {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s
+	}
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case ',', '=', ' ', '"', '\\':
+				i++
+			}
+		}
+		buf.WriteByte(s[i])
+	}
+	return buf.String()
+}
+
+// Returns the Wavefront value for a field, or false if the field is not numeric.
+func parseInfluxValue(raw string) (string, bool, error) {
+	switch raw {
+	case "t", "T", "true", "True", "TRUE":
+		return "1", true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return "0", true, nil
+	}
+	if raw[0] == '"' {
+		return "", false, nil
+	}
+
+	last := raw[len(raw)-1]
+	if last == 'i' || last == 'u' {
+		_, err := strconv.ParseInt(raw[:len(raw)-1], 10, 64)
+		if err != nil && last == 'u' {
+			_, err = strconv.ParseUint(raw[:len(raw)-1], 10, 64)
+		}
+		if err != nil {
+			return "", false, ErrInvalidInfluxDB
+		}
+		return raw[:len(raw)-1], true, nil
+	}
+
+	_, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return "", false, ErrInvalidInfluxDB
+	}
+	return raw, true, nil
+}
+
+// Converts a timestamp in nanoseconds (the line protocol default), microseconds,
+// milliseconds or seconds to seconds.
+func parseInfluxTimestamp(raw string) (int64, error) {
+	ts, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || ts < 0 {
+		return 0, ErrInvalidInfluxDB
+	}
+	switch len(raw) {
+	case 19:
+		return ts / 1e9, nil
+	case 16:
+		return ts / 1e6, nil
+	case 13:
+		return ts / 1e3, nil
+	case 10:
+		return ts, nil
+	}
+	return 0, ErrInvalidInfluxDB
+}
@@ -0,0 +1,67 @@
+package decoder
+
+import (
+	"testing"
+)
+
+var invalidInfluxDBLines = [...]string{
+	"",
+	"cpu",
+	"cpu,host=foo",
+	"cpu,host=foo usage=",
+	"cpu,host=foo usage=abc",
+	"cpu,host usage=1",
+	"cpu,host=foo usage=1 abc",
+	"cpu,host=foo usage=1 1505454047 extra",
+	"cpu,host=foo msg=\"no numeric fields\"",
+	"cpu,region=us usage=1",
+}
+
+func TestInvalidInfluxDBLines(t *testing.T) {
+	decoder := InfluxDBBuilder{}.Build()
+	for _, line := range invalidInfluxDBLines {
+		if _, err := decoder.Decode([]byte(line)); err == nil {
+			t.Errorf("Error expected but not detected for line: %q", line)
+		}
+	}
+}
+
+func TestInfluxDBDecode(t *testing.T) {
+	decoder := InfluxDBBuilder{}.Build()
+	line := `disk\,io,host=foo,region=us\ west\,2 usage_idle=90.5,usage_user=2i,up=true,msg="a b, c=d" 1505454047000000000`
+	points, err := decoder.Decode([]byte(line))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"disk,io.usage_idle": "90.5",
+		"disk,io.usage_user": "2",
+		"disk,io.up":         "1",
+	}
+	if len(points) != len(expected) {
+		t.Fatalf("Expected %d points, found %d", len(expected), len(points))
+	}
+	for _, point := range points {
+		if value, ok := expected[point.Name]; !ok || value != point.Value {
+			t.Errorf("Unexpected value %s for %s", point.Value, point.Name)
+		}
+		if point.Source != "foo" || point.Timestamp != 1505454047 {
+			t.Errorf("Unexpected source %s or timestamp %d", point.Source, point.Timestamp)
+		}
+		if len(point.Tags) != 1 || point.Tags["region"] != "us west,2" {
+			t.Errorf("Unexpected tags %v", point.Tags)
+		}
+	}
+}
+
+func TestInfluxDBDefaultTimestamp(t *testing.T) {
+	decoder := InfluxDBBuilder{}.Build()
+	points, err := decoder.Decode([]byte("mem,host=foo used=10"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 1 || points[0].Timestamp == 0 {
+		t.Errorf("Expected a point with the current timestamp")
+	}
+}
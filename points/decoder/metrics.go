@@ -0,0 +1,75 @@
+package decoder
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/common"
+	"github.com/wavefronthq/go-proxy/logger"
+)
+
+const (
+	// max bytes of a failed line included in the debug log
+	maxSampleLength = 256
+	// min time between logging failed lines of a format
+	sampleInterval = 10 * time.Second
+)
+
+// Lines decoded and failed by each format, e.g. decode.failures.graphite
+var (
+	graphiteCounters     = newDecodeCounters("graphite")
+	openTSDBCounters     = newDecodeCounters("opentsdb")
+	influxDBCounters     = newDecodeCounters("influxdb")
+	statsDCounters       = newDecodeCounters("statsd")
+	histogramCounters    = newDecodeCounters("histogram")
+	distributionCounters = newDecodeCounters("distribution")
+	csvCounters          = newDecodeCounters("csv")
+	collectdCounters     = newDecodeCounters("collectd")
+	promWriteCounters    = newDecodeCounters("prometheus")
+)
+
+type decodeCounters struct {
+	format     string
+	successes  metrics.Counter
+	failures   metrics.Counter
+	lastSample int64 // unix time a failed line was last logged
+	now        func() time.Time
+}
+
+func newDecodeCounters(format string) *decodeCounters {
+	return &decodeCounters{
+		format:    format,
+		successes: metrics.GetOrRegisterCounter("decode.successes."+format, nil),
+		failures:  metrics.GetOrRegisterCounter("decode.failures."+format, nil),
+		now:       time.Now,
+	}
+}
+
+// Counts the result of decoding a line and returns it unchanged. A failed line is
+// logged at debug level at most once per sampleInterval per format.
+func (c *decodeCounters) count(b []byte, points []*common.Point, err error) ([]*common.Point, error) {
+	if c == nil {
+		return points, err
+	}
+	if err == nil {
+		c.successes.Inc(1)
+		return points, nil
+	}
+	c.failures.Inc(1)
+
+	if logger.Enabled(logger.DebugLevel) {
+		now, last := c.now().Unix(), atomic.LoadInt64(&c.lastSample)
+		if now-last >= int64(sampleInterval/time.Second) && atomic.CompareAndSwapInt64(&c.lastSample, last, now) {
+			logger.Debugf("Failed to decode %s line %q: %v (%d failures)", c.format, truncateLine(b), err, c.failures.Count())
+		}
+	}
+	return points, err
+}
+
+func truncateLine(b []byte) string {
+	if len(b) > maxSampleLength {
+		return string(b[:maxSampleLength]) + "..."
+	}
+	return string(b)
+}
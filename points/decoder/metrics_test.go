@@ -0,0 +1,54 @@
+package decoder
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wavefronthq/go-proxy/logger"
+)
+
+func TestDecodeCounters(t *testing.T) {
+	successes, failures := graphiteCounters.successes.Count(), graphiteCounters.failures.Count()
+	pd := GraphiteBuilder{}.Build()
+	pd.Decode([]byte("foo.metric 1 source=a"))
+	pd.Decode([]byte("foo.metric source=a"))
+	pd.Decode([]byte("foo.metric"))
+
+	if n := graphiteCounters.successes.Count() - successes; n != 1 {
+		t.Errorf("Expected 1 success, found %d", n)
+	}
+	if n := graphiteCounters.failures.Count() - failures; n != 2 {
+		t.Errorf("Expected 2 failures, found %d", n)
+	}
+}
+
+func TestDecodeFailureSamples(t *testing.T) {
+	var buf bytes.Buffer
+	logger.SetOutput(&buf)
+	logger.SetLevel(logger.DebugLevel)
+	defer func() {
+		logger.SetOutput(os.Stderr)
+		logger.SetLevel(logger.InfoLevel)
+	}()
+
+	now := time.Unix(1500000000, 0)
+	c := newDecodeCounters("test")
+	c.now = func() time.Time { return now }
+
+	long := []byte(strings.Repeat("x", 2*maxSampleLength))
+	c.count(long, nil, ErrInvalidPoint)
+	c.count([]byte("second"), nil, ErrInvalidPoint)
+	if strings.Count(buf.String(), "Failed to decode") != 1 || strings.Contains(buf.String(), string(long)) {
+		t.Errorf("Expected a single truncated sample, found %q", buf.String())
+	}
+
+	now = now.Add(sampleInterval)
+	buf.Reset()
+	c.count([]byte("third"), nil, ErrInvalidPoint)
+	if !strings.Contains(buf.String(), "third") || !strings.Contains(buf.String(), "(3 failures)") {
+		t.Errorf("Expected a sample after the interval, found %q", buf.String())
+	}
+}
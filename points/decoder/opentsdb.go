@@ -0,0 +1,134 @@
+package decoder
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/common"
+	"github.com/wavefronthq/go-proxy/points/parser"
+)
+
+var (
+	unknownOpenTSDBCommands = metrics.GetOrRegisterCounter("opentsdb.commands.unknown", nil)
+	strippedOpenTSDBTags    = metrics.GetOrRegisterCounter("opentsdb.tags.stripped", nil)
+	renamedOpenTSDBTags     = metrics.GetOrRegisterCounter("opentsdb.tags.renamed", nil)
+)
+
+// The reply of OpenTSDB to a put while its writes are throttled, returned to OpenTSDB clients while
+// the proxy pauses reading from their connections.
+const OpenTSDBThrottleReply = "put: Please throttle writes: the proxy buffer is full\n"
+
+// Implemented by decoders for protocols that mix commands with point lines on a connection.
+type CommandHandler interface {
+	// Handles a command line, returning false for point lines which should be decoded.
+	// A non empty reply is written back to the client, and the connection is closed if close is set.
+	HandleCommand(b []byte) (reply string, handled, close bool)
+}
+
+// Builds OpenTSDB telnet decoders. Version is reported in reply to the version command.
+// StripTags holds the OpenTSDB specific tags removed from the points, such as _aggregate,
+// each renamed to its value instead if not empty.
+type OpenTSDBBuilder struct {
+	Version   string
+	StripTags map[string]string
+}
+
+type OpenTSDBDecoder struct {
+	DefaultDecoder
+	version   string
+	stripTags map[string]string
+}
+
+func (b OpenTSDBBuilder) Build() PointDecoder {
+	decoder := &OpenTSDBDecoder{version: b.Version, stripTags: b.StripTags}
+	decoder.parser = &parser.PointParser{Elements: openTSDBElements}
+	decoder.counters = openTSDBCounters
+	return decoder
+}
+
+// Parses a comma-separated list of the tag keys stripped from OpenTSDB points, each renamed
+// instead when given as key=newKey, e.g. _aggregate,_downsample=downsample.
+func ParseOpenTSDBStripTags(list string) (map[string]string, error) {
+	var tags map[string]string
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, newKey, rename := strings.Cut(entry, "=")
+		key, newKey = strings.TrimSpace(key), strings.TrimSpace(newKey)
+		if key == "" || validateRunes(key) != nil {
+			return nil, fmt.Errorf("invalid opentsdb tag key %q to strip", key)
+		}
+		if rename && (newKey == "" || validateRunes(newKey) != nil) {
+			return nil, fmt.Errorf("invalid opentsdb tag key %q to rename %s to", newKey, key)
+		}
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		tags[key] = newKey
+	}
+	return tags, nil
+}
+
+func (d *OpenTSDBDecoder) Decode(b []byte) ([]*common.Point, error) {
+	if len(d.stripTags) == 0 {
+		return d.DefaultDecoder.Decode(b)
+	}
+	points, err := d.decodeStripped(b)
+	return d.counters.count(b, points, err)
+}
+
+// Strips the tags before the source is set and the point validated, so renamed tags are
+// validated and a stripped host tag is not taken as the source.
+func (d *OpenTSDBDecoder) decodeStripped(b []byte) ([]*common.Point, error) {
+	if len(bytes.TrimSpace(b)) == 0 {
+		return nil, ErrInvalidPoint
+	}
+	point, err := d.parser.Parse(b)
+	if err != nil {
+		return nil, err
+	}
+	for key, newKey := range d.stripTags {
+		value, ok := point.Tags[key]
+		if !ok {
+			continue
+		}
+		delete(point.Tags, key)
+		// a tag already set under the new key is kept
+		if _, exists := point.Tags[newKey]; newKey != "" && !exists {
+			point.Tags[newKey] = value
+			renamedOpenTSDBTags.Inc(1)
+			continue
+		}
+		strippedOpenTSDBTags.Inc(1)
+	}
+	return checkPoint(point)
+}
+
+// Replies to version, closes the connection on exit and diediedie, accepts the other OpenTSDB
+// commands without a reply, and counts and drops unknown commands.
+func (d *OpenTSDBDecoder) HandleCommand(b []byte) (string, bool, bool) {
+	b = bytes.TrimSpace(b)
+	command := b
+	if i := bytes.IndexAny(b, " \t"); i >= 0 {
+		command = b[:i]
+	}
+
+	switch string(command) {
+	case "put", "":
+		return "", false, false
+	case "version":
+		return "Wavefront Proxy version " + d.version + "\n", true, false
+	case "exit", "diediedie":
+		// only the connection is closed, not the proxy
+		return "", true, true
+	case "help", "stats", "dropcaches":
+		return "", true, false
+	default:
+		unknownOpenTSDBCommands.Inc(1)
+		return "", true, false
+	}
+}
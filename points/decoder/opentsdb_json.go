@@ -0,0 +1,117 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/wavefronthq/go-proxy/common"
+)
+
+var (
+	ErrInvalidPutBody = errors.New("expected a data point object or an array of data points")
+	ErrMissingMetric  = errors.New("missing metric")
+)
+
+// A data point of an OpenTSDB HTTP /api/put request, e.g.
+// {"metric": "sys.cpu.nice", "timestamp": 1346846400, "value": 18, "tags": {"host": "web01"}}
+type openTSDBDataPoint struct {
+	Metric    string            `json:"metric"`
+	Timestamp json.Number       `json:"timestamp"`
+	Value     json.Number       `json:"value"`
+	Tags      map[string]string `json:"tags"`
+}
+
+// Builds decoders for the data points of OpenTSDB HTTP /api/put requests.
+type OpenTSDBJSONBuilder struct{}
+
+type OpenTSDBJSONDecoder struct {
+	now func() time.Time
+}
+
+func (OpenTSDBJSONBuilder) Build() PointDecoder {
+	return &OpenTSDBJSONDecoder{now: time.Now}
+}
+
+// Splits the body of an /api/put request, a single data point object or an array of them,
+// into the data points to decode.
+func SplitOpenTSDBPut(body []byte) ([]json.RawMessage, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil, ErrInvalidPutBody
+	}
+	switch body[0] {
+	case '{':
+		return []json.RawMessage{body}, nil
+	case '[':
+		var dataPoints []json.RawMessage
+		if err := json.Unmarshal(body, &dataPoints); err != nil {
+			return nil, err
+		}
+		return dataPoints, nil
+	default:
+		return nil, ErrInvalidPutBody
+	}
+}
+
+// Decodes a JSON data point. Timestamps in seconds or milliseconds are accepted, data points
+// without a timestamp are given the current time. The host or source tag is the source.
+func (d *OpenTSDBJSONDecoder) Decode(b []byte) ([]*common.Point, error) {
+	points, err := d.decode(b)
+	return openTSDBCounters.count(b, points, err)
+}
+
+func (d *OpenTSDBJSONDecoder) decode(b []byte) ([]*common.Point, error) {
+	var dp openTSDBDataPoint
+	if err := json.Unmarshal(b, &dp); err != nil {
+		return nil, err
+	}
+	if dp.Metric == "" {
+		return nil, ErrMissingMetric
+	}
+	if _, err := dp.Value.Float64(); err != nil {
+		return nil, fmt.Errorf("invalid metric value %q", dp.Value)
+	}
+
+	point := &common.Point{Name: dp.Metric, Value: dp.Value.String(), Tags: dp.Tags}
+	if point.Tags == nil {
+		point.Tags = make(map[string]string)
+	}
+	timestamp, err := d.timestamp(dp.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	point.Timestamp = timestamp
+
+	err = handleSource(point)
+	if err != nil {
+		return nil, err
+	}
+	err = validate(point)
+	if err != nil {
+		return nil, err
+	}
+	return []*common.Point{point}, nil
+}
+
+// Returns the timestamp in seconds, OpenTSDB treats timestamps that do not fit in 32 bits
+// as milliseconds.
+func (d *OpenTSDBJSONDecoder) timestamp(n json.Number) (int64, error) {
+	if n == "" {
+		return d.now().Unix(), nil
+	}
+	ts, err := n.Int64()
+	if err != nil || ts < 0 {
+		return 0, fmt.Errorf("invalid timestamp %q", n)
+	}
+	if ts == 0 {
+		return d.now().Unix(), nil
+	}
+	if ts > math.MaxUint32 {
+		ts /= 1000
+	}
+	return ts, nil
+}
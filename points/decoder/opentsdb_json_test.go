@@ -0,0 +1,63 @@
+package decoder
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSplitOpenTSDBPut(t *testing.T) {
+	dataPoints, err := SplitOpenTSDBPut([]byte(` {"metric": "a", "value": 1} `))
+	if err != nil || len(dataPoints) != 1 {
+		t.Errorf("Expected 1 data point, found %d: %v", len(dataPoints), err)
+	}
+	dataPoints, err = SplitOpenTSDBPut([]byte(`[{"metric": "a", "value": 1}, {"metric": "b", "value": 2}]`))
+	if err != nil || len(dataPoints) != 2 {
+		t.Errorf("Expected 2 data points, found %d: %v", len(dataPoints), err)
+	}
+	for _, body := range []string{"", "foo 1 host=a", `[{"metric": "a"}`} {
+		if _, err := SplitOpenTSDBPut([]byte(body)); err == nil {
+			t.Errorf("Expected error for %q", body)
+		}
+	}
+}
+
+func TestOpenTSDBJSONDecoder(t *testing.T) {
+	d := OpenTSDBJSONBuilder{}.Build().(*OpenTSDBJSONDecoder)
+	d.now = func() time.Time { return time.Unix(1500000000, 0) }
+
+	cases := []struct {
+		dataPoint string
+		timestamp int64
+		value     string
+	}{
+		{`{"metric": "sys.cpu.nice", "timestamp": 1346846400, "value": 18, "tags": {"host": "web01", "dc": "lga"}}`, 1346846400, "18"},
+		{`{"metric": "sys.cpu.nice", "timestamp": 1346846400500, "value": 1.5, "tags": {"host": "web01", "dc": "lga"}}`, 1346846400, "1.5"},
+		{`{"metric": "sys.cpu.nice", "value": "-2", "tags": {"source": "web01", "dc": "lga"}}`, 1500000000, "-2"},
+	}
+	for _, c := range cases {
+		points, err := d.Decode([]byte(c.dataPoint))
+		if err != nil {
+			t.Errorf("Error decoding %s: %v", c.dataPoint, err)
+			continue
+		}
+		p := points[0]
+		if p.Name != "sys.cpu.nice" || p.Source != "web01" || p.Timestamp != c.timestamp || p.Value != c.value ||
+			len(p.Tags) != 1 || p.Tags["dc"] != "lga" {
+			t.Errorf("Unexpected point decoded from %s: %+v", c.dataPoint, p)
+		}
+	}
+
+	invalid := []string{
+		`{"timestamp": 1346846400, "value": 18, "tags": {"host": "web01"}}`,
+		`{"metric": "sys.cpu.nice", "timestamp": 1346846400, "value": "high", "tags": {"host": "web01"}}`,
+		`{"metric": "sys.cpu.nice", "timestamp": 1346846400, "value": 18}`,
+		`{"metric": "sys.cpu.nice", "timestamp": -1, "value": 18, "tags": {"host": "web01"}}`,
+		`{"metric": "sys cpu", "timestamp": 1346846400, "value": 18, "tags": {"host": "web01"}}`,
+		`{"metric": "sys.cpu.nice", "value": 18, "tags": {"host": 1}}`,
+	}
+	for _, dp := range invalid {
+		if _, err := d.Decode([]byte(dp)); err == nil {
+			t.Errorf("Expected error for %s", dp)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package decoder
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOpenTSDBCommands(t *testing.T) {
+	decoder := OpenTSDBBuilder{Version: "1.2"}.Build().(*OpenTSDBDecoder)
+	unknown := unknownOpenTSDBCommands.Count()
+
+	cases := []struct {
+		line    string
+		reply   string
+		handled bool
+		close   bool
+	}{
+		{"put foo.bar 1505454047 1 host=a", "", false, false},
+		{"version", "Wavefront Proxy version 1.2\n", true, false},
+		{" version \r", "Wavefront Proxy version 1.2\n", true, false},
+		{"stats", "", true, false},
+		{"exit", "", true, true},
+		{"diediedie", "", true, true},
+		{"rollup foo", "", true, false},
+		{"", "", false, false},
+	}
+	for _, c := range cases {
+		reply, handled, close := decoder.HandleCommand([]byte(c.line))
+		if reply != c.reply || handled != c.handled || close != c.close {
+			t.Errorf("Expected %q %v %v for %q, found %q %v %v", c.reply, c.handled, c.close, c.line, reply, handled, close)
+		}
+	}
+	if count := unknownOpenTSDBCommands.Count() - unknown; count != 1 {
+		t.Errorf("Expected 1 unknown command, found %d", count)
+	}
+}
+
+func TestParseOpenTSDBStripTags(t *testing.T) {
+	tags, err := ParseOpenTSDBStripTags(" _aggregate, _downsample = downsample ,")
+	if err != nil || len(tags) != 2 || tags["_aggregate"] != "" || tags["_downsample"] != "downsample" {
+		t.Errorf("Unexpected tags %v: %v", tags, err)
+	}
+	if tags, err := ParseOpenTSDBStripTags(""); err != nil || tags != nil {
+		t.Errorf("Expected no tags, found %v: %v", tags, err)
+	}
+	for _, list := range []string{"_aggregate,=dc", "_downsample=", "_aggregate=agg regate", "a b"} {
+		if _, err := ParseOpenTSDBStripTags(list); err == nil {
+			t.Errorf("Expected error for %q", list)
+		}
+	}
+}
+
+func TestOpenTSDBStripTags(t *testing.T) {
+	tags, _ := ParseOpenTSDBStripTags("_aggregate,_downsample=downsample,_rollup=dc")
+	decoder := OpenTSDBBuilder{StripTags: tags}.Build()
+	stripped, renamed := strippedOpenTSDBTags.Count(), renamedOpenTSDBTags.Count()
+
+	cases := []struct {
+		line     string
+		expected map[string]string
+	}{
+		{"put sys.cpu 1505454047 1 host=web01 _aggregate=sum dc=lga", map[string]string{"dc": "lga"}},
+		{"put sys.cpu 1505454047 1 host=web01 _downsample=1m-avg", map[string]string{"downsample": "1m-avg"}},
+		// the tag already set is kept
+		{"put sys.cpu 1505454047 1 host=web01 _rollup=sum dc=lga", map[string]string{"dc": "lga"}},
+		{"put sys.cpu 1505454047 1 host=web01", map[string]string{}},
+	}
+	for _, c := range cases {
+		points, err := decoder.Decode([]byte(c.line))
+		if err != nil {
+			t.Errorf("Error decoding %q: %v", c.line, err)
+			continue
+		}
+		if p := points[0]; p.Source != "web01" || !reflect.DeepEqual(p.Tags, c.expected) {
+			t.Errorf("Expected tags %v for %q, found %v", c.expected, c.line, p.Tags)
+		}
+	}
+	if count := strippedOpenTSDBTags.Count() - stripped; count != 2 {
+		t.Errorf("Expected 2 stripped tags, found %d", count)
+	}
+	if count := renamedOpenTSDBTags.Count() - renamed; count != 1 {
+		t.Errorf("Expected 1 renamed tag, found %d", count)
+	}
+	if _, err := decoder.Decode([]byte("  \n")); err == nil {
+		t.Error("Expected error for an empty line")
+	}
+}
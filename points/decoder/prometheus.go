@@ -0,0 +1,259 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/wavefronthq/go-proxy/common"
+)
+
+const (
+	// max bytes of a decompressed remote_write request
+	MaxPromWriteLength = 32 << 20
+
+	promNameLabel     = "__name__"
+	promInstanceLabel = "instance"
+)
+
+var (
+	ErrInvalidProtobuf = errors.New("DecodeError: invalid remote_write protobuf")
+	ErrMissingPromName = errors.New("DecodeError: missing __name__ label")
+)
+
+// Decodes Prometheus remote_write requests: snappy compressed WriteRequest protobuf messages.
+// Each sample of a series is a point named by the __name__ label, with the other labels as point
+// tags. The source is the source, host or instance label, in that order, without a port, or Hostname
+// if the series has none of them.
+type PromWriteDecoder struct {
+	Hostname string
+}
+
+type promSeries struct {
+	labels  map[string]string
+	samples []promSample
+}
+
+type promSample struct {
+	value     float64
+	timestamp int64 // epoch millis
+}
+
+// Returns the points of the valid series of the request and the series that failed, formatted
+// as name{label="value",...}, or an error if the request is not a valid WriteRequest.
+func (d PromWriteDecoder) Decode(compressed []byte) ([]*common.Point, []string, error) {
+	b, err := snappyDecode(compressed, MaxPromWriteLength)
+	if err != nil {
+		return nil, nil, err
+	}
+	series, err := parseWriteRequest(b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var points []*common.Point
+	var failed []string
+	for _, s := range series {
+		seriesPoints, err := d.seriesPoints(s)
+		if err != nil {
+			name := s.String()
+			promWriteCounters.count([]byte(name), nil, err)
+			failed = append(failed, name)
+			continue
+		}
+		promWriteCounters.count(nil, seriesPoints, nil)
+		points = append(points, seriesPoints...)
+	}
+	return points, failed, nil
+}
+
+func (d PromWriteDecoder) seriesPoints(s *promSeries) ([]*common.Point, error) {
+	name := s.labels[promNameLabel]
+	if name == "" {
+		return nil, ErrMissingPromName
+	}
+	tags := make(map[string]string, len(s.labels))
+	for k, v := range s.labels {
+		// empty labels are the same as missing ones in Prometheus
+		if k != promNameLabel && v != "" {
+			tags[k] = v
+		}
+	}
+	source, defaultSource := d.Hostname, true
+	for _, key := range []string{sourceKey, hostKey, promInstanceLabel} {
+		if v, ok := tags[key]; ok {
+			source, defaultSource = v, false
+			delete(tags, key)
+			break
+		}
+	}
+	// instances are usually host:port, but the port is not valid in a source
+	if host, _, err := net.SplitHostPort(source); err == nil {
+		source = host
+	}
+
+	points := make([]*common.Point, 0, len(s.samples))
+	for i, sample := range s.samples {
+		// NaN marks stale series, neither it nor infinite values can be sent
+		if math.IsNaN(sample.value) || math.IsInf(sample.value, 0) {
+			continue
+		}
+		point := &common.Point{
+			Name:          name,
+			Value:         strconv.FormatFloat(sample.value, 'f', -1, 64),
+			Timestamp:     sample.timestamp / 1000,
+			Source:        source,
+			DefaultSource: defaultSource,
+			Tags:          tags,
+		}
+		if i > 0 {
+			// the preprocessors may change the tags of each point
+			point.Tags = make(map[string]string, len(tags))
+			for k, v := range tags {
+				point.Tags[k] = v
+			}
+		}
+		if err := validate(point); err != nil {
+			return nil, err
+		}
+		points = append(points, point)
+	}
+	return points, nil
+}
+
+// Formats the series as Prometheus does, with the labels sorted.
+func (s *promSeries) String() string {
+	keys := make([]string, 0, len(s.labels))
+	for k := range s.labels {
+		if k != promNameLabel {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var buf strings.Builder
+	buf.WriteString(s.labels[promNameLabel])
+	buf.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(k)
+		buf.WriteByte('=')
+		buf.WriteString(strconv.Quote(s.labels[k]))
+	}
+	buf.WriteByte('}')
+	return buf.String()
+}
+
+// Parses a WriteRequest:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; ... }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; ... }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+//
+// Other fields, such as the metadata, exemplars and native histograms, are skipped.
+func parseWriteRequest(b []byte) ([]*promSeries, error) {
+	var series []*promSeries
+	err := parseMessage(b, func(field int, value []byte, _ uint64) error {
+		if field != 1 || value == nil {
+			return nil
+		}
+		s := &promSeries{labels: make(map[string]string)}
+		err := parseMessage(value, func(field int, value []byte, _ uint64) error {
+			switch {
+			case field == 1 && value != nil:
+				return parseLabel(value, s.labels)
+			case field == 2 && value != nil:
+				sample, err := parseSample(value)
+				s.samples = append(s.samples, sample)
+				return err
+			}
+			return nil
+		})
+		series = append(series, s)
+		return err
+	})
+	return series, err
+}
+
+func parseLabel(b []byte, labels map[string]string) error {
+	var name, value string
+	err := parseMessage(b, func(field int, v []byte, _ uint64) error {
+		switch {
+		case field == 1 && v != nil:
+			name = string(v)
+		case field == 2 && v != nil:
+			value = string(v)
+		}
+		return nil
+	})
+	labels[name] = value
+	return err
+}
+
+func parseSample(b []byte) (promSample, error) {
+	var sample promSample
+	err := parseMessage(b, func(field int, _ []byte, n uint64) error {
+		switch field {
+		case 1:
+			sample.value = math.Float64frombits(n)
+		case 2:
+			sample.timestamp = int64(n)
+		}
+		return nil
+	})
+	return sample, err
+}
+
+// Calls fn with the number and value of each field of a protobuf message: the bytes of
+// length delimited fields, or the number of varint and fixed size fields.
+func parseMessage(b []byte, fn func(field int, value []byte, n uint64) error) error {
+	for len(b) > 0 {
+		key, size := binary.Uvarint(b)
+		if size <= 0 || key>>3 == 0 {
+			return ErrInvalidProtobuf
+		}
+		b = b[size:]
+		field := int(key >> 3)
+		var value []byte
+		var n uint64
+		switch key & 7 {
+		case 0: // varint
+			n, size = binary.Uvarint(b)
+			if size <= 0 {
+				return ErrInvalidProtobuf
+			}
+			b = b[size:]
+		case 1: // fixed64
+			if len(b) < 8 {
+				return ErrInvalidProtobuf
+			}
+			n = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case 2: // length delimited
+			length, size := binary.Uvarint(b)
+			if size <= 0 || length > uint64(len(b)-size) {
+				return ErrInvalidProtobuf
+			}
+			value = b[size : size+int(length)]
+			b = b[size+int(length):]
+		case 5: // fixed32
+			if len(b) < 4 {
+				return ErrInvalidProtobuf
+			}
+			n = uint64(binary.LittleEndian.Uint32(b))
+			b = b[4:]
+		default:
+			return ErrInvalidProtobuf
+		}
+		if err := fn(field, value, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func pbBytes(field int, value []byte) []byte {
+	b := binary.AppendUvarint(nil, uint64(field<<3|2))
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+func pbLabel(name, value string) []byte {
+	return pbBytes(1, append(pbBytes(1, []byte(name)), pbBytes(2, []byte(value))...))
+}
+
+func pbSample(value float64, timestamp int64) []byte {
+	b := binary.AppendUvarint(nil, 1<<3|1)
+	b = binary.LittleEndian.AppendUint64(b, math.Float64bits(value))
+	b = binary.AppendUvarint(b, 2<<3|0)
+	b = binary.AppendUvarint(b, uint64(timestamp))
+	return pbBytes(2, b)
+}
+
+func pbSeries(fields ...[]byte) []byte {
+	return pbBytes(1, bytes.Join(fields, nil))
+}
+
+// Compresses b as snappy literals, of up to 256 bytes each.
+func snappyLiterals(b []byte) []byte {
+	out := binary.AppendUvarint(nil, uint64(len(b)))
+	for len(b) > 0 {
+		n := min(len(b), 256)
+		out = append(out, 60<<2, byte(n-1))
+		out = append(out, b[:n]...)
+		b = b[n:]
+	}
+	return out
+}
+
+func TestSnappyDecode(t *testing.T) {
+	data := bytes.Repeat([]byte("abcdefgh"), 100)
+	if b, err := snappyDecode(snappyLiterals(data), len(data)); err != nil || !bytes.Equal(b, data) {
+		t.Errorf("Expected literals decoded, found %q: %v", b, err)
+	}
+
+	// "ab" then copies of length 6 at offset 2 with 1, 2 and 4 byte offsets, overlapping the bytes appended
+	copies := []byte{20, 1 << 2, 'a', 'b', 2<<2 | 1, 2, 5<<2 | 2, 2, 0, 5<<2 | 3, 2, 0, 0, 0}
+	if b, err := snappyDecode(copies, 100); err != nil || string(b) != "abababababababababab" {
+		t.Errorf("Expected copies decoded, found %q: %v", b, err)
+	}
+
+	invalid := [][]byte{
+		nil,
+		{5, 0, 'a'},              // shorter than its length
+		{1, 0, 'a', 'b'},         // longer than its length
+		{4, 1<<2 | 1, 1},         // copy before any bytes
+		{4, 0, 'a', 0<<2 | 1, 2}, // offset past the start
+	}
+	for _, b := range invalid {
+		if _, err := snappyDecode(b, 100); err == nil {
+			t.Errorf("Expected error decoding % x", b)
+		}
+	}
+	if _, err := snappyDecode(snappyLiterals(data), len(data)-1); err == nil {
+		t.Error("Expected error decoding more than the max length")
+	}
+
+	// "a" then copies of the longest length at offset 1, the highest compression ratio
+	const copiesLen = 1 + 1000*64
+	dense := binary.AppendUvarint(nil, copiesLen)
+	dense = append(dense, 0, 'a')
+	for i := 0; i < 1000; i++ {
+		dense = append(dense, 63<<2|2, 1, 0)
+	}
+	if b, err := snappyDecode(dense, MaxPromWriteLength); err != nil || len(b) != copiesLen {
+		t.Errorf("Expected %d bytes decoded, found %d: %v", copiesLen, len(b), err)
+	}
+	// a length in the header out of proportion to the data is rejected before it is allocated
+	header := binary.AppendUvarint(nil, MaxPromWriteLength)
+	if _, err := snappyDecode(append(header, 0, 'a'), MaxPromWriteLength); err == nil {
+		t.Error("Expected error decoding a length over the max compression ratio")
+	}
+}
+
+func TestPromWriteDecoder(t *testing.T) {
+	request := bytes.Join([][]byte{
+		pbSeries(pbLabel("__name__", "http_requests_total"), pbLabel("instance", "web01:9090"), pbLabel("job", "api"),
+			pbSample(10, 1500000000500), pbSample(12.5, 1500000015000)),
+		pbSeries(pbLabel("__name__", "up"), pbLabel("job", "api"), pbLabel("empty", ""), pbSample(1, 1500000000000),
+			pbSample(math.NaN(), 1500000015000)),
+		pbSeries(pbLabel("job", "api"), pbSample(1, 1500000000000)),
+		// metadata
+		pbBytes(3, pbBytes(2, []byte("up"))),
+	}, nil)
+
+	d := PromWriteDecoder{Hostname: "proxy"}
+	failures := promWriteCounters.failures.Count()
+	points, failed, err := d.Decode(snappyLiterals(request))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 3 {
+		t.Fatalf("Expected 3 points, found %d", len(points))
+	}
+	p := points[0]
+	if p.Name != "http_requests_total" || p.Value != "10" || p.Timestamp != 1500000000 || p.Source != "web01" ||
+		p.DefaultSource || len(p.Tags) != 1 || p.Tags["job"] != "api" {
+		t.Errorf("Unexpected point %+v", p)
+	}
+	if p := points[1]; p.Value != "12.5" || p.Timestamp != 1500000015 || p.Tags["job"] != "api" {
+		t.Errorf("Unexpected point %+v", p)
+	}
+	if p := points[2]; p.Name != "up" || p.Source != "proxy" || !p.DefaultSource || len(p.Tags) != 1 {
+		t.Errorf("Expected the stale marker skipped and the hostname as source, found %+v", p)
+	}
+	if len(failed) != 1 || failed[0] != `{job="api"}` || promWriteCounters.failures.Count() != failures+1 {
+		t.Errorf("Expected the series without a name failed, found %v", failed)
+	}
+
+	for _, body := range [][]byte{[]byte("foo"), snappyLiterals([]byte{0x0a, 0x05, 0x01})} {
+		if _, _, err := d.Decode(body); err == nil {
+			t.Errorf("Expected error decoding % x", body)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var ErrInvalidSnappy = errors.New("DecodeError: invalid snappy data")
+
+// Max ratio of the decompressed to the compressed length, the longest copy of 64 bytes is encoded
+// in 3 bytes.
+const maxSnappyRatio = 32
+
+// Decompresses data in the snappy block format, as sent by Prometheus remote_write, failing if
+// the data decompresses to more than maxLen bytes. The decompressed length in the header is checked
+// against the compressed length before it is allocated.
+func snappyDecode(src []byte, maxLen int) ([]byte, error) {
+	n, size := binary.Uvarint(src)
+	if size <= 0 || n > uint64(maxLen) || n > uint64(len(src))*maxSnappyRatio {
+		return nil, ErrInvalidSnappy
+	}
+	src = src[size:]
+	dst := make([]byte, 0, n)
+
+	for len(src) > 0 {
+		tag := src[0]
+		var length, offset int
+		switch tag & 3 {
+		case 0: // literal
+			length = int(tag >> 2)
+			src = src[1:]
+			if length >= 60 {
+				// the length less one is in the next 1 to 4 bytes
+				bytes := length - 59
+				if len(src) < bytes {
+					return nil, ErrInvalidSnappy
+				}
+				length = 0
+				for i := bytes - 1; i >= 0; i-- {
+					length = length<<8 | int(src[i])
+				}
+				src = src[bytes:]
+			}
+			length++
+			if length <= 0 || length > len(src) || len(dst)+length > int(n) {
+				return nil, ErrInvalidSnappy
+			}
+			dst = append(dst, src[:length]...)
+			src = src[length:]
+			continue
+		case 1: // copy with a 1 byte offset
+			if len(src) < 2 {
+				return nil, ErrInvalidSnappy
+			}
+			length = 4 + int(tag>>2&7)
+			offset = int(tag&0xe0)<<3 | int(src[1])
+			src = src[2:]
+		case 2: // copy with a 2 byte offset
+			if len(src) < 3 {
+				return nil, ErrInvalidSnappy
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[1:]))
+			src = src[3:]
+		case 3: // copy with a 4 byte offset
+			if len(src) < 5 {
+				return nil, ErrInvalidSnappy
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[1:]))
+			src = src[5:]
+		}
+		if offset <= 0 || offset > len(dst) || len(dst)+length > int(n) {
+			return nil, ErrInvalidSnappy
+		}
+		// copied a byte at a time as the copy may overlap the bytes it appends
+		start := len(dst) - offset
+		for i := 0; i < length; i++ {
+			dst = append(dst, dst[start+i])
+		}
+	}
+	if len(dst) != int(n) {
+		return nil, ErrInvalidSnappy
+	}
+	return dst, nil
+}
@@ -0,0 +1,270 @@
+package decoder
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/common"
+)
+
+const (
+	statsDCounter = "c"
+	statsDGauge   = "g"
+	statsDTimer   = "ms"
+	statsDSet     = "s"
+
+	// buckets aggregated per aggregator across all types, new buckets are dropped past this
+	maxStatsDBuckets = 100000
+)
+
+var (
+	ErrInvalidStatsD    = errors.New("DecodeError: incorrect statsd format")
+	ErrInvalidStatsType = errors.New("DecodeError: unsupported statsd metric type")
+	ErrInvalidRate      = errors.New("DecodeError: invalid statsd sample rate")
+	ErrTooManyBuckets   = errors.New("DecodeError: too many statsd buckets")
+)
+
+// Interface for builders whose decoders aggregate points until flushed.
+// Flush may retain points until their aggregation interval ends, FlushAll returns all points.
+type AggregatingBuilder interface {
+	DecoderBuilder
+	Flush() []*common.Point
+	FlushAll() []*common.Point
+}
+
+// Aggregates StatsD metrics received between flushes.
+type StatsDAggregator struct {
+	source         string
+	gaugeExpiry    int // flushes a gauge is reported for after its last update, forever if 0
+	mtx            sync.Mutex
+	counters       map[string]float64
+	gauges         map[string]*gaugeValue
+	timers         map[string]*timerStats
+	sets           map[string]map[string]struct{}
+	dropped        metrics.Counter
+	bucketsDropped metrics.Counter
+}
+
+type gaugeValue struct {
+	value float64
+	idle  int // flushes since the last update
+}
+
+type timerStats struct {
+	count float64
+	min   float64
+	max   float64
+	sum   float64
+	n     int
+}
+
+type StatsDBuilder struct {
+	Aggregator *StatsDAggregator
+}
+
+type StatsDDecoder struct {
+	aggregator *StatsDAggregator
+}
+
+// Returns a StatsD builder whose points are reported with the given source. Gauges are reported
+// on each flush until gaugeExpiry flushes passed without an update, or forever if 0.
+func NewStatsDBuilder(source string, gaugeExpiry int) StatsDBuilder {
+	aggregator := &StatsDAggregator{
+		source:         source,
+		gaugeExpiry:    gaugeExpiry,
+		dropped:        metrics.GetOrRegisterCounter("statsd.points.dropped", nil),
+		bucketsDropped: metrics.GetOrRegisterCounter("statsd.buckets.dropped", nil),
+	}
+	aggregator.reset()
+	return StatsDBuilder{Aggregator: aggregator}
+}
+
+func (b StatsDBuilder) Build() PointDecoder {
+	return &StatsDDecoder{aggregator: b.Aggregator}
+}
+
+func (b StatsDBuilder) Flush() []*common.Point {
+	return b.Aggregator.Flush()
+}
+
+func (b StatsDBuilder) FlushAll() []*common.Point {
+	return b.Aggregator.Flush()
+}
+
+// Decodes a bucket:value|type[|@rate] line. Points are aggregated and
+// only emitted when the aggregator is flushed.
+func (d *StatsDDecoder) Decode(b []byte) ([]*common.Point, error) {
+	points, err := d.decode(b)
+	return statsDCounters.count(b, points, err)
+}
+
+func (d *StatsDDecoder) decode(b []byte) ([]*common.Point, error) {
+	err := d.aggregator.add(strings.TrimSpace(string(b)))
+	if err != nil {
+		d.aggregator.dropped.Inc(1)
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (a *StatsDAggregator) reset() {
+	a.counters = make(map[string]float64)
+	a.gauges = make(map[string]*gaugeValue)
+	a.timers = make(map[string]*timerStats)
+	a.sets = make(map[string]map[string]struct{})
+}
+
+func (a *StatsDAggregator) add(line string) error {
+	sep := strings.LastIndex(line, ":")
+	if sep <= 0 {
+		return ErrInvalidStatsD
+	}
+	bucket := line[:sep]
+	fields := strings.Split(line[sep+1:], "|")
+	if len(fields) < 2 || fields[0] == "" {
+		return ErrInvalidStatsD
+	}
+
+	err := validateStr(bucket, 1024)
+	if err != nil {
+		return err
+	}
+
+	rate := 1.0
+	for _, field := range fields[2:] {
+		if strings.HasPrefix(field, "@") {
+			rate, err = strconv.ParseFloat(field[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return ErrInvalidRate
+			}
+		}
+	}
+
+	raw, metricType := fields[0], fields[1]
+	if metricType == statsDSet {
+		a.mtx.Lock()
+		defer a.mtx.Unlock()
+		if _, ok := a.sets[bucket]; !ok {
+			if a.full() {
+				return ErrTooManyBuckets
+			}
+			a.sets[bucket] = make(map[string]struct{})
+		}
+		a.sets[bucket][raw] = struct{}{}
+		return nil
+	}
+
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return ErrInvalidStatsD
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	switch metricType {
+	case statsDCounter:
+		if _, ok := a.counters[bucket]; !ok && a.full() {
+			return ErrTooManyBuckets
+		}
+		a.counters[bucket] += value / rate
+	case statsDGauge:
+		gauge, ok := a.gauges[bucket]
+		if !ok {
+			if a.full() {
+				return ErrTooManyBuckets
+			}
+			gauge = &gaugeValue{}
+			a.gauges[bucket] = gauge
+		}
+		// a leading sign modifies the current gauge value
+		if raw[0] == '+' || raw[0] == '-' {
+			gauge.value += value
+		} else {
+			gauge.value = value
+		}
+		gauge.idle = 0
+	case statsDTimer:
+		stats, ok := a.timers[bucket]
+		if !ok {
+			if a.full() {
+				return ErrTooManyBuckets
+			}
+			stats = &timerStats{min: value, max: value}
+			a.timers[bucket] = stats
+		}
+		stats.count += 1 / rate
+		stats.min = math.Min(stats.min, value)
+		stats.max = math.Max(stats.max, value)
+		stats.sum += value
+		stats.n++
+	default:
+		return ErrInvalidStatsType
+	}
+	return nil
+}
+
+// Returns true if no new bucket can be added, counting the bucket dropped. Must be called with
+// the lock held.
+func (a *StatsDAggregator) full() bool {
+	if len(a.counters)+len(a.gauges)+len(a.timers)+len(a.sets) < maxStatsDBuckets {
+		return false
+	}
+	a.bucketsDropped.Inc(1)
+	return true
+}
+
+// Flush returns the points aggregated since the previous flush and resets the aggregator.
+// Gauges retain their last value and are reported on every flush, until they expire.
+func (a *StatsDAggregator) Flush() []*common.Point {
+	ts := time.Now().Unix()
+
+	a.mtx.Lock()
+	counters, timers, sets := a.counters, a.timers, a.sets
+	gauges := make(map[string]float64, len(a.gauges))
+	for k, gauge := range a.gauges {
+		gauges[k] = gauge.value
+		// gauges of clients that went away are not reported forever
+		if gauge.idle++; a.gaugeExpiry > 0 && gauge.idle >= a.gaugeExpiry {
+			delete(a.gauges, k)
+		}
+	}
+	a.counters = make(map[string]float64)
+	a.timers = make(map[string]*timerStats)
+	a.sets = make(map[string]map[string]struct{})
+	a.mtx.Unlock()
+
+	var points []*common.Point
+	for name, v := range counters {
+		points = append(points, a.newPoint(name, v, ts))
+	}
+	for name, v := range gauges {
+		points = append(points, a.newPoint(name, v, ts))
+	}
+	for name, stats := range timers {
+		points = append(points,
+			a.newPoint(name+".count", stats.count, ts),
+			a.newPoint(name+".min", stats.min, ts),
+			a.newPoint(name+".max", stats.max, ts),
+			a.newPoint(name+".mean", stats.sum/float64(stats.n), ts))
+	}
+	for name, members := range sets {
+		points = append(points, a.newPoint(name, float64(len(members)), ts))
+	}
+	return points
+}
+
+func (a *StatsDAggregator) newPoint(name string, value float64, ts int64) *common.Point {
+	return &common.Point{
+		Name:          name,
+		Value:         strconv.FormatFloat(value, 'f', -1, 64),
+		Timestamp:     ts,
+		Source:        a.source,
+		DefaultSource: true,
+	}
+}
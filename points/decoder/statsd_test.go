@@ -0,0 +1,128 @@
+package decoder
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/wavefronthq/go-proxy/common"
+)
+
+var invalidStatsDLines = [...]string{
+	"",
+	"foo.counter",
+	"foo.counter:1",
+	"foo.counter:|c",
+	"foo.counter:abc|c",
+	"foo.counter:1|x",
+	"foo.counter:1|c|@0",
+	"foo.counter:1|c|@abc",
+	":1|c",
+	"foo counter:1|c",
+}
+
+func TestInvalidStatsDLines(t *testing.T) {
+	builder := NewStatsDBuilder("test-source", 0)
+	decoder := builder.Build()
+	for _, line := range invalidStatsDLines {
+		if _, err := decoder.Decode([]byte(line)); err == nil {
+			t.Errorf("Error expected but not detected for line: %q", line)
+		}
+	}
+}
+
+func TestStatsDAggregation(t *testing.T) {
+	builder := NewStatsDBuilder("test-source", 0)
+	decoder := builder.Build()
+	lines := []string{
+		"foo.counter:1|c",
+		"foo.counter:2|c|@0.5",
+		"foo.gauge:10|g",
+		"foo.gauge:-3|g",
+		"foo.timer:10|ms",
+		"foo.timer:30|ms",
+		"foo.set:a|s",
+		"foo.set:b|s",
+		"foo.set:a|s",
+	}
+	for _, line := range lines {
+		points, err := decoder.Decode([]byte(line))
+		if err != nil {
+			t.Fatal(line, err)
+		}
+		if len(points) != 0 {
+			t.Errorf("Expected no points before flush for line: %s", line)
+		}
+	}
+
+	expected := map[string]string{
+		"foo.counter":     "5",
+		"foo.gauge":       "7",
+		"foo.timer.count": "2",
+		"foo.timer.min":   "10",
+		"foo.timer.max":   "30",
+		"foo.timer.mean":  "20",
+		"foo.set":         "2",
+	}
+	verifyPoints(t, builder.Flush(), expected)
+
+	// counters, timers and sets reset after a flush, gauges retain their value
+	verifyPoints(t, builder.Flush(), map[string]string{"foo.gauge": "7"})
+}
+
+func TestStatsDGaugeExpiry(t *testing.T) {
+	builder := NewStatsDBuilder("test-source", 2)
+	decoder := builder.Build()
+	decoder.Decode([]byte("foo.gauge:10|g"))
+
+	// reported for 2 flushes after the last update
+	verifyPoints(t, builder.Flush(), map[string]string{"foo.gauge": "10"})
+	decoder.Decode([]byte("foo.gauge:+1|g"))
+	verifyPoints(t, builder.Flush(), map[string]string{"foo.gauge": "11"})
+	verifyPoints(t, builder.Flush(), map[string]string{"foo.gauge": "11"})
+	verifyPoints(t, builder.Flush(), map[string]string{})
+
+	// an expired gauge starts again from its next value
+	decoder.Decode([]byte("foo.gauge:+1|g"))
+	verifyPoints(t, builder.Flush(), map[string]string{"foo.gauge": "1"})
+}
+
+func TestStatsDMaxBuckets(t *testing.T) {
+	builder := NewStatsDBuilder("test-source", 0)
+	decoder := builder.Build()
+	for i := 0; i < maxStatsDBuckets; i++ {
+		if _, err := decoder.Decode([]byte("foo." + strconv.Itoa(i) + ":1|c")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dropped := builder.Aggregator.bucketsDropped.Count()
+	for _, line := range []string{"bar:1|c", "bar:1|g", "bar:1|ms", "bar:a|s"} {
+		if _, err := decoder.Decode([]byte(line)); err != ErrTooManyBuckets {
+			t.Errorf("Expected %s dropped, found %v", line, err)
+		}
+	}
+	if n := builder.Aggregator.bucketsDropped.Count() - dropped; n != 4 {
+		t.Errorf("Expected 4 buckets dropped, found %d", n)
+	}
+	// existing buckets are still aggregated
+	if _, err := decoder.Decode([]byte("foo.0:1|c")); err != nil {
+		t.Error(err)
+	}
+	if points := builder.Flush(); len(points) != maxStatsDBuckets {
+		t.Errorf("Expected %d points, found %d", maxStatsDBuckets, len(points))
+	}
+}
+
+func verifyPoints(t *testing.T, points []*common.Point, expected map[string]string) {
+	if len(points) != len(expected) {
+		t.Errorf("Expected %d points, found %d", len(expected), len(points))
+	}
+	for _, point := range points {
+		if point.Source != "test-source" {
+			t.Errorf("Invalid source %s for %s", point.Source, point.Name)
+		}
+		if value, ok := expected[point.Name]; !ok || value != point.Value {
+			t.Errorf("Unexpected value %s for %s", point.Value, point.Name)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package decoder
 import (
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/wavefronthq/go-proxy/common"
 )
@@ -19,7 +20,7 @@ var (
 )
 
 func validate(point *common.Point) error {
-	err := validateStr(point.Name, 1024)
+	err := ValidateMetricName(point.Name)
 	if err != nil {
 		return err
 	}
@@ -30,11 +31,7 @@ func validate(point *common.Point) error {
 	}
 
 	for k, v := range point.Tags {
-		totalLen := len(k) + len(v)
-		if totalLen >= 255 {
-			return fmt.Errorf(lengthErrStr, 254, totalLen)
-		}
-		err = validateRunes(k)
+		err = ValidateTag(k, v)
 		if err != nil {
 			return err
 		}
@@ -42,6 +39,30 @@ func validate(point *common.Point) error {
 	return nil
 }
 
+// Checks the length of a point tag and the characters of its key.
+func ValidateTag(k, v string) error {
+	totalLen := len(k) + len(v)
+	if totalLen >= 255 {
+		return fmt.Errorf(lengthErrStr, 254, totalLen)
+	}
+	return validateRunes(k)
+}
+
+// Checks the length and characters of a metric name, which may be a delta counter name.
+func ValidateMetricName(name string) error {
+	return validateStr(trimDeltaPrefix(name), 1024)
+}
+
+// Delta counter names start with a delta character, the increment sign or the greek letter.
+func trimDeltaPrefix(name string) string {
+	for _, prefix := range []string{"∆", "Δ"} {
+		if strings.HasPrefix(name, prefix) {
+			return name[len(prefix):]
+		}
+	}
+	return name
+}
+
 func validateStr(s string, maxLen int) error {
 	strLen := len(s)
 	if strLen <= 0 || strLen >= maxLen {
@@ -70,7 +91,7 @@ func handleSource(point *common.Point) error {
 		return nil
 	} else {
 		if host, ok := point.Tags[hostKey]; ok {
-			delete(point.Tags, sourceKey)
+			delete(point.Tags, hostKey)
 			point.Source = host
 			return nil
 		}
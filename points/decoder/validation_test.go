@@ -2,6 +2,7 @@ package decoder
 
 import (
 	"github.com/wavefronthq/go-proxy/common"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -15,6 +16,12 @@ func TestValidPoints(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
+
+	for _, name := range []string{"∆" + VALID_NAME, "Δ" + VALID_NAME, "~" + VALID_NAME} {
+		if err := validate(getPoint(name, VALID_SOURCE)); err != nil {
+			t.Error(err)
+		}
+	}
 }
 
 func TestInvalidPoints(t *testing.T) {
@@ -33,6 +40,12 @@ func TestInvalidPoints(t *testing.T) {
 
 	point = getPoint("system.cpu.load\\", VALID_SOURCE)
 	handleExpectedError(t, point)
+
+	point = getPoint("∆∆"+VALID_NAME, VALID_SOURCE)
+	handleExpectedError(t, point)
+
+	point = getPoint("system.∆cpu", VALID_SOURCE)
+	handleExpectedError(t, point)
 }
 
 func handleExpectedError(t *testing.T, point *common.Point) {
@@ -52,3 +65,31 @@ func getPoint(name, source string) *common.Point {
 	point.Source = source
 	return point
 }
+
+func TestHandleSource(t *testing.T) {
+	cases := []struct {
+		tags     map[string]string
+		source   string
+		expected map[string]string
+	}{
+		{map[string]string{"source": "a", "dc": "lga"}, "a", map[string]string{"dc": "lga"}},
+		// the host tag is the source of points without a source tag
+		{map[string]string{"host": "b", "dc": "lga"}, "b", map[string]string{"dc": "lga"}},
+		{map[string]string{"source": "a", "host": "b"}, "a", map[string]string{"host": "b"}},
+	}
+	for _, c := range cases {
+		point := &common.Point{Name: VALID_NAME, Tags: c.tags}
+		if err := handleSource(point); err != nil {
+			t.Errorf("Error handling the source of %v: %v", c.tags, err)
+			continue
+		}
+		if point.Source != c.source || !reflect.DeepEqual(point.Tags, c.expected) {
+			t.Errorf("Expected source %s and tags %v, found %s and %v", c.source, c.expected, point.Source, point.Tags)
+		}
+	}
+
+	point := &common.Point{Name: VALID_NAME, Tags: map[string]string{"dc": "lga"}}
+	if err := handleSource(point); err != ErrMissingSource {
+		t.Errorf("Expected ErrMissingSource, found %v", err)
+	}
+}
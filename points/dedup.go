@@ -0,0 +1,60 @@
+package points
+
+import (
+	"hash/fnv"
+	"strconv"
+	"sync"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/common"
+)
+
+// max points remembered per flush window, points past it are not checked for duplicates
+const maxDedupPoints = 1000000
+
+// Drops points with the same metric name, source, tags and timestamp as a point seen
+// earlier in the current flush window.
+type dedupFilter struct {
+	mtx     sync.Mutex
+	seen    map[uint64]struct{}
+	max     int
+	dropped metrics.Counter
+}
+
+func newDedupFilter(name string, max int) *dedupFilter {
+	return &dedupFilter{
+		seen:    make(map[uint64]struct{}),
+		max:     max,
+		dropped: metrics.GetOrRegisterCounter("points."+name+".duplicates", nil),
+	}
+}
+
+// Returns true if the point was seen before in the window. Histograms are not checked.
+func (d *dedupFilter) duplicate(point *common.Point) bool {
+	if point.Histogram != nil {
+		return false
+	}
+	h := fnv.New64a()
+	h.Write([]byte(seriesKey(point)))
+	h.Write([]byte{0})
+	h.Write([]byte(strconv.FormatInt(point.Timestamp, 10)))
+	key := h.Sum64()
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if _, ok := d.seen[key]; ok {
+		d.dropped.Inc(1)
+		return true
+	}
+	if len(d.seen) < d.max {
+		d.seen[key] = struct{}{}
+	}
+	return false
+}
+
+// Starts a new window.
+func (d *dedupFilter) reset() {
+	d.mtx.Lock()
+	d.seen = make(map[uint64]struct{})
+	d.mtx.Unlock()
+}
@@ -0,0 +1,36 @@
+package points
+
+import (
+	"testing"
+)
+
+func TestDedupFilter(t *testing.T) {
+	d := newDedupFilter("test", 3)
+	if d.duplicate(newTestPoint("foo", map[string]string{"env": "dev", "region": "us"})) {
+		t.Error("First point should not be a duplicate")
+	}
+	duplicate := newTestPoint("foo", map[string]string{"region": "us", "env": "dev"})
+	duplicate.Value = "2"
+	if !d.duplicate(duplicate) {
+		t.Error("Point with the same metric, source, tags and timestamp should be a duplicate")
+	}
+
+	later := newTestPoint("foo", map[string]string{"env": "dev", "region": "us"})
+	later.Timestamp++
+	if d.duplicate(later) || d.duplicate(newTestPoint("foo", nil)) {
+		t.Error("Points with another timestamp or tags should not be duplicates")
+	}
+
+	// points past the limit are not remembered
+	if d.duplicate(newTestPoint("bar", nil)) || d.duplicate(newTestPoint("bar", nil)) {
+		t.Error("Points past the limit should not be duplicates")
+	}
+	if d.dropped.Count() != 1 {
+		t.Errorf("Expected 1 duplicate counted, found %d", d.dropped.Count())
+	}
+
+	d.reset()
+	if d.duplicate(newTestPoint("foo", nil)) {
+		t.Error("Points should not be duplicates after a reset")
+	}
+}
@@ -0,0 +1,97 @@
+package points
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/wavefronthq/go-proxy/common"
+)
+
+// Metric name prefixes of Wavefront delta counters, the increment sign and the greek letter.
+var deltaPrefixes = []string{"∆", "Δ"}
+
+func isDeltaCounter(name string) bool {
+	for _, prefix := range deltaPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sums the values of delta counters by metric name, source and tags, so a single point
+// per series is sent each flush interval instead of one per increment.
+type deltaAggregator struct {
+	mtx    sync.Mutex
+	series map[string]*common.Point
+	sums   map[string]float64
+}
+
+func newDeltaAggregator() *deltaAggregator {
+	return &deltaAggregator{
+		series: make(map[string]*common.Point),
+		sums:   make(map[string]float64),
+	}
+}
+
+// Adds the point to the accumulator. Returns false for points that are not delta counters,
+// including delta counters with invalid values, which are sent unchanged.
+func (a *deltaAggregator) add(point *common.Point) bool {
+	if point.Histogram != nil || !isDeltaCounter(point.Name) {
+		return false
+	}
+	value, err := strconv.ParseFloat(point.Value, 64)
+	if err != nil {
+		return false
+	}
+	key := seriesKey(point)
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if existing, ok := a.series[key]; !ok {
+		a.series[key] = point
+	} else if point.Timestamp > existing.Timestamp {
+		existing.Timestamp = point.Timestamp
+	}
+	a.sums[key] += value
+	return true
+}
+
+// Returns a point per series with the sum of its values, timestamped with the latest
+// point of the series, and resets the accumulator.
+func (a *deltaAggregator) flush() []*common.Point {
+	a.mtx.Lock()
+	series, sums := a.series, a.sums
+	a.series = make(map[string]*common.Point)
+	a.sums = make(map[string]float64)
+	a.mtx.Unlock()
+
+	points := make([]*common.Point, 0, len(series))
+	for key, point := range series {
+		point.Value = strconv.FormatFloat(sums[key], 'f', -1, 64)
+		points = append(points, point)
+	}
+	return points
+}
+
+func seriesKey(point *common.Point) string {
+	keys := make([]string, 0, len(point.Tags))
+	for k := range point.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(point.Name)
+	b.WriteByte(0)
+	b.WriteString(point.Source)
+	for _, k := range keys {
+		b.WriteByte(0)
+		b.WriteString(k)
+		b.WriteByte(0)
+		b.WriteString(point.Tags[k])
+	}
+	return b.String()
+}
@@ -0,0 +1,74 @@
+package points
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestDeltaAggregator(t *testing.T) {
+	a := newDeltaAggregator()
+	if a.add(newTestPoint("foo.count", nil)) {
+		t.Error("Non delta point should not be aggregated")
+	}
+
+	for i, value := range []string{"1", "2.5", "3"} {
+		point := newTestPoint("∆foo.count", map[string]string{"env": "dev", "region": "us"})
+		point.Value = value
+		point.Timestamp += int64(i)
+		if !a.add(point) {
+			t.Fatalf("Delta point %s should be aggregated", value)
+		}
+	}
+	if !a.add(newTestPoint("Δfoo.count", map[string]string{"region": "us", "env": "prod"})) {
+		t.Error("Delta point with greek prefix should be aggregated")
+	}
+	invalid := newTestPoint("∆foo.count", nil)
+	invalid.Value = "abc"
+	if a.add(invalid) {
+		t.Error("Delta point with invalid value should not be aggregated")
+	}
+
+	points := a.flush()
+	if len(points) != 2 {
+		t.Fatalf("Expected 2 series, found %d", len(points))
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].Name > points[j].Name })
+	if points[0].Value != "6.5" || points[0].Timestamp != 1505454049 {
+		t.Errorf("Expected sum 6.5 at 1505454049, found %s at %d", points[0].Value, points[0].Timestamp)
+	}
+	if points[1].Value != "1" {
+		t.Errorf("Expected sum 1, found %s", points[1].Value)
+	}
+
+	if points := a.flush(); len(points) != 0 {
+		t.Errorf("Expected accumulator reset after flush, found %d series", len(points))
+	}
+}
+
+func TestHandlerAggregatesDeltas(t *testing.T) {
+	service := &testAPI{}
+	handler, err := newPointHandler("2878", "", 0, time.Second, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.init(1, 60000, 1000, 0, 10, "wavefront", "", service)
+	for i := 0; i < 5; i++ {
+		handler.reportPoint(newTestPoint("∆foo.count", nil))
+		handler.reportPoint(newTestPoint("foo.count", nil))
+	}
+	handler.stop()
+
+	if posted := service.postedPoints(); posted != 6 {
+		t.Fatalf("Expected 5 points and 1 delta sum flushed, found %d", posted)
+	}
+	found := false
+	for _, line := range service.points {
+		if line == "\"∆foo.count\" 5 1505454047 source=\"test\"" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected summed delta counter in %v", service.points)
+	}
+}
@@ -0,0 +1,97 @@
+package points
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/api"
+	"github.com/wavefronthq/go-proxy/logger"
+)
+
+var (
+	// max bytes of the request body of a flush, before compression, unlimited if 0
+	maxFlushBytes int64
+
+	oversizedFlushPoints = metrics.GetOrRegisterCounter("push.points.oversized", nil)
+	splitFlushes         = metrics.GetOrRegisterCounter("push.flushes.split", nil)
+)
+
+// Sets the max bytes of the uncompressed request body of a flush, sending the points of larger
+// batches in several requests, for servers that cap the payload size. Unlimited if 0.
+func SetMaxFlushBytes(n int) {
+	atomic.StoreInt64(&maxFlushBytes, int64(n))
+}
+
+// Splits the points into batches whose request bodies, the points joined by newlines, are at
+// most maxBytes. Points longer than maxBytes are left out, as they cannot be sent in a request,
+// and returned. The batches share the array of the points.
+func splitByBytes(points []string, maxBytes int) (batches [][]string, oversized []string) {
+	start, size := 0, -1 // bytes of points[start:i] joined, -1 if none
+	for i, point := range points {
+		if len(point) > maxBytes {
+			if i > start {
+				batches = append(batches, points[start:i])
+			}
+			start, size = i+1, -1
+			oversized = append(oversized, point)
+			continue
+		}
+		if size+1+len(point) > maxBytes {
+			batches = append(batches, points[start:i])
+			start, size = i, -1
+		}
+		size += 1 + len(point)
+	}
+	if start < len(points) {
+		batches = append(batches, points[start:])
+	}
+	return batches, oversized
+}
+
+// Counts the points longer than maxBytes as dropped, once the flush they were left out of is
+// not retried, so the points of a batch buffered again are not counted twice.
+func dropOversized(oversized []string, maxBytes int) {
+	for _, point := range oversized {
+		oversizedFlushPoints.Inc(1)
+		recordBlockedLine(point, fmt.Sprintf("maxFlushBytes %d", maxBytes))
+		logger.Debugf("Dropping point of %d bytes, longer than maxFlushBytes %d", len(point), maxBytes)
+	}
+}
+
+// Posts the points in batches of at most maxFlushBytes, one after the other, stopping at the
+// first batch that fails so the points are buffered and retried. Points resent from the
+// batches that succeeded overwrite those already received. Returns the result of the first
+// rejected batch, if any, and the time taken by all the requests.
+func postSplit(service api.WavefrontAPI, workUnitId, format string, points []string, maxBytes int) (*http.Response, time.Duration, error) {
+	batches, oversized := splitByBytes(points, maxBytes)
+	if len(batches) == 0 {
+		dropOversized(oversized, maxBytes)
+		// every point was dropped, which the caller handles as a rejection
+		return &http.Response{StatusCode: http.StatusRequestEntityTooLarge, Status: "413 points over maxFlushBytes"}, 0, nil
+	}
+	if len(batches) > 1 {
+		splitFlushes.Inc(1)
+	}
+	var rejected, last *http.Response
+	var total time.Duration
+	for _, batch := range batches {
+		resp, elapsed, err := postBatch(service, workUnitId, format, batch)
+		total += elapsed
+		if err != nil || resp.StatusCode == api.NotAcceptableStatusCode {
+			// the points are buffered and retried, the oversized points with them
+			return resp, total, err
+		}
+		if rejected == nil && (resp.StatusCode < 200 || resp.StatusCode >= 300) {
+			rejected = resp
+		}
+		last = resp
+	}
+	dropOversized(oversized, maxBytes)
+	if rejected != nil {
+		return rejected, total, nil
+	}
+	return last, total, nil
+}
@@ -0,0 +1,105 @@
+package points
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitByBytes(t *testing.T) {
+	points := []string{"aaaa", "bb", "cccccccccc", "d", "eeeeeeeeeeeeeeee", "ff"}
+	batches, oversized := splitByBytes(points, 10)
+	expected := [][]string{{"aaaa", "bb"}, {"cccccccccc"}, {"d"}, {"ff"}}
+	if fmt.Sprint(batches) != fmt.Sprint(expected) {
+		t.Errorf("Expected batches %v, found %v", expected, batches)
+	}
+	if len(oversized) != 1 || oversized[0] != "eeeeeeeeeeeeeeee" {
+		t.Errorf("Expected 1 oversized point left out, found %v", oversized)
+	}
+	for _, batch := range batches {
+		if size := len(strings.Join(batch, "\n")); size > 10 {
+			t.Errorf("Expected batches of at most 10 bytes, found %d in %v", size, batch)
+		}
+	}
+	if batches, _ := splitByBytes([]string{"toolongpoint"}, 10); len(batches) != 0 {
+		t.Errorf("Expected no batches, found %v", batches)
+	}
+}
+
+func TestHandlerMaxFlushBytes(t *testing.T) {
+	SetMaxFlushBytes(4096)
+	defer SetMaxFlushBytes(0)
+
+	service := &testAPI{}
+	handler, err := newPointHandler("2878", "", 0, time.Second, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.init(1, 60000, 10000, 0, 1000, "wavefront", "", service)
+	tags := map[string]string{"description": strings.Repeat("x", 200), "owner": strings.Repeat("y", 100)}
+	for i := 0; i < 500; i++ {
+		handler.reportPoint(newTestPoint(fmt.Sprintf("foo.%d", i), tags))
+	}
+	handler.reportPoint(newTestPoint("huge", map[string]string{"blob": strings.Repeat("z", 5000)}))
+	handler.stop()
+
+	if service.maxBody > 4096 {
+		t.Errorf("Expected requests of at most 4096 bytes, found %d", service.maxBody)
+	}
+	if service.requests < 500*300/4096 {
+		t.Errorf("Expected the flush split in at least %d requests, found %d", 500*300/4096, service.requests)
+	}
+	if len(service.points) != 500 {
+		t.Fatalf("Expected 500 points sent without the oversized point, found %d", len(service.points))
+	}
+	for i, point := range service.points {
+		if !strings.HasPrefix(point, fmt.Sprintf("\"foo.%d\" ", i)) {
+			t.Fatalf("Expected point %d in order, found %s", i, point)
+		}
+	}
+}
+
+func TestPostSplitFailure(t *testing.T) {
+	service := &testAPI{}
+	failing := &failingAPI{testAPI: service, failAfter: 1}
+	oversized := oversizedFlushPoints.Count()
+	points := []string{"aaaa", "toolong", "bbbb", "cccc"}
+	resp, _, err := postSplit(failing, "", "wavefront", points, 4)
+	if err == nil {
+		t.Fatalf("Expected the failure of the second batch, found %v", resp.StatusCode)
+	}
+	if service.requests != 1 {
+		t.Errorf("Expected the batches after the failure not sent, found %d requests", service.requests)
+	}
+	if n := oversizedFlushPoints.Count() - oversized; n != 0 {
+		t.Errorf("Expected the oversized point not counted before the retry, found %d", n)
+	}
+
+	// the retry of the points counts the oversized point once
+	if _, _, err = postSplit(service, "", "wavefront", points, 4); err != nil {
+		t.Fatal(err)
+	}
+	if n := oversizedFlushPoints.Count() - oversized; n != 1 {
+		t.Errorf("Expected 1 oversized point dropped, found %d", n)
+	}
+
+	resp, _, err = postSplit(service, "", "wavefront", []string{"toolong"}, 4)
+	if err != nil || resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected points over the limit rejected, found %v %v", resp, err)
+	}
+}
+
+// Fails the requests after the first failAfter.
+type failingAPI struct {
+	*testAPI
+	failAfter int
+}
+
+func (a *failingAPI) PostData(workUnitId, format, pointLines string) (*http.Response, error) {
+	if a.requests >= a.failAfter {
+		return &http.Response{}, fmt.Errorf("failed")
+	}
+	return a.testAPI.PostData(workUnitId, format, pointLines)
+}
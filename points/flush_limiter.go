@@ -0,0 +1,109 @@
+package points
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/api"
+	"github.com/wavefronthq/go-proxy/logger"
+)
+
+// limits the flushes in flight across all forwarders, queue replays and shutdown flushes
+var flushLimiter = newFlushSemaphore()
+
+// Bounds the concurrent flushes, each of which holds a copy of its batch, so memory use does
+// not grow with the number of flush threads. Flushes over the limit wait for a free slot.
+type flushSemaphore struct {
+	mtx      sync.Mutex
+	cond     *sync.Cond
+	limit    int // 0 if unlimited
+	inFlight int
+	bytes    int64
+	flushes  metrics.Gauge
+	buffered metrics.Gauge
+}
+
+func newFlushSemaphore() *flushSemaphore {
+	s := &flushSemaphore{
+		flushes:  metrics.GetOrRegisterGauge("push.flushes.inflight", nil),
+		buffered: metrics.GetOrRegisterGauge("push.flushes.bytes", nil),
+	}
+	s.cond = sync.NewCond(&s.mtx)
+	return s
+}
+
+// Sets the max flushes in flight to the Wavefront server, 0 for unlimited.
+func SetMaxConcurrentFlushes(n int) {
+	flushLimiter.setLimit(n)
+}
+
+func (s *flushSemaphore) setLimit(n int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if n == s.limit {
+		return
+	}
+	s.limit = n
+	s.cond.Broadcast()
+	if n > 0 {
+		logger.Infof("Limiting concurrent flushes to %d", n)
+	}
+}
+
+// Blocks until a flush may start.
+func (s *flushSemaphore) acquire() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for s.limit > 0 && s.inFlight >= s.limit {
+		s.cond.Wait()
+	}
+	s.inFlight++
+	s.flushes.Update(int64(s.inFlight))
+}
+
+func (s *flushSemaphore) addBytes(n int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.bytes += int64(n)
+	s.buffered.Update(s.bytes)
+}
+
+func (s *flushSemaphore) release(n int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.inFlight--
+	s.bytes -= int64(n)
+	s.flushes.Update(int64(s.inFlight))
+	s.buffered.Update(s.bytes)
+	s.cond.Signal()
+}
+
+// Posts the points, sorted by timestamp first when enabled, in requests of at most maxFlushBytes
+// when set. Returns the time taken by the requests themselves.
+func postPoints(service api.WavefrontAPI, workUnitId, format string, points []string) (*http.Response, time.Duration, error) {
+	if atomic.LoadInt32(&sortByTimestamp) != 0 {
+		sortLines(points)
+	}
+	if maxBytes := int(atomic.LoadInt64(&maxFlushBytes)); maxBytes > 0 {
+		return postSplit(service, workUnitId, format, points, maxBytes)
+	}
+	return postBatch(service, workUnitId, format, points)
+}
+
+// Posts the points in a request once a flush slot is free. The batch is only joined into its
+// request body after the slot is acquired, so waiting flushes do not hold a second copy of
+// their points.
+func postBatch(service api.WavefrontAPI, workUnitId, format string, points []string) (*http.Response, time.Duration, error) {
+	flushLimiter.acquire()
+	pointLines := strings.Join(points, "\n")
+	flushLimiter.addBytes(len(pointLines))
+	defer flushLimiter.release(len(pointLines))
+
+	start := time.Now()
+	resp, err := service.PostData(workUnitId, format, pointLines)
+	return resp, time.Since(start), err
+}
@@ -0,0 +1,50 @@
+package points
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlushSemaphore(t *testing.T) {
+	s := newFlushSemaphore()
+	s.setLimit(2)
+	s.acquire()
+	s.addBytes(100)
+	s.acquire()
+	s.addBytes(50)
+	if s.flushes.Value() != 2 || s.buffered.Value() != 150 {
+		t.Errorf("Expected 2 flushes of 150 bytes, found %d of %d", s.flushes.Value(), s.buffered.Value())
+	}
+
+	var acquired int32
+	go func() {
+		s.acquire()
+		atomic.StoreInt32(&acquired, 1)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt32(&acquired) != 0 {
+		t.Fatal("Expected the third flush to wait")
+	}
+
+	s.release(100)
+	for i := 0; i < 100 && atomic.LoadInt32(&acquired) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if atomic.LoadInt32(&acquired) != 1 {
+		t.Fatal("Expected the third flush to start after a release")
+	}
+	if s.buffered.Value() != 50 {
+		t.Errorf("Expected 50 bytes in flight, found %d", s.buffered.Value())
+	}
+}
+
+func TestFlushSemaphoreUnlimited(t *testing.T) {
+	s := newFlushSemaphore()
+	for i := 0; i < 10; i++ {
+		s.acquire()
+	}
+	if s.flushes.Value() != 10 {
+		t.Errorf("Expected 10 flushes in flight, found %d", s.flushes.Value())
+	}
+}
@@ -1,26 +1,55 @@
 package points
 
 import (
-	"log"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rcrowley/go-metrics"
 	"github.com/wavefronthq/go-proxy/api"
+	"github.com/wavefronthq/go-proxy/config"
+	"github.com/wavefronthq/go-proxy/logger"
 )
 
+// percent of the max flush size buffered by a forwarder that triggers a flush before
+// the flush interval elapses, disabled if 0
+var flushTriggerPercent int32 = 100
+
+// Sets the percent of pushFlushMaxPoints buffered by a flush thread that flushes the points
+// at once instead of at the next interval, so bursts do not overflow the buffer. Disabled if 0.
+func SetFlushTriggerPercent(percent int) {
+	atomic.StoreInt32(&flushTriggerPercent, int32(percent))
+}
+
+// drops the newest points instead of the oldest when the buffer overflows without a disk buffer
+var dropNewest int32 = 1
+
+// Sets the points dropped when the memory buffer overflows and there is no disk buffer to spool
+// them to: the oldest, so the buffer keeps the latest points as a ring, or the newest, rejecting
+// points received while the buffer is full.
+func SetDropPolicy(policy string) {
+	var v int32 = 1
+	if policy == config.DropPolicyOldest {
+		v = 0
+	}
+	atomic.StoreInt32(&dropNewest, v)
+}
+
 // Interface that forwards points to a Wavefront instance.
 type PointForwarder interface {
 	init()
 	addPoint(point string)
+	buffer(points []string)
+	drain() []string
 	checkOverflow()
 	incrementBlockedPoint()
 	receivedPoints() int64
 	blockedPoints() int64
 	sentPoints() int64
 	queuedPoints() int64
+	bufferedPoints() int
 	stop()
+	redirect(buffer func(points []string))
 }
 
 type DefaultPointForwarder struct {
@@ -30,15 +59,29 @@ type DefaultPointForwarder struct {
 	dataFormat      string
 	points          []string
 	maxBufferSize   int
+	maxBufferBytes  int64 // of all forwarders of the handler, unlimited if 0
 	maxFlushSize    int
 	mtx             sync.Mutex
 	api             api.WavefrontAPI
+	queue           PointQueue
 	pushTicker      *time.Ticker
+	flushInterval   time.Duration
+	flushNow        chan struct{} // signalled when the buffered points reach the flush trigger
+	done            chan struct{}
+	redirected      func(points []string) // buffers the points of failed posts once replaced
+	lastFlush       *int64                // shared with the handler
+	batchSize       *adaptiveBatchSize    // shared with the handler
+	bufferUsage     *bufferUsage          // shared with the handler
 	pointsReceived  metrics.Counter
 	pointsBlocked   metrics.Counter
 	pointsQueued    metrics.Counter
 	pointsSent      metrics.Counter
+	droppedOldest   metrics.Counter
+	droppedNewest   metrics.Counter
+	ingestRate      metrics.Meter
+	flushRate       metrics.Meter
 	pointsFlushTime metrics.Timer
+	earlyFlushes    metrics.Counter
 }
 
 func (f *DefaultPointForwarder) init() {
@@ -46,21 +89,41 @@ func (f *DefaultPointForwarder) init() {
 	f.pointsBlocked = metrics.GetOrRegisterCounter("points."+f.prefix+".blocked", nil)
 	f.pointsQueued = metrics.GetOrRegisterCounter("points."+f.prefix+".queued", nil)
 	f.pointsSent = metrics.GetOrRegisterCounter("points."+f.prefix+".sent", nil)
+	f.droppedOldest = metrics.GetOrRegisterCounter("points."+f.prefix+".dropped.oldest", nil)
+	f.droppedNewest = metrics.GetOrRegisterCounter("points."+f.prefix+".dropped.newest", nil)
+	f.ingestRate = metrics.GetOrRegisterMeter("ingest."+f.prefix+".points", nil)
+	f.flushRate = metrics.GetOrRegisterMeter("flush."+f.prefix+".points", nil)
 	f.pointsFlushTime = metrics.GetOrRegisterTimer("push."+f.prefix+".duration", nil)
+	f.earlyFlushes = metrics.GetOrRegisterCounter("push."+f.prefix+".flushes.early", nil)
+	f.flushNow = make(chan struct{}, 1)
+	f.done = make(chan struct{})
 	go f.flushPoints()
 }
 
 func (f *DefaultPointForwarder) flushPoints() {
-	for range f.pushTicker.C {
-		f.pointsFlushTime.Time(func() {
-			f.post(f.getPointsBatch())
-		})
+	for {
+		select {
+		case <-f.pushTicker.C:
+			f.pointsFlushTime.Time(func() {
+				f.post(f.getPointsBatch())
+			})
+		case <-f.flushNow:
+			f.earlyFlushes.Inc(1)
+			f.pointsFlushTime.Time(func() {
+				f.post(f.getPointsBatch())
+			})
+			// the next flush is a full interval after this one
+			f.pushTicker.Reset(f.flushInterval)
+		case <-f.done:
+			logger.Debugf("%s: exiting flushPoints", f.name)
+			return
+		}
 	}
-	log.Printf("%s: exiting flushPoints", f.name)
 }
 
 func (f *DefaultPointForwarder) stop() {
 	f.pushTicker.Stop()
+	close(f.done)
 }
 
 func min(x, y int) int {
@@ -73,43 +136,101 @@ func min(x, y int) int {
 func (f *DefaultPointForwarder) getPointsBatch() []string {
 	f.mtx.Lock()
 	currLen := len(f.points)
-	batchSize := min(currLen, f.maxFlushSize)
+	batchSize := pushLimiter.acquire(min(currLen, f.batchSize.current()))
 	batchPoints := f.points[:batchSize]
 	f.points = f.points[batchSize:currLen]
 	f.mtx.Unlock()
+	f.bufferUsage.add(-len(batchPoints), -pointsSize(batchPoints))
 	return batchPoints
 }
 
 func (f *DefaultPointForwarder) buffer(points []string) {
 	f.mtx.Lock()
+	if redirected := f.redirected; redirected != nil {
+		f.mtx.Unlock()
+		redirected(points)
+		return
+	}
 	if len(points) > 0 {
-		f.points = append(points, f.points...)
+		// the points may share their array with points buffered elsewhere, e.g. by the
+		// forwarder they were redirected from, so they are not appended to in place
+		merged := make([]string, 0, len(points)+len(f.points))
+		f.points = append(append(merged, points...), f.points...)
 	}
 	f.mtx.Unlock()
+	f.bufferUsage.add(len(points), pointsSize(points))
 	f.checkOverflow()
 }
 
+// Sends the points buffered from now on, such as those of a failed post in flight when the
+// forwarder is replaced, to the given buffer instead.
+func (f *DefaultPointForwarder) redirect(buffer func(points []string)) {
+	f.mtx.Lock()
+	f.redirected = buffer
+	f.mtx.Unlock()
+}
+
+// Removes and returns all buffered points.
+func (f *DefaultPointForwarder) drain() []string {
+	f.mtx.Lock()
+	points := f.points
+	f.points = nil
+	f.mtx.Unlock()
+	f.bufferUsage.add(-len(points), -pointsSize(points))
+	return points
+}
+
 func (f *DefaultPointForwarder) addPoint(point string) {
 	f.pointsReceived.Inc(1)
+	f.ingestRate.Mark(1)
 	f.mtx.Lock()
 	f.points = append(f.points, point)
+	buffered := len(f.points)
 	f.mtx.Unlock()
+	f.bufferUsage.add(1, pointSize(point))
+
+	if trigger := f.maxFlushSize * int(atomic.LoadInt32(&flushTriggerPercent)) / 100; trigger > 0 && buffered >= trigger {
+		select {
+		case f.flushNow <- struct{}{}:
+		default:
+		}
+	}
 }
 
 func (f *DefaultPointForwarder) checkOverflow() {
+	f.mtx.Lock()
 	ptsLength := len(f.points)
-	if ptsLength > f.maxBufferSize {
+	f.mtx.Unlock()
+	if ptsLength > f.maxBufferSize || f.bytesOverflow() > 0 {
 		f.drainToQueue()
 	}
 }
 
+// Returns the bytes buffered by the handler over the byte limit.
+func (f *DefaultPointForwarder) bytesOverflow() int64 {
+	if f.maxBufferBytes <= 0 {
+		return 0
+	}
+	return f.bufferUsage.load() - f.maxBufferBytes
+}
+
+// Queues the oldest points until both the point and byte limits are met, whichever is exceeded.
+// Without a disk buffer the points over the limits are dropped instead, by the drop policy.
 func (f *DefaultPointForwarder) drainToQueue() {
+	if _, ok := f.queue.(DefaultPointQueue); ok {
+		f.dropOverflow()
+		return
+	}
 	f.mtx.Lock()
 	ptsLength := len(f.points)
-	overflow := ptsLength - f.maxBufferSize
-	if overflow > 0 {
+	trimIdx := max(ptsLength-f.maxBufferSize, 0)
+	trimmed := pointsSize(f.points[:trimIdx])
+	for overflow := f.bytesOverflow(); trimIdx < ptsLength && trimmed < overflow; trimIdx++ {
+		trimmed += pointSize(f.points[trimIdx])
+	}
+	if trimIdx > 0 {
 		// provide headroom for arriving points
-		trimIdx := min(overflow+f.maxFlushSize, ptsLength)
+		trimIdx = min(trimIdx+f.maxFlushSize, ptsLength)
 		pointsToQueue := f.points[:trimIdx]
 
 		if trimIdx == ptsLength {
@@ -118,13 +239,54 @@ func (f *DefaultPointForwarder) drainToQueue() {
 			f.points = f.points[trimIdx:]
 		}
 		f.mtx.Unlock()
+		f.bufferUsage.add(-len(pointsToQueue), -pointsSize(pointsToQueue))
 		f.pointsQueued.Inc(int64(len(pointsToQueue)))
-		bufferQueue.queuePoints(pointsToQueue)
+		f.queue.queuePoints(pointsToQueue)
 	} else {
 		f.mtx.Unlock()
 	}
 }
 
+// Drops the oldest or newest points until both the point and byte limits are met. Only the
+// points over the limits are dropped, so with the oldest policy each point received once the
+// buffer is full replaces the oldest one.
+func (f *DefaultPointForwarder) dropOverflow() {
+	newest := atomic.LoadInt32(&dropNewest) != 0
+	f.mtx.Lock()
+	ptsLength := len(f.points)
+	// the i-th point dropped
+	point := func(i int) string {
+		if newest {
+			return f.points[ptsLength-1-i]
+		}
+		return f.points[i]
+	}
+	drop := max(ptsLength-f.maxBufferSize, 0)
+	size := int64(0)
+	for i := 0; i < drop; i++ {
+		size += pointSize(point(i))
+	}
+	for overflow := f.bytesOverflow(); drop < ptsLength && size < overflow; drop++ {
+		size += pointSize(point(drop))
+	}
+	if drop == 0 {
+		f.mtx.Unlock()
+		return
+	}
+	if newest {
+		f.points = f.points[:ptsLength-drop]
+	} else {
+		f.points = f.points[drop:]
+	}
+	f.mtx.Unlock()
+	f.bufferUsage.add(-drop, -size)
+	if newest {
+		f.droppedNewest.Inc(int64(drop))
+	} else {
+		f.droppedOldest.Inc(int64(drop))
+	}
+}
+
 func (f *DefaultPointForwarder) incrementBlockedPoint() {
 	f.pointsBlocked.Inc(1)
 }
@@ -145,21 +307,106 @@ func (f *DefaultPointForwarder) queuedPoints() int64 {
 	return f.pointsQueued.Count()
 }
 
+func (f *DefaultPointForwarder) bufferedPoints() int {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return len(f.points)
+}
+
 func (f *DefaultPointForwarder) post(points []string) {
 	ptsLength := len(points)
 	if ptsLength == 0 {
 		return
 	}
 
-	pointLines := strings.Join(points, "\n")
-	resp, err := f.api.PostData(f.workUnitId, f.dataFormat, pointLines)
+	resp, elapsed, err := sharedFlush.post(f.api, f.workUnitId, f.dataFormat, f.maxFlushSize, points)
+	if err == api.ErrCircuitOpen {
+		// not attempted, kept until the circuit closes
+		f.buffer(points)
+		return
+	}
+	failed := err != nil || resp.StatusCode == api.NotAcceptableStatusCode
+	f.batchSize.record(elapsed, failed)
 
-	if err != nil || (resp.StatusCode == api.NotAcceptableStatusCode) {
+	if failed {
 		if err != nil {
-			log.Printf("%s: error posting data: %v\n", f.name, err)
+			logger.Warnf("%s: error posting data: %v", f.name, err)
 		}
 		f.buffer(points)
 		return
 	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// rejected by the server, counted by the service as they would be rejected again
+		logger.Warnf("%s: dropping %d points rejected by the server: %s", f.name, ptsLength, resp.Status)
+		return
+	}
 	f.pointsSent.Inc(int64(ptsLength))
+	f.flushRate.Mark(int64(ptsLength))
+	recordFlush(f.lastFlush)
+}
+
+// approximate memory used by a buffered point in addition to its characters
+const pointOverhead = 16
+
+func pointSize(point string) int64 {
+	return int64(len(point)) + pointOverhead
+}
+
+func pointsSize(points []string) int64 {
+	size := int64(0)
+	for _, point := range points {
+		size += pointSize(point)
+	}
+	return size
+}
+
+// Points and approximate bytes buffered in memory by the forwarders of a handler, with the most
+// points buffered since the high-water mark was last reset.
+type bufferUsage struct {
+	bytes       int64 // updated atomically
+	points      int64 // updated atomically
+	peak        int64 // updated atomically
+	gauge       metrics.Gauge
+	pointsGauge metrics.Gauge
+	peakGauge   metrics.Gauge
+}
+
+func newBufferUsage(name string) *bufferUsage {
+	b := &bufferUsage{
+		gauge:       metrics.GetOrRegisterGauge("buffer."+name+".memory.bytes", nil),
+		pointsGauge: metrics.GetOrRegisterGauge("buffer."+name+".memory.points", nil),
+		peakGauge:   metrics.GetOrRegisterGauge("buffer."+name+".memory.points.max", nil),
+	}
+	b.gauge.Update(0)
+	b.pointsGauge.Update(0)
+	b.peakGauge.Update(0)
+	return b
+}
+
+func (b *bufferUsage) add(points int, bytes int64) {
+	if bytes != 0 {
+		b.gauge.Update(atomic.AddInt64(&b.bytes, bytes))
+	}
+	if points == 0 {
+		return
+	}
+	n := atomic.AddInt64(&b.points, int64(points))
+	b.pointsGauge.Update(n)
+	for peak := atomic.LoadInt64(&b.peak); n > peak; peak = atomic.LoadInt64(&b.peak) {
+		if atomic.CompareAndSwapInt64(&b.peak, peak, n) {
+			b.peakGauge.Update(atomic.LoadInt64(&b.peak))
+			return
+		}
+	}
+}
+
+// Starts a new high-water mark from the points buffered now.
+func (b *bufferUsage) resetPeak() {
+	n := atomic.LoadInt64(&b.points)
+	atomic.StoreInt64(&b.peak, n)
+	b.peakGauge.Update(n)
+}
+
+func (b *bufferUsage) load() int64 {
+	return atomic.LoadInt64(&b.bytes)
 }
@@ -3,14 +3,16 @@ package points
 import (
 	"bytes"
 	"fmt"
-	"log"
 	"math/rand"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/rcrowley/go-metrics"
 	"github.com/wavefronthq/go-proxy/api"
 	"github.com/wavefronthq/go-proxy/common"
+	"github.com/wavefronthq/go-proxy/logger"
 )
 
 const (
@@ -21,20 +23,43 @@ const (
 
 // Interface that handles the reporting of points.
 type PointHandler interface {
-	init(numTasks, interval, buffer, maxFlush int, dataFormat, workUnitId string, service api.WavefrontAPI)
+	init(numTasks, interval, buffer, bufferBytes, maxFlush int, dataFormat, workUnitId string, service api.WavefrontAPI)
+	update(numTasks, interval, buffer, bufferBytes, maxFlush int)
 	stop()
 	reportPoint(point *common.Point)
 	reportPoints(points []*common.Point)
 	handleBlockedPoint(pointLine string)
+	status() (bufferedPoints int, lastFlush int64)
+	spooledPoints() int64
 }
 
 type DefaultPointHandler struct {
 	name            string
+	mtx             sync.RWMutex
 	pointForwarders []PointForwarder
 	bufPool         sync.Pool
+	queue           PointQueue
+	preprocessor    PointPreprocessor
+	service         api.WavefrontAPI
+	dataFormat      string
+	workUnitId      string
+	maxFlushSize    int
+	batchSize       *adaptiveBatchSize
+	bufferUsage     *bufferUsage
+	shutdownTimeout time.Duration
+	replayTicker    *time.Ticker
+	pointsReplayed  metrics.Counter
+	flushRate       metrics.Meter // shared with the forwarders
+	deltas          *deltaAggregator
+	dedup           *dedupFilter // drops duplicate points when set
+	windowTicker    *time.Ticker
+	done            chan struct{} // closed on stop, ending the ticker loops
+	deltasSent      metrics.Counter
+	lastFlush       int64 // epoch millis, updated atomically
+	unflushed       int64 // points drained on stop and not flushed yet, updated atomically
 }
 
-func (h *DefaultPointHandler) init(numForwarders, flushInterval, maxBufferSize, maxFlushSize int,
+func (h *DefaultPointHandler) init(numForwarders, flushInterval, maxBufferSize, maxBufferBytes, maxFlushSize int,
 	dataFormat, workUnitId string, service api.WavefrontAPI) {
 
 	h.bufPool = sync.Pool{
@@ -43,33 +68,191 @@ func (h *DefaultPointHandler) init(numForwarders, flushInterval, maxBufferSize,
 		},
 	}
 
-	h.pointForwarders = make([]PointForwarder, numForwarders)
+	if h.queue == nil {
+		h.queue = DefaultPointQueue{}
+	}
+	h.service = service
+	h.dataFormat = dataFormat
+	h.workUnitId = workUnitId
+	h.maxFlushSize = maxFlushSize
+	h.batchSize = newAdaptiveBatchSize(h.name, maxFlushSize)
+	h.bufferUsage = newBufferUsage(h.name)
+	h.pointsReplayed = metrics.GetOrRegisterCounter("points."+h.name+".replayed", nil)
+	h.flushRate = metrics.GetOrRegisterMeter("flush."+h.name+".points", nil)
+	h.deltas = newDeltaAggregator()
+	h.deltasSent = metrics.GetOrRegisterCounter("points."+h.name+".deltas.sent", nil)
+
+	h.pointForwarders = h.newForwarders(numForwarders, flushInterval, maxBufferSize, maxBufferBytes, maxFlushSize)
+
+	// replay points spooled by a previous run before accepting new points
+	if !h.replayQueue() {
+		logger.Infof("%s-handler: remaining spooled points will be replayed in the background", h.name)
+	}
+	h.done = make(chan struct{})
+	h.replayTicker = time.NewTicker(time.Millisecond * time.Duration(flushInterval))
+	go h.replay()
+
+	h.windowTicker = time.NewTicker(time.Millisecond * time.Duration(flushInterval))
+	go h.flushWindows()
+
+	go h.printSummary()
+}
+
+func (h *DefaultPointHandler) newForwarders(numForwarders, flushInterval, maxBufferSize, maxBufferBytes, maxFlushSize int) []PointForwarder {
+	forwarders := make([]PointForwarder, numForwarders)
 	for i := 0; i < numForwarders; i++ {
 		pointForwarder := &DefaultPointForwarder{
-			name:          fmt.Sprintf("%s-forwarder-%d", h.name, i),
-			prefix:        h.name,
-			api:           service,
-			dataFormat:    dataFormat,
-			workUnitId:    workUnitId,
-			maxFlushSize:  maxFlushSize,
-			maxBufferSize: maxBufferSize,
-			pushTicker:    time.NewTicker(time.Millisecond * time.Duration(flushInterval)),
+			name:           fmt.Sprintf("%s-forwarder-%d", h.name, i),
+			prefix:         h.name,
+			api:            h.service,
+			dataFormat:     h.dataFormat,
+			workUnitId:     h.workUnitId,
+			maxFlushSize:   maxFlushSize,
+			maxBufferSize:  maxBufferSize,
+			maxBufferBytes: int64(maxBufferBytes),
+			queue:          h.queue,
+			lastFlush:      &h.lastFlush,
+			batchSize:      h.batchSize,
+			bufferUsage:    h.bufferUsage,
+			pushTicker:     time.NewTicker(time.Millisecond * time.Duration(flushInterval)),
+			flushInterval:  time.Millisecond * time.Duration(flushInterval),
 		}
-		h.pointForwarders[i] = pointForwarder
+		forwarders[i] = pointForwarder
 		pointForwarder.init()
 	}
+	return forwarders
+}
 
-	go h.printSummary()
+// Replaces the forwarders with ones using the given settings.
+// Points buffered by the previous forwarders are carried over, as are the points of their
+// failed posts still in flight.
+func (h *DefaultPointHandler) update(numForwarders, flushInterval, maxBufferSize, maxBufferBytes, maxFlushSize int) {
+	forwarders := h.newForwarders(numForwarders, flushInterval, maxBufferSize, maxBufferBytes, maxFlushSize)
+
+	// points are reported with the read lock held, so none are added to the previous
+	// forwarders once replaced
+	h.mtx.Lock()
+	previous := h.pointForwarders
+	h.pointForwarders = forwarders
+	h.maxFlushSize = maxFlushSize
+	h.mtx.Unlock()
+	h.batchSize.setMax(maxFlushSize)
+	h.replayTicker.Reset(time.Millisecond * time.Duration(flushInterval))
+	h.windowTicker.Reset(time.Millisecond * time.Duration(flushInterval))
+
+	for i, forwarder := range previous {
+		next := forwarders[i%len(forwarders)]
+		forwarder.stop()
+		forwarder.redirect(next.buffer)
+		next.buffer(forwarder.drain())
+	}
+	logger.Infof("%s-handler: updated to %d forwarders", h.name, numForwarders)
+}
+
+func (h *DefaultPointHandler) replay() {
+	for {
+		select {
+		case <-h.replayTicker.C:
+			h.replayQueue()
+		case <-h.done:
+			return
+		}
+	}
+}
+
+// Flushes queued segments until the queue is empty or a flush fails.
+// Returns true if the queue was drained.
+func (h *DefaultPointHandler) replayQueue() bool {
+	for {
+		name, points, err := h.queue.nextSegment()
+		if err != nil {
+			logger.Errorf("%s-handler: error reading segment %s: %v", h.name, name, err)
+			return false
+		}
+		if name == "" {
+			return true
+		}
+
+		for start := 0; start < len(points); {
+			batch := points[start:min(start+h.batchSize.current(), len(points))]
+			pushLimiter.wait(len(batch))
+			resp, elapsed, err := postPoints(h.service, h.workUnitId, h.dataFormat, batch)
+			if err == api.ErrCircuitOpen {
+				return false
+			}
+			failed := err != nil || resp.StatusCode == api.NotAcceptableStatusCode
+			h.batchSize.record(elapsed, failed)
+			if failed {
+				return false
+			}
+			start += len(batch)
+			h.pointsReplayed.Inc(int64(len(batch)))
+			h.flushRate.Mark(int64(len(batch)))
+			recordFlush(&h.lastFlush)
+		}
+		h.queue.removeSegment(name)
+	}
+}
+
+// Ends the current flush window, sending the delta counters and forgetting the points
+// seen for deduplication.
+func (h *DefaultPointHandler) flushWindows() {
+	for {
+		select {
+		case <-h.windowTicker.C:
+			h.flushDeltas()
+			if h.dedup != nil {
+				h.dedup.reset()
+			}
+		case <-h.done:
+			return
+		}
+	}
+}
+
+// Forwards the summed delta counters accumulated since the last call.
+func (h *DefaultPointHandler) flushDeltas() {
+	points := h.deltas.flush()
+	if len(points) == 0 {
+		return
+	}
+	lines := make([]string, len(points))
+	for i, point := range points {
+		lines[i] = h.pointToString(point)
+	}
+	h.forward(lines...)
+	h.deltasSent.Inc(int64(len(points)))
 }
 
 func (h *DefaultPointHandler) getForwarder() PointForwarder {
+	h.mtx.RLock()
+	defer h.mtx.RUnlock()
 	index := rand.Intn(len(h.pointForwarders))
 	return h.pointForwarders[index]
 }
 
 func (h *DefaultPointHandler) reportPoint(point *common.Point) {
-	forwarder := h.getForwarder()
-	forwarder.addPoint(h.pointToString(point))
+	if h.preprocessor != nil && !h.preprocessor.Process(point) {
+		return
+	}
+	if h.dedup != nil && h.dedup.duplicate(point) {
+		return
+	}
+	if h.deltas.add(point) {
+		return
+	}
+	h.forward(h.pointToString(point))
+}
+
+// Adds the points to a random forwarder. The read lock is held until they are added, so the
+// forwarder is not replaced and drained by an update meanwhile.
+func (h *DefaultPointHandler) forward(lines ...string) {
+	h.mtx.RLock()
+	defer h.mtx.RUnlock()
+	forwarder := h.pointForwarders[rand.Intn(len(h.pointForwarders))]
+	for _, line := range lines {
+		forwarder.addPoint(line)
+	}
 	forwarder.checkOverflow()
 }
 
@@ -80,22 +263,134 @@ func (h *DefaultPointHandler) reportPoints(points []*common.Point) {
 }
 
 func (h *DefaultPointHandler) handleBlockedPoint(pointLine string) {
-	log.Printf("%s-handler: blocked point: %s", h.name, pointLine)
+	logger.Warnf("%s-handler: blocked point: %s", h.name, pointLine)
+	recordBlockedLine(pointLine, "invalid point")
 	h.getForwarder().incrementBlockedPoint()
 }
 
+// Stops the forwarders and flushes their buffered points. Points that cannot be flushed
+// within the shutdown timeout are spooled to the queue.
 func (h *DefaultPointHandler) stop() {
-	for _, forwarder := range h.pointForwarders {
+	if h.done != nil {
+		close(h.done)
+	}
+	if h.replayTicker != nil {
+		h.replayTicker.Stop()
+	}
+	if h.windowTicker != nil {
+		h.windowTicker.Stop()
+		h.flushDeltas()
+	}
+	h.mtx.RLock()
+	forwarders := h.pointForwarders
+	h.mtx.RUnlock()
+
+	var points []string
+	for _, forwarder := range forwarders {
 		forwarder.stop()
+		points = append(points, forwarder.drain()...)
+	}
+	atomic.StoreInt64(&h.unflushed, int64(len(points)))
+
+	remaining := h.flushRemaining(points)
+	atomic.StoreInt64(&h.unflushed, int64(len(remaining)))
+	if len(remaining) > 0 {
+		if _, ok := h.queue.(DefaultPointQueue); ok {
+			logger.Warnf("%s-handler: %d buffered points lost on shutdown", h.name, len(remaining))
+		} else {
+			logger.Infof("%s-handler: spooling %d buffered points on shutdown", h.name, len(remaining))
+			h.queue.queuePoints(remaining)
+		}
+	}
+	h.queue.close()
+}
+
+// Flushes points until done, a flush fails or the shutdown timeout elapses.
+// Returns the points that were not flushed. A batch still in flight when the
+// timeout elapses is included, favouring duplicates over lost points.
+func (h *DefaultPointHandler) flushRemaining(points []string) []string {
+	if len(points) == 0 {
+		return nil
+	}
+
+	var mtx sync.Mutex
+	next, stopped := 0, false
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			mtx.Lock()
+			if stopped || next >= len(points) {
+				mtx.Unlock()
+				return
+			}
+			end := min(next+h.maxFlushSize, len(points))
+			mtx.Unlock()
+
+			resp, _, err := postPoints(h.service, h.workUnitId, h.dataFormat, points[next:end])
+			if err != nil || resp.StatusCode == api.NotAcceptableStatusCode {
+				return
+			}
+
+			mtx.Lock()
+			next = end
+			if !stopped {
+				atomic.StoreInt64(&h.unflushed, int64(len(points)-end))
+			}
+			mtx.Unlock()
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(h.shutdownTimeout):
+		logger.Warnf("%s-handler: timed out flushing buffered points", h.name)
 	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	stopped = true
+	return points[next:]
+}
+
+// Returns the number of points buffered in memory and the time of the last successful flush.
+// Once stopped, the points that could not be flushed, spooled or lost, are counted as buffered.
+func (h *DefaultPointHandler) status() (int, int64) {
+	h.mtx.RLock()
+	defer h.mtx.RUnlock()
+	buffered := int(atomic.LoadInt64(&h.unflushed))
+	for _, forwarder := range h.pointForwarders {
+		buffered += forwarder.bufferedPoints()
+	}
+	return buffered, atomic.LoadInt64(&h.lastFlush)
+}
+
+// Returns the points queued to the disk buffer and not flushed yet.
+func (h *DefaultPointHandler) spooledPoints() int64 {
+	return h.queue.spooledPoints()
+}
+
+func recordFlush(lastFlush *int64) {
+	atomic.StoreInt64(lastFlush, time.Now().UnixNano()/int64(time.Millisecond))
 }
 
 func (h *DefaultPointHandler) printSummary() {
 	ticker := time.NewTicker(time.Minute * time.Duration(1))
-	for range ticker.C {
-		f := h.getForwarder()
-		log.Printf("[%s] (SUMMARY): points received: %d; sent: %d; blocked: %d; queued: %d", h.name,
-			f.receivedPoints(), f.sentPoints(), f.blockedPoints(), f.queuedPoints())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// the buffer high-water mark covers the points buffered since the last summary
+			h.bufferUsage.resetPeak()
+			f := h.getForwarder()
+			logger.Infof("[%s] (SUMMARY): points received: %d; sent: %d; blocked: %d; queued: %d", h.name,
+				f.receivedPoints(), f.sentPoints(), f.blockedPoints(), f.queuedPoints())
+			if p, ok := h.preprocessor.(tickingPreprocessor); ok {
+				p.tick()
+			}
+		case <-h.done:
+			return
+		}
 	}
 }
 
@@ -104,11 +399,26 @@ func (h *DefaultPointHandler) pointToString(point *common.Point) string {
 	buf := h.bufPool.Get().(*bytes.Buffer)
 	defer h.bufPool.Put(buf)
 	buf.Reset()
-	buf.WriteString(strconv.Quote(point.Name))
-	buf.WriteString(" ")
-	buf.WriteString(point.Value)
-	buf.WriteString(" ")
-	buf.WriteString(strconv.FormatInt(point.Timestamp, 10))
+	if point.Histogram != nil {
+		//<granularity> <timestamp> #<count> <value> [#<count> <value>...] <metricName> source=<source> [pointTags]
+		buf.WriteString(point.Histogram.Granularity)
+		buf.WriteString(" ")
+		buf.WriteString(strconv.FormatInt(point.Timestamp, 10))
+		for _, centroid := range point.Histogram.Centroids {
+			buf.WriteString(" #")
+			buf.WriteString(strconv.Itoa(centroid.Count))
+			buf.WriteString(" ")
+			buf.WriteString(strconv.FormatFloat(centroid.Value, 'f', -1, 64))
+		}
+		buf.WriteString(" ")
+		buf.WriteString(strconv.Quote(point.Name))
+	} else {
+		buf.WriteString(strconv.Quote(point.Name))
+		buf.WriteString(" ")
+		buf.WriteString(point.Value)
+		buf.WriteString(" ")
+		buf.WriteString(strconv.FormatInt(point.Timestamp, 10))
+	}
 	buf.WriteString(" source=")
 	buf.WriteString(strconv.Quote(point.Source))
 
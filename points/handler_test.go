@@ -1,17 +1,338 @@
 package points
 
 import (
+	"errors"
 	"fmt"
-	"github.com/wavefronthq/go-proxy/api"
-	"github.com/wavefronthq/go-proxy/common"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/wavefronthq/go-proxy/api"
+	"github.com/wavefronthq/go-proxy/common"
+	"github.com/wavefronthq/go-proxy/config"
 )
 
+// WavefrontAPI that records posted points
+type testAPI struct {
+	mtx      sync.Mutex
+	points   []string
+	requests int
+	maxBody  int // bytes of the largest request
+	delay    time.Duration
+}
+
+func (a *testAPI) GetConfig(currentMillis, bytesLeft, bytesPerMinute, currentQueueSize int64) (*config.AgentConfig, error) {
+	return nil, nil
+}
+
+func (a *testAPI) Checkin(currentMillis int64, localAgent, pushAgent, ephemeral bool, agentMetrics []byte) (*config.AgentConfig, error) {
+	return nil, nil
+}
+
+func (a *testAPI) PostData(workUnitId, format, pointLines string) (*http.Response, error) {
+	time.Sleep(a.delay)
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	a.points = append(a.points, strings.Split(pointLines, "\n")...)
+	a.requests++
+	a.maxBody = max(a.maxBody, len(pointLines))
+	return &http.Response{StatusCode: http.StatusAccepted}, nil
+}
+
+func (a *testAPI) AgentError(details string) {}
+
+func (a *testAPI) AgentConfigProcessed() error {
+	return nil
+}
+
+func (a *testAPI) postedPoints() int {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	return len(a.points)
+}
+
+func TestHandlerStopFlushesPoints(t *testing.T) {
+	service := &testAPI{}
+	handler, err := newPointHandler("2878", "", 0, time.Second, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.init(1, 60000, 1000, 0, 2, "wavefront", "", service)
+	for i := 0; i < 5; i++ {
+		handler.reportPoint(newTestPoint("foo", nil))
+	}
+	handler.stop()
+
+	if posted := service.postedPoints(); posted != 5 {
+		t.Errorf("Expected 5 points flushed on stop, found %d", posted)
+	}
+	if buffered, _ := handler.status(); buffered != 0 {
+		t.Errorf("Expected no points buffered once flushed, found %d", buffered)
+	}
+}
+
+func TestHandlerStopSpoolsPoints(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wavefront-handler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// only flushed on stop
+	SetFlushTriggerPercent(0)
+	defer SetFlushTriggerPercent(100)
+
+	service := &testAPI{delay: time.Second}
+	handler, err := newPointHandler("2878", dir, 1024*1024, 100*time.Millisecond, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.init(1, 60000, 1000, 0, 2, "wavefront", "", service)
+	for i := 0; i < 5; i++ {
+		handler.reportPoint(newTestPoint("foo", nil))
+	}
+	handler.stop()
+
+	// points not flushed within the timeout, including the batch in flight, are spooled
+	_, points, err := handler.(*DefaultPointHandler).queue.nextSegment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 5 {
+		t.Errorf("Expected 5 points spooled on stop, found %d", len(points))
+	}
+	if buffered, _ := handler.status(); buffered != 5 {
+		t.Errorf("Expected the 5 points not flushed reported as buffered, found %d", buffered)
+	}
+}
+
+func TestHandlerBufferBytesLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wavefront-handler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	SetFlushTriggerPercent(0)
+	defer SetFlushTriggerPercent(100)
+
+	// each point takes 48 bytes, so 4 fit within the limit while the point limit is not reached
+	h, err := newPointHandler("2879", dir, 1024*1024, time.Second, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := h.(*DefaultPointHandler)
+	handler.init(1, 60000, 1000, 200, 2, "wavefront", "", &testAPI{})
+	defer handler.stop()
+	queued := handler.getForwarder().queuedPoints()
+	for i := 0; i < 10; i++ {
+		handler.reportPoint(newTestPoint("foo", nil))
+	}
+
+	if buffered, _ := handler.status(); buffered != 4 {
+		t.Errorf("Expected 4 points buffered, found %d", buffered)
+	}
+	if bytes := handler.bufferUsage.load(); bytes != 4*48 {
+		t.Errorf("Expected %d bytes buffered, found %d", 4*48, bytes)
+	}
+	if value := handler.bufferUsage.gauge.Value(); value != 4*48 {
+		t.Errorf("Expected the gauge to report %d bytes, found %d", 4*48, value)
+	}
+	if queued := handler.getForwarder().queuedPoints() - queued; queued != 6 {
+		t.Errorf("Expected 6 points spooled, found %d", queued)
+	}
+}
+
+func TestHandlerBufferGauges(t *testing.T) {
+	SetFlushTriggerPercent(0)
+	defer SetFlushTriggerPercent(100)
+
+	h, err := newPointHandler("2895", "", 0, time.Second, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := h.(*DefaultPointHandler)
+	handler.init(1, 60000, 1000, 0, 100, "wavefront", "", &testAPI{})
+	defer handler.stop()
+	for i := 0; i < 5; i++ {
+		handler.reportPoint(newTestPoint("foo", nil))
+	}
+	usage := handler.bufferUsage
+	if usage.pointsGauge.Value() != 5 || usage.peakGauge.Value() != 5 {
+		t.Errorf("Expected 5 points and a high-water mark of 5, found %d and %d", usage.pointsGauge.Value(), usage.peakGauge.Value())
+	}
+
+	// the high-water mark is kept once the points are flushed, until reset
+	handler.getForwarder().(*DefaultPointForwarder).getPointsBatch()
+	if usage.pointsGauge.Value() != 0 || usage.peakGauge.Value() != 5 {
+		t.Errorf("Expected no points and a high-water mark of 5, found %d and %d", usage.pointsGauge.Value(), usage.peakGauge.Value())
+	}
+	handler.reportPoint(newTestPoint("foo", nil))
+	usage.resetPeak()
+	if usage.peakGauge.Value() != 1 {
+		t.Errorf("Expected the high-water mark reset to the 1 point buffered, found %d", usage.peakGauge.Value())
+	}
+}
+
+func TestHandlerFlushTrigger(t *testing.T) {
+	service := &testAPI{}
+	h, err := newPointHandler("2880", "", 0, time.Second, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := h.(*DefaultPointHandler)
+	handler.init(1, 60000, 1000, 0, 4, "wavefront", "", service)
+	defer handler.stop()
+
+	// flushed once 4 points are buffered, rather than after the 60s interval
+	for i := 0; i < 3; i++ {
+		handler.reportPoint(newTestPoint("foo", nil))
+	}
+	time.Sleep(100 * time.Millisecond)
+	if posted := service.postedPoints(); posted != 0 {
+		t.Errorf("Expected no points flushed below the trigger, found %d", posted)
+	}
+	handler.reportPoint(newTestPoint("foo", nil))
+	deadline := time.Now().Add(time.Second)
+	for service.postedPoints() < 4 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if posted := service.postedPoints(); posted != 4 {
+		t.Errorf("Expected 4 points flushed early, found %d", posted)
+	}
+}
+
+func TestHistogramToString(t *testing.T) {
+	h, err := newPointHandler("2878", "", 0, time.Second, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := h.(*DefaultPointHandler)
+	handler.init(1, 60000, 1000, 0, 2, "histogram", "", &testAPI{})
+	defer handler.stop()
+
+	point := newTestPoint("foo.latency", nil)
+	point.Histogram = &common.Histogram{
+		Granularity: "!M",
+		Centroids:   []common.Centroid{{Value: 10, Count: 2}, {Value: 30.5, Count: 1}},
+	}
+	expected := "!M 1505454047 #2 10 #1 30.5 \"foo.latency\" source=\"test\""
+	if line := handler.pointToString(point); line != expected {
+		t.Errorf("Expected %s, found %s", expected, line)
+	}
+}
+
+func TestHandlerDropPolicy(t *testing.T) {
+	SetFlushTriggerPercent(0)
+	defer SetFlushTriggerPercent(100)
+	defer SetDropPolicy(config.DropPolicyNewest)
+
+	SetDropPolicy("")
+	if atomic.LoadInt32(&dropNewest) != 1 {
+		t.Error("Expected the newest points dropped by default")
+	}
+	for _, policy := range []string{config.DropPolicyOldest, config.DropPolicyNewest} {
+		SetDropPolicy(policy)
+		// no disk buffer, so the points over the limit of 3 are dropped
+		h, err := newPointHandler("2882", "", 0, time.Second, nil, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler := h.(*DefaultPointHandler)
+		handler.init(1, 60000, 3, 0, 2, "wavefront", "", &testAPI{})
+		f := handler.getForwarder().(*DefaultPointForwarder)
+		oldest, newest := f.droppedOldest.Count(), f.droppedNewest.Count()
+		for i := 0; i < 5; i++ {
+			handler.reportPoint(&common.Point{Name: "foo", Value: strconv.Itoa(i), Timestamp: 1, Source: "bar"})
+		}
+
+		expected := []string{`"foo" 0 1 source="bar"`, `"foo" 1 1 source="bar"`, `"foo" 2 1 source="bar"`}
+		if policy == config.DropPolicyOldest {
+			expected = []string{`"foo" 2 1 source="bar"`, `"foo" 3 1 source="bar"`, `"foo" 4 1 source="bar"`}
+		}
+		points := f.drain()
+		if !reflect.DeepEqual(points, expected) {
+			t.Errorf("%s: expected %v buffered, found %v", policy, expected, points)
+		}
+		dropped := f.droppedOldest.Count() - oldest
+		if policy == config.DropPolicyNewest {
+			dropped = f.droppedNewest.Count() - newest
+		}
+		if dropped != 2 || f.queuedPoints() != 0 {
+			t.Errorf("%s: expected 2 points dropped and none queued, found %d and %d", policy, dropped, f.queuedPoints())
+		}
+		handler.stop()
+	}
+}
+
+// WavefrontAPI failing every other post while failing is set
+type flakyAPI struct {
+	testAPI
+	failing int32
+	posts   int32
+}
+
+func (a *flakyAPI) PostData(workUnitId, format, pointLines string) (*http.Response, error) {
+	if atomic.LoadInt32(&a.failing) == 1 && atomic.AddInt32(&a.posts, 1)%2 == 0 {
+		// still in flight while the forwarders are replaced
+		time.Sleep(5 * time.Millisecond)
+		return nil, errors.New("unavailable")
+	}
+	return a.testAPI.PostData(workUnitId, format, pointLines)
+}
+
+func TestHandlerUpdateKeepsPoints(t *testing.T) {
+	service := &flakyAPI{failing: 1}
+	handler, err := newPointHandler("2878", "", 0, time.Second, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.init(2, 1000, 100000, 0, 10, "wavefront", "", service)
+
+	// points are reported and failed posts buffered again while the forwarders are replaced
+	var reported int
+	updating, done := int32(1), make(chan struct{})
+	go func() {
+		defer close(done)
+		for ; atomic.LoadInt32(&updating) == 1; reported++ {
+			handler.reportPoint(newTestPoint("foo."+strconv.Itoa(reported), nil))
+			if reported%10 == 0 {
+				time.Sleep(100 * time.Microsecond)
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		time.Sleep(5 * time.Millisecond)
+		handler.update(2+i%3, 1000, 100000, 0, 10)
+	}
+	atomic.StoreInt32(&updating, 0)
+	<-done
+	atomic.StoreInt32(&service.failing, 0)
+	time.Sleep(50 * time.Millisecond)
+	handler.stop()
+
+	posted := make(map[string]bool)
+	service.mtx.Lock()
+	for _, point := range service.points {
+		posted[point] = true
+	}
+	service.mtx.Unlock()
+	if reported == 0 || len(posted) != reported {
+		t.Errorf("Expected all %d points posted across the updates, found %d", reported, len(posted))
+	}
+}
+
 func BenchmarkPointToStringBase(b *testing.B) {
 	p := getPoint(1)
 	h := &DefaultPointHandler{}
-	h.init(2, 1000, 0, 0, "", "", &api.WavefrontAPIService{})
+	h.init(2, 1000, 0, 0, 0, "", "", &api.WavefrontAPIService{})
 	for i := 0; i < b.N; i++ {
 		h.pointToString(p)
 	}
@@ -20,7 +341,7 @@ func BenchmarkPointToStringBase(b *testing.B) {
 func BenchmarkPointToStringComplex(b *testing.B) {
 	p := getPoint(10)
 	h := &DefaultPointHandler{}
-	h.init(2, 1000, 0, 0, "", "", &api.WavefrontAPIService{})
+	h.init(2, 1000, 0, 0, 0, "", "", &api.WavefrontAPIService{})
 	for i := 0; i < b.N; i++ {
 		h.pointToString(p)
 	}
@@ -0,0 +1,289 @@
+package points
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/wavefronthq/go-proxy/api"
+	"github.com/wavefronthq/go-proxy/common"
+	"github.com/wavefronthq/go-proxy/logger"
+	"github.com/wavefronthq/go-proxy/points/decoder"
+)
+
+// bytes of a request body read by the http listener, compressed or decompressed
+const MaxRequestLength = 32 << 20
+
+var errRequestTooLarge = errors.New("request body too large")
+
+// Listener that accepts newline separated points POSTed to /report, and OpenTSDB JSON
+// data points POSTed to /api/put.
+type HTTPPointListener struct {
+	Port         int
+	Host         string // interface to listen on, all interfaces if empty
+	Builder      decoder.DecoderBuilder
+	BufferDir    string // spools points exceeding the memory buffer to disk when set
+	DiskLimit    int64  // max bytes spooled to disk
+	Preprocessor PointPreprocessor
+	// time allowed to flush buffered points when stopped
+	ShutdownTimeout time.Duration
+	// drops duplicate points received within a flush window
+	Dedup bool
+	// decoders for points posted in other formats, selected with the format query parameter
+	FormatBuilders map[string]decoder.DecoderBuilder
+	// time allowed for requests in progress to complete when stopped, they are closed at once if 0
+	DrainTimeout time.Duration
+	// sets the timestamps of points to the time they are received
+	UseProxyTime bool
+	handler      PointHandler
+	server       *http.Server
+	running      int32
+}
+
+func (l *HTTPPointListener) Start(numForwarders, flushInterval, bufferSize, bufferBytes, maxFlushSize int,
+	format, workUnitId string, service api.WavefrontAPI) error {
+
+	logger.Infof("Starting http listener on %s", l.address())
+	listener, err := net.Listen("tcp", listenAddr(l.Host, l.Port))
+	if err != nil {
+		return err
+	}
+
+	numForwarders, flushInterval = checkFlushSettings(numForwarders, flushInterval)
+	handler, err := newPointHandler(strconv.Itoa(l.Port), l.BufferDir, l.DiskLimit, l.ShutdownTimeout, l.Preprocessor, l.Dedup)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+	l.handler = handler
+	l.handler.init(numForwarders, flushInterval, bufferSize, bufferBytes, maxFlushSize, format, workUnitId, service)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/report", l.report)
+	mux.HandleFunc("/api/put", l.openTSDBPut)
+	l.server = &http.Server{Handler: mux}
+
+	go func() {
+		if err := l.server.Serve(listener); err != http.ErrServerClosed {
+			logger.Errorf("%d-listener: %v", l.Port, err)
+		}
+	}()
+	atomic.StoreInt32(&l.running, 1)
+	logger.Infof("Configured %d forwarders for %s http listener on %s", numForwarders, format, l.address())
+	return nil
+}
+
+func (l *HTTPPointListener) address() string {
+	if l.Host != "" {
+		return "address: " + listenAddr(l.Host, l.Port)
+	}
+	return fmt.Sprintf("port: %d", l.Port)
+}
+
+// Reports the points of the request once its body is read, responding 202 with the number of
+// points that failed to decode, if any, so clients do not post the valid points again.
+func (l *HTTPPointListener) report(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := requestBody(w, r)
+	if err != nil {
+		http.Error(w, "Invalid gzip body", http.StatusBadRequest)
+		return
+	}
+	defer body.Close()
+
+	builder := l.Builder
+	if format := r.URL.Query().Get("format"); format != "" {
+		var ok bool
+		if builder, ok = l.FormatBuilders[format]; !ok {
+			http.Error(w, "Unsupported format "+format, http.StatusBadRequest)
+			return
+		}
+	}
+
+	var pd decoder.PointDecoder = builder.Build()
+	var points []*common.Point
+	var blocked []string
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		pointBytes := scanner.Bytes()
+		if len(pointBytes) == 0 {
+			continue
+		}
+		decoded, err := pd.Decode(pointBytes)
+		if err != nil {
+			blocked = append(blocked, string(pointBytes))
+			continue
+		}
+		points = append(points, decoded...)
+	}
+
+	if err := scanner.Err(); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if l.UseProxyTime {
+		stampProxyTime(points)
+	}
+	l.handler.reportPoints(points)
+	for _, line := range blocked {
+		l.handler.handleBlockedPoint(line)
+	}
+	w.WriteHeader(http.StatusAccepted)
+	if len(blocked) > 0 {
+		fmt.Fprintf(w, "%d points failed to decode\n", len(blocked))
+	}
+}
+
+// Returns the body of the request, decompressed if gzip encoded. Reads fail once more than
+// MaxRequestLength bytes are read, compressed or decompressed.
+func requestBody(w http.ResponseWriter, r *http.Request) (io.ReadCloser, error) {
+	body := http.MaxBytesReader(w, r.Body, MaxRequestLength)
+	if r.Header.Get("Content-Encoding") != "gzip" {
+		return body, nil
+	}
+	gz, err := gzip.NewReader(body)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{&limitedReader{r: gz, n: MaxRequestLength}, body}, nil
+}
+
+// Reader failing with errRequestTooLarge once more than n bytes are read.
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	if l.n -= int64(n); l.n < 0 {
+		return n, errRequestTooLarge
+	}
+	return n, err
+}
+
+// Responds 413 if the body is too large, otherwise 400.
+func writeBodyError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if err == errRequestTooLarge || errors.As(err, &maxBytesErr) {
+		http.Error(w, "Request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, "Error reading body: "+err.Error(), http.StatusBadRequest)
+}
+
+// Response to an /api/put request with the summary or details query parameter.
+type openTSDBPutResponse struct {
+	Failed  int                `json:"failed"`
+	Success int                `json:"success"`
+	Errors  []openTSDBPutError `json:"errors,omitempty"`
+}
+
+type openTSDBPutError struct {
+	DataPoint json.RawMessage `json:"datapoint"`
+	Error     string          `json:"error"`
+}
+
+// Accepts a data point or an array of data points in the OpenTSDB JSON format. Like OpenTSDB,
+// it responds 204 when all were decoded, unless the summary or details of the data points
+// are requested, and 400 when any failed.
+func (l *HTTPPointListener) openTSDBPut(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := requestBody(w, r)
+	if err != nil {
+		http.Error(w, "Invalid gzip body", http.StatusBadRequest)
+		return
+	}
+	defer body.Close()
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	dataPoints, err := decoder.SplitOpenTSDBPut(b)
+	if err != nil {
+		http.Error(w, "Invalid body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, details := r.URL.Query()["details"]
+	_, summary := r.URL.Query()["summary"]
+	var resp openTSDBPutResponse
+	pd := decoder.OpenTSDBJSONBuilder{}.Build()
+	for _, dp := range dataPoints {
+		points, err := pd.Decode(dp)
+		if err != nil {
+			resp.Failed++
+			l.handler.handleBlockedPoint(string(dp))
+			if details {
+				resp.Errors = append(resp.Errors, openTSDBPutError{DataPoint: dp, Error: err.Error()})
+			}
+			continue
+		}
+		resp.Success++
+		if l.UseProxyTime {
+			stampProxyTime(points)
+		}
+		l.handler.reportPoints(points)
+	}
+
+	status := http.StatusOK
+	if resp.Failed > 0 {
+		status = http.StatusBadRequest
+	} else if !details && !summary {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (l *HTTPPointListener) Update(numForwarders, flushInterval, bufferSize, bufferBytes, maxFlushSize int) {
+	numForwarders, flushInterval = checkFlushSettings(numForwarders, flushInterval)
+	l.handler.update(numForwarders, flushInterval, bufferSize, bufferBytes, maxFlushSize)
+}
+
+func (l *HTTPPointListener) Stop() {
+	logger.Info("Stopping http listener", l.Port)
+	atomic.StoreInt32(&l.running, 0)
+	if l.DrainTimeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), l.DrainTimeout)
+		if err := l.server.Shutdown(ctx); err != nil {
+			logger.Warnf("%d-listener: closing requests still in progress: %v", l.Port, err)
+		}
+		cancel()
+	}
+	l.server.Close()
+	l.handler.stop()
+}
+
+func (l *HTTPPointListener) Status() ListenerStatus {
+	status := newListenerStatus(l.Port, ProtocolHTTP, atomic.LoadInt32(&l.running) == 1, l.handler)
+	status.Host = l.Host
+	return status
+}
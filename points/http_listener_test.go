@@ -0,0 +1,227 @@
+package points
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/wavefronthq/go-proxy/api"
+	"github.com/wavefronthq/go-proxy/common"
+	"github.com/wavefronthq/go-proxy/points/decoder"
+)
+
+// PointHandler that records reported points
+type testPointHandler struct {
+	mtx     sync.Mutex
+	points  []*common.Point
+	blocked []string
+}
+
+func (h *testPointHandler) init(numTasks, interval, buffer, bufferBytes, maxFlush int, dataFormat, workUnitId string, service api.WavefrontAPI) {
+}
+
+func (h *testPointHandler) update(numTasks, interval, buffer, bufferBytes, maxFlush int) {}
+
+func (h *testPointHandler) stop() {}
+
+func (h *testPointHandler) reportPoint(point *common.Point) {
+	h.mtx.Lock()
+	h.points = append(h.points, point)
+	h.mtx.Unlock()
+}
+
+func (h *testPointHandler) reportPoints(points []*common.Point) {
+	for _, point := range points {
+		h.reportPoint(point)
+	}
+}
+
+func (h *testPointHandler) count() int {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	return len(h.points)
+}
+
+func (h *testPointHandler) status() (int, int64) {
+	return 0, 0
+}
+
+func (h *testPointHandler) spooledPoints() int64 {
+	return 0
+}
+
+func (h *testPointHandler) handleBlockedPoint(pointLine string) {
+	h.mtx.Lock()
+	h.blocked = append(h.blocked, pointLine)
+	h.mtx.Unlock()
+}
+
+func TestHTTPReport(t *testing.T) {
+	handler := &testPointHandler{}
+	l := &HTTPPointListener{Builder: decoder.GraphiteBuilder{}, handler: handler}
+
+	body := "foo.metric 1 source=a\nfoo.metric 2 source=b\n"
+	resp := doReport(l, strings.NewReader(body), "")
+	if resp.Code != http.StatusAccepted || len(handler.points) != 2 {
+		t.Errorf("Expected 202 and 2 points, found %d and %d", resp.Code, len(handler.points))
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(body))
+	gz.Close()
+	resp = doReport(l, &buf, "gzip")
+	if resp.Code != http.StatusAccepted || len(handler.points) != 4 {
+		t.Errorf("Expected 202 and 4 points, found %d and %d", resp.Code, len(handler.points))
+	}
+
+	resp = doReport(l, strings.NewReader("foo.metric 1 source=a\nfoo.metric\n"), "")
+	if resp.Code != http.StatusAccepted || len(handler.points) != 5 || len(handler.blocked) != 1 {
+		t.Errorf("Expected 202 with 5 points, found %d with %d points", resp.Code, len(handler.points))
+	}
+	if body := strings.TrimSpace(resp.Body.String()); body != "1 points failed to decode" {
+		t.Errorf("Unexpected response %q", body)
+	}
+}
+
+func TestHTTPReportTooLarge(t *testing.T) {
+	handler := &testPointHandler{}
+	l := &HTTPPointListener{Builder: decoder.GraphiteBuilder{}, handler: handler}
+
+	line := []byte("foo.metric 1 source=a\n")
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	for n := 0; n <= MaxRequestLength; n += len(line) {
+		gz.Write(line)
+	}
+	gz.Close()
+	if buf.Len() >= MaxRequestLength {
+		t.Fatalf("Expected a compressed body smaller than the limit, found %d bytes", buf.Len())
+	}
+	resp := doReport(l, &buf, "gzip")
+	if resp.Code != http.StatusRequestEntityTooLarge || handler.count() != 0 {
+		t.Errorf("Expected 413 and no points, found %d and %d", resp.Code, handler.count())
+	}
+
+	resp = doReport(l, bytes.NewReader(bytes.Repeat(line, MaxRequestLength/len(line)+1)), "")
+	if resp.Code != http.StatusRequestEntityTooLarge || handler.count() != 0 {
+		t.Errorf("Expected 413 and no points, found %d and %d", resp.Code, handler.count())
+	}
+}
+
+func TestHTTPListenerPortInUse(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	l := &HTTPPointListener{Host: "127.0.0.1", Port: ln.Addr().(*net.TCPAddr).Port, Builder: decoder.GraphiteBuilder{}}
+	if err := l.Start(1, 1000, 100, 0, 100, "graphite_v2", "wu", &api.WavefrontAPIService{}); err == nil {
+		l.Stop()
+		t.Fatal("Expected an error starting a listener on a port in use")
+	}
+	if l.Status().Running {
+		t.Error("Expected the listener not to be running")
+	}
+}
+
+func TestHTTPListenerBufferError(t *testing.T) {
+	// the buffer cannot be created under a file
+	file, err := ioutil.TempFile("", "wavefront-buffer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	l := &HTTPPointListener{Host: "127.0.0.1", Builder: decoder.GraphiteBuilder{}, BufferDir: file.Name()}
+	if err := l.Start(1, 1000, 100, 0, 100, "graphite_v2", "wu", &api.WavefrontAPIService{}); err == nil {
+		l.Stop()
+		t.Fatal("Expected an error starting a listener whose buffer cannot be created")
+	}
+	if l.Status().Running {
+		t.Error("Expected the listener not to be running")
+	}
+}
+
+func doReport(l *HTTPPointListener, body io.Reader, encoding string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/report", body)
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	resp := httptest.NewRecorder()
+	l.report(resp, req)
+	return resp
+}
+
+func TestHTTPReportFormat(t *testing.T) {
+	csv, err := decoder.NewCSVBuilder(",", decoder.DefaultCSVColumns, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &testPointHandler{}
+	l := &HTTPPointListener{
+		Builder:        decoder.GraphiteBuilder{},
+		FormatBuilders: map[string]decoder.DecoderBuilder{"csv": csv},
+		handler:        handler,
+	}
+
+	req := httptest.NewRequest("POST", "/report?format=csv", strings.NewReader("foo.metric,1,1500000000,source=a\n"))
+	resp := httptest.NewRecorder()
+	l.report(resp, req)
+	if resp.Code != http.StatusAccepted || len(handler.points) != 1 || handler.points[0].Source != "a" {
+		t.Errorf("Expected 202 and 1 point from source a, found %d and %v", resp.Code, handler.points)
+	}
+
+	req = httptest.NewRequest("POST", "/report?format=xml", strings.NewReader("foo.metric 1 source=a\n"))
+	resp = httptest.NewRecorder()
+	l.report(resp, req)
+	if resp.Code != http.StatusBadRequest || len(handler.points) != 1 {
+		t.Errorf("Expected 400 for an unsupported format, found %d", resp.Code)
+	}
+}
+
+func TestHTTPOpenTSDBPut(t *testing.T) {
+	handler := &testPointHandler{}
+	l := &HTTPPointListener{Builder: decoder.GraphiteBuilder{}, handler: handler}
+	put := func(query, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/put"+query, strings.NewReader(body))
+		resp := httptest.NewRecorder()
+		l.openTSDBPut(resp, req)
+		return resp
+	}
+
+	resp := put("", `{"metric": "sys.cpu.nice", "timestamp": 1346846400, "value": 18, "tags": {"host": "web01"}}`)
+	if resp.Code != http.StatusNoContent || len(handler.points) != 1 {
+		t.Errorf("Expected 204 and 1 point, found %d and %d", resp.Code, len(handler.points))
+	}
+
+	body := `[{"metric": "sys.cpu.nice", "timestamp": 1346846400, "value": 18, "tags": {"host": "web01"}},
+		{"metric": "sys.cpu.nice", "timestamp": 1346846400, "value": 18}]`
+	resp = put("?details", body)
+	expected := `{"failed":1,"success":1,"errors":[{"datapoint":{"metric":"sys.cpu.nice","timestamp":1346846400,"value":18},"error":"Missing source tag"}]}`
+	if resp.Code != http.StatusBadRequest || strings.TrimSpace(resp.Body.String()) != expected {
+		t.Errorf("Expected 400 with details, found %d %s", resp.Code, resp.Body.String())
+	}
+	if len(handler.points) != 2 || len(handler.blocked) != 1 {
+		t.Errorf("Expected 2 points and 1 blocked, found %d and %d", len(handler.points), len(handler.blocked))
+	}
+
+	resp = put("?summary", `{"metric": "sys.cpu.nice", "value": 18, "tags": {"host": "web01"}}`)
+	if resp.Code != http.StatusOK || strings.TrimSpace(resp.Body.String()) != `{"failed":0,"success":1}` {
+		t.Errorf("Expected 200 with a summary, found %d %s", resp.Code, resp.Body.String())
+	}
+
+	if resp = put("", "sys.cpu.nice 1346846400 18 host=web01"); resp.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a telnet line, found %d", resp.Code)
+	}
+}
@@ -0,0 +1,66 @@
+package points
+
+import (
+	"bufio"
+	"bytes"
+)
+
+// Returns a split function for a bufio.Scanner which splits lines like bufio.ScanLines,
+// but skips lines longer than maxLength instead of failing, calling onSkip for each.
+// The scanner buffer must allow tokens of at least maxLength+2 bytes.
+func scanLines(maxLength int, onSkip func()) bufio.SplitFunc {
+	split := skipLines(maxLength, onSkip)
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		// skipped lines are consumed with the next line, as the scanner stops at the end of the
+		// input once a split returns no token, dropping the lines still buffered
+		consumed := 0
+		for {
+			advance, token, err := split(data[consumed:], atEOF)
+			consumed += advance
+			if token != nil || err != nil || advance == 0 || consumed == len(data) {
+				return consumed, token, err
+			}
+		}
+	}
+}
+
+// Splits a line, or skips a line longer than maxLength returning no token.
+func skipLines(maxLength int, onSkip func()) bufio.SplitFunc {
+	skipping := false
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			if skipping {
+				skipping = false
+				return i + 1, nil, nil
+			}
+			line := dropCR(data[:i])
+			if len(line) > maxLength {
+				onSkip()
+				return i + 1, nil, nil
+			}
+			return i + 1, line, nil
+		}
+		if skipping || len(dropCR(data)) > maxLength {
+			// discard the start of the line and keep skipping until its end
+			if !skipping {
+				skipping = true
+				onSkip()
+			}
+			return len(data), nil, nil
+		}
+		if atEOF {
+			return len(data), dropCR(data), nil
+		}
+		return 0, nil, nil
+	}
+}
+
+func dropCR(data []byte) []byte {
+	if len(data) > 0 && data[len(data)-1] == '\r' {
+		return data[:len(data)-1]
+	}
+	return data
+}
@@ -2,31 +2,259 @@ package points
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/rcrowley/go-metrics"
 	"github.com/wavefronthq/go-proxy/api"
+	"github.com/wavefronthq/go-proxy/logger"
 	"github.com/wavefronthq/go-proxy/points/decoder"
 )
 
 // Interface that handles listening for points.
 type PointListener interface {
-	Start(numForwarders, flushInterval, bufferSize, maxFlushSize int, format, workUnitId string, service api.WavefrontAPI)
+	// Returns an error, with nothing left running, if the listener cannot listen on its address.
+	Start(numForwarders, flushInterval, bufferSize, bufferBytes, maxFlushSize int, format, workUnitId string, service api.WavefrontAPI) error
+	Update(numForwarders, flushInterval, bufferSize, bufferBytes, maxFlushSize int)
 	Stop()
+	Status() ListenerStatus
 }
 
+// Listener state reported by the health endpoints.
+type ListenerStatus struct {
+	Port           int    `json:"port"`
+	Host           string `json:"host,omitempty"` // bound interface, all if empty
+	Protocol       string `json:"protocol"`
+	Path           string `json:"path,omitempty"` // socket path of unix listeners
+	Running        bool   `json:"running"`
+	BufferedPoints int    `json:"bufferedPoints"`
+	SpooledPoints  int64  `json:"spooledPoints"` // queued to the disk buffer
+	LastFlush      int64  `json:"lastFlush"`     // epoch millis of the last successful flush, 0 if none
+}
+
+const (
+	ProtocolTCP  = "tcp"
+	ProtocolUDP  = "udp"
+	ProtocolHTTP = "http"
+	ProtocolUnix = "unix"
+	// reads the lines of an io.Reader, such as os.Stdin, until EOF
+	ProtocolStdin = "stdin"
+
+	maxPacketSize = 65536
+
+	DefaultMaxLineLength = 65536
+
+	// reading 16KB at a time scans lines about 20% faster than 4KB, larger buffers add little
+	// but use more memory per connection
+	DefaultReadBufferSize = 16384
+)
+
+var (
+	tlsHandshakeFailures = metrics.GetOrRegisterCounter("tls.handshake.failures", nil)
+	// time allowed to write a reply to a command, so a client that does not read cannot hold up
+	// the connection
+	replyTimeout = 10 * time.Second
+	// time allowed to complete the TLS handshake of a connection
+	handshakeTimeout = 10 * time.Second
+)
+
 type DefaultPointListener struct {
-	Port    int
-	Builder decoder.DecoderBuilder
-	handler PointHandler
+	Port         int
+	Host         string      // interface to listen on, all interfaces if empty
+	Protocol     string      // tcp (default), udp or unix
+	SocketPath   string      // path of the socket for unix listeners
+	SocketMode   os.FileMode // permissions of the socket file for unix listeners
+	Input        io.Reader   // read by stdin listeners
+	Builder      decoder.DecoderBuilder
+	TLSConfig    *tls.Config // enables TLS for tcp listeners when set
+	BufferDir    string      // spools points exceeding the memory buffer to disk when set
+	DiskLimit    int64       // max bytes spooled to disk
+	Preprocessor PointPreprocessor
+	// time allowed to flush buffered points when stopped
+	ShutdownTimeout time.Duration
+	// max concurrent tcp connections, unlimited if 0
+	MaxConnections int
+	// goroutines accepting the connections of tcp and unix listeners, 1 if 0
+	AcceptGoroutines int
+	// closes tcp connections that send nothing for this long, disabled if 0
+	IdleTimeout time.Duration
+	// period of the keepalive probes of tcp connections, disabled if 0
+	KeepAlive time.Duration
+	// sets SO_REUSEPORT on tcp and udp sockets where supported, so processes listening on the
+	// same port share its connections and packets
+	ReusePort bool
+	// tags points with the IP address of the connection they arrived on when set
+	SourceIPTag string
+	// drops duplicate points received within a flush window
+	Dedup bool
+	// sets the timestamps of points to the time they are received
+	UseProxyTime bool
+	// lines longer than this are skipped, DefaultMaxLineLength if 0
+	MaxLineLength int
+	// bytes each connection is read into at a time, DefaultReadBufferSize if 0. The buffer grows
+	// past it only to hold a line of up to the MaxLineLength.
+	ReadBufferSize int
+	// percent of the memory buffer above which reading from connections is paused, disabled if 0
+	HighWatermark int
+	// percent of the memory buffer below which reading from connections resumes
+	LowWatermark int
+	// time allowed for open connections to finish sending when stopped, they are closed at once if 0
+	DrainTimeout time.Duration
+	// max points per second read from a single connection, disabled if 0
+	AbuseThreshold int
+	// written to connections each time their reads are paused by the HighWatermark, asking well
+	// behaved clients to slow down
+	ThrottleReply string
+	// closes connections exceeding the AbuseThreshold instead of throttling them
+	CloseAbusive  bool
+	backpressure  *backpressure
+	activeConns   int64
+	connsMtx      sync.Mutex
+	conns         map[net.Conn]struct{} // open connections, nil once stopping
+	connsWg       sync.WaitGroup
+	acceptWg      sync.WaitGroup // running accept loops
+	connsActive   metrics.Gauge
+	connsRejected metrics.Counter
+	connsAbusive  metrics.Counter
+	throttled     metrics.Counter
+	linesTooLong  metrics.Counter
+	connPoints    metrics.Histogram // points read per connection
+	sourceConns   *sourceConns
+	handler       PointHandler
+	aggTicker     *time.Ticker
+	aggDone       chan struct{} // closed on stop, ending the aggregated flushes
+	udpConn       *net.UDPConn
+	tcpListener   net.Listener
+	unixListener  net.Listener
+	inputDone     chan struct{} // closed once the Input of stdin listeners is read
+	wg            sync.WaitGroup
+	running       int32
 }
 
-func (l *DefaultPointListener) Start(numForwarders, flushInterval, bufferSize, maxFlushSize int,
-	format, workUnitId string, service api.WavefrontAPI) {
+func (l *DefaultPointListener) Start(numForwarders, flushInterval, bufferSize, bufferBytes, maxFlushSize int,
+	format, workUnitId string, service api.WavefrontAPI) error {
+
+	if l.Protocol == "" {
+		l.Protocol = ProtocolTCP
+	}
+	logger.Infof("Starting %s listener on %s", l.Protocol, l.address())
+
+	numForwarders, flushInterval = checkFlushSettings(numForwarders, flushInterval)
+
+	handler, err := newPointHandler(l.name(), l.BufferDir, l.DiskLimit, l.ShutdownTimeout, l.Preprocessor, l.Dedup)
+	if err != nil {
+		return err
+	}
+	l.handler = handler
+	l.handler.init(numForwarders, flushInterval, bufferSize, bufferBytes, maxFlushSize, format, workUnitId, service)
+
+	if aggregator, ok := l.Builder.(decoder.AggregatingBuilder); ok {
+		l.aggTicker = time.NewTicker(time.Millisecond * time.Duration(flushInterval))
+		l.aggDone = make(chan struct{})
+		go l.flushAggregated(aggregator)
+	}
 
-	log.Printf("Starting listener on port: %d\n", l.Port)
+	if l.HighWatermark > 0 && l.Protocol != ProtocolUDP {
+		l.backpressure = newBackpressure(l.name(), l.HighWatermark, l.LowWatermark, numForwarders*bufferSize,
+			func() int {
+				buffered, _ := handler.status()
+				return buffered
+			})
+		l.backpressure.start()
+	}
+
+	connStr := listenAddr(l.Host, l.Port)
+	switch l.Protocol {
+	case ProtocolUDP:
+		err = l.startUDPServer(connStr)
+	case ProtocolUnix:
+		err = l.startUnixServer(l.SocketPath)
+	case ProtocolStdin:
+		l.linesTooLong = metrics.GetOrRegisterCounter("points."+l.name()+".oversized", nil)
+		l.inputDone = make(chan struct{})
+		go l.readInput()
+	default:
+		err = l.startTCPServer(connStr)
+	}
+	if err != nil {
+		if l.backpressure != nil {
+			l.backpressure.stop()
+		}
+		if l.aggTicker != nil {
+			l.aggTicker.Stop()
+			close(l.aggDone)
+		}
+		l.handler.stop()
+		return err
+	}
+	atomic.StoreInt32(&l.running, 1)
+	logger.Infof("Configured %d forwarders for %s listener on %s", numForwarders, format, l.address())
+	return nil
+}
+
+// Name used in logs and metrics, the port, "socket" for unix listeners or "stdin".
+func (l *DefaultPointListener) name() string {
+	switch l.Protocol {
+	case ProtocolUnix:
+		return "socket"
+	case ProtocolStdin:
+		return ProtocolStdin
+	}
+	return strconv.Itoa(l.Port)
+}
+
+func (l *DefaultPointListener) address() string {
+	if l.Protocol == ProtocolUnix {
+		return "socket: " + l.SocketPath
+	}
+	if l.Protocol == ProtocolStdin {
+		return ProtocolStdin
+	}
+	if l.Host != "" {
+		return "address: " + listenAddr(l.Host, l.Port)
+	}
+	return fmt.Sprintf("port: %d", l.Port)
+}
+
+// Returns the host:port address to listen on, with IPv6 hosts in brackets.
+func listenAddr(host string, port int) string {
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// Returns an error if the disk buffer of the handler cannot be opened.
+func newPointHandler(name string, bufferDir string, diskLimit int64, shutdownTimeout time.Duration,
+	preprocessor PointPreprocessor, dedup bool) (PointHandler, error) {
+
+	handler := &DefaultPointHandler{
+		name:            name,
+		preprocessor:    preprocessor,
+		shutdownTimeout: shutdownTimeout,
+	}
+	if dedup {
+		handler.dedup = newDedupFilter(name, maxDedupPoints)
+	}
+	if bufferDir != "" {
+		queue, err := NewDiskPointQueue(filepath.Join(bufferDir, handler.name), diskLimit, handler.name)
+		if err != nil {
+			return nil, err
+		}
+		handler.queue = queue
+	}
+	return handler, nil
+}
 
+func checkFlushSettings(numForwarders, flushInterval int) (int, int) {
 	if numForwarders <= 0 || numForwarders > maxForwarders {
 		numForwarders = minForwarders
 	}
@@ -34,62 +262,498 @@ func (l *DefaultPointListener) Start(numForwarders, flushInterval, bufferSize, m
 	if flushInterval < minFlushInterval {
 		flushInterval = minFlushInterval
 	}
+	return numForwarders, flushInterval
+}
 
-	l.handler = &DefaultPointHandler{name: fmt.Sprintf("%d", l.Port)}
-	l.handler.init(numForwarders, flushInterval, bufferSize, maxFlushSize, format, workUnitId, service)
+// Applies new flush settings without interrupting connections or dropping buffered points.
+func (l *DefaultPointListener) Update(numForwarders, flushInterval, bufferSize, bufferBytes, maxFlushSize int) {
+	numForwarders, flushInterval = checkFlushSettings(numForwarders, flushInterval)
+	l.handler.update(numForwarders, flushInterval, bufferSize, bufferBytes, maxFlushSize)
+	if l.backpressure != nil {
+		l.backpressure.setCapacity(numForwarders * bufferSize)
+	}
+	if l.aggTicker != nil {
+		l.aggTicker.Reset(time.Millisecond * time.Duration(flushInterval))
+	}
+}
 
-	connStr := fmt.Sprintf(":%d", l.Port)
-	addr, err := net.ResolveTCPAddr("tcp", connStr)
+func (l *DefaultPointListener) startTCPServer(connStr string) error {
+	// probes idle connections every KeepAlive, the default count of failed probes closes them
+	lc := l.listenConfig()
+	lc.KeepAliveConfig = net.KeepAliveConfig{Enable: l.KeepAlive > 0, Idle: l.KeepAlive, Interval: l.KeepAlive}
+	if l.KeepAlive <= 0 {
+		lc.KeepAlive = -1
+	}
+	tcpListener, err := lc.Listen(context.Background(), "tcp", connStr)
 	if err != nil {
-		panic(err)
+		return err
+	}
+
+	l.tcpListener = tcpListener
+	l.registerMetrics()
+	l.conns = make(map[net.Conn]struct{})
+	if l.TLSConfig != nil {
+		l.tcpListener = tls.NewListener(tcpListener, l.TLSConfig)
+	}
+	l.startAccepting(l.tcpListener)
+	return nil
+}
+
+func (l *DefaultPointListener) listenConfig() net.ListenConfig {
+	var lc net.ListenConfig
+	if l.ReusePort && ReusePortSupported {
+		lc.Control = setReusePort
+	}
+	return lc
+}
+
+func (l *DefaultPointListener) registerMetrics() {
+	l.connsActive = metrics.GetOrRegisterGauge("connections."+l.name()+".active", nil)
+	l.connsRejected = metrics.GetOrRegisterCounter("connections."+l.name()+".rejected", nil)
+	l.connsAbusive = metrics.GetOrRegisterCounter("connections."+l.name()+".abusive", nil)
+	l.throttled = metrics.GetOrRegisterCounter("connections."+l.name()+".throttled", nil)
+	l.linesTooLong = metrics.GetOrRegisterCounter("points."+l.name()+".oversized", nil)
+	l.connPoints = metrics.GetOrRegisterHistogram("connections."+l.name()+".points", nil, metrics.NewExpDecaySample(1028, 0.015))
+	l.sourceConns = newSourceConns(l.name())
+}
+
+// Listens on a unix domain socket, replacing the socket file left behind by a previous run.
+func (l *DefaultPointListener) startUnixServer(path string) error {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
 	}
 
-	tcpListener, err := net.ListenTCP("tcp", addr)
+	unixListener, err := net.Listen("unix", path)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	if l.SocketMode != 0 {
+		if err := os.Chmod(path, l.SocketMode); err != nil {
+			unixListener.Close()
+			return err
+		}
 	}
 
-	go l.startServer(tcpListener)
-	log.Printf("Configured %d forwarders for %s listener on port: %d\n", numForwarders, format, l.Port)
+	l.unixListener = unixListener
+	l.registerMetrics()
+	l.conns = make(map[net.Conn]struct{})
+	l.startAccepting(l.unixListener)
+	return nil
 }
 
-func (l *DefaultPointListener) startServer(tcpListener *net.TCPListener) {
+func (l *DefaultPointListener) startUDPServer(connStr string) error {
+	lc := l.listenConfig()
+	conn, err := lc.ListenPacket(context.Background(), "udp", connStr)
+	if err != nil {
+		return err
+	}
+	l.udpConn = conn.(*net.UDPConn)
+	l.wg.Add(1)
+	go l.readPackets()
+	return nil
+}
+
+// Starts the accept loops of the listener, several of which set up connections in parallel when
+// many clients reconnect at once.
+func (l *DefaultPointListener) startAccepting(listener net.Listener) {
+	n := l.AcceptGoroutines
+	if n <= 0 {
+		n = 1
+	}
+	l.acceptWg.Add(n)
+	for i := 0; i < n; i++ {
+		go l.acceptConnections(listener)
+	}
+	l.sourceConns.start()
+}
+
+func (l *DefaultPointListener) acceptConnections(listener net.Listener) {
+	defer l.acceptWg.Done()
 	for {
 		// Listen for incoming connections
-		conn, err := tcpListener.Accept()
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil || conn == nil {
-			log.Printf("%d-listener: error accepting connection: %v\n", l.Port, err.Error())
+			logger.Errorf("%s-listener: error accepting connection: %v", l.name(), err.Error())
 			continue
 		}
 
+		// counted before the check, so accept loops running in parallel do not exceed the limit
+		active := atomic.AddInt64(&l.activeConns, 1)
+		if l.MaxConnections > 0 && active > int64(l.MaxConnections) {
+			atomic.AddInt64(&l.activeConns, -1)
+			logger.Warnf("%s-listener: rejecting connection from %s, %d connections open", l.name(), conn.RemoteAddr(), l.MaxConnections)
+			l.connsRejected.Inc(1)
+			conn.Close()
+			continue
+		}
+		if !l.addConn(conn) {
+			// accepted while stopping
+			atomic.AddInt64(&l.activeConns, -1)
+			conn.Close()
+			continue
+		}
+		l.connsActive.Update(active)
+		// unix socket connections have no source IP
+		ip := remoteIP(conn.RemoteAddr())
+		tracked := ip != "" && l.sourceConns.open(ip)
+
 		// Handle connections in a new goroutine
-		go l.handleRequest(conn)
+		go func() {
+			l.handleRequest(conn)
+			if tracked {
+				l.sourceConns.close(ip)
+			}
+			l.connsActive.Update(atomic.AddInt64(&l.activeConns, -1))
+			l.removeConn(conn)
+		}()
+	}
+}
+
+func (l *DefaultPointListener) addConn(conn net.Conn) bool {
+	l.connsMtx.Lock()
+	defer l.connsMtx.Unlock()
+	if l.conns == nil {
+		return false
+	}
+	l.conns[conn] = struct{}{}
+	l.connsWg.Add(1)
+	return true
+}
+
+func (l *DefaultPointListener) removeConn(conn net.Conn) {
+	l.connsMtx.Lock()
+	defer l.connsMtx.Unlock()
+	delete(l.conns, conn)
+	l.connsWg.Done()
+}
+
+// Waits up to the drain timeout for open connections to be closed by their clients,
+// then closes the remaining connections. New connections are no longer tracked.
+func (l *DefaultPointListener) drainConnections() {
+	l.connsMtx.Lock()
+	open := len(l.conns)
+	l.connsMtx.Unlock()
+	if open > 0 && l.DrainTimeout > 0 {
+		logger.Infof("%s-listener: waiting up to %v for %d connections to close", l.name(), l.DrainTimeout, open)
+		done := make(chan struct{})
+		go func() {
+			l.connsWg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(l.DrainTimeout):
+		}
+	}
+
+	l.connsMtx.Lock()
+	defer l.connsMtx.Unlock()
+	if len(l.conns) > 0 {
+		logger.Infof("%s-listener: closing %d open connections", l.name(), len(l.conns))
+	}
+	for conn := range l.conns {
+		conn.Close()
 	}
+	l.conns = nil
+}
+
+// Reports points held by an aggregating decoder once per flush interval.
+func (l *DefaultPointListener) flushAggregated(aggregator decoder.AggregatingBuilder) {
+	for {
+		select {
+		case <-l.aggTicker.C:
+			l.handler.reportPoints(aggregator.Flush())
+		case <-l.aggDone:
+			return
+		}
+	}
+}
+
+// Reads packets until the UDP connection is closed.
+// Each packet may contain multiple newline separated points.
+func (l *DefaultPointListener) readPackets() {
+	defer l.wg.Done()
+	var pd decoder.PointDecoder = l.Builder.Build()
+	_, packets := pd.(decoder.PacketDecoder)
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := l.udpConn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logger.Errorf("%s-listener: error reading packet: %v", l.name(), err)
+			continue
+		}
+
+		if packets {
+			l.handlePacket(pd, buf[:n], addr)
+			continue
+		}
+		for _, pointBytes := range bytes.Split(buf[:n], []byte("\n")) {
+			if len(bytes.TrimSpace(pointBytes)) == 0 {
+				continue
+			}
+			l.handleLine(pd, pointBytes, addr.IP.String())
+		}
+	}
+}
+
+// Reports the points of a binary packet, which cannot be logged as a blocked line when invalid.
+func (l *DefaultPointListener) handlePacket(pd decoder.PointDecoder, packet []byte, addr *net.UDPAddr) {
+	points, err := pd.Decode(packet)
+	if err != nil {
+		l.handler.handleBlockedPoint(fmt.Sprintf("%d byte packet from %s: %v", len(packet), addr, err))
+		return
+	}
+	if l.SourceIPTag != "" {
+		TagRemoteIP(points, l.SourceIPTag, addr.IP.String())
+	}
+	if l.UseProxyTime {
+		stampProxyTime(points)
+	}
+	l.handler.reportPoints(points)
 }
 
 // Handles incoming requests.
 func (l *DefaultPointListener) handleRequest(conn net.Conn) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		// handshake up front so that plaintext clients are rejected immediately, and within the
+		// handshakeTimeout so that clients cannot hold connections open without one
+		tlsConn.SetDeadline(time.Now().Add(handshakeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			logger.Warnf("%s-listener: TLS handshake error from %s: %v", l.name(), conn.RemoteAddr(), err)
+			tlsHandshakeFailures.Inc(1)
+			conn.Close()
+			return
+		}
+		tlsConn.SetDeadline(time.Time{})
+	}
+	l.extendDeadline(conn)
+
 	var pd decoder.PointDecoder = l.Builder.Build()
-	scanner := bufio.NewScanner(conn)
+	commands, _ := pd.(decoder.CommandHandler)
+	remoteIP := remoteIP(conn.RemoteAddr())
+	var rate *connRate
+	if l.AbuseThreshold > 0 {
+		rate = newConnRate(l.AbuseThreshold)
+	}
+	points := 0
+	scanner := l.lineScanner(conn, conn.RemoteAddr().String())
 	for scanner.Scan() {
-		pointBytes := scanner.Bytes()
-		point, err := pd.Decode(pointBytes)
-		if err != nil {
-			log.Println("Error decoding point", err)
-			l.handler.handleBlockedPoint(string(pointBytes))
-			continue
+		l.extendDeadline(conn)
+		if commands != nil {
+			reply, ok, closeConn := commands.HandleCommand(scanner.Bytes())
+			if reply != "" && !l.reply(conn, reply) {
+				break
+			}
+			if closeConn {
+				break
+			}
+			if ok {
+				continue
+			}
+		}
+		count := l.handleLine(pd, scanner.Bytes(), remoteIP)
+		points += count
+		if rate != nil && !l.limitConnRate(conn, rate, count) {
+			break
+		}
+		if l.backpressure != nil && l.backpressure.paused() {
+			l.throttle(conn)
 		}
-		l.handler.reportPoint(point)
 	}
 
 	if err := scanner.Err(); err != nil {
-		log.Printf("%d-listener: error during scan: %v\n", l.Port, err)
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			logger.Debugf("%s-listener: closing idle connection from %s", l.name(), conn.RemoteAddr())
+		} else if errors.Is(err, net.ErrClosed) {
+			logger.Debugf("%s-listener: closed connection from %s", l.name(), conn.RemoteAddr())
+		} else {
+			logger.Warnf("%s-listener: error during scan: %v", l.name(), err)
+		}
 	}
+	l.connPoints.Update(int64(points))
 	conn.Close()
 }
 
+// Returns a scanner of the lines read from r, skipping and counting lines over the MaxLineLength.
+func (l *DefaultPointListener) lineScanner(r io.Reader, from string) *bufio.Scanner {
+	maxLineLength := l.MaxLineLength
+	if maxLineLength <= 0 {
+		maxLineLength = DefaultMaxLineLength
+	}
+	readBufferSize := l.ReadBufferSize
+	if readBufferSize <= 0 {
+		readBufferSize = DefaultReadBufferSize
+	}
+	scanner := bufio.NewScanner(r)
+	// the split function skips lines over the MaxLineLength, so a larger buffer only holds more lines
+	scanner.Buffer(make([]byte, 0, readBufferSize), max(readBufferSize, maxLineLength+2))
+	scanner.Split(scanLines(maxLineLength, func() {
+		logger.Debugf("%s-listener: skipping line longer than %d bytes from %s", l.name(), maxLineLength, from)
+		l.linesTooLong.Inc(1)
+	}))
+	return scanner
+}
+
+// Reads the points of the Input of a stdin listener until EOF.
+func (l *DefaultPointListener) readInput() {
+	defer close(l.inputDone)
+	pd := l.Builder.Build()
+	scanner := l.lineScanner(l.Input, ProtocolStdin)
+	for scanner.Scan() {
+		l.handleLine(pd, scanner.Bytes(), "")
+		if l.backpressure != nil {
+			l.backpressure.wait()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		logger.Warnf("%s-listener: error reading input: %v", l.name(), err)
+	}
+}
+
+// Closed once a stdin listener has read all of its Input, nil for other listeners.
+func (l *DefaultPointListener) InputDone() <-chan struct{} {
+	return l.inputDone
+}
+
+// Throttles a connection reading more than the AbuseThreshold, returning false if it is to be
+// closed instead. Each abusive connection is logged and counted once.
+func (l *DefaultPointListener) limitConnRate(conn net.Conn, rate *connRate, points int) bool {
+	delay := rate.delay(rate.add(points))
+	if delay == 0 {
+		return true
+	}
+	if !rate.exceeded {
+		rate.exceeded = true
+		l.connsAbusive.Inc(1)
+		action := "throttling"
+		if l.CloseAbusive {
+			action = "closing"
+		}
+		logger.Warnf("%s-listener: connection from %s exceeded %d points per second, %s it",
+			l.name(), conn.RemoteAddr(), l.AbuseThreshold, action)
+	}
+	if l.CloseAbusive {
+		return false
+	}
+	time.Sleep(delay)
+	l.extendDeadline(conn)
+	return true
+}
+
+// Pauses reading from a connection while backpressure is applied, first sending the client the
+// ThrottleReply if set.
+func (l *DefaultPointListener) throttle(conn net.Conn) {
+	if l.ThrottleReply != "" {
+		conn.Write([]byte(l.ThrottleReply))
+		l.throttled.Inc(1)
+	}
+	l.backpressure.wait()
+	l.extendDeadline(conn)
+}
+
+// Writes a reply to a command within the replyTimeout, returning false if it failed.
+func (l *DefaultPointListener) reply(conn net.Conn, reply string) bool {
+	conn.SetWriteDeadline(time.Now().Add(replyTimeout))
+	defer conn.SetWriteDeadline(time.Time{})
+	if _, err := conn.Write([]byte(reply)); err != nil {
+		logger.Debugf("%s-listener: error replying to %s: %v", l.name(), conn.RemoteAddr(), err)
+		return false
+	}
+	return true
+}
+
+// Resets the idle timeout for a connection.
+func (l *DefaultPointListener) extendDeadline(conn net.Conn) {
+	if l.IdleTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(l.IdleTimeout))
+	}
+}
+
+func remoteIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	if _, ok := addr.(*net.UnixAddr); ok {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// Decodes and reports the points of a line, returning the number of points decoded.
+func (l *DefaultPointListener) handleLine(pd decoder.PointDecoder, pointBytes []byte, remoteIP string) int {
+	points, err := pd.Decode(pointBytes)
+	if err != nil {
+		logger.Warn("Error decoding point", err)
+		l.handler.handleBlockedPoint(string(pointBytes))
+		return 0
+	}
+	if l.SourceIPTag != "" && remoteIP != "" {
+		TagRemoteIP(points, l.SourceIPTag, remoteIP)
+	}
+	if l.UseProxyTime {
+		stampProxyTime(points)
+	}
+	l.handler.reportPoints(points)
+	return len(points)
+}
+
 func (l *DefaultPointListener) Stop() {
-	//TODO: gracefully shutdown TCP listener
-	log.Println("Stopping listener", l.Port)
+	logger.Info("Stopping listener", l.address())
+	atomic.StoreInt32(&l.running, 0)
+	if l.tcpListener != nil {
+		l.tcpListener.Close()
+	}
+	if l.unixListener != nil {
+		l.unixListener.Close()
+		if err := os.Remove(l.SocketPath); err != nil && !os.IsNotExist(err) {
+			logger.Warnf("%s-listener: error removing %s: %v", l.name(), l.SocketPath, err)
+		}
+	}
+	if l.tcpListener != nil || l.unixListener != nil {
+		l.acceptWg.Wait()
+		l.sourceConns.stop()
+		l.drainConnections()
+	}
+	// releases connections paused for backpressure, so that they see they are closed
+	if l.backpressure != nil {
+		l.backpressure.stop()
+	}
+	l.connsWg.Wait()
+	if l.udpConn != nil {
+		l.udpConn.Close()
+		l.wg.Wait()
+	}
+	if l.aggTicker != nil {
+		l.aggTicker.Stop()
+		close(l.aggDone)
+	}
+	if aggregator, ok := l.Builder.(decoder.AggregatingBuilder); ok {
+		l.handler.reportPoints(aggregator.FlushAll())
+	}
 	l.handler.stop()
 }
+
+func (l *DefaultPointListener) Status() ListenerStatus {
+	status := newListenerStatus(l.Port, l.Protocol, atomic.LoadInt32(&l.running) == 1, l.handler)
+	status.Host, status.Path = l.Host, l.SocketPath
+	return status
+}
+
+func newListenerStatus(port int, protocol string, running bool, handler PointHandler) ListenerStatus {
+	status := ListenerStatus{Port: port, Protocol: protocol, Running: running}
+	if handler != nil {
+		status.BufferedPoints, status.LastFlush = handler.status()
+		status.SpooledPoints = handler.spooledPoints()
+	}
+	return status
+}
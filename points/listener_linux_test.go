@@ -0,0 +1,47 @@
+package points
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/wavefronthq/go-proxy/points/decoder"
+)
+
+func TestTCPKeepAlive(t *testing.T) {
+	for _, keepAlive := range []time.Duration{0, 7 * time.Second} {
+		l := &DefaultPointListener{Builder: decoder.GraphiteBuilder{}, KeepAlive: keepAlive, handler: &testPointHandler{}}
+		l.startTCPServer("127.0.0.1:0")
+		conn, err := net.Dial("tcp", l.tcpListener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var accepted net.Conn
+		for i := 0; i < 100 && accepted == nil; i++ {
+			time.Sleep(10 * time.Millisecond)
+			l.connsMtx.Lock()
+			for c := range l.conns {
+				accepted = c
+			}
+			l.connsMtx.Unlock()
+		}
+		if accepted == nil {
+			t.Fatal("Expected the connection to be accepted")
+		}
+		raw, _ := accepted.(*net.TCPConn).SyscallConn()
+		var enabled, idle, interval int
+		raw.Control(func(fd uintptr) {
+			enabled, _ = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_KEEPALIVE)
+			idle, _ = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE)
+			interval, _ = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL)
+		})
+		if keepAlive == 0 && enabled != 0 || keepAlive > 0 && (enabled == 0 || idle != 7 || interval != 7) {
+			t.Errorf("Expected keepalive %v, found enabled %d after %ds every %ds", keepAlive, enabled, idle, interval)
+		}
+		conn.Close()
+		l.tcpListener.Close()
+		l.connsWg.Wait()
+	}
+}
@@ -0,0 +1,701 @@
+package points
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/common"
+	"github.com/wavefronthq/go-proxy/points/decoder"
+)
+
+func TestConnectionLimits(t *testing.T) {
+	handler := &testPointHandler{}
+	l := &DefaultPointListener{
+		Builder:        decoder.GraphiteBuilder{},
+		MaxConnections: 1,
+		IdleTimeout:    200 * time.Millisecond,
+		handler:        handler,
+	}
+	l.startTCPServer("127.0.0.1:0")
+	defer l.tcpListener.Close()
+	addr := l.tcpListener.Addr().String()
+	// the counter is shared by the listeners of the tests
+	rejectedBefore := l.connsRejected.Count()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("foo.metric 1 source=a\n"))
+	time.Sleep(50 * time.Millisecond)
+
+	// connections past the limit are closed immediately
+	rejected, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rejected.Close()
+	if !closedWithin(rejected, 100*time.Millisecond) {
+		t.Error("Expected connection past the limit to be closed")
+	}
+	if n, active := l.connsRejected.Count()-rejectedBefore, atomic.LoadInt64(&l.activeConns); n != 1 || active != 1 {
+		t.Errorf("Expected 1 rejected and 1 active connection, found %d and %d", n, active)
+	}
+
+	// idle connections are closed after the timeout
+	if !closedWithin(conn, time.Second) {
+		t.Error("Expected idle connection to be closed")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if active := atomic.LoadInt64(&l.activeConns); active != 0 || len(handler.points) != 1 {
+		t.Errorf("Expected no active connections and 1 point, found %d and %d", active, len(handler.points))
+	}
+}
+
+func TestAcceptGoroutines(t *testing.T) {
+	l := &DefaultPointListener{
+		Builder:          decoder.GraphiteBuilder{},
+		MaxConnections:   3,
+		AcceptGoroutines: 4,
+		handler:          &testPointHandler{},
+	}
+	l.startTCPServer("127.0.0.1:0")
+	addr := l.tcpListener.Addr().String()
+	rejected := l.connsRejected.Count()
+
+	// the limit holds with the connections accepted in parallel
+	var conns []net.Conn
+	for i := 0; i < 8; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	time.Sleep(100 * time.Millisecond)
+	// the connections.0.active gauge is shared by the listeners of the tests
+	if n, active := l.connsRejected.Count()-rejected, atomic.LoadInt64(&l.activeConns); n != 5 || active != 3 {
+		t.Errorf("Expected 5 rejected and 3 active connections, found %d and %d", n, active)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		l.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Expected stop to return once every accept loop returned")
+	}
+	for i, conn := range conns {
+		if !closedWithin(conn, time.Second) {
+			t.Errorf("Expected connection %d to be closed", i)
+		}
+	}
+}
+
+// Connections set up per second by 1 and 4 accept loops, e.g.
+//
+//	go test -run none -bench AcceptConnections ./points
+func BenchmarkAcceptConnections(b *testing.B) {
+	for _, n := range []int{1, 4} {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			l := &DefaultPointListener{Builder: decoder.GraphiteBuilder{}, AcceptGoroutines: n, handler: &testPointHandler{}}
+			l.startTCPServer("127.0.0.1:0")
+			defer l.Stop()
+			addr := l.tcpListener.Addr().String()
+
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					conn, err := net.Dial("tcp", addr)
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					conn.Close()
+				}
+			})
+		})
+	}
+}
+
+func closedWithin(conn net.Conn, timeout time.Duration) bool {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	_, err := conn.Read(make([]byte, 1))
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return false
+	}
+	return err != nil
+}
+
+func TestOpenTSDBSession(t *testing.T) {
+	handler := &testPointHandler{}
+	l := &DefaultPointListener{Builder: decoder.OpenTSDBBuilder{Version: "1.2"}, handler: handler}
+	l.startTCPServer("127.0.0.1:0")
+	defer l.tcpListener.Close()
+
+	conn, err := net.Dial("tcp", l.tcpListener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("version\nput foo.bar 1505454047 1 host=a\nstats\nbogus command\nput foo.bar 1505454048 2 host=a\nversion\n"))
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	reply := make([]byte, 0, 64)
+	buf := make([]byte, 64)
+	for len(reply) < 2*len("Wavefront Proxy version 1.2\n") {
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply = append(reply, buf[:n]...)
+	}
+	if string(reply) != "Wavefront Proxy version 1.2\nWavefront Proxy version 1.2\n" {
+		t.Errorf("Unexpected reply %q", reply)
+	}
+
+	// the lines after exit are not read
+	conn.Write([]byte("exit\nput foo.bar 1505454049 3 host=a\n"))
+	if _, err := conn.Read(buf); err != io.EOF {
+		t.Errorf("Expected the connection closed on exit, found %v", err)
+	}
+
+	handler.mtx.Lock()
+	defer handler.mtx.Unlock()
+	if len(handler.points) != 2 || len(handler.blocked) != 0 {
+		t.Errorf("Expected 2 points and none blocked, found %d and %v", len(handler.points), handler.blocked)
+	}
+}
+
+func TestCommandReplyTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { replyTimeout = timeout }(replyTimeout)
+	replyTimeout = 50 * time.Millisecond
+
+	// writes to a pipe block until the client reads, which it never does
+	server, client := net.Pipe()
+	defer client.Close()
+	l := &DefaultPointListener{Builder: decoder.OpenTSDBBuilder{Version: "1.2"}, handler: &testPointHandler{}}
+	l.registerMetrics()
+	done := make(chan struct{})
+	go func() {
+		l.handleRequest(server)
+		close(done)
+	}()
+	client.Write([]byte("version\n"))
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the connection closed once the reply timed out")
+	}
+}
+
+func TestOpenTSDBThrottle(t *testing.T) {
+	handler := &testPointHandler{}
+	l := &DefaultPointListener{
+		Builder:       decoder.OpenTSDBBuilder{},
+		ThrottleReply: decoder.OpenTSDBThrottleReply,
+		handler:       handler,
+	}
+	var buffered int64 = 100
+	l.backpressure = newBackpressure("test-throttle", 90, 50, 100, func() int { return int(atomic.LoadInt64(&buffered)) })
+	l.backpressure.start()
+	defer l.backpressure.stop()
+	time.Sleep(2 * backpressureInterval)
+	l.startTCPServer("127.0.0.1:0")
+	defer l.tcpListener.Close()
+	throttled := l.throttled.Count()
+
+	conn, err := net.Dial("tcp", l.tcpListener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("put foo.bar 1505454047 1 host=a\nput foo.bar 1505454048 2 host=a\n"))
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	reply := make([]byte, len(decoder.OpenTSDBThrottleReply))
+	if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != decoder.OpenTSDBThrottleReply {
+		t.Fatalf("Expected the throttle reply, found %q (%v)", reply, err)
+	}
+	time.Sleep(2 * backpressureInterval)
+	if n := handler.count(); n != 1 {
+		t.Errorf("Expected reads paused after the first point, found %d points", n)
+	}
+
+	atomic.StoreInt64(&buffered, 10)
+	time.Sleep(3 * backpressureInterval)
+	if n := handler.count(); n != 2 {
+		t.Errorf("Expected reads resumed below the low watermark, found %d points", n)
+	}
+	if n := l.throttled.Count() - throttled; n != 1 {
+		t.Errorf("Expected 1 throttle reply counted, found %d", n)
+	}
+}
+
+// Decodes Wavefront lines as if their source was a default, like the sources of StatsD points.
+type defaultSourceBuilder struct{}
+
+func (defaultSourceBuilder) Build() decoder.PointDecoder {
+	return defaultSourceDecoder{decoder.GraphiteBuilder{}.Build()}
+}
+
+type defaultSourceDecoder struct {
+	decoder.PointDecoder
+}
+
+func (d defaultSourceDecoder) Decode(b []byte) ([]*common.Point, error) {
+	points, err := d.PointDecoder.Decode(b)
+	for _, point := range points {
+		point.DefaultSource = true
+	}
+	return points, err
+}
+
+func TestSourceIPTag(t *testing.T) {
+	for _, c := range []struct {
+		builder  decoder.DecoderBuilder
+		expected []string
+	}{
+		{defaultSourceBuilder{}, []string{"127.0.0.1", "10.0.0.1"}},
+		// points with a source of their own are not tagged
+		{decoder.GraphiteBuilder{}, []string{"", "10.0.0.1"}},
+	} {
+		handler := &testPointHandler{}
+		l := &DefaultPointListener{Builder: c.builder, SourceIPTag: "_remote_ip", handler: handler}
+		l.startTCPServer("127.0.0.1:0")
+
+		conn, err := net.Dial("tcp", l.tcpListener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte("foo.metric 1 source=a\nfoo.metric 1 source=a _remote_ip=10.0.0.1\n"))
+		conn.Close()
+		time.Sleep(100 * time.Millisecond)
+		l.tcpListener.Close()
+
+		handler.mtx.Lock()
+		if len(handler.points) != 2 {
+			t.Fatalf("Expected 2 points, found %d", len(handler.points))
+		}
+		for i, expected := range c.expected {
+			if ip := handler.points[i].Tags["_remote_ip"]; ip != expected {
+				t.Errorf("Expected remote IP %q, found %q", expected, ip)
+			}
+		}
+		handler.mtx.Unlock()
+	}
+}
+
+func TestConnectionMetrics(t *testing.T) {
+	l := &DefaultPointListener{Builder: decoder.GraphiteBuilder{}, handler: &testPointHandler{}}
+	l.startTCPServer("127.0.0.1:0")
+	defer l.tcpListener.Close()
+	// the histogram is shared by the listeners of the tests
+	countBefore, sumBefore := l.connPoints.Count(), l.connPoints.Sum()
+
+	conn, err := net.Dial("tcp", l.tcpListener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("foo.metric 1 source=a\nfoo.metric 2 source=a\n"))
+	time.Sleep(50 * time.Millisecond)
+	l.sourceConns.mtx.Lock()
+	count := l.sourceConns.sources["127.0.0.1"]
+	open := count != nil && count.open == 1
+	l.sourceConns.mtx.Unlock()
+	if !open {
+		t.Error("Expected 1 connection open by 127.0.0.1")
+	}
+	conn.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	if n, sum := l.connPoints.Count()-countBefore, l.connPoints.Sum()-sumBefore; n != 1 || sum != 2 {
+		t.Errorf("Expected 1 connection with 2 points, found %d with %d", n, sum)
+	}
+	l.sourceConns.mtx.Lock()
+	defer l.sourceConns.mtx.Unlock()
+	if count.open != 0 {
+		t.Errorf("Expected no connections open by 127.0.0.1, found %d", count.open)
+	}
+}
+
+func TestUseProxyTime(t *testing.T) {
+	for _, useProxyTime := range []bool{false, true} {
+		handler := &testPointHandler{}
+		l := &DefaultPointListener{Builder: decoder.GraphiteBuilder{}, UseProxyTime: useProxyTime, handler: handler}
+		l.handleLine(l.Builder.Build(), []byte("foo.metric 1 1500000000 source=a"), "")
+
+		handler.mtx.Lock()
+		received := time.Now().Unix() - handler.points[0].Timestamp
+		handler.mtx.Unlock()
+		if useProxyTime && received > 1 || !useProxyTime && handler.points[0].Timestamp != 1500000000 {
+			t.Errorf("Expected the proxy time used %t, found timestamp %d", useProxyTime, handler.points[0].Timestamp)
+		}
+	}
+}
+
+func TestIPv6Listener(t *testing.T) {
+	if probe, err := net.Listen("tcp", "[::1]:0"); err != nil {
+		t.Skip("IPv6 loopback not available:", err)
+	} else {
+		probe.Close()
+	}
+
+	handler := &testPointHandler{}
+	l := &DefaultPointListener{Host: "::1", Builder: defaultSourceBuilder{}, SourceIPTag: "_remote_ip", handler: handler}
+	if addr := l.address(); addr != "address: [::1]:0" {
+		t.Errorf("Unexpected listener address %q", addr)
+	}
+	l.startTCPServer(listenAddr(l.Host, l.Port))
+	defer l.tcpListener.Close()
+
+	conn, err := net.Dial("tcp", l.tcpListener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("foo.metric 1 source=a\n"))
+	conn.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	handler.mtx.Lock()
+	defer handler.mtx.Unlock()
+	if len(handler.points) != 1 || handler.points[0].Tags["_remote_ip"] != "::1" {
+		t.Errorf("Expected 1 point from ::1, found %v", handler.points)
+	}
+}
+
+func TestUnixSocketListener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wavefront-socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "wavefront.sock")
+
+	handler := &testPointHandler{}
+	l := &DefaultPointListener{
+		Protocol:    ProtocolUnix,
+		SocketPath:  path,
+		SocketMode:  0666,
+		SourceIPTag: "_remote_ip",
+		Builder:     defaultSourceBuilder{},
+		handler:     handler,
+	}
+	l.startUnixServer(path)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0666 {
+		t.Errorf("Expected socket permissions 0666, found %o", info.Mode().Perm())
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("foo.metric 1 source=a\n"))
+	conn.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	l.Stop()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected socket file removed on stop, found %v", err)
+	}
+	handler.mtx.Lock()
+	defer handler.mtx.Unlock()
+	if len(handler.points) != 1 || len(handler.points[0].Tags) != 0 {
+		t.Errorf("Expected 1 untagged point, found %v", handler.points)
+	}
+}
+
+func TestUDPListener(t *testing.T) {
+	handler := &testPointHandler{}
+	l := &DefaultPointListener{
+		Protocol:    ProtocolUDP,
+		SourceIPTag: "_remote_ip",
+		Builder:     defaultSourceBuilder{},
+		handler:     handler,
+	}
+	if err := l.startUDPServer("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("udp", l.udpConn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, packet := range []string{
+		"foo.a 1 source=a\n",
+		// several lines per packet, blank and invalid lines skipped
+		"foo.b 2 source=a\nfoo.c 3 source=a\n\ninvalid\nfoo.d 4 source=a",
+		"foo.e 5 source=a",
+	} {
+		if _, err := conn.Write([]byte(packet)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	l.Stop()
+
+	handler.mtx.Lock()
+	defer handler.mtx.Unlock()
+	var names []string
+	for _, point := range handler.points {
+		names = append(names, point.Name)
+		if ip := point.Tags["_remote_ip"]; ip != "127.0.0.1" {
+			t.Errorf("Expected remote IP 127.0.0.1, found %q", ip)
+		}
+	}
+	if strings.Join(names, ",") != "foo.a,foo.b,foo.c,foo.d,foo.e" {
+		t.Errorf("Expected points foo.a to foo.e, found %v", names)
+	}
+	if len(handler.blocked) != 1 {
+		t.Errorf("Expected 1 blocked line, found %v", handler.blocked)
+	}
+}
+
+func TestOversizedLines(t *testing.T) {
+	handler := &testPointHandler{}
+	l := &DefaultPointListener{Builder: decoder.GraphiteBuilder{}, MaxLineLength: 100, handler: handler}
+	l.startTCPServer("127.0.0.1:0")
+	defer l.tcpListener.Close()
+
+	conn, err := net.Dial("tcp", l.tcpListener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	longTags := strings.Repeat(" tag=value", 1000)
+	conn.Write([]byte("foo.metric 1 source=a\n"))
+	conn.Write([]byte("foo.metric 2 source=a" + longTags + "\n"))
+	conn.Write([]byte("foo.metric 3 source=a\r\n"))
+	conn.Write([]byte("foo.metric 4 source=a" + strings.Repeat(" tag=value", 8) + "\n"))
+	conn.Write([]byte("foo.metric 5 source=a\n"))
+	conn.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	handler.mtx.Lock()
+	defer handler.mtx.Unlock()
+	var values []string
+	for _, point := range handler.points {
+		values = append(values, point.Value)
+	}
+	if strings.Join(values, ",") != "1,3,5" {
+		t.Errorf("Expected points 1,3,5, found %v", values)
+	}
+	if l.linesTooLong.Count() != 2 {
+		t.Errorf("Expected 2 oversized lines, found %d", l.linesTooLong.Count())
+	}
+}
+
+func TestReadBufferSize(t *testing.T) {
+	input := "foo.metric 1 source=a\n" + "foo.metric 2 source=a" + strings.Repeat(" tag=value", 20) + "\nfoo.metric 3 source=a\n"
+	for _, size := range []int{8, 64, 1 << 20} {
+		l := &DefaultPointListener{MaxLineLength: 100, ReadBufferSize: size, linesTooLong: metrics.NewCounter()}
+		// the input is read with the end of the input, so the lines after a skipped line are not dropped
+		scanner := l.lineScanner(iotest.DataErrReader(strings.NewReader(input)), "test")
+		var lines []string
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		// lines longer than the buffer are read up to the MaxLineLength whatever its size
+		if scanner.Err() != nil || strings.Join(lines, ",") != "foo.metric 1 source=a,foo.metric 3 source=a" ||
+			l.linesTooLong.Count() != 1 {
+			t.Errorf("Buffer of %d: unexpected lines %q, %d too long: %v", size, lines, l.linesTooLong.Count(), scanner.Err())
+		}
+	}
+}
+
+func TestDrainConnections(t *testing.T) {
+	handler := &testPointHandler{}
+	l := &DefaultPointListener{Builder: decoder.GraphiteBuilder{}, DrainTimeout: 2 * time.Second, handler: handler}
+	l.startTCPServer("127.0.0.1:0")
+	addr := l.tcpListener.Addr().String()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("foo.metric 1 source=a\nfoo.metric 2 "))
+	time.Sleep(50 * time.Millisecond)
+
+	stopped := make(chan struct{})
+	go func() {
+		l.Stop()
+		close(stopped)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// no new connections while draining, the open connection can finish its line
+	if _, err := net.DialTimeout("tcp", addr, 100*time.Millisecond); err == nil {
+		t.Error("Expected new connections to be refused while draining")
+	}
+	conn.Write([]byte("source=a\n"))
+	conn.Close()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Expected stop to return once the connection closed")
+	}
+	if len(handler.points) != 2 {
+		t.Errorf("Expected 2 points, found %d", len(handler.points))
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	handler := &testPointHandler{}
+	l := &DefaultPointListener{Builder: decoder.GraphiteBuilder{}, DrainTimeout: 100 * time.Millisecond, handler: handler}
+	l.startTCPServer("127.0.0.1:0")
+
+	conn, err := net.Dial("tcp", l.tcpListener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("foo.metric 1 source=a\n"))
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	l.Stop()
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Expected stop to wait for the drain timeout, took %v", elapsed)
+	}
+	if !closedWithin(conn, time.Second) {
+		t.Error("Expected the connection to be closed after the drain timeout")
+	}
+	if len(handler.points) != 1 {
+		t.Errorf("Expected 1 point, found %d", len(handler.points))
+	}
+}
+
+func TestStdinListener(t *testing.T) {
+	input := "foo.metric 1 source=a\nbad line\nfoo.metric 2 source=a"
+	api := &testAPI{}
+	l := &DefaultPointListener{Protocol: ProtocolStdin, Input: strings.NewReader(input), Builder: decoder.GraphiteBuilder{},
+		ShutdownTimeout: time.Second}
+	if err := l.Start(1, 10000, 100, 0, 100, "graphite_v2", "wu", api); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-l.InputDone():
+	case <-time.After(time.Second):
+		t.Fatal("Expected the input to be read")
+	}
+	// points still buffered are flushed when stopped
+	l.Stop()
+	api.mtx.Lock()
+	defer api.mtx.Unlock()
+	if len(api.points) != 2 {
+		t.Errorf("Expected the 2 valid points flushed, found %q", api.points)
+	}
+	if l.Status().Protocol != ProtocolStdin || l.address() != ProtocolStdin {
+		t.Errorf("Unexpected status %+v", l.Status())
+	}
+}
+
+func TestStopGoroutines(t *testing.T) {
+	api := &testAPI{}
+	start := func(port int) (*DefaultPointListener, error) {
+		l := &DefaultPointListener{Host: "127.0.0.1", Port: port, Builder: decoder.NewStatsDBuilder("test", 0),
+			HighWatermark: 80, LowWatermark: 50, ShutdownTimeout: time.Second}
+		return l, l.Start(1, 1000, 100, 0, 100, "graphite_v2", "wu", api)
+	}
+	// the first cycle starts the goroutines kept across listeners, e.g. of the shared flush
+	l, err := start(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Stop()
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 10; i++ {
+		l, err := start(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.Stop()
+	}
+	// listeners failing to start leave nothing running
+	inUse, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inUse.Close()
+	for i := 0; i < 10; i++ {
+		if _, err := start(inUse.Addr().(*net.TCPAddr).Port); err == nil {
+			t.Fatal("Expected an error starting a listener on a port in use")
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("Expected the goroutines of stopped listeners to exit, found %d before and %d after", before, after)
+	}
+}
+
+// Lines read per second from a connection with read buffers of several sizes, e.g.
+//
+//	go test -run none -bench ReadBufferSize ./points
+func BenchmarkReadBufferSize(b *testing.B) {
+	var chunk strings.Builder
+	for chunk.Len() < 1<<20 {
+		chunk.WriteString("cpu.load.avg.1m 0.42 1505454047 source=web01.example.com dc=lga env=prod\n")
+	}
+	data := []byte(chunk.String())
+	for _, size := range []int{4096, 16384, 65536, 262144} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			l := &DefaultPointListener{ReadBufferSize: size}
+			server, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer server.Close()
+			go func() {
+				conn, err := net.Dial("tcp", server.Addr().String())
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				for i := 0; i < b.N; i++ {
+					if _, err := conn.Write(data); err != nil {
+						return
+					}
+				}
+			}()
+			conn, err := server.Accept()
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer conn.Close()
+
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+			scanner := l.lineScanner(conn, "")
+			for scanner.Scan() {
+			}
+		})
+	}
+}
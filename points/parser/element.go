@@ -4,16 +4,39 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"sync/atomic"
 	"time"
 
+	"github.com/rcrowley/go-metrics"
 	"github.com/wavefronthq/go-proxy/common"
 )
 
+// Units of the timestamps of parsed points
+const (
+	// inferred from the number of digits: 10 for seconds, 13 for milliseconds, 16 for
+	// microseconds and 19 for nanoseconds
+	UnitAuto = iota
+	UnitSeconds
+	UnitMillis
+)
+
 var (
 	ErrEOF              = errors.New("EOF")
 	ErrInvalidTimestamp = errors.New("Invalid timestamp")
+
+	timestampUnit int32 = UnitAuto
+
+	// timestamps converted to seconds, by the unit they were received in
+	normalizedMillis = metrics.GetOrRegisterCounter("timestamps.normalized.ms", nil)
+	normalizedMicros = metrics.GetOrRegisterCounter("timestamps.normalized.us", nil)
+	normalizedNanos  = metrics.GetOrRegisterCounter("timestamps.normalized.ns", nil)
 )
 
+// Sets the unit of the timestamps parsed from then on, which are converted to seconds.
+func SetTimestampUnit(unit int) {
+	atomic.StoreInt32(&timestampUnit, int32(unit))
+}
+
 // Interface for parsing line elements.
 type ElementParser interface {
 	parse(p *PointParser, pt *common.Point) error
@@ -37,11 +60,18 @@ type LiteralParser struct {
 func (ep *NameParser) parse(p *PointParser, pt *common.Point) error {
 	//Valid characters are: a-z, A-Z, 0-9, hyphen ("-"), underscore ("_"), dot (".").
 	// Forward slash ("/") and comma (",") are allowed if metricName is enclosed in double quotes.
+	// Delta counter names start with a delta character ("∆" or "Δ").
+	prefix := ""
+	if tok, lit := p.scan(); tok == DELTA {
+		prefix = lit
+	} else {
+		p.unscan()
+	}
 	name, err := parseLiteral(p)
 	if err != nil {
 		return err
 	}
-	pt.Name = name
+	pt.Name = prefix + name
 	return nil
 }
 
@@ -101,16 +131,38 @@ func (ep *TimestampParser) parse(p *PointParser, pt *common.Point) error {
 }
 
 func setTimestamp(pt *common.Point, ts int64, numDigits int) error {
+	switch atomic.LoadInt32(&timestampUnit) {
+	case UnitSeconds:
+		if ts == 0 {
+			ts = getCurrentTime()
+		}
+		pt.Timestamp = ts
+		return nil
+	case UnitMillis:
+		if ts == 0 {
+			ts = getCurrentTime()
+		} else {
+			ts = ts / 1e3
+			normalizedMillis.Inc(1)
+		}
+		pt.Timestamp = ts
+		return nil
+	}
 
+	// 11 and 12 digits are neither seconds nor milliseconds of recent times, so are rejected
+	// rather than guessed
 	if numDigits == 19 {
 		// nanoseconds
 		ts = ts / 1e9
+		normalizedNanos.Inc(1)
 	} else if numDigits == 16 {
 		// microseconds
 		ts = ts / 1e6
+		normalizedMicros.Inc(1)
 	} else if numDigits == 13 {
 		// milliseconds
 		ts = ts / 1e3
+		normalizedMillis.Inc(1)
 	} else if numDigits != 10 {
 		// must be in seconds, return error if not 0
 		if ts == 0 {
@@ -34,6 +34,11 @@ var validPoints = [...]string{
 	// quotes
 	"\"mac.disk.total\" 4.9895440384E11 1504118031 source=\"Vikrams-MacBook-Pro.local\" \"path\"=\"/\" \"os\"=\"Mac\" \"device\"=\"disk1\" \"fstype\"=\"hfs\"",
 	"mac.cpu.usage.steal 0.000000 1505844752 cpu=\"cpu2\" os=\"Mac\" source=\"Vikrams-MacBook-Pro.local\"",
+
+	// delta counters
+	"∆foo.count 1 source=foo-linux",
+	"Δfoo.count 1 1505454047 source=foo-linux",
+	"\"∆foo.count\" 1 source=foo-linux",
 }
 
 var invalidPoints = [...]string{
@@ -114,3 +119,69 @@ func BenchmarkGraphiteParseComplex(b *testing.B) {
 func parsePoint(pt string) (*common.Point, error) {
 	return graphiteParser.Parse([]byte(pt))
 }
+
+func TestDeltaCounterName(t *testing.T) {
+	for _, pointLine := range []string{"∆foo.count 1 source=foo-linux", "\"∆foo.count\" 1 source=foo-linux"} {
+		pt, err := parsePoint(pointLine)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pt.Name != "∆foo.count" {
+			t.Errorf("Expected name ∆foo.count, found %s", pt.Name)
+		}
+	}
+}
+
+func TestTimestampUnits(t *testing.T) {
+	defer SetTimestampUnit(UnitAuto)
+	cases := []struct {
+		unit      int
+		timestamp string
+		expected  int64 // 0 if invalid
+	}{
+		{UnitAuto, "1505454047", 1505454047},
+		{UnitAuto, "9999999999", 9999999999},
+		{UnitAuto, "1505454047123", 1505454047},
+		{UnitAuto, "1000000000000", 1000000000},
+		{UnitAuto, "1505454047123456", 1505454047},
+		{UnitAuto, "1505454047123456789", 1505454047},
+		// too long for seconds and too short for milliseconds
+		{UnitAuto, "10000000000", 0},
+		{UnitAuto, "999999999999", 0},
+		{UnitAuto, "150545404", 0},
+		{UnitSeconds, "1505454047", 1505454047},
+		{UnitSeconds, "150545404", 150545404},
+		{UnitMillis, "1505454047123", 1505454047},
+		{UnitMillis, "150545404712", 150545404},
+	}
+	for _, c := range cases {
+		SetTimestampUnit(c.unit)
+		pt, err := parsePoint("foo.metric 1 " + c.timestamp + " source=foo-linux")
+		switch {
+		case c.expected == 0 && err == nil:
+			t.Errorf("Expected an error for %s with unit %d, found %d", c.timestamp, c.unit, pt.Timestamp)
+		case c.expected != 0 && (err != nil || pt.Timestamp != c.expected):
+			t.Errorf("Expected %d for %s with unit %d, found %v %v", c.expected, c.timestamp, c.unit, pt, err)
+		}
+	}
+}
+
+func TestNormalizedTimestampCounts(t *testing.T) {
+	defer SetTimestampUnit(UnitAuto)
+	millis, micros, nanos := normalizedMillis.Count(), normalizedMicros.Count(), normalizedNanos.Count()
+	for _, ts := range []string{"1505454047", "1505454047123", "1505454047123", "1505454047123456", "1505454047123456789"} {
+		if _, err := parsePoint("foo.metric 1 " + ts + " source=foo-linux"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	SetTimestampUnit(UnitMillis)
+	if _, err := parseOpenTSDBPoint("put foo.metric 1505454047000 1 host=foo-linux"); err != nil {
+		t.Fatal(err)
+	}
+	if n := normalizedMillis.Count() - millis; n != 3 {
+		t.Errorf("Expected 3 millisecond timestamps normalized, found %d", n)
+	}
+	if normalizedMicros.Count()-micros != 1 || normalizedNanos.Count()-nanos != 1 {
+		t.Error("Expected 1 microsecond and 1 nanosecond timestamp normalized")
+	}
+}
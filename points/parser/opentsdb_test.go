@@ -93,3 +93,18 @@ func BenchmarkOpenTSDBParseComplex(b *testing.B) {
 func parseOpenTSDBPoint(pt string) (*common.Point, error) {
 	return openTSDBParser.Parse([]byte(pt))
 }
+
+func TestOpenTSDBTimestampUnits(t *testing.T) {
+	for _, line := range []string{
+		"put foo.metric 1505454047 1 host=foo-linux",
+		"put foo.metric 1505454047000 1 host=foo-linux",
+	} {
+		pt, err := parseOpenTSDBPoint(line)
+		if err != nil || pt.Timestamp != 1505454047 {
+			t.Errorf("Expected seconds for %q, found %v %v", line, pt, err)
+		}
+	}
+	if _, err := parseOpenTSDBPoint("put foo.metric 150545404700 1 host=foo-linux"); err == nil {
+		t.Error("Expected an error for an ambiguous timestamp")
+	}
+}
@@ -2,9 +2,9 @@ package parser
 
 import (
 	"bytes"
-	"log"
 
 	"github.com/wavefronthq/go-proxy/common"
+	"github.com/wavefronthq/go-proxy/logger"
 )
 
 const MAX_BUFFER_SIZE = 2
@@ -42,6 +42,15 @@ func NewOpenTSDBElements() []ElementParser {
 	return elements
 }
 
+// Returns a slice of ElementParser's for the metric name and tags following histogram centroids
+func NewHistogramElements() []ElementParser {
+	var elements []ElementParser
+	wsParser := WhiteSpaceParser{}
+	repeatParser := LoopedParser{wrappedParser: &TagParser{}, wsPaser: &wsParser}
+	elements = append(elements, &NameParser{}, &wsParser, &repeatParser)
+	return elements
+}
+
 // Returns new instance of Graphite format specific parser
 func NewGraphiteParser() *PointParser {
 	elements := NewGraphiteElements()
@@ -93,7 +102,7 @@ func (p *PointParser) unscan() {
 func (p *PointParser) unscanTokens(n int) {
 	if n > MAX_BUFFER_SIZE {
 		// just log for now
-		log.Printf("cannot unscan more than %d tokens", MAX_BUFFER_SIZE)
+		logger.Errorf("cannot unscan more than %d tokens", MAX_BUFFER_SIZE)
 	}
 	p.buf.n += n
 }
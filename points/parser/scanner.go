@@ -62,6 +62,8 @@ func (s *PointScanner) Scan() (Token, string) {
 		return QUOTES, string(ch)
 	case '=':
 		return EQUALS, string(ch)
+	case '∆', 'Δ':
+		return DELTA, string(ch)
 	}
 	return ILLEGAL, string(ch)
 }
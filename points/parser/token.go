@@ -23,6 +23,7 @@ const (
 	// Misc characters
 	EQUALS
 	NEWLINE
+	DELTA // prefix of delta counter metric names
 )
 
 func isWhitespace(ch rune) bool {
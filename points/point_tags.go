@@ -0,0 +1,50 @@
+package points
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/wavefronthq/go-proxy/common"
+	"github.com/wavefronthq/go-proxy/points/decoder"
+)
+
+// Adds fixed tags, such as the environment or region of the proxy, to every point.
+// Tags the client already set for the same keys are kept.
+type PointTagger struct {
+	tags map[string]string
+}
+
+// Parses a comma separated list of key=value tags.
+func NewPointTagger(pairs string) (*PointTagger, error) {
+	t := &PointTagger{tags: make(map[string]string)}
+	for _, pair := range strings.Split(pairs, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		sep := strings.Index(pair, "=")
+		if sep <= 0 || sep == len(pair)-1 {
+			return nil, fmt.Errorf("invalid point tag %q, expected key=value", pair)
+		}
+		key, value := strings.TrimSpace(pair[:sep]), strings.TrimSpace(pair[sep+1:])
+		if key == "source" || key == "host" {
+			return nil, fmt.Errorf("invalid point tag %q, the source is not a tag", pair)
+		}
+		if err := decoder.ValidateTag(key, value); err != nil {
+			return nil, fmt.Errorf("invalid point tag %q: %v", pair, err)
+		}
+		t.tags[key] = value
+	}
+	return t, nil
+}
+
+func (t *PointTagger) Process(point *common.Point) bool {
+	if point.Tags == nil {
+		point.Tags = make(map[string]string, len(t.tags))
+	}
+	for k, v := range t.tags {
+		if _, ok := point.Tags[k]; !ok {
+			point.Tags[k] = v
+		}
+	}
+	return true
+}
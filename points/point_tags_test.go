@@ -0,0 +1,33 @@
+package points
+
+import (
+	"testing"
+
+	"github.com/wavefronthq/go-proxy/common"
+)
+
+func TestPointTagger(t *testing.T) {
+	tagger, err := NewPointTagger("env=prod, region=us-west,proxy=edge1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	point := &common.Point{Name: "foo.metric", Source: "a"}
+	if !tagger.Process(point) || len(point.Tags) != 3 || point.Tags["region"] != "us-west" {
+		t.Errorf("Expected 3 tags, found %v", point.Tags)
+	}
+
+	point = &common.Point{Name: "foo.metric", Source: "a", Tags: map[string]string{"env": "dev", "app": "web"}}
+	tagger.Process(point)
+	if len(point.Tags) != 4 || point.Tags["env"] != "dev" || point.Tags["proxy"] != "edge1" {
+		t.Errorf("Expected the client env tag to be kept, found %v", point.Tags)
+	}
+}
+
+func TestPointTaggerInvalid(t *testing.T) {
+	for _, pairs := range []string{"env", "env=", "=prod", "env prod", "source=proxy", "en v=prod"} {
+		if _, err := NewPointTagger(pairs); err == nil {
+			t.Errorf("Expected error for %q", pairs)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package points
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/wavefronthq/go-proxy/common"
+	"github.com/wavefronthq/go-proxy/points/decoder"
+)
+
+// Prepends a prefix to the metric names of points, namespacing the metrics sent by the proxy
+// so they do not collide with the same metrics sent another way. Delta counter names keep
+// their delta character first.
+type MetricPrefixer struct {
+	prefix string
+}
+
+// Joins the prefix and metric names with the separator, unless the prefix already ends with it.
+func NewMetricPrefixer(prefix, separator string) (*MetricPrefixer, error) {
+	if err := decoder.ValidateMetricName(prefix + separator); err != nil {
+		return nil, fmt.Errorf("invalid metric prefix %q: %v", prefix+separator, err)
+	}
+	if !strings.HasSuffix(prefix, separator) {
+		prefix += separator
+	}
+	return &MetricPrefixer{prefix: prefix}, nil
+}
+
+func (p *MetricPrefixer) Process(point *common.Point) bool {
+	for _, delta := range deltaPrefixes {
+		if strings.HasPrefix(point.Name, delta) {
+			point.Name = delta + p.prefix + point.Name[len(delta):]
+			return true
+		}
+	}
+	point.Name = p.prefix + point.Name
+	return true
+}
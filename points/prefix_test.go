@@ -0,0 +1,35 @@
+package points
+
+import (
+	"testing"
+)
+
+func TestMetricPrefixer(t *testing.T) {
+	cases := []struct {
+		prefix, separator, name, expected string
+	}{
+		{"legacy", ".", "cpu.idle", "legacy.cpu.idle"},
+		{"legacy.", ".", "cpu.idle", "legacy.cpu.idle"},
+		{"legacy_", "", "cpu.idle", "legacy_cpu.idle"},
+		{"legacy", "_", "cpu.idle", "legacy_cpu.idle"},
+		{"legacy", ".", "∆requests", "∆legacy.requests"},
+	}
+	for _, c := range cases {
+		prefixer, err := NewMetricPrefixer(c.prefix, c.separator)
+		if err != nil {
+			t.Fatal(err)
+		}
+		point := newTestPoint(c.name, nil)
+		if !prefixer.Process(point) || point.Name != c.expected {
+			t.Errorf("Expected %s for prefix %q and separator %q, found %s", c.expected, c.prefix, c.separator, point.Name)
+		}
+	}
+}
+
+func TestMetricPrefixerInvalid(t *testing.T) {
+	for _, prefix := range []string{"leg acy", "legacy#"} {
+		if _, err := NewMetricPrefixer(prefix, "."); err == nil {
+			t.Errorf("Expected error for prefix %q", prefix)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package points
+
+import (
+	"regexp"
+	"sync"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/common"
+)
+
+// Interface for transforming or filtering decoded points before they are buffered.
+// Process returns false if the point should be dropped.
+type PointPreprocessor interface {
+	Process(point *common.Point) bool
+}
+
+// Implemented by preprocessors reporting metrics that must be refreshed while no points are
+// received, ticked every minute with the summary of the handlers.
+type tickingPreprocessor interface {
+	tick()
+}
+
+// Applies preprocessors in order, stopping at the first one that drops the point.
+type PreprocessorChain []PointPreprocessor
+
+func (c PreprocessorChain) Process(point *common.Point) bool {
+	for _, preprocessor := range c {
+		if !preprocessor.Process(point) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c PreprocessorChain) tick() {
+	for _, preprocessor := range c {
+		if p, ok := preprocessor.(tickingPreprocessor); ok {
+			p.tick()
+		}
+	}
+}
+
+// Adds a tag with the IP address points were received from to the points without a source of
+// their own. Tags already set on a point are not overridden. When the tag is source or host, the
+// IP address replaces the default source instead.
+func TagRemoteIP(points []*common.Point, tag, ip string) {
+	for _, point := range points {
+		if point.Source != "" && !point.DefaultSource {
+			continue
+		}
+		if tag == "source" || tag == "host" {
+			point.Source, point.DefaultSource = ip, false
+			continue
+		}
+		if _, ok := point.Tags[tag]; ok {
+			continue
+		}
+		if point.Tags == nil {
+			point.Tags = make(map[string]string)
+		}
+		point.Tags[tag] = ip
+	}
+}
+
+// Filters point tags by key using allow and deny regexes.
+// Tags that are denied or not allowed are stripped, or the point is dropped if dropPoints is set.
+type TagFilter struct {
+	allow          []*regexp.Regexp
+	deny           []*regexp.Regexp
+	dropPoints     bool
+	pointsModified metrics.Counter
+	pointsDropped  metrics.Counter
+}
+
+func NewTagFilter(allow, deny []string, dropPoints bool) (*TagFilter, error) {
+	allowRegexes, err := compileRegexes(allow)
+	if err != nil {
+		return nil, err
+	}
+	denyRegexes, err := compileRegexes(deny)
+	if err != nil {
+		return nil, err
+	}
+	return &TagFilter{
+		allow:          allowRegexes,
+		deny:           denyRegexes,
+		dropPoints:     dropPoints,
+		pointsModified: metrics.GetOrRegisterCounter("preprocessor.tags.modified", nil),
+		pointsDropped:  metrics.GetOrRegisterCounter("preprocessor.tags.dropped", nil),
+	}, nil
+}
+
+func compileRegexes(patterns []string) ([]*regexp.Regexp, error) {
+	regexes := make([]*regexp.Regexp, len(patterns))
+	for i, pattern := range patterns {
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		regexes[i] = regex
+	}
+	return regexes, nil
+}
+
+func matchesAny(regexes []*regexp.Regexp, s string) bool {
+	for _, regex := range regexes {
+		if regex.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *TagFilter) Process(point *common.Point) bool {
+	modified := false
+	for k := range point.Tags {
+		if f.allowed(k) {
+			continue
+		}
+		if f.dropPoints {
+			f.pointsDropped.Inc(1)
+			recordBlocked(point, "tag filter: "+k)
+			return false
+		}
+		delete(point.Tags, k)
+		modified = true
+	}
+	if modified {
+		f.pointsModified.Inc(1)
+	}
+	return true
+}
+
+func (f *TagFilter) allowed(key string) bool {
+	if len(f.allow) > 0 && !matchesAny(f.allow, key) {
+		return false
+	}
+	return !matchesAny(f.deny, key)
+}
+
+// Filters points by metric name. Points must match the whitelist, if set, and must not match the blacklist.
+// The regexes may be updated while points are processed.
+type MetricFilter struct {
+	mtx               sync.RWMutex
+	whitelist         *regexp.Regexp
+	blacklist         *regexp.Regexp
+	whitelistRejected metrics.Counter
+	blacklistRejected metrics.Counter
+}
+
+func NewMetricFilter(whitelist, blacklist string) (*MetricFilter, error) {
+	f := &MetricFilter{
+		whitelistRejected: metrics.GetOrRegisterCounter("preprocessor.metrics.dropped.whitelist", nil),
+		blacklistRejected: metrics.GetOrRegisterCounter("preprocessor.metrics.dropped.blacklist", nil),
+	}
+	err := f.Update(whitelist, blacklist)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Replaces the whitelist and blacklist, an empty regex disables the list.
+// The filter is unchanged if either regex is invalid.
+func (f *MetricFilter) Update(whitelist, blacklist string) error {
+	var whitelistRegex, blacklistRegex *regexp.Regexp
+	var err error
+	if whitelist != "" {
+		if whitelistRegex, err = regexp.Compile(whitelist); err != nil {
+			return err
+		}
+	}
+	if blacklist != "" {
+		if blacklistRegex, err = regexp.Compile(blacklist); err != nil {
+			return err
+		}
+	}
+
+	f.mtx.Lock()
+	f.whitelist, f.blacklist = whitelistRegex, blacklistRegex
+	f.mtx.Unlock()
+	return nil
+}
+
+func (f *MetricFilter) Process(point *common.Point) bool {
+	f.mtx.RLock()
+	whitelist, blacklist := f.whitelist, f.blacklist
+	f.mtx.RUnlock()
+
+	if whitelist != nil && !whitelist.MatchString(point.Name) {
+		f.whitelistRejected.Inc(1)
+		recordBlocked(point, "whitelistRegex "+whitelist.String())
+		return false
+	}
+	if blacklist != nil && blacklist.MatchString(point.Name) {
+		f.blacklistRejected.Inc(1)
+		recordBlocked(point, "blacklistRegex "+blacklist.String())
+		return false
+	}
+	return true
+}
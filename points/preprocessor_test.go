@@ -0,0 +1,117 @@
+package points
+
+import (
+	"testing"
+
+	"github.com/wavefronthq/go-proxy/common"
+)
+
+func newTestPoint(name string, tags map[string]string) *common.Point {
+	return &common.Point{Name: name, Value: "1", Timestamp: 1505454047, Source: "test", Tags: tags}
+}
+
+func TestTagFilter(t *testing.T) {
+	filter, err := NewTagFilter([]string{"^env$", "^region"}, []string{"^region_id$"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	point := newTestPoint("foo", map[string]string{"env": "dev", "region": "us", "region_id": "1", "request": "abc"})
+	if !filter.Process(point) {
+		t.Fatal("Point should not be dropped")
+	}
+	if len(point.Tags) != 2 || point.Tags["env"] != "dev" || point.Tags["region"] != "us" {
+		t.Errorf("Unexpected tags %v", point.Tags)
+	}
+
+	filter, _ = NewTagFilter(nil, []string{"^request"}, true)
+	if filter.Process(newTestPoint("foo", map[string]string{"request": "abc"})) {
+		t.Error("Point should be dropped")
+	}
+	if !filter.Process(newTestPoint("foo", map[string]string{"env": "dev"})) {
+		t.Error("Point should not be dropped")
+	}
+}
+
+func TestInvalidTagFilter(t *testing.T) {
+	if _, err := NewTagFilter([]string{"("}, nil, false); err == nil {
+		t.Error("Error expected for invalid regex")
+	}
+}
+
+func TestMetricFilter(t *testing.T) {
+	filter, err := NewMetricFilter("^prod\\.", "^prod\\.test\\.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	whitelisted := filter.whitelistRejected.Count()
+	blacklisted := filter.blacklistRejected.Count()
+
+	if !filter.Process(newTestPoint("prod.cpu.usage", nil)) {
+		t.Error("Point should not be dropped")
+	}
+	if filter.Process(newTestPoint("dev.cpu.usage", nil)) {
+		t.Error("Point not matching the whitelist should be dropped")
+	}
+	if filter.Process(newTestPoint("prod.test.cpu", nil)) {
+		t.Error("Point matching the blacklist should be dropped")
+	}
+	if filter.whitelistRejected.Count()-whitelisted != 1 || filter.blacklistRejected.Count()-blacklisted != 1 {
+		t.Error("Unexpected dropped point counts")
+	}
+
+	if _, err := NewMetricFilter("", "("); err == nil {
+		t.Error("Error expected for invalid regex")
+	}
+}
+
+func TestUpdateMetricFilter(t *testing.T) {
+	filter, _ := NewMetricFilter("", "^debug\\.")
+	if filter.Process(newTestPoint("debug.foo", nil)) || !filter.Process(newTestPoint("prod.foo", nil)) {
+		t.Fatal("Unexpected result before update")
+	}
+
+	if err := filter.Update("^debug\\.", ""); err != nil {
+		t.Fatal(err)
+	}
+	if !filter.Process(newTestPoint("debug.foo", nil)) || filter.Process(newTestPoint("prod.foo", nil)) {
+		t.Error("Unexpected result after update")
+	}
+
+	// invalid regexes leave the filter unchanged
+	if err := filter.Update("", "("); err == nil {
+		t.Error("Error expected for invalid regex")
+	}
+	if !filter.Process(newTestPoint("debug.foo", nil)) {
+		t.Error("Filter should not change after an invalid update")
+	}
+}
+
+func TestTagRemoteIP(t *testing.T) {
+	tagged := newTestPoint("foo", nil)
+	tagged.DefaultSource = true
+	explicitTag := newTestPoint("foo", map[string]string{"_remote_ip": "10.0.0.1"})
+	explicitTag.DefaultSource = true
+	explicitSource := newTestPoint("foo", nil)
+	TagRemoteIP([]*common.Point{tagged, explicitTag, explicitSource}, "_remote_ip", "127.0.0.1")
+	if tagged.Tags["_remote_ip"] != "127.0.0.1" {
+		t.Errorf("Expected remote IP tag, found %v", tagged.Tags)
+	}
+	if explicitTag.Tags["_remote_ip"] != "10.0.0.1" {
+		t.Errorf("Expected explicit tag to be kept, found %v", explicitTag.Tags)
+	}
+	if len(explicitSource.Tags) != 0 {
+		t.Errorf("Expected a point with its own source not to be tagged, found %v", explicitSource.Tags)
+	}
+
+	point := newTestPoint("foo", nil)
+	defaulted := newTestPoint("foo", nil)
+	defaulted.DefaultSource = true
+	TagRemoteIP([]*common.Point{point, defaulted}, "source", "127.0.0.1")
+	if point.Source != "test" || len(point.Tags) != 0 {
+		t.Errorf("Expected explicit source to be kept, found %s and %v", point.Source, point.Tags)
+	}
+	if defaulted.Source != "127.0.0.1" || defaulted.DefaultSource || len(defaulted.Tags) != 0 {
+		t.Errorf("Expected the default source replaced, found %s and %v", defaulted.Source, defaulted.Tags)
+	}
+}
@@ -0,0 +1,141 @@
+package points
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/api"
+	"github.com/wavefronthq/go-proxy/logger"
+	"github.com/wavefronthq/go-proxy/points/decoder"
+)
+
+var invalidPromWrites = metrics.GetOrRegisterCounter("promwrite.requests.invalid", nil)
+
+// Listener that accepts Prometheus remote_write requests POSTed to any path, e.g. /api/v1/write.
+type PromWriteListener struct {
+	Port         int
+	Host         string // interface to listen on, all interfaces if empty
+	Decoder      decoder.PromWriteDecoder
+	BufferDir    string // spools points exceeding the memory buffer to disk when set
+	DiskLimit    int64  // max bytes spooled to disk
+	Preprocessor PointPreprocessor
+	// time allowed to flush buffered points when stopped
+	ShutdownTimeout time.Duration
+	// drops duplicate points received within a flush window
+	Dedup bool
+	// time allowed for requests in progress to complete when stopped, they are closed at once if 0
+	DrainTimeout time.Duration
+	// sets the timestamps of points to the time they are received
+	UseProxyTime bool
+	handler      PointHandler
+	server       *http.Server
+	running      int32
+}
+
+func (l *PromWriteListener) Start(numForwarders, flushInterval, bufferSize, bufferBytes, maxFlushSize int,
+	format, workUnitId string, service api.WavefrontAPI) error {
+
+	logger.Infof("Starting prometheus remote_write listener on %s", l.address())
+	listener, err := net.Listen("tcp", listenAddr(l.Host, l.Port))
+	if err != nil {
+		return err
+	}
+
+	numForwarders, flushInterval = checkFlushSettings(numForwarders, flushInterval)
+	handler, err := newPointHandler(strconv.Itoa(l.Port), l.BufferDir, l.DiskLimit, l.ShutdownTimeout, l.Preprocessor, l.Dedup)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+	l.handler = handler
+	l.handler.init(numForwarders, flushInterval, bufferSize, bufferBytes, maxFlushSize, format, workUnitId, service)
+
+	l.server = &http.Server{Handler: http.HandlerFunc(l.write)}
+	go func() {
+		if err := l.server.Serve(listener); err != http.ErrServerClosed {
+			logger.Errorf("%d-listener: %v", l.Port, err)
+		}
+	}()
+	atomic.StoreInt32(&l.running, 1)
+	logger.Infof("Configured %d forwarders for %s prometheus remote_write listener on %s", numForwarders, format, l.address())
+	return nil
+}
+
+func (l *PromWriteListener) address() string {
+	if l.Host != "" {
+		return "address: " + listenAddr(l.Host, l.Port)
+	}
+	return fmt.Sprintf("port: %d", l.Port)
+}
+
+// Responds 204 once the points are buffered. Prometheus does not retry requests failing with
+// 400, so it is returned for malformed requests and requests with invalid series, whose valid
+// series are kept.
+func (l *PromWriteListener) write(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, decoder.MaxPromWriteLength+1))
+	if err != nil {
+		http.Error(w, "Error reading body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body) > decoder.MaxPromWriteLength {
+		invalidPromWrites.Inc(1)
+		http.Error(w, "Request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	points, failed, err := l.Decoder.Decode(body)
+	if err != nil {
+		invalidPromWrites.Inc(1)
+		http.Error(w, "Invalid remote_write request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if l.UseProxyTime {
+		stampProxyTime(points)
+	}
+	l.handler.reportPoints(points)
+	for _, series := range failed {
+		l.handler.handleBlockedPoint(series)
+	}
+	if len(failed) > 0 {
+		http.Error(w, fmt.Sprintf("%d series failed to decode", len(failed)), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (l *PromWriteListener) Update(numForwarders, flushInterval, bufferSize, bufferBytes, maxFlushSize int) {
+	numForwarders, flushInterval = checkFlushSettings(numForwarders, flushInterval)
+	l.handler.update(numForwarders, flushInterval, bufferSize, bufferBytes, maxFlushSize)
+}
+
+func (l *PromWriteListener) Stop() {
+	logger.Info("Stopping prometheus remote_write listener", l.Port)
+	atomic.StoreInt32(&l.running, 0)
+	if l.DrainTimeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), l.DrainTimeout)
+		if err := l.server.Shutdown(ctx); err != nil {
+			logger.Warnf("%d-listener: closing requests still in progress: %v", l.Port, err)
+		}
+		cancel()
+	}
+	l.server.Close()
+	l.handler.stop()
+}
+
+func (l *PromWriteListener) Status() ListenerStatus {
+	status := newListenerStatus(l.Port, ProtocolHTTP, atomic.LoadInt32(&l.running) == 1, l.handler)
+	status.Host = l.Host
+	return status
+}
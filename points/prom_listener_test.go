@@ -0,0 +1,42 @@
+package points
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// snappy compressed WriteRequest with the series up{instance="web01:9090"} and {job="api"}, which
+// has no name, each with a sample of 1 at 1500000000000
+const testPromWrite = "5cf05b0a3a0a0e0a085f5f6e616d655f5f120275700a160a08696e7374616e6365120a77656230313a39303930121009" +
+	"000000000000f03f1080b0def7d32b0a1e0a0a0a036a6f621203617069121009000000000000f03f1080b0def7d32b"
+
+func TestPromWrite(t *testing.T) {
+	handler := &testPointHandler{}
+	l := &PromWriteListener{handler: handler}
+	write := func(method string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/write", bytes.NewReader(body))
+		resp := httptest.NewRecorder()
+		l.write(resp, req)
+		return resp
+	}
+
+	body, _ := hex.DecodeString(testPromWrite)
+	resp := write("POST", body)
+	if resp.Code != http.StatusBadRequest || len(handler.points) != 1 || len(handler.blocked) != 1 {
+		t.Fatalf("Expected 400 with 1 point and 1 blocked series, found %d with %d points and %v", resp.Code, len(handler.points), handler.blocked)
+	}
+	if p := handler.points[0]; p.Name != "up" || p.Value != "1" || p.Timestamp != 1500000000 || p.Source != "web01" {
+		t.Errorf("Unexpected point %+v", p)
+	}
+
+	invalid := invalidPromWrites.Count()
+	if resp := write("POST", []byte("not snappy")); resp.Code != http.StatusBadRequest || invalidPromWrites.Count() != invalid+1 {
+		t.Errorf("Expected 400 counted for a malformed request, found %d", resp.Code)
+	}
+	if resp := write("GET", nil); resp.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, found %d", resp.Code)
+	}
+}
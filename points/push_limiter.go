@@ -0,0 +1,90 @@
+package points
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/logger"
+)
+
+// limits the points flushed per second across all forwarders and queue replays
+var pushLimiter = newPushRateLimiter()
+
+// Token bucket shared by all forwarders. Points that cannot be flushed remain buffered.
+type pushRateLimiter struct {
+	mtx       sync.Mutex
+	rate      float64 // 0 if unlimited
+	tokens    float64
+	last      time.Time
+	permitted metrics.Meter
+	rateGauge metrics.GaugeFloat64
+	throttled metrics.Gauge
+	now       func() time.Time
+}
+
+func newPushRateLimiter() *pushRateLimiter {
+	return &pushRateLimiter{
+		permitted: metrics.NewMeter(),
+		rateGauge: metrics.GetOrRegisterGaugeFloat64("push.ratelimit.rate", nil),
+		throttled: metrics.GetOrRegisterGauge("push.ratelimit.throttled", nil),
+		now:       time.Now,
+	}
+}
+
+// Sets the max points per second pushed to the Wavefront server, 0 for unlimited.
+func SetPushRateLimit(pointsPerSecond int) {
+	pushLimiter.setRate(pointsPerSecond)
+}
+
+func (l *pushRateLimiter) setRate(pointsPerSecond int) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if float64(pointsPerSecond) == l.rate {
+		return
+	}
+	l.rate = float64(pointsPerSecond)
+	l.tokens = l.rate
+	l.last = l.now()
+	if pointsPerSecond > 0 {
+		logger.Infof("Limiting pushes to %d points per second", pointsPerSecond)
+	}
+}
+
+// Returns the number of points, up to n, that may be flushed now.
+func (l *pushRateLimiter) acquire(n int) int {
+	if n == 0 {
+		return 0
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	permitted := n
+	if l.rate > 0 {
+		now := l.now()
+		l.tokens = math.Min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+		l.last = now
+		permitted = min(n, int(l.tokens))
+		l.tokens -= float64(permitted)
+	}
+
+	if permitted < n {
+		l.throttled.Update(1)
+	} else {
+		l.throttled.Update(0)
+	}
+	l.permitted.Mark(int64(permitted))
+	l.rateGauge.Update(l.permitted.Rate1())
+	return permitted
+}
+
+// Blocks until n points may be flushed.
+func (l *pushRateLimiter) wait(n int) {
+	for n > 0 {
+		n -= l.acquire(n)
+		if n > 0 {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package points
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPushRateLimiter(t *testing.T) {
+	now := time.Now()
+	limiter := newPushRateLimiter()
+	limiter.now = func() time.Time { return now }
+
+	if permitted := limiter.acquire(1000); permitted != 1000 {
+		t.Errorf("Expected all points permitted when unlimited, found %d", permitted)
+	}
+
+	limiter.setRate(100)
+	if permitted := limiter.acquire(150); permitted != 100 {
+		t.Errorf("Expected 100 points permitted, found %d", permitted)
+	}
+	if limiter.throttled.Value() != 1 {
+		t.Error("Expected throttling to be active")
+	}
+	if permitted := limiter.acquire(10); permitted != 0 {
+		t.Errorf("Expected no points permitted, found %d", permitted)
+	}
+
+	now = now.Add(200 * time.Millisecond)
+	if permitted := limiter.acquire(10); permitted != 10 {
+		t.Errorf("Expected 10 points permitted, found %d", permitted)
+	}
+	if limiter.throttled.Value() != 0 {
+		t.Error("Expected throttling to be inactive")
+	}
+}
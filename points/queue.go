@@ -1,13 +1,386 @@
 package points
 
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/logger"
+)
+
+const (
+	segmentSuffix  = ".spool"
+	maxSegmentSize = 4 * 1024 * 1024
+
+	segmentMagic   = "WFSPOOL"
+	segmentVersion = 1
+	// magic, version, encoding and a zero padded point count, so the count can be updated in place
+	segmentHeaderFormat = segmentMagic + " %d %s %012d\n"
+	segmentHeaderLength = len(segmentMagic) + 21
+	encodingPlain       = "none"
+	encodingGzip        = "gzip"
+)
+
+var (
+	// total bytes spooled to disk across all queues
+	spooledBytes      int64
+	spooledBytesGauge = metrics.GetOrRegisterGauge("buffer.disk.bytes", nil)
+
+	// set to gzip compress segments created from then on
+	compressSegments int32
+
+	ErrInvalidSegment = errors.New("invalid spool segment header")
+)
+
+// Sets whether segments spooled from then on are gzip compressed. Existing segments are
+// replayed either way.
+func SetSpoolCompression(compress bool) {
+	var value int32
+	if compress {
+		value = 1
+	}
+	atomic.StoreInt32(&compressSegments, value)
+}
+
+// Interface for queueing points that do not fit in the memory buffer.
 type PointQueue interface {
 	queuePoints(points []string)
+	nextSegment() (string, []string, error)
+	removeSegment(name string)
+	// number of points queued and not flushed yet
+	spooledPoints() int64
+	close()
 }
 
+// Drops queued points.
 type DefaultPointQueue struct{}
 
-var bufferQueue = DefaultPointQueue{}
+func (DefaultPointQueue) queuePoints(points []string) {}
+
+func (DefaultPointQueue) nextSegment() (string, []string, error) {
+	return "", nil, nil
+}
+
+func (DefaultPointQueue) removeSegment(name string) {}
+
+func (DefaultPointQueue) spooledPoints() int64 {
+	return 0
+}
+
+func (DefaultPointQueue) close() {}
+
+// Disk backed queue that spools points to append-only segment files.
+// Segments are deleted once their points have been flushed.
+//
+// A segment starts with a fixed length header line, e.g. "WFSPOOL 1 gzip 000000012345", giving
+// the format version, the encoding of the points, none or gzip, and the number of points, which
+// is updated after each write. The points follow as newline separated lines in the Wavefront
+// format. Compressed segments hold a gzip member per write, which gzip tools read as one stream.
+// Segments without a header, written before the header was added, hold plain lines.
+type DiskPointQueue struct {
+	dir           string
+	maxBytes      int64
+	mtx           sync.Mutex
+	segments      []string // completed segments, oldest first
+	current       *os.File
+	currentSize   int64
+	currentPoints int64
+	compressed    bool // of the current segment
+	sizes         map[string]int64
+	points        map[string]int64 // of the completed segments
+	pointsLost    metrics.Counter
+}
+
+// Header of a spool segment.
+type SegmentHeader struct {
+	Version    int // 0 for segments without a header
+	Compressed bool
+	Points     int64
+}
+
+func (h SegmentHeader) encoding() string {
+	if h.Compressed {
+		return encodingGzip
+	}
+	return encodingPlain
+}
+
+func (h SegmentHeader) bytes() []byte {
+	return []byte(fmt.Sprintf(segmentHeaderFormat, h.Version, h.encoding(), h.Points))
+}
+
+func NewDiskPointQueue(dir string, maxBytes int64, prefix string) (*DiskPointQueue, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &DiskPointQueue{
+		dir:        dir,
+		maxBytes:   maxBytes,
+		sizes:      make(map[string]int64),
+		points:     make(map[string]int64),
+		pointsLost: metrics.GetOrRegisterCounter("buffer."+prefix+".points.lost", nil),
+	}
+
+	// pick up segments spooled by a previous run
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), segmentSuffix) {
+			continue
+		}
+		name := filepath.Join(dir, file.Name())
+		q.segments = append(q.segments, name)
+		q.sizes[name] = file.Size()
+		q.points[name] = segmentPoints(name)
+		addSpooledBytes(file.Size())
+	}
+	sort.Strings(q.segments)
+
+	if len(q.segments) > 0 {
+		logger.Infof("Found %d spooled segments in %s", len(q.segments), dir)
+	}
+	return q, nil
+}
+
+func addSpooledBytes(delta int64) {
+	spooledBytesGauge.Update(atomic.AddInt64(&spooledBytes, delta))
+}
+
+func (q *DiskPointQueue) queuePoints(points []string) {
+	if len(points) == 0 {
+		return
+	}
+	data := []byte(strings.Join(points, "\n") + "\n")
+
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	// checked before compression, which only ever shrinks the points
+	if atomic.LoadInt64(&spooledBytes)+int64(len(data)) > q.maxBytes {
+		logger.Warnf("%s: disk buffer limit reached, dropping %d points", q.dir, len(points))
+		q.pointsLost.Inc(int64(len(points)))
+		return
+	}
+
+	if q.current == nil {
+		// segment names sort in creation order
+		name := filepath.Join(q.dir, fmt.Sprintf("%020d%s", time.Now().UnixNano(), segmentSuffix))
+		if err := q.create(name); err != nil {
+			logger.Errorf("%s: error creating segment: %v", q.dir, err)
+			q.pointsLost.Inc(int64(len(points)))
+			return
+		}
+	}
+
+	if q.compressed {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(data)
+		zw.Close()
+		data = buf.Bytes()
+	}
+	header := SegmentHeader{Version: segmentVersion, Compressed: q.compressed, Points: q.currentPoints + int64(len(points))}
+	_, err := q.current.WriteAt(data, q.currentSize)
+	if err == nil {
+		_, err = q.current.WriteAt(header.bytes(), 0)
+	}
+	if err != nil {
+		logger.Errorf("%s: error writing segment: %v", q.dir, err)
+		q.pointsLost.Inc(int64(len(points)))
+		return
+	}
+	size := int64(len(data))
+	q.currentSize += size
+	q.currentPoints = header.Points
+	addSpooledBytes(size)
+
+	if q.currentSize >= maxSegmentSize {
+		q.rotate()
+	}
+}
+
+// Creates the current segment with its header, compressed if set when it is created.
+func (q *DiskPointQueue) create(name string) error {
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	header := SegmentHeader{Version: segmentVersion, Compressed: atomic.LoadInt32(&compressSegments) == 1}
+	if _, err := file.Write(header.bytes()); err != nil {
+		file.Close()
+		os.Remove(name)
+		return err
+	}
+	q.current = file
+	q.currentSize = int64(segmentHeaderLength)
+	q.currentPoints = 0
+	q.compressed = header.Compressed
+	addSpooledBytes(q.currentSize)
+	return nil
+}
+
+// closes the current segment making it available for replay
+func (q *DiskPointQueue) rotate() {
+	if q.current == nil {
+		return
+	}
+	name := q.current.Name()
+	q.current.Close()
+	q.current = nil
+	q.segments = append(q.segments, name)
+	q.sizes[name] = q.currentSize
+	q.points[name] = q.currentPoints
+	q.currentSize = 0
+	q.currentPoints = 0
+}
+
+// Returns the oldest segment and its points, or an empty name if nothing is queued.
+func (q *DiskPointQueue) nextSegment() (string, []string, error) {
+	q.mtx.Lock()
+	if len(q.segments) == 0 && q.currentSize > 0 {
+		q.rotate()
+	}
+	if len(q.segments) == 0 {
+		q.mtx.Unlock()
+		return "", nil, nil
+	}
+	name := q.segments[0]
+	q.mtx.Unlock()
+
+	_, points, err := ReadSegment(name)
+	return name, points, err
+}
+
+// Reads the header and points of a segment file, whether compressed or not.
+func ReadSegment(name string) (SegmentHeader, []string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return SegmentHeader{}, nil, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	header, err := readSegmentHeader(r)
+	if err != nil {
+		return header, nil, err
+	}
+
+	// sniffed rather than taken from the header, so compressed segments without one are read too
+	var body io.Reader = r
+	if magic, _ := r.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return header, nil, err
+		}
+		defer zr.Close()
+		header.Compressed = true
+		body = zr
+	}
+
+	var points []string
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxSegmentSize)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			points = append(points, line)
+		}
+	}
+	err = scanner.Err()
+	if err == io.ErrUnexpectedEOF && header.Compressed {
+		// the last write was cut short, e.g. by a crash, the points before it are intact
+		logger.Warnf("%s: compressed segment truncated after %d points", name, len(points))
+		err = nil
+	}
+	if header.Version == 0 {
+		header.Points = int64(len(points))
+	}
+	return header, points, err
+}
+
+// Returns the number of points of a segment from its header, reading the points of segments
+// without one.
+func segmentPoints(name string) int64 {
+	file, err := os.Open(name)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+	if header, err := readSegmentHeader(bufio.NewReader(file)); err != nil || header.Version != 0 {
+		return header.Points
+	}
+	_, points, _ := ReadSegment(name)
+	return int64(len(points))
+}
+
+// Reads the header line of a segment, returning a version 0 header if it has none.
+func readSegmentHeader(r *bufio.Reader) (SegmentHeader, error) {
+	var header SegmentHeader
+	if magic, _ := r.Peek(len(segmentMagic)); string(magic) != segmentMagic {
+		return header, nil
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return header, ErrInvalidSegment
+	}
+	var encoding string
+	if _, err := fmt.Sscanf(strings.TrimSpace(line), segmentMagic+" %d %s %d", &header.Version, &encoding, &header.Points); err != nil {
+		return header, ErrInvalidSegment
+	}
+	if header.Version != segmentVersion || (encoding != encodingPlain && encoding != encodingGzip) {
+		return header, fmt.Errorf("unsupported spool segment version %d encoding %s", header.Version, encoding)
+	}
+	header.Compressed = encoding == encodingGzip
+	return header, nil
+}
+
+func (q *DiskPointQueue) removeSegment(name string) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	err := os.Remove(name)
+	if err != nil && !os.IsNotExist(err) {
+		logger.Errorf("%s: error removing segment: %v", q.dir, err)
+		return
+	}
+	for i, segment := range q.segments {
+		if segment == name {
+			q.segments = append(q.segments[:i], q.segments[i+1:]...)
+			break
+		}
+	}
+	addSpooledBytes(-q.sizes[name])
+	delete(q.sizes, name)
+	delete(q.points, name)
+}
+
+func (q *DiskPointQueue) spooledPoints() int64 {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	spooled := q.currentPoints
+	for _, points := range q.points {
+		spooled += points
+	}
+	return spooled
+}
 
-func (DefaultPointQueue) queuePoints(points []string) {
-	//TODO: dropping on the floor for now, enhance to buffer to disk and process the buffer queues
+func (q *DiskPointQueue) close() {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	q.rotate()
 }
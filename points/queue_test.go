@@ -0,0 +1,161 @@
+package points
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiskPointQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wavefront-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q, err := NewDiskPointQueue(dir, 1024*1024, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.queuePoints([]string{"a 1 source=s", "b 2 source=s"})
+	q.queuePoints([]string{"c 3 source=s"})
+	if n := q.spooledPoints(); n != 3 {
+		t.Errorf("Expected 3 points spooled, found %d", n)
+	}
+	q.close()
+
+	// segments should survive a restart
+	q, err = NewDiskPointQueue(dir, 1024*1024, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := q.spooledPoints(); n != 3 {
+		t.Errorf("Expected 3 points spooled after a restart, found %d", n)
+	}
+	name, points, err := q.nextSegment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 3 {
+		t.Errorf("Expected 3 points, found %d", len(points))
+	}
+
+	q.removeSegment(name)
+	if n := q.spooledPoints(); n != 0 {
+		t.Errorf("Expected no points spooled, found %d", n)
+	}
+	name, points, err = q.nextSegment()
+	if err != nil || name != "" || len(points) != 0 {
+		t.Errorf("Expected empty queue, found %s %v %v", name, points, err)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 0 {
+		t.Errorf("Expected segments to be removed, found %d", len(files))
+	}
+}
+
+func TestDiskPointQueueLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wavefront-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	spooledBytes = 0
+	q, err := NewDiskPointQueue(dir, 16, "limit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.queuePoints([]string{"a 1 source=s"})
+	q.queuePoints([]string{"b 2 source=s"})
+	if q.pointsLost.Count() != 1 {
+		t.Errorf("Expected 1 lost point, found %d", q.pointsLost.Count())
+	}
+
+	name, _, _ := q.nextSegment()
+	q.removeSegment(name)
+}
+
+func TestCompressedSegments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wavefront-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q, err := NewDiskPointQueue(dir, 1024*1024, "compressed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetSpoolCompression(true)
+	q.queuePoints([]string{"a 1 source=s", "b 2 source=s"})
+	q.queuePoints([]string{"c 3 source=s"})
+	q.close()
+	// segments created before the setting changes stay as they are
+	SetSpoolCompression(false)
+	q.queuePoints([]string{"d 4 source=s"})
+	q.close()
+
+	expected := []struct {
+		header SegmentHeader
+		points int
+	}{
+		{SegmentHeader{Version: segmentVersion, Compressed: true, Points: 3}, 3},
+		{SegmentHeader{Version: segmentVersion, Points: 1}, 1},
+	}
+	for _, e := range expected {
+		name, points, err := q.nextSegment()
+		if err != nil || len(points) != e.points {
+			t.Fatalf("Expected %d points, found %v %v", e.points, points, err)
+		}
+		header, _, err := ReadSegment(name)
+		if err != nil || header != e.header {
+			t.Errorf("Expected header %+v, found %+v %v", e.header, header, err)
+		}
+		raw, _ := ioutil.ReadFile(name)
+		if compressed := bytes.HasPrefix(raw[segmentHeaderLength:], []byte{0x1f, 0x8b}); compressed != e.header.Compressed {
+			t.Errorf("Expected compressed %t for %s", e.header.Compressed, raw[:segmentHeaderLength])
+		}
+		q.removeSegment(name)
+	}
+}
+
+func TestReadSegmentFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wavefront-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte("a 1 source=s\nb 2 source=s\n"))
+	zw.Close()
+	segments := map[string]struct {
+		data   []byte
+		header SegmentHeader
+		valid  bool
+	}{
+		// written before segments had headers
+		"legacy":           {[]byte("a 1 source=s\nb 2 source=s\n"), SegmentHeader{Points: 2}, true},
+		"legacy gzip":      {gz.Bytes(), SegmentHeader{Compressed: true, Points: 2}, true},
+		"truncated":        {append([]byte("WFSPOOL 1 gzip 000000000004\n"), gz.Bytes()[:gz.Len()-4]...), SegmentHeader{Version: 1, Compressed: true, Points: 4}, true},
+		"unknown version":  {[]byte("WFSPOOL 2 none 000000000000\n"), SegmentHeader{}, false},
+		"unknown encoding": {[]byte("WFSPOOL 1 zstd 000000000000\n"), SegmentHeader{}, false},
+		"header only":      {[]byte("WFSPOOL 1 none"), SegmentHeader{}, false},
+	}
+	for name, segment := range segments {
+		path := filepath.Join(dir, name+segmentSuffix)
+		ioutil.WriteFile(path, segment.data, 0644)
+		header, points, err := ReadSegment(path)
+		if (err == nil) != segment.valid {
+			t.Errorf("Unexpected error reading the %s segment: %v", name, err)
+		}
+		if segment.valid && (header != segment.header || len(points) != 2) {
+			t.Errorf("Expected header %+v and 2 points for the %s segment, found %+v %v", segment.header, name, header, points)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package points
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/common"
+	"github.com/wavefronthq/go-proxy/logger"
+)
+
+const (
+	rateLimitSweepInterval = time.Minute
+	rateLimitIdleExpiry    = 5 * time.Minute
+)
+
+// Token bucket tracking the points accepted for a single source.
+type tokenBucket struct {
+	tokens   float64
+	last     time.Time
+	dropped  int64 // dropped since the last sweep
+	counter  metrics.Counter
+	lastSeen time.Time
+}
+
+// Limits the points per second accepted from each source.
+// Sources that exceed the limit are logged every sweep interval and idle sources are expired.
+type SourceRateLimiter struct {
+	rate      float64
+	burst     float64
+	mtx       sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	dropped   metrics.Counter
+	now       func() time.Time
+}
+
+func NewSourceRateLimiter(pointsPerSecond int) *SourceRateLimiter {
+	return &SourceRateLimiter{
+		rate:      float64(pointsPerSecond),
+		burst:     float64(pointsPerSecond),
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+		dropped:   metrics.GetOrRegisterCounter("ratelimit.points.dropped", nil),
+		now:       time.Now,
+	}
+}
+
+func (l *SourceRateLimiter) Process(point *common.Point) bool {
+	now := l.now()
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if now.Sub(l.lastSweep) >= rateLimitSweepInterval {
+		l.sweep(now)
+	}
+
+	bucket, ok := l.buckets[point.Source]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[point.Source] = bucket
+	}
+	bucket.lastSeen = now
+
+	bucket.tokens += now.Sub(bucket.last).Seconds() * l.rate
+	if bucket.tokens > l.burst {
+		bucket.tokens = l.burst
+	}
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		if bucket.counter == nil {
+			bucket.counter = metrics.GetOrRegisterCounter(rateLimitCounterName(point.Source), nil)
+		}
+		bucket.counter.Inc(1)
+		bucket.dropped++
+		l.dropped.Inc(1)
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// logs sources that were rate limited since the last sweep and expires idle sources
+func (l *SourceRateLimiter) sweep(now time.Time) {
+	for source, bucket := range l.buckets {
+		if bucket.dropped > 0 {
+			logger.Warnf("Source %s exceeded the rate limit, %d points dropped", source, bucket.dropped)
+			bucket.dropped = 0
+		}
+		if now.Sub(bucket.lastSeen) >= rateLimitIdleExpiry {
+			if bucket.counter != nil {
+				metrics.Unregister(rateLimitCounterName(source))
+			}
+			delete(l.buckets, source)
+		}
+	}
+	l.lastSweep = now
+}
+
+func rateLimitCounterName(source string) string {
+	return "ratelimit." + source + ".dropped"
+}
@@ -0,0 +1,48 @@
+package points
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSourceRateLimiter(t *testing.T) {
+	now := time.Now()
+	limiter := NewSourceRateLimiter(10)
+	limiter.now = func() time.Time { return now }
+
+	accepted := 0
+	for i := 0; i < 20; i++ {
+		if limiter.Process(newTestPoint("foo", nil)) {
+			accepted++
+		}
+	}
+	if accepted != 10 {
+		t.Errorf("Expected 10 points accepted, found %d", accepted)
+	}
+
+	// other sources have their own limit
+	other := newTestPoint("foo", nil)
+	other.Source = "other"
+	if !limiter.Process(other) {
+		t.Error("Point from another source should not be dropped")
+	}
+
+	// tokens are replenished over time
+	now = now.Add(500 * time.Millisecond)
+	accepted = 0
+	for i := 0; i < 10; i++ {
+		if limiter.Process(newTestPoint("foo", nil)) {
+			accepted++
+		}
+	}
+	if accepted != 5 {
+		t.Errorf("Expected 5 points accepted, found %d", accepted)
+	}
+
+	// idle sources are expired
+	now = now.Add(rateLimitIdleExpiry)
+	limiter.Process(other)
+	if _, ok := limiter.buckets["test"]; ok {
+		t.Error("Idle source should be expired")
+	}
+}
@@ -0,0 +1,6 @@
+//go:build linux && (mips || mipsle || mips64 || mips64le)
+
+package points
+
+// SO_REUSEPORT, which the syscall package does not define for linux
+const soReusePort = 0x200
@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package points
+
+import "syscall"
+
+// Whether the platform supports SO_REUSEPORT, which the ReusePort of the listeners sets.
+const ReusePortSupported = false
+
+// Does nothing, listeners are started without SO_REUSEPORT.
+func setReusePort(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,45 @@
+package points
+
+import (
+	"context"
+	"testing"
+)
+
+func TestReusePort(t *testing.T) {
+	if !ReusePortSupported {
+		t.Skip("SO_REUSEPORT is not supported")
+	}
+	l := &DefaultPointListener{ReusePort: true}
+	lc := l.listenConfig()
+	first, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	addr := first.Addr().String()
+
+	second, err := lc.Listen(context.Background(), "tcp", addr)
+	if err != nil {
+		t.Fatalf("Expected a second listener on %s, found %v", addr, err)
+	}
+	second.Close()
+
+	packets, err := lc.ListenPacket(context.Background(), "udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer packets.Close()
+	if more, err := lc.ListenPacket(context.Background(), "udp", addr); err != nil {
+		t.Errorf("Expected a second udp socket on %s, found %v", addr, err)
+	} else {
+		more.Close()
+	}
+
+	// sockets without the option cannot share the port
+	l.ReusePort = false
+	lc = l.listenConfig()
+	if other, err := lc.Listen(context.Background(), "tcp", addr); err == nil {
+		other.Close()
+		t.Errorf("Expected listening on %s without reusePort to fail", addr)
+	}
+}
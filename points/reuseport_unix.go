@@ -0,0 +1,20 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package points
+
+import "syscall"
+
+// Whether the platform supports SO_REUSEPORT, which the ReusePort of the listeners sets.
+const ReusePortSupported = true
+
+// Sets SO_REUSEPORT, a net.ListenConfig control function.
+func setReusePort(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
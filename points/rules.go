@@ -0,0 +1,237 @@
+package points
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/common"
+)
+
+const (
+	RuleReplaceRegex = "replaceRegex"
+	RuleLowercase    = "lowercase"
+	RuleAddTag       = "addTag"
+	RuleRenameTag    = "renameTag"
+	RuleDropTag      = "dropTag"
+
+	// rule scopes other than these name a point tag
+	ScopeMetricName = "metricName"
+	ScopeSourceName = "sourceName"
+)
+
+// A preprocessing rule as described in a rules file.
+//
+//	replaceRegex: replaces matches of search in scope with replace, which may reference groups as $1
+//	lowercase:    lowercases scope
+//	addTag:       sets tag to value
+//	renameTag:    renames tag to newTag
+//	dropTag:      removes tags with keys matching the tag regex
+type PreprocessorRule struct {
+	Name    string `json:"name"`
+	Action  string `json:"action"`
+	Scope   string `json:"scope"`
+	Tag     string `json:"tag"`
+	NewTag  string `json:"newTag"`
+	Value   string `json:"value"`
+	Search  string `json:"search"`
+	Replace string `json:"replace"`
+}
+
+// Applies rules loaded from a file to points in order. Rules never drop points.
+type RuleSet struct {
+	rules []*compiledRule
+}
+
+type compiledRule struct {
+	apply   func(point *common.Point) bool // returns true if the point was modified
+	applied metrics.Counter
+}
+
+// Loads a JSON array of rules from a file.
+func LoadRules(filename string) (*RuleSet, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRules(filename, data)
+}
+
+// Parses a JSON array of rules, errors are reported with the file name and line of the offending rule.
+func ParseRules(filename string, data []byte) (*RuleSet, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	errorAt := func(offset int64, err error) error {
+		return fmt.Errorf("%s:%d: %v", filename, lineAt(data, offset), err)
+	}
+
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return nil, errorAt(dec.InputOffset(), fmt.Errorf("expected an array of rules"))
+	}
+	ruleSet := &RuleSet{}
+	for dec.More() {
+		// skip the separator so the offset points at the rule itself
+		offset := dec.InputOffset()
+		for offset < int64(len(data)) && strings.ContainsRune(", \t\r\n", rune(data[offset])) {
+			offset++
+		}
+		var rule PreprocessorRule
+		err := dec.Decode(&rule)
+		if err != nil {
+			if syntaxErr, ok := err.(*json.SyntaxError); ok {
+				offset = syntaxErr.Offset
+			}
+			return nil, errorAt(offset, err)
+		}
+		compiled, err := compileRule(rule, len(ruleSet.rules)+1)
+		if err != nil {
+			return nil, errorAt(offset, err)
+		}
+		ruleSet.rules = append(ruleSet.rules, compiled)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, errorAt(dec.InputOffset(), err)
+	}
+	return ruleSet, nil
+}
+
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+func compileRule(rule PreprocessorRule, index int) (*compiledRule, error) {
+	name := rule.Name
+	if name == "" {
+		name = fmt.Sprintf("%s-%d", rule.Action, index)
+	}
+	compiled := &compiledRule{}
+
+	// takes pairs of field names and values
+	require := func(fields ...string) error {
+		for i := 0; i < len(fields); i += 2 {
+			if fields[i+1] == "" {
+				return fmt.Errorf("rule %s: %s requires %s", name, rule.Action, fields[i])
+			}
+		}
+		return nil
+	}
+
+	switch rule.Action {
+	case RuleReplaceRegex:
+		if err := require("scope", rule.Scope, "search", rule.Search); err != nil {
+			return nil, err
+		}
+		regex, err := regexp.Compile(rule.Search)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: invalid search: %v", name, err)
+		}
+		compiled.apply = func(point *common.Point) bool {
+			return updateScope(point, rule.Scope, func(s string) string {
+				return regex.ReplaceAllString(s, rule.Replace)
+			})
+		}
+	case RuleLowercase:
+		if err := require("scope", rule.Scope); err != nil {
+			return nil, err
+		}
+		compiled.apply = func(point *common.Point) bool {
+			return updateScope(point, rule.Scope, strings.ToLower)
+		}
+	case RuleAddTag:
+		if err := require("tag", rule.Tag, "value", rule.Value); err != nil {
+			return nil, err
+		}
+		compiled.apply = func(point *common.Point) bool {
+			if value, ok := point.Tags[rule.Tag]; ok && value == rule.Value {
+				return false
+			}
+			if point.Tags == nil {
+				point.Tags = make(map[string]string)
+			}
+			point.Tags[rule.Tag] = rule.Value
+			return true
+		}
+	case RuleRenameTag:
+		if err := require("tag", rule.Tag, "newTag", rule.NewTag); err != nil {
+			return nil, err
+		}
+		compiled.apply = func(point *common.Point) bool {
+			value, ok := point.Tags[rule.Tag]
+			if !ok {
+				return false
+			}
+			delete(point.Tags, rule.Tag)
+			point.Tags[rule.NewTag] = value
+			return true
+		}
+	case RuleDropTag:
+		if err := require("tag", rule.Tag); err != nil {
+			return nil, err
+		}
+		regex, err := regexp.Compile(rule.Tag)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: invalid tag: %v", name, err)
+		}
+		compiled.apply = func(point *common.Point) bool {
+			modified := false
+			for k := range point.Tags {
+				if regex.MatchString(k) {
+					delete(point.Tags, k)
+					modified = true
+				}
+			}
+			return modified
+		}
+	default:
+		return nil, fmt.Errorf("rule %s: unknown action %q", name, rule.Action)
+	}
+
+	compiled.applied = metrics.GetOrRegisterCounter("preprocessor.rules."+name+".applied", nil)
+	return compiled, nil
+}
+
+// Updates the metric name, source or tag value named by scope, returning true if it changed.
+func updateScope(point *common.Point, scope string, update func(string) string) bool {
+	var current string
+	switch scope {
+	case ScopeMetricName:
+		current = point.Name
+	case ScopeSourceName:
+		current = point.Source
+	default:
+		value, ok := point.Tags[scope]
+		if !ok {
+			return false
+		}
+		current = value
+	}
+
+	updated := update(current)
+	if updated == current {
+		return false
+	}
+	switch scope {
+	case ScopeMetricName:
+		point.Name = updated
+	case ScopeSourceName:
+		point.Source = updated
+	default:
+		point.Tags[scope] = updated
+	}
+	return true
+}
+
+func (r *RuleSet) Process(point *common.Point) bool {
+	for _, rule := range r.rules {
+		if rule.apply(point) {
+			rule.applied.Inc(1)
+		}
+	}
+	return true
+}
@@ -0,0 +1,62 @@
+package points
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const testRules = `[
+  {"action": "lowercase", "scope": "metricName"},
+  {"action": "replaceRegex", "scope": "metricName", "search": "^prod\\.(.*)$", "replace": "$1"},
+  {"action": "replaceRegex", "scope": "sourceName", "search": "\\.corp$", "replace": ""},
+  {"action": "renameTag", "tag": "host", "newTag": "hostname"},
+  {"action": "addTag", "tag": "env", "value": "prod"},
+  {"name": "tmp", "action": "dropTag", "tag": "^tmp_"}
+]`
+
+func TestRules(t *testing.T) {
+	rules, err := ParseRules("rules.json", []byte(testRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	point := newTestPoint("Prod.CPU.Idle", map[string]string{"host": "web1", "tmp_id": "1"})
+	point.Source = "web1.corp"
+	if !rules.Process(point) {
+		t.Fatal("Point should not be dropped")
+	}
+	if point.Name != "cpu.idle" || point.Source != "web1" {
+		t.Errorf("Unexpected name %s or source %s", point.Name, point.Source)
+	}
+	if len(point.Tags) != 2 || point.Tags["hostname"] != "web1" || point.Tags["env"] != "prod" {
+		t.Errorf("Unexpected tags %v", point.Tags)
+	}
+
+	// rules that do not change a point are not counted as applied
+	rules.Process(newTestPoint("foo", nil))
+	if count := metrics.GetOrRegisterCounter("preprocessor.rules.tmp.applied", nil).Count(); count != 1 {
+		t.Errorf("Expected dropTag to be applied once, found %d", count)
+	}
+	if count := metrics.GetOrRegisterCounter("preprocessor.rules.addTag-5.applied", nil).Count(); count != 2 {
+		t.Errorf("Expected addTag to be applied twice, found %d", count)
+	}
+}
+
+func TestInvalidRules(t *testing.T) {
+	invalid := map[string]string{
+		`{"action": "lowercase"}`: "rules.json:1: expected an array",
+		"[\n  {\"action\": \"lowercase\", \"scope\": \"metricName\"},\n  {\"action\": \"upcase\"}\n]":    "rules.json:3: rule upcase-2: unknown action",
+		"[\n  {\"action\": \"renameTag\", \"tag\": \"host\"}\n]":                                         "rules.json:2: rule renameTag-1: renameTag requires newTag",
+		"[\n\n  {\"action\": \"replaceRegex\", \"scope\": \"metricName\", \"search\": \"(\"}\n]":         "rules.json:3: rule replaceRegex-1: invalid search",
+		"[\n  {\"action\": \"dropTag\", \"tag\": \"x\"},\n  {\"action\": \"dropTag\" \"tag\": \"y\"}\n]": "rules.json:3: invalid character",
+		"[\n  {\"action\": \"addTag\", \"tag\": 1}\n]":                                                   "rules.json:2: json: cannot unmarshal",
+	}
+	for rules, expected := range invalid {
+		_, err := ParseRules("rules.json", []byte(rules))
+		if err == nil || !strings.HasPrefix(err.Error(), expected) {
+			t.Errorf("Expected error %q for rules %s, found %v", expected, rules, err)
+		}
+	}
+}
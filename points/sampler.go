@@ -0,0 +1,101 @@
+package points
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/common"
+)
+
+// Keeps a fraction of the series of metrics matching each rule's pattern. A series is kept when the hash
+// of its metric name, source and tags falls within the rate, so the same series are kept on every flush.
+// Points are sampled by the first matching rule, points matching no rule are kept.
+type Sampler struct {
+	rules []*sampleRule
+}
+
+type sampleRule struct {
+	pattern *regexp.Regexp
+	rate    float64
+	kept    metrics.Counter
+	dropped metrics.Counter
+}
+
+// Parses a comma separated list of pattern=rate rules, where rate is the fraction of series to keep.
+func NewSampler(rules string) (*Sampler, error) {
+	s := &Sampler{}
+	for _, rule := range strings.Split(rules, ",") {
+		if rule = strings.TrimSpace(rule); rule == "" {
+			continue
+		}
+		sep := strings.LastIndex(rule, "=")
+		if sep <= 0 {
+			return nil, fmt.Errorf("invalid sample rule %q, expected pattern=rate", rule)
+		}
+		pattern, err := regexp.Compile(rule[:sep])
+		if err != nil {
+			return nil, fmt.Errorf("invalid sample rule %q: %v", rule, err)
+		}
+		rate, err := strconv.ParseFloat(rule[sep+1:], 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("invalid sample rule %q, rate must be between 0 and 1", rule)
+		}
+		name := fmt.Sprintf("preprocessor.sample.rule-%d", len(s.rules)+1)
+		s.rules = append(s.rules, &sampleRule{
+			pattern: pattern,
+			rate:    rate,
+			kept:    metrics.GetOrRegisterCounter(name+".kept", nil),
+			dropped: metrics.GetOrRegisterCounter(name+".dropped", nil),
+		})
+	}
+	return s, nil
+}
+
+func (s *Sampler) Process(point *common.Point) bool {
+	for _, rule := range s.rules {
+		if !rule.pattern.MatchString(point.Name) {
+			continue
+		}
+		if seriesFraction(point) < rule.rate {
+			rule.kept.Inc(1)
+			return true
+		}
+		rule.dropped.Inc(1)
+		return false
+	}
+	return true
+}
+
+// Maps the series of a point to a value in [0, 1).
+func seriesFraction(point *common.Point) float64 {
+	keys := make([]string, 0, len(point.Tags))
+	for k := range point.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := fnv.New64a()
+	h.Write([]byte(point.Name))
+	h.Write([]byte{0})
+	h.Write([]byte(point.Source))
+	for _, k := range keys {
+		h.Write([]byte{0})
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write([]byte(point.Tags[k]))
+	}
+	// FNV spreads changes in the last bytes poorly, so mix the bits before using the top 53,
+	// which convert to a float64 exactly
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return float64(x>>11) / (1 << 53)
+}
@@ -0,0 +1,48 @@
+package points
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestSampler(t *testing.T) {
+	sampler, err := NewSampler(`^debug\.=0.25, ^trace\.=0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kept := 0
+	for i := 0; i < 1000; i++ {
+		point := newTestPoint("debug.requests", map[string]string{"id": fmt.Sprint(i)})
+		if sampler.Process(point) {
+			kept++
+		}
+		// the same series is consistently kept or dropped
+		again := newTestPoint("debug.requests", map[string]string{"id": fmt.Sprint(i)})
+		if sampler.Process(again) != sampler.Process(point) {
+			t.Fatalf("Series %d sampled inconsistently", i)
+		}
+	}
+	if math.Abs(float64(kept)-250) > 50 {
+		t.Errorf("Expected about 250 of 1000 series to be kept, found %d", kept)
+	}
+	if sampler.rules[0].kept.Count()+sampler.rules[0].dropped.Count() != 3000 {
+		t.Errorf("Unexpected sample counts %d and %d", sampler.rules[0].kept.Count(), sampler.rules[0].dropped.Count())
+	}
+
+	if sampler.Process(newTestPoint("trace.requests", nil)) {
+		t.Error("Points should be dropped at rate 0")
+	}
+	if !sampler.Process(newTestPoint("cpu.idle", nil)) {
+		t.Error("Points matching no rule should be kept")
+	}
+}
+
+func TestInvalidSampleRules(t *testing.T) {
+	for _, rules := range []string{"debug", "=0.5", "(=0.5", "debug=x", "debug=1.5"} {
+		if _, err := NewSampler(rules); err == nil {
+			t.Errorf("Error expected for sample rules %q", rules)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package points
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/api"
+	"github.com/wavefronthq/go-proxy/logger"
+)
+
+// coalesces the batches flushed by the forwarders of all listeners, disabled until a window is set
+var sharedFlush = newSharedBatcher()
+
+// Posts the batches of points flushed by forwarders of any listener within a window together,
+// so many listeners do not each send small requests every interval. The forwarders keep their
+// own buffers and wait for the merged request, re-buffering their points if it fails.
+type sharedBatcher struct {
+	mtx         sync.Mutex
+	window      time.Duration // 0 if disabled
+	pending     map[sharedBatchKey]*sharedBatch
+	batches     metrics.Counter
+	batchPoints metrics.Histogram
+}
+
+// Only batches of the same format and work unit for the same service can be merged, and only
+// those of listeners with the same pushFlushMaxPoints, so no request exceeds the limit of its group.
+type sharedBatchKey struct {
+	service    api.WavefrontAPI
+	workUnitId string
+	format     string
+	maxPoints  int
+}
+
+type sharedBatch struct {
+	key     sharedBatchKey
+	points  []string
+	waiting []chan flushResult // a result for each forwarder whose points are in the batch
+	timer   *time.Timer
+}
+
+type flushResult struct {
+	resp    *http.Response
+	elapsed time.Duration
+	err     error
+}
+
+func newSharedBatcher() *sharedBatcher {
+	return &sharedBatcher{
+		pending:     make(map[sharedBatchKey]*sharedBatch),
+		batches:     metrics.GetOrRegisterCounter("push.shared.batches", nil),
+		batchPoints: metrics.GetOrRegisterHistogram("push.shared.batch.points", nil, metrics.NewExpDecaySample(1028, 0.015)),
+	}
+}
+
+// Sets how long batches flushed by the listeners are collected for before they are posted
+// together, up to the pushFlushMaxPoints of their listeners per request. Disabled if 0.
+func SetSharedFlush(window time.Duration) {
+	sharedFlush.set(window)
+}
+
+func (b *sharedBatcher) set(window time.Duration) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if window == b.window {
+		return
+	}
+	if window > 0 && b.window == 0 {
+		logger.Infof("Merging flushes of all listeners within %v", window)
+	}
+	b.window = window
+}
+
+// Posts the points with the other batches flushed in the window, or right away if disabled.
+// The batches are posted in requests of at most maxPoints, the max flush size of the listener.
+func (b *sharedBatcher) post(service api.WavefrontAPI, workUnitId, format string, maxPoints int, points []string) (*http.Response, time.Duration, error) {
+	b.mtx.Lock()
+	if b.window == 0 {
+		b.mtx.Unlock()
+		return postPoints(service, workUnitId, format, points)
+	}
+
+	key := sharedBatchKey{service: service, workUnitId: workUnitId, format: format, maxPoints: max(maxPoints, 1)}
+	batch := b.pending[key]
+	if batch != nil && len(batch.points)+len(points) > key.maxPoints {
+		// no room left, the points start the next batch
+		b.detach(batch)
+		go b.flush(batch)
+		batch = nil
+	}
+	if batch == nil {
+		batch = &sharedBatch{key: key}
+		batch.timer = time.AfterFunc(b.window, func() { b.expire(batch) })
+		b.pending[key] = batch
+	}
+	result := make(chan flushResult, 1)
+	batch.points = append(batch.points, points...)
+	batch.waiting = append(batch.waiting, result)
+	if len(batch.points) >= key.maxPoints {
+		b.detach(batch)
+		go b.flush(batch)
+	}
+	b.mtx.Unlock()
+
+	r := <-result
+	return r.resp, r.elapsed, r.err
+}
+
+// Removes a batch from the pending batches before it is posted, must be called with the lock held.
+func (b *sharedBatcher) detach(batch *sharedBatch) {
+	delete(b.pending, batch.key)
+	batch.timer.Stop()
+}
+
+// Posts the batch at the end of its window, unless it was posted when it filled up.
+func (b *sharedBatcher) expire(batch *sharedBatch) {
+	b.mtx.Lock()
+	if b.pending[batch.key] != batch {
+		b.mtx.Unlock()
+		return
+	}
+	delete(b.pending, batch.key)
+	b.mtx.Unlock()
+	b.flush(batch)
+}
+
+func (b *sharedBatcher) flush(batch *sharedBatch) {
+	resp, elapsed, err := postPoints(batch.key.service, batch.key.workUnitId, batch.key.format, batch.points)
+	b.batches.Inc(1)
+	b.batchPoints.Update(int64(len(batch.points)))
+	for _, result := range batch.waiting {
+		result <- flushResult{resp: resp, elapsed: elapsed, err: err}
+	}
+}
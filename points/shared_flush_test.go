@@ -0,0 +1,86 @@
+package points
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSharedFlush(t *testing.T) {
+	SetSharedFlush(100 * time.Millisecond)
+	defer SetSharedFlush(0)
+	batches := sharedFlush.batches.Count()
+
+	// batches flushed within the window are posted in one request
+	service := &testAPI{}
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			points := []string{"a" + strconv.Itoa(i), "b" + strconv.Itoa(i), "c" + strconv.Itoa(i)}
+			if resp, _, err := sharedFlush.post(service, "", "wavefront", 10, points); err != nil || resp.StatusCode != 202 {
+				t.Errorf("Unexpected result %v %v", resp, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if service.requests != 1 || service.postedPoints() != 9 {
+		t.Errorf("Expected 9 points in 1 request, found %d in %d", service.postedPoints(), service.requests)
+	}
+
+	// batches that do not fit are posted separately
+	service = &testAPI{}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sharedFlush.post(service, "", "wavefront", 10, []string{"a", "b", "c", "d", "e", "f"})
+		}()
+		time.Sleep(10 * time.Millisecond)
+	}
+	wg.Wait()
+	if service.requests != 2 || service.postedPoints() != 12 {
+		t.Errorf("Expected 12 points in 2 requests, found %d in %d", service.postedPoints(), service.requests)
+	}
+
+	// other formats are not merged
+	service = &testAPI{}
+	for _, format := range []string{"wavefront", "histogram"} {
+		wg.Add(1)
+		go func(format string) {
+			defer wg.Done()
+			sharedFlush.post(service, "", format, 10, []string{"a"})
+		}(format)
+	}
+	wg.Wait()
+	if service.requests != 2 {
+		t.Errorf("Expected a request per format, found %d", service.requests)
+	}
+
+	// batches of listeners with other max flush sizes are not merged, each capped at its own
+	service = &testAPI{}
+	for _, maxPoints := range []int{4, 10} {
+		wg.Add(1)
+		go func(maxPoints int) {
+			defer wg.Done()
+			sharedFlush.post(service, "", "wavefront", maxPoints, []string{"a", "b", "c"})
+		}(maxPoints)
+	}
+	wg.Wait()
+	if service.requests != 2 {
+		t.Errorf("Expected a request per max flush size, found %d", service.requests)
+	}
+	if sent := sharedFlush.batches.Count() - batches; sent != 7 {
+		t.Errorf("Expected 7 batches counted, found %d", sent)
+	}
+
+	SetSharedFlush(0)
+	service = &testAPI{}
+	start := time.Now()
+	sharedFlush.post(service, "", "wavefront", 10, []string{"a"})
+	if service.requests != 1 || time.Since(start) >= 100*time.Millisecond {
+		t.Errorf("Expected points to be posted at once when disabled")
+	}
+}
@@ -0,0 +1,68 @@
+package points
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// sorts the points of each flush by timestamp when set
+var sortByTimestamp int32
+
+// Sorts the points of each flush batch by timestamp before they are sent, for consumers that
+// expect points in order. Off by default as it costs CPU on every flush.
+func SetSortByTimestamp(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&sortByTimestamp, v)
+}
+
+// Sorts point lines by timestamp, keeping the order of points with the same timestamp. Lines
+// without a timestamp, which are not formatted by the handlers, sort as the epoch.
+func sortLines(lines []string) {
+	type keyed struct {
+		ts   int64
+		line string
+	}
+	keys := make([]keyed, len(lines))
+	for i, line := range lines {
+		keys[i] = keyed{lineTimestamp(line), line}
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].ts < keys[j].ts })
+	for i := range keys {
+		lines[i] = keys[i].line
+	}
+}
+
+// Returns the timestamp of a line formatted by pointToString, 0 if not found.
+func lineTimestamp(line string) int64 {
+	var field string
+	if len(line) > 2 && line[0] == '!' {
+		// histograms: <granularity> <timestamp> ...
+		field = nextField(line[strings.IndexByte(line, ' ')+1:])
+	} else {
+		rest := line
+		if quoted, err := strconv.QuotedPrefix(line); err == nil {
+			rest = line[len(quoted):]
+		} else if i := strings.IndexByte(line, ' '); i >= 0 {
+			rest = line[i:]
+		}
+		// <metricName> <metricValue> <timestamp> ...
+		rest = strings.TrimLeft(rest, " ")
+		if i := strings.IndexByte(rest, ' '); i >= 0 {
+			field = nextField(rest[i+1:])
+		}
+	}
+	ts, _ := strconv.ParseInt(field, 10, 64)
+	return ts
+}
+
+func nextField(s string) string {
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
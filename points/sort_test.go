@@ -0,0 +1,97 @@
+package points
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/wavefronthq/go-proxy/common"
+)
+
+func TestLineTimestamp(t *testing.T) {
+	tests := []struct {
+		line string
+		ts   int64
+	}{
+		{`"foo" 1 1505454047 source="bar"`, 1505454047},
+		{`"foo bar" 1 1505454047 source="bar" "env"="prod"`, 1505454047},
+		{`foo 1 1505454047 source=bar`, 1505454047},
+		{`!M 1505454000 #2 1.5 "foo" source="bar"`, 1505454000},
+		{`"foo" 1`, 0},
+		{``, 0},
+	}
+	for _, test := range tests {
+		if ts := lineTimestamp(test.line); ts != test.ts {
+			t.Errorf("%q: expected %d, found %d", test.line, test.ts, ts)
+		}
+	}
+}
+
+func TestSortLines(t *testing.T) {
+	lines := []string{
+		`"c" 1 30 source="a"`,
+		`"a" 1 10 source="a"`,
+		`!M 20 #1 1 "h" source="a"`,
+		`"b" 1 10 source="a"`,
+		`"a" 2 10 source="a"`,
+	}
+	sortLines(lines)
+	expected := []string{
+		`"a" 1 10 source="a"`,
+		`"b" 1 10 source="a"`,
+		`"a" 2 10 source="a"`,
+		`!M 20 #1 1 "h" source="a"`,
+		`"c" 1 30 source="a"`,
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected %v, found %v", expected, lines)
+	}
+}
+
+func TestHandlerSortByTimestamp(t *testing.T) {
+	SetSortByTimestamp(true)
+	defer SetSortByTimestamp(false)
+	service := &testAPI{}
+	handler, err := newPointHandler("2881", "", 0, time.Second, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.init(1, 60000, 1000, 0, 10, "wavefront", "", service)
+	for _, ts := range []int64{30, 10, 20} {
+		handler.reportPoint(&common.Point{Name: "foo", Value: "1", Timestamp: ts, Source: "bar"})
+	}
+	handler.stop()
+
+	expected := []string{`"foo" 1 10 source="bar"`, `"foo" 1 20 source="bar"`, `"foo" 1 30 source="bar"`}
+	if !reflect.DeepEqual(service.points, expected) {
+		t.Errorf("Expected %v, found %v", expected, service.points)
+	}
+}
+
+// Cost of sorting a flush of default size, in order and out of order.
+func BenchmarkSortLines(b *testing.B) {
+	const size = 10000
+	ordered := make([]string, size)
+	for i := range ordered {
+		ordered[i] = fmt.Sprintf(`"cpu.load.%d" 0.5 %d source="host-%d" "env"="prod"`, i%100, 1505454047+i/10, i%20)
+	}
+	shuffled := append([]string(nil), ordered...)
+	rand.New(rand.NewSource(1)).Shuffle(size, func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+
+	for _, bench := range []struct {
+		name  string
+		lines []string
+	}{{"ordered", ordered}, {"shuffled", shuffled}} {
+		b.Run(bench.name, func(b *testing.B) {
+			lines := make([]string, size)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				copy(lines, bench.lines)
+				sortLines(lines)
+			}
+			b.ReportMetric(float64(size*b.N)/b.Elapsed().Seconds(), "points/s")
+		})
+	}
+}
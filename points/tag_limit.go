@@ -0,0 +1,49 @@
+package points
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/common"
+)
+
+// Limits the number of tags of a point. Points over the limit are trimmed to the tags with the
+// first keys in sorted order, so a point always keeps the same tags, or dropped if dropPoints is set.
+type TagLimiter struct {
+	maxTags       int
+	dropPoints    bool
+	pointsTrimmed metrics.Counter
+	pointsDropped metrics.Counter
+}
+
+func NewTagLimiter(maxTags int, dropPoints bool) *TagLimiter {
+	return &TagLimiter{
+		maxTags:       maxTags,
+		dropPoints:    dropPoints,
+		pointsTrimmed: metrics.GetOrRegisterCounter("preprocessor.maxtags.trimmed", nil),
+		pointsDropped: metrics.GetOrRegisterCounter("preprocessor.maxtags.dropped", nil),
+	}
+}
+
+func (l *TagLimiter) Process(point *common.Point) bool {
+	if len(point.Tags) <= l.maxTags {
+		return true
+	}
+	if l.dropPoints {
+		l.pointsDropped.Inc(1)
+		recordBlocked(point, "maxTagsPerPoint "+strconv.Itoa(l.maxTags))
+		return false
+	}
+
+	keys := make([]string, 0, len(point.Tags))
+	for k := range point.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys[l.maxTags:] {
+		delete(point.Tags, k)
+	}
+	l.pointsTrimmed.Inc(1)
+	return true
+}
@@ -0,0 +1,46 @@
+package points
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTagLimiter(t *testing.T) {
+	tags := func(n int) map[string]string {
+		tags := make(map[string]string, n)
+		for i := 0; i < n; i++ {
+			tags[fmt.Sprintf("tag%02d", i)] = "v"
+		}
+		return tags
+	}
+
+	limiter := NewTagLimiter(3, false)
+	trimmed := limiter.pointsTrimmed.Count()
+	if point := newTestPoint("foo", tags(3)); !limiter.Process(point) || len(point.Tags) != 3 {
+		t.Errorf("Expected the tags under the limit kept, found %v", point.Tags)
+	}
+	for i := 0; i < 10; i++ {
+		point := newTestPoint("foo", tags(20))
+		if !limiter.Process(point) {
+			t.Fatal("Point should not be dropped")
+		}
+		if len(point.Tags) != 3 || point.Tags["tag00"] == "" || point.Tags["tag01"] == "" || point.Tags["tag02"] == "" {
+			t.Fatalf("Expected the first 3 tags kept, found %v", point.Tags)
+		}
+	}
+	if n := limiter.pointsTrimmed.Count() - trimmed; n != 10 {
+		t.Errorf("Expected 10 points trimmed, found %d", n)
+	}
+
+	limiter = NewTagLimiter(3, true)
+	dropped := limiter.pointsDropped.Count()
+	if limiter.Process(newTestPoint("foo", tags(4))) {
+		t.Error("Point over the limit should be dropped")
+	}
+	if !limiter.Process(newTestPoint("foo", tags(3))) {
+		t.Error("Point at the limit should not be dropped")
+	}
+	if n := limiter.pointsDropped.Count() - dropped; n != 1 {
+		t.Errorf("Expected 1 point dropped, found %d", n)
+	}
+}
@@ -0,0 +1,73 @@
+package points
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/common"
+	"github.com/wavefronthq/go-proxy/logger"
+)
+
+// min time between logging clamped points
+const clampLogInterval = time.Minute
+
+// Rejects points with timestamps further in the future or past than the allowed skew from now,
+// as the Wavefront server rejects whole batches because of them. If clamp is set the timestamps
+// are moved to the nearest allowed time instead.
+type TimestampFilter struct {
+	maxFutureSkew int64 // seconds
+	maxPastSkew   int64 // seconds
+	clamp         bool
+	rejected      metrics.Counter
+	clamped       metrics.Counter
+	lastLog       int64 // unix time clamped points were last logged
+	now           func() time.Time
+}
+
+func NewTimestampFilter(maxFutureSkew, maxPastSkew int, clamp bool) *TimestampFilter {
+	return &TimestampFilter{
+		maxFutureSkew: int64(maxFutureSkew),
+		maxPastSkew:   int64(maxPastSkew),
+		clamp:         clamp,
+		rejected:      metrics.GetOrRegisterCounter("preprocessor.timestamps.rejected", nil),
+		clamped:       metrics.GetOrRegisterCounter("preprocessor.timestamps.clamped", nil),
+		now:           time.Now,
+	}
+}
+
+func (f *TimestampFilter) Process(point *common.Point) bool {
+	now := f.now().Unix()
+	earliest, latest := now-f.maxPastSkew, now+f.maxFutureSkew
+	if point.Timestamp >= earliest && point.Timestamp <= latest {
+		return true
+	}
+	if !f.clamp {
+		f.rejected.Inc(1)
+		recordBlocked(point, "timestamp filter")
+		return false
+	}
+
+	original := point.Timestamp
+	if point.Timestamp < earliest {
+		point.Timestamp = earliest
+	} else {
+		point.Timestamp = latest
+	}
+	f.clamped.Inc(1)
+
+	last := atomic.LoadInt64(&f.lastLog)
+	if now-last >= int64(clampLogInterval/time.Second) && atomic.CompareAndSwapInt64(&f.lastLog, last, now) {
+		logger.Debugf("Clamped timestamp %d of %s from source %s to %d", original, point.Name, point.Source, point.Timestamp)
+	}
+	return true
+}
+
+// Sets the timestamps of the points to the current time, for listeners using the proxy time
+// instead of the client timestamps.
+func stampProxyTime(points []*common.Point) {
+	now := time.Now().Unix()
+	for _, point := range points {
+		point.Timestamp = now
+	}
+}
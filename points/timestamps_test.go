@@ -0,0 +1,51 @@
+package points
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimestampFilter(t *testing.T) {
+	now := time.Unix(1505454047, 0)
+	filter := NewTimestampFilter(60, 3600, false)
+	filter.now = func() time.Time { return now }
+
+	cases := map[int64]bool{
+		now.Unix():        true,
+		now.Unix() + 60:   true,
+		now.Unix() + 61:   false,
+		now.Unix() - 3600: true,
+		now.Unix() - 3601: false,
+		0:                 false,
+	}
+	rejected := filter.rejected.Count()
+	for ts, expected := range cases {
+		point := newTestPoint("foo", nil)
+		point.Timestamp = ts
+		if filter.Process(point) != expected {
+			t.Errorf("Expected %v for timestamp %d", expected, ts)
+		}
+	}
+	if count := filter.rejected.Count() - rejected; count != 3 {
+		t.Errorf("Expected 3 rejected points, found %d", count)
+	}
+}
+
+func TestTimestampFilterClamp(t *testing.T) {
+	now := time.Unix(1505454047, 0)
+	filter := NewTimestampFilter(60, 3600, true)
+	filter.now = func() time.Time { return now }
+
+	cases := map[int64]int64{
+		now.Unix() + 1e6: now.Unix() + 60,
+		0:                now.Unix() - 3600,
+		now.Unix() - 10:  now.Unix() - 10,
+	}
+	for ts, expected := range cases {
+		point := newTestPoint("foo", nil)
+		point.Timestamp = ts
+		if !filter.Process(point) || point.Timestamp != expected {
+			t.Errorf("Expected timestamp %d to be clamped to %d, found %d", ts, expected, point.Timestamp)
+		}
+	}
+}
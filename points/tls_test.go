@@ -0,0 +1,179 @@
+package points
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wavefronthq/go-proxy/points/decoder"
+)
+
+// Writes the certificate and key of an httptest server to dir, returning their paths and the
+// certificate, which is its own CA.
+func writeTestCert(t *testing.T, dir string) (string, string, *x509.Certificate) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	cert := srv.TLS.Certificates[0]
+	ca := srv.Certificate()
+	srv.Close()
+
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, ca
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wavefront-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile, _ := writeTestCert(t, dir)
+	emptyFile := filepath.Join(dir, "empty.pem")
+	if err := ioutil.WriteFile(emptyFile, []byte("no certificates\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := NewTLSConfig(certFile, keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Certificates) != 1 || cfg.ClientAuth != tls.NoClientCert {
+		t.Errorf("Expected 1 certificate and no client certificates, found %d and %v", len(cfg.Certificates), cfg.ClientAuth)
+	}
+	cfg, err = NewTLSConfig(certFile, keyFile, certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ClientCAs == nil || cfg.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("Expected client certificates to be required, found %v", cfg.ClientAuth)
+	}
+
+	for _, c := range []struct {
+		certFile, keyFile, caFile string
+	}{
+		{filepath.Join(dir, "missing.pem"), keyFile, ""},
+		{certFile, certFile, ""},
+		{certFile, keyFile, filepath.Join(dir, "missing.pem")},
+		{certFile, keyFile, emptyFile},
+	} {
+		if _, err := NewTLSConfig(c.certFile, c.keyFile, c.caFile); err == nil {
+			t.Errorf("Expected an error for %+v", c)
+		}
+	}
+}
+
+func startTestTLSListener(t *testing.T, caFile bool) (*DefaultPointListener, *testPointHandler, *x509.Certificate) {
+	dir, err := ioutil.TempDir("", "wavefront-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile, ca := writeTestCert(t, dir)
+	clientCAFile := ""
+	if caFile {
+		clientCAFile = certFile
+	}
+	cfg, err := NewTLSConfig(certFile, keyFile, clientCAFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := &testPointHandler{}
+	l := &DefaultPointListener{Builder: decoder.GraphiteBuilder{}, TLSConfig: cfg, handler: handler}
+	if err := l.startTCPServer("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	return l, handler, ca
+}
+
+func TestTLSListener(t *testing.T) {
+	l, handler, ca := startTestTLSListener(t, false)
+	defer l.tcpListener.Close()
+	addr := l.tcpListener.Addr().String()
+	failures := tlsHandshakeFailures.Count()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: pool})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("foo.metric 1 source=a\n"))
+	conn.Close()
+
+	// plaintext clients are rejected
+	plain, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	plain.Write([]byte("foo.metric 2 source=a\n"))
+	if !closedWithin(plain, time.Second) {
+		t.Error("Expected plaintext connection to be closed")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if n := tlsHandshakeFailures.Count() - failures; n != 1 || handler.count() != 1 {
+		t.Errorf("Expected 1 handshake failure and 1 point, found %d and %d", n, handler.count())
+	}
+}
+
+func TestTLSListenerClientCert(t *testing.T) {
+	l, handler, ca := startTestTLSListener(t, true)
+	defer l.tcpListener.Close()
+	failures := tlsHandshakeFailures.Count()
+
+	// the handshake of the client completes before the server checks its certificate under
+	// TLS 1.3, so the connection is closed once read
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	conn, err := tls.Dial("tcp", l.tcpListener.Addr().String(), &tls.Config{RootCAs: pool})
+	if err == nil {
+		defer conn.Close()
+		conn.Write([]byte("foo.metric 1 source=a\n"))
+		if !closedWithin(conn, time.Second) {
+			t.Error("Expected connection without a client certificate to be closed")
+		}
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if n := tlsHandshakeFailures.Count() - failures; n != 1 || handler.count() != 0 {
+		t.Errorf("Expected 1 handshake failure and no points, found %d and %d", n, handler.count())
+	}
+}
+
+func TestTLSHandshakeTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { handshakeTimeout = timeout }(handshakeTimeout)
+	handshakeTimeout = 100 * time.Millisecond
+
+	// connections are never idle without a timeout, but must still complete a handshake
+	l, _, _ := startTestTLSListener(t, false)
+	defer l.tcpListener.Close()
+
+	conn, err := net.Dial("tcp", l.tcpListener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if !closedWithin(conn, time.Second) {
+		t.Error("Expected connection without a handshake to be closed")
+	}
+}
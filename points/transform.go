@@ -0,0 +1,125 @@
+package points
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/common"
+)
+
+// Operations of the value transform rules
+const (
+	TransformMultiply = "multiply"
+	TransformDivide   = "divide"
+	TransformOffset   = "offset"
+)
+
+// Converts the values of metrics matching each rule's pattern, e.g. metrics sent in bytes to
+// kilobytes. Points are transformed by the first matching rule, the values of histograms by
+// their centroids. Points whose transformed value is not a finite number are dropped.
+type ValueTransformer struct {
+	rules []*transformRule
+}
+
+type transformRule struct {
+	pattern     *regexp.Regexp
+	op          string
+	factor      float64
+	transformed metrics.Counter
+	invalid     metrics.Counter
+}
+
+// Parses a comma separated list of pattern:op:factor rules, where op is multiply, divide or
+// offset, which adds the factor.
+func NewValueTransformer(rules string) (*ValueTransformer, error) {
+	t := &ValueTransformer{}
+	for _, rule := range strings.Split(rules, ",") {
+		if rule = strings.TrimSpace(rule); rule == "" {
+			continue
+		}
+		// the pattern may contain colons
+		parts := strings.Split(rule, ":")
+		if len(parts) < 3 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid value transform %q, expected pattern:op:factor", rule)
+		}
+		op, factorStr := parts[len(parts)-2], parts[len(parts)-1]
+		pattern, err := regexp.Compile(strings.Join(parts[:len(parts)-2], ":"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value transform %q: %v", rule, err)
+		}
+		if op != TransformMultiply && op != TransformDivide && op != TransformOffset {
+			return nil, fmt.Errorf("invalid value transform %q, op must be multiply, divide or offset", rule)
+		}
+		factor, err := strconv.ParseFloat(factorStr, 64)
+		if err != nil || math.IsNaN(factor) || math.IsInf(factor, 0) || (op == TransformDivide && factor == 0) {
+			return nil, fmt.Errorf("invalid value transform %q, factor must be a finite number, not 0 to divide", rule)
+		}
+		name := fmt.Sprintf("preprocessor.transform.rule-%d", len(t.rules)+1)
+		t.rules = append(t.rules, &transformRule{
+			pattern:     pattern,
+			op:          op,
+			factor:      factor,
+			transformed: metrics.GetOrRegisterCounter(name+".transformed", nil),
+			invalid:     metrics.GetOrRegisterCounter(name+".invalid", nil),
+		})
+	}
+	return t, nil
+}
+
+func (t *ValueTransformer) Process(point *common.Point) bool {
+	for _, rule := range t.rules {
+		if rule.pattern.MatchString(point.Name) {
+			return rule.transform(point)
+		}
+	}
+	return true
+}
+
+func (rule *transformRule) transform(point *common.Point) bool {
+	if point.Histogram != nil {
+		centroids := point.Histogram.Centroids
+		for i := range centroids {
+			if centroids[i].Value = rule.apply(centroids[i].Value); !isFinite(centroids[i].Value) {
+				return rule.drop(point)
+			}
+		}
+		rule.transformed.Inc(1)
+		return true
+	}
+
+	value, err := strconv.ParseFloat(point.Value, 64)
+	if err != nil {
+		// left for the value filter to drop
+		return true
+	}
+	if value = rule.apply(value); !isFinite(value) {
+		return rule.drop(point)
+	}
+	point.Value = strconv.FormatFloat(value, 'f', -1, 64)
+	rule.transformed.Inc(1)
+	return true
+}
+
+func (rule *transformRule) apply(value float64) float64 {
+	switch rule.op {
+	case TransformMultiply:
+		return value * rule.factor
+	case TransformDivide:
+		return value / rule.factor
+	}
+	return value + rule.factor
+}
+
+func (rule *transformRule) drop(point *common.Point) bool {
+	rule.invalid.Inc(1)
+	recordBlocked(point, "value transform "+rule.pattern.String())
+	return false
+}
+
+func isFinite(value float64) bool {
+	return !math.IsNaN(value) && !math.IsInf(value, 0)
+}
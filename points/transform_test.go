@@ -0,0 +1,62 @@
+package points
+
+import (
+	"testing"
+
+	"github.com/wavefronthq/go-proxy/common"
+)
+
+func TestValueTransformer(t *testing.T) {
+	transformer, err := NewValueTransformer(`^legacy\.bytes\.:divide:1024, ^legacy\.latency$:multiply:0.001, ^temp\.:offset:-273, ^legacy\.:multiply:2`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, value, expected string
+	}{
+		{"legacy.bytes.sent", "2048", "2"},
+		{"legacy.latency", "1500", "1.5"},
+		{"temp.kelvin", "300", "27"},
+		// the first matching rule applies
+		{"legacy.requests", "3", "6"},
+		{"cpu.idle", "90", "90"},
+	}
+	for _, test := range tests {
+		point := &common.Point{Name: test.name, Value: test.value}
+		if !transformer.Process(point) || point.Value != test.expected {
+			t.Errorf("%s %s: expected %s, found %s", test.name, test.value, test.expected, point.Value)
+		}
+	}
+	if n := transformer.rules[3].transformed.Count(); n < 1 {
+		t.Errorf("Expected the transformed points counted, found %d", n)
+	}
+
+	histogram := &common.Point{Name: "legacy.bytes.hist", Histogram: &common.Histogram{
+		Centroids: []common.Centroid{{Value: 1024, Count: 2}, {Value: 4096, Count: 1}}}}
+	if !transformer.Process(histogram) || histogram.Histogram.Centroids[0].Value != 1 || histogram.Histogram.Centroids[1].Value != 4 {
+		t.Errorf("Expected the centroids transformed, found %+v", histogram.Histogram.Centroids)
+	}
+
+	// values overflowing to Inf are dropped
+	invalid := transformer.rules[3].invalid.Count()
+	if transformer.Process(&common.Point{Name: "legacy.requests", Value: "1e308"}) {
+		t.Error("Expected the point overflowing to Inf dropped")
+	}
+	if n := transformer.rules[3].invalid.Count() - invalid; n != 1 {
+		t.Errorf("Expected 1 invalid point counted, found %d", n)
+	}
+}
+
+func TestValueTransformerRules(t *testing.T) {
+	// patterns may contain colons
+	transformer, err := NewValueTransformer(`^a:b$:multiply:10`)
+	if err != nil || transformer.rules[0].pattern.String() != "^a:b$" {
+		t.Fatalf("Expected a pattern with a colon, found %v", err)
+	}
+	for _, rules := range []string{"^foo", "^foo:multiply", ":multiply:2", "^foo:pow:2", "^foo:divide:0",
+		"^foo:multiply:x", "^foo:offset:NaN", "(:multiply:2"} {
+		if _, err := NewValueTransformer(rules); err == nil {
+			t.Errorf("Expected an error for %q", rules)
+		}
+	}
+}
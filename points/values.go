@@ -0,0 +1,63 @@
+package points
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-proxy/common"
+)
+
+// Drops points whose value is not a finite number, such as NaN or Inf, which the Wavefront server
+// rejects along with the rest of their batch. Negative values are dropped too if rejectNegative is set.
+// Histogram points are checked by the values of their centroids.
+type ValueFilter struct {
+	rejectNegative bool
+	invalid        metrics.Counter
+	negative       metrics.Counter
+}
+
+func NewValueFilter(rejectNegative bool) *ValueFilter {
+	return &ValueFilter{
+		rejectNegative: rejectNegative,
+		invalid:        metrics.GetOrRegisterCounter("preprocessor.values.invalid", nil),
+		negative:       metrics.GetOrRegisterCounter("preprocessor.values.negative", nil),
+	}
+}
+
+func (f *ValueFilter) Process(point *common.Point) bool {
+	if point.Histogram != nil {
+		for _, centroid := range point.Histogram.Centroids {
+			if !f.check(centroid.Value) {
+				recordBlocked(point, "value filter")
+				return false
+			}
+		}
+		return true
+	}
+
+	value, err := strconv.ParseFloat(point.Value, 64)
+	if err != nil {
+		// out of range values parse as +/-Inf with an error
+		f.invalid.Inc(1)
+		recordBlocked(point, "value filter")
+		return false
+	}
+	if !f.check(value) {
+		recordBlocked(point, "value filter")
+		return false
+	}
+	return true
+}
+
+func (f *ValueFilter) check(value float64) bool {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		f.invalid.Inc(1)
+		return false
+	}
+	if f.rejectNegative && value < 0 {
+		f.negative.Inc(1)
+		return false
+	}
+	return true
+}
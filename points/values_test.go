@@ -0,0 +1,69 @@
+package points
+
+import (
+	"math"
+	"testing"
+
+	"github.com/wavefronthq/go-proxy/common"
+)
+
+func TestValueFilter(t *testing.T) {
+	filter := NewValueFilter(false)
+	cases := map[string]bool{
+		"1":      true,
+		"-2.5":   true,
+		"1e10":   true,
+		"NaN":    false,
+		"nan":    false,
+		"Inf":    false,
+		"-Inf":   false,
+		"+Inf":   false,
+		"1e400":  false,
+		"abc":    false,
+		"":       false,
+		"0x1p-2": true,
+	}
+	invalid := filter.invalid.Count()
+	for value, expected := range cases {
+		point := newTestPoint("foo", nil)
+		point.Value = value
+		if filter.Process(point) != expected {
+			t.Errorf("Expected %v for value %q", expected, value)
+		}
+	}
+	if count := filter.invalid.Count() - invalid; count != 8 {
+		t.Errorf("Expected 8 invalid values, found %d", count)
+	}
+}
+
+func TestValueFilterRejectNegative(t *testing.T) {
+	filter := NewValueFilter(true)
+	negative := filter.negative.Count()
+
+	point := newTestPoint("foo", nil)
+	point.Value = "-1"
+	if filter.Process(point) {
+		t.Error("Expected negative value to be dropped")
+	}
+	point.Value = "0"
+	if !filter.Process(point) {
+		t.Error("Expected zero value to be kept")
+	}
+	if count := filter.negative.Count() - negative; count != 1 {
+		t.Errorf("Expected 1 negative value, found %d", count)
+	}
+}
+
+func TestValueFilterHistogram(t *testing.T) {
+	filter := NewValueFilter(false)
+	point := newTestPoint("foo", nil)
+	point.Value = ""
+	point.Histogram = &common.Histogram{Granularity: "!M", Centroids: []common.Centroid{{Value: 1, Count: 2}}}
+	if !filter.Process(point) {
+		t.Error("Expected histogram with finite centroids to be kept")
+	}
+	point.Histogram.Centroids = append(point.Histogram.Centroids, common.Centroid{Value: math.NaN(), Count: 1})
+	if filter.Process(point) {
+		t.Error("Expected histogram with a NaN centroid to be dropped")
+	}
+}